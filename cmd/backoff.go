@@ -0,0 +1,60 @@
+package main
+
+import (
+	"time"
+)
+
+// volumeBackoff tracks the recoveries of a volume across passes
+type volumeBackoff struct {
+	attempts int
+	last     time.Time
+}
+
+// backoffDelay returns the time to wait after the given number of consecutive
+// recoveries of a volume: the cooldown, doubled after each attempt up to the
+// maximum backoff
+func backoffDelay(attempts int) time.Duration {
+	delay := conf.RecoveryCooldown
+	for i := 1; i < attempts && delay < conf.RecoveryBackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, conf.RecoveryBackoffMax)
+}
+
+// backingOff reports whether the volume was recovered too recently to be
+// recovered again, and when the next recovery is allowed
+func (r *runner) backingOff(key string) (bool, time.Time) {
+	b, ok := r.backoff[key]
+	if !ok || conf.RecoveryCooldown <= 0 {
+		return false, time.Time{}
+	}
+	next := b.last.Add(backoffDelay(b.attempts))
+	return time.Now().Before(next), next
+}
+
+// recordRecoveryAttempt starts the backoff of the volume after a recovery
+func (r *runner) recordRecoveryAttempt(key string) {
+	b, ok := r.backoff[key]
+	if !ok {
+		b = &volumeBackoff{}
+		r.backoff[key] = b
+	}
+	b.attempts++
+	b.last = time.Now()
+}
+
+// resetBackoff forgets the recoveries of a volume found healthy
+func (r *runner) resetBackoff(key string) {
+	delete(r.backoff, key)
+}
+
+// pruneBackoff forgets the volumes which haven't needed a recovery for twice
+// the maximum backoff, they are healthy or gone
+func (r *runner) pruneBackoff() {
+	for key, b := range r.backoff {
+		if time.Since(b.last) > 2*conf.RecoveryBackoffMax {
+			delete(r.backoff, key)
+			delete(r.circuitFailures, key)
+		}
+	}
+}
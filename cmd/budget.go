@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// namespaceBudget returns the number of recoveries allowed in the namespace
+// per budget window, unlimited when 0
+func (r *runner) namespaceBudget(namespace string) int {
+	if budget, ok := r.namespaceBudgets[namespace]; ok {
+		return budget
+	}
+	return conf.NamespaceBudget
+}
+
+// withinBudget reports whether another recovery is allowed in the namespace,
+// or why not. The pass is limited to --max-concurrent-recoveries volumes, the
+// others wait for the next pass, so a node-wide storage incident doesn't
+// disrupt every workload at once.
+func (r *runner) withinBudget(namespace string) (bool, string) {
+	if conf.MaxConcurrentRecoveries > 0 && r.passRecoveries >= conf.MaxConcurrentRecoveries {
+		return false, fmt.Sprintf("%d volumes already recovered in this pass", r.passRecoveries)
+	}
+	budget := r.namespaceBudget(namespace)
+	if budget <= 0 {
+		return true, ""
+	}
+	recent := r.recentRecoveries(namespace)
+	if len(recent) >= budget {
+		return false, fmt.Sprintf("%d recoveries in namespace %s in the last %s", len(recent), namespace, conf.NamespaceBudgetWindow)
+	}
+	return true, ""
+}
+
+// recentRecoveries returns the times of the recoveries in the namespace within
+// the budget window, older ones are forgotten
+func (r *runner) recentRecoveries(namespace string) []time.Time {
+	cutoff := time.Now().Add(-conf.NamespaceBudgetWindow)
+	recent := r.namespaceRecoveries[namespace][:0]
+	for _, t := range r.namespaceRecoveries[namespace] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) == 0 {
+		delete(r.namespaceRecoveries, namespace)
+		return nil
+	}
+	r.namespaceRecoveries[namespace] = recent
+	return recent
+}
+
+// spendBudget counts a recovery in the namespace against the budgets
+func (r *runner) spendBudget(namespace string) {
+	r.passRecoveries++
+	r.namespaceRecoveries[namespace] = append(r.namespaceRecoveries[namespace], time.Now())
+}
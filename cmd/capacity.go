@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/audit"
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+	v1alpha1 "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+)
+
+// checkCapacity reports the PVCs whose volumes use more space or inodes than
+// the thresholds, according to the kubelet summary. The warning is recorded
+// on the PVC and cleared once usage drops again. Volumes running out of space
+// are expanded when enabled along with the actions.
+func (r *runner) checkCapacity(ctx context.Context, metrics *v1alpha1.Summary) {
+	seen := map[string]bool{}
+	for i := range metrics.Pods {
+		for j := range metrics.Pods[i].VolumeStats {
+			stats := &metrics.Pods[i].VolumeStats[j]
+			if stats.PVCRef == nil || seen[stats.PVCRef.Namespace+"/"+stats.PVCRef.Name] {
+				continue
+			}
+			seen[stats.PVCRef.Namespace+"/"+stats.PVCRef.Name] = true
+			r.checkVolumeUsage(ctx, stats)
+		}
+	}
+}
+
+// checkVolumeUsage compares the usage of the volume with the thresholds
+func (r *runner) checkVolumeUsage(ctx context.Context, stats *v1alpha1.VolumeStats) {
+	namespace, name := stats.PVCRef.Namespace, stats.PVCRef.Name
+	var warnings []string
+	spaceUsed, ok := usedPercent(stats.CapacityBytes, stats.AvailableBytes)
+	spaceFull := ok && conf.CapacityThreshold > 0 && spaceUsed >= conf.CapacityThreshold
+	if spaceFull {
+		warnings = append(warnings, fmt.Sprintf("space %d%% used", spaceUsed))
+	}
+	if inodesUsed, ok := usedPercent(stats.Inodes, stats.InodesFree); ok && conf.InodeThreshold > 0 && inodesUsed >= conf.InodeThreshold {
+		warnings = append(warnings, fmt.Sprintf("inodes %d%% used", inodesUsed))
+	}
+
+	pvc, err := r.kubeClient.GetPVC(ctx, name, namespace)
+	if err != nil {
+		r.logger.Error("failed to get PVC", "pvc", name, "namespace", namespace, "error", err)
+		return
+	}
+	current, annotated := pvc.Annotations[kubernetes.UsageWarningAnnotation]
+	if len(warnings) == 0 {
+		if annotated {
+			r.logger.Info("volume usage is back under the thresholds", "pvc", name, "namespace", namespace)
+			r.annotateUsage(ctx, name, namespace, nil)
+		}
+		return
+	}
+
+	warning := strings.Join(warnings, ", ")
+	r.logger.Warn("volume is running out of space", "pvc", name, "namespace", namespace, "usage", warning)
+	if current != warning || !annotated {
+		r.annotateUsage(ctx, name, namespace, &warning)
+	}
+	if !spaceFull || !conf.AutoExpand {
+		return
+	}
+	if !actionsEnabled() {
+		r.logger.Warn("recovery actions are disabled, not expanding PVC; enable them with --enable-actions", "pvc", name, "namespace", namespace)
+		return
+	}
+	if r.protectedNamespaces[namespace] {
+		r.logger.Warn("PVC is in a protected namespace, not expanding it", "pvc", name, "namespace", namespace)
+		return
+	}
+	ctx = audit.WithReason(ctx, "volume running out of space: "+warning)
+	size, err := r.kubeClient.ExpandPVC(ctx, name, namespace, conf.ExpandPercent, r.expandLimit)
+	if err != nil {
+		r.logger.Error("failed to expand PVC", "pvc", name, "namespace", namespace, "error", err)
+		return
+	}
+	if size == nil {
+		r.logger.Info("PVC can't be expanded", "pvc", name, "namespace", namespace,
+			"reason", "expansion in progress, limit reached or not allowed by its StorageClass")
+		return
+	}
+	r.logger.Info("expanded PVC", "pvc", name, "namespace", namespace, "size", size.String())
+}
+
+// annotateUsage records the usage warning on the PVC, nil clears it
+func (r *runner) annotateUsage(ctx context.Context, name, namespace string, warning *string) {
+	err := r.kubeClient.AnnotatePVC(ctx, name, namespace, map[string]*string{kubernetes.UsageWarningAnnotation: warning})
+	if err != nil {
+		r.logger.Error("failed to record usage warning on PVC", "pvc", name, "namespace", namespace, "error", err)
+	}
+}
+
+// usedPercent returns the used percentage of the total, false when the
+// kubelet didn't report both values
+func usedPercent(total, free *uint64) (int, bool) {
+	if total == nil || free == nil || *total == 0 || *free > *total {
+		return 0, false
+	}
+	return int((*total - *free) * 100 / *total), true
+}
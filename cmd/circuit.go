@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+)
+
+// circuitOpen reports whether the recovery circuit of the volume or of its
+// driver is open, and why
+func (r *runner) circuitOpen(ctx context.Context, driver string, pv podVolume) (bool, string, error) {
+	if conf.CircuitBreakerThreshold <= 0 {
+		return false, "", nil
+	}
+	if r.openDrivers[driver] {
+		return true, fmt.Sprintf("circuit of driver %s is open, remove it from the %s annotation of the node to reset it",
+			driver, kubernetes.CircuitOpenDriversAnnotation), nil
+	}
+	open, err := r.kubeClient.VolumeCircuitOpen(ctx, pv.namespace, pv.podName, pv.pvcName)
+	if err != nil || !open {
+		return false, "", err
+	}
+	object := "PVC"
+	if pv.inline() {
+		object = "pod"
+	}
+	return true, fmt.Sprintf("circuit of the volume is open, remove the %s annotation of the %s to reset it",
+		kubernetes.CircuitOpenAnnotation, object), nil
+}
+
+// recordCircuit counts the consecutive failed recoveries of the volume and of
+// its driver, and opens their circuit once they reach the threshold, so a
+// broken workload isn't churned endlessly
+func (r *runner) recordCircuit(ctx context.Context, driver string, pv podVolume, err error) {
+	if conf.CircuitBreakerThreshold <= 0 || errors.Is(err, kubernetes.ErrRecoverySkipped) {
+		return
+	}
+	key := pv.key()
+	if err == nil {
+		delete(r.circuitFailures, key)
+		delete(r.driverRecoveryFailures, driver)
+		return
+	}
+	r.circuitFailures[key]++
+	r.driverRecoveryFailures[driver]++
+
+	if failures := r.circuitFailures[key]; failures >= conf.CircuitBreakerThreshold {
+		message := fmt.Sprintf("recovery of volume %s failed %d consecutive times, last error: %v", key, failures, err)
+		r.logger.Error("opening recovery circuit of volume", "volume", key, "failures", failures, "error", err)
+		if err := r.kubeClient.OpenVolumeCircuit(ctx, pv.namespace, pv.podName, pv.pvcName, message); err != nil {
+			r.logger.Error("failed to open recovery circuit of volume", "volume", key, "error", err)
+		} else {
+			delete(r.circuitFailures, key)
+		}
+	}
+	if failures := r.driverRecoveryFailures[driver]; failures >= conf.CircuitBreakerThreshold {
+		message := fmt.Sprintf("recoveries of driver %s volumes failed %d consecutive times, last error: %v", driver, failures, err)
+		r.logger.Error("opening recovery circuit of driver", "driver", driver, "failures", failures, "error", err)
+		if err := r.kubeClient.OpenDriverCircuit(ctx, driver, message); err != nil {
+			r.logger.Error("failed to open recovery circuit of driver", "driver", driver, "error", err)
+		} else {
+			delete(r.driverRecoveryFailures, driver)
+			r.openDrivers[driver] = true
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/ceph"
+	"github.com/Madhu-1/csi-volume-recovery/internal/csi"
+	"github.com/Madhu-1/csi-volume-recovery/internal/device"
+	"github.com/Madhu-1/csi-volume-recovery/internal/healthcheck"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+)
+
+// mountProbeTimeout bounds the probe of a volume mount, probes of hard NFS
+// mounts whose server is gone never return
+const mountProbeTimeout = 10 * time.Second
+
+// Actions taken on abnormal volumes depending on their severity
+const (
+	severityIgnore  = "ignore"
+	severityAlert   = "alert"
+	severityRecover = "recover"
+)
+
+// severityActionMap parses --severity-actions into the action of each
+// severity
+func severityActionMap() (map[healthcheck.Severity]string, error) {
+	entries, err := conf.SeverityActionMap()
+	if err != nil {
+		return nil, err
+	}
+	actions := map[healthcheck.Severity]string{}
+	for name, action := range entries {
+		severity, err := healthcheck.ParseSeverity(name)
+		if err != nil {
+			return nil, err
+		}
+		switch action {
+		case severityIgnore, severityAlert, severityRecover:
+		default:
+			return nil, fmt.Errorf("unknown action %q for severity %s, expected ignore, alert or recover", action, name)
+		}
+		actions[severity] = action
+	}
+	return actions, nil
+}
+
+// healthCheckerNames returns the health checkers selected with
+// --health-checkers, or the default ones along with the local checks enabled
+// with their flags
+func healthCheckerNames() []string {
+	names := conf.HealthCheckerList()
+	if len(names) == 0 {
+		enabled := map[string]bool{
+			healthcheck.SourceMount:    conf.ProbeMounts,
+			healthcheck.SourceNFS:      conf.CheckShares,
+			healthcheck.SourceSMB:      conf.CheckShares,
+			healthcheck.SourceReadOnly: conf.CheckReadOnly,
+			healthcheck.SourceDevice:   conf.CheckDevices,
+			healthcheck.SourceCeph:     conf.CheckCeph,
+			healthcheck.SourceIO:       conf.ProbeIO,
+			healthcheck.SourceEvents:   conf.VolumeEvents,
+			healthcheck.SourceBind:     conf.CheckBindMounts,
+		}
+		for _, name := range healthcheck.DefaultCheckers {
+			if on, local := enabled[name]; !local || on {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// newHealthPipeline builds the pipeline running the named health checkers
+func newHealthPipeline(logger *slog.Logger, names []string) (*healthcheck.Pipeline, error) {
+	opts := healthcheck.Options{
+		Logger:        logger,
+		ProbeTimeout:  mountProbeTimeout,
+		IOTimeout:     conf.IOProbeTimeout,
+		KernelLogPath: conf.KernelLogPath,
+		SysfsPath:     device.DefaultSysfsPath,
+		DebugfsPath:   ceph.DefaultDebugfsPath,
+	}
+	var checkers []healthcheck.HealthChecker
+	for _, name := range names {
+		checker, err := healthcheck.NewChecker(name, opts)
+		if err != nil {
+			return nil, err
+		}
+		checkers = append(checkers, checker)
+	}
+	return healthcheck.NewPipeline(checkers...), nil
+}
+
+// volumeHealth checks the health of the pod's volume. The volume is located
+// in the kubelet directory, block volumes are queried with the device file
+// published to the pod. The driver is only asked when it reports volume
+// conditions.
+func (r *runner) volumeHealth(ctx context.Context, client csi.Client, pv podVolume, queryDriver bool) (healthcheck.Verdict, *volume.VolumeInfo, error) {
+	info, err := r.locateVolume(ctx, pv)
+	if err != nil {
+		return healthcheck.Verdict{}, nil, err
+	}
+	vol := healthcheck.Volume{
+		Info:          info,
+		KubeletHealth: pv.health,
+		Suspect:       pv.suspect,
+	}
+	if !pv.inline() {
+		vol.Event = r.volumeEvents[pv.key()]
+	}
+	if queryDriver {
+		vol.Client = client
+	}
+	verdict, err := r.health.Check(ctx, vol)
+	return verdict, info, err
+}
+
+// locateVolume finds the pod's volume in the kubelet directory, by the PV of
+// its PVC or by its name for inline ephemeral volumes
+func (r *runner) locateVolume(ctx context.Context, pv podVolume) (*volume.VolumeInfo, error) {
+	name := pv.volumeName
+	if !pv.inline() {
+		pvc, err := r.kubeClient.GetPVC(ctx, pv.pvcName, pv.namespace)
+		if err != nil {
+			return nil, err
+		}
+		name = pvc.Spec.VolumeName
+	}
+	volumes, err := r.scanner.PodVolumes(pv.podUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan volumes of pod %s: %w", pv.podName, err)
+	}
+	for i := range volumes {
+		if (pv.inline() && volumes[i].Name == name) || (!pv.inline() && volumes[i].PersistentVolumeName == name) {
+			if len(volumes[i].UnknownFields) > 0 {
+				r.logger.Debug("volume data has unknown fields", "volume", pv.key(), "fields", volumes[i].UnknownFields)
+			}
+			return &volumes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("volume %s of pod %s not found in the kubelet directory", name, pv.podName)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/recovery"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+)
+
+// podOwner returns the owner recovered as a whole along with the pod of the
+// volume, it is looked up once per pod and pass
+func (r *runner) podOwner(ctx context.Context, pv podVolume) string {
+	if owner, ok := r.passOwners[pv.podUID]; ok {
+		return owner
+	}
+	owner, err := r.kubeClient.ScaledOwner(ctx, pv.namespace, pv.podName)
+	if err != nil {
+		r.logger.Error("failed to find the owner of the pod", "namespace", pv.namespace, "pod", pv.podName, "error", err)
+	}
+	r.passOwners[pv.podUID] = owner
+	return owner
+}
+
+// recoveredBy returns the action which already recovered the pod of the
+// volume, its owner or the volume shared with other pods, in the current
+// pass, empty if none did. The info is nil for pods stuck before their
+// volumes were mounted.
+func (r *runner) recoveredBy(ctx context.Context, pv podVolume, info *volume.VolumeInfo) string {
+	if action, ok := r.passScopes[recovery.PodScope(pv.namespace, pv.podUID)]; ok {
+		return action
+	}
+	if info != nil && info.PersistentVolumeName != "" {
+		if action, ok := r.passScopes[recovery.VolumeScope(info.PersistentVolumeName)]; ok {
+			return action
+		}
+	}
+	if owner := r.podOwner(ctx, pv); owner != "" {
+		return r.passScopes[recovery.OwnerScope(pv.namespace, owner)]
+	}
+	return ""
+}
+
+// markRecovered records the pod, owner or volume the action recovered, so the
+// other volumes of the scope aren't recovered again in the pass
+func (r *runner) markRecovered(action recovery.Action, target *recovery.Target) {
+	if scope := recovery.Scope(action, target); scope != "" {
+		r.passScopes[scope] = action.Name()
+	}
+}
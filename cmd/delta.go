@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	v1alpha1 "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+)
+
+// statsFingerprint sums up the kubelet stats of a volume, they change as the
+// volume is used
+func statsFingerprint(stats *v1alpha1.VolumeStats) string {
+	abnormal := stats.VolumeHealthStats != nil && stats.VolumeHealthStats.Abnormal
+	return fmt.Sprintf("%d/%d/%d/%d/%t", value(stats.CapacityBytes), value(stats.UsedBytes),
+		value(stats.Inodes), value(stats.InodesUsed), abnormal)
+}
+
+// value returns the stat, 0 when it isn't reported
+func value(stat *uint64) uint64 {
+	if stat == nil {
+		return 0
+	}
+	return *stat
+}
+
+// fingerprint identifies the state of the volume a pass checked, a volume
+// with the same fingerprint in the next pass is expected to be as healthy
+func (pv podVolume) fingerprint() string {
+	return fmt.Sprintf("%s/%t/%s/%s", pv.podUID, pv.pending, pv.suspect, pv.stats)
+}
+
+// deltaProcessing reports whether the passes only check the volumes which
+// changed, with --full-resync-interval in daemon mode
+func (r *runner) deltaProcessing() bool {
+	return conf.FullResyncInterval > 0 && conf.Interval > 0 && r.plan == nil
+}
+
+// deltaVolumes returns the volumes the pass checks. With delta processing the
+// volumes found healthy whose fingerprint didn't change are left out, except
+// in a full resync. The caller holds r.mu.
+func (r *runner) deltaVolumes(volumes []podVolume) []podVolume {
+	if !r.deltaProcessing() {
+		return volumes
+	}
+	present := make(map[string]bool, len(volumes))
+	for _, pv := range volumes {
+		present[pv.key()] = true
+	}
+	// forget the volumes which are gone
+	for key := range r.healthyStats {
+		if !present[key] {
+			delete(r.healthyStats, key)
+		}
+	}
+	if now := time.Now(); now.Sub(r.lastResync) >= conf.FullResyncInterval {
+		r.lastResync = now
+		r.logger.Info("full resync, checking all the volumes", "volumes", len(volumes))
+		return volumes
+	}
+	changed := volumes[:0:0]
+	for _, pv := range volumes {
+		if stats, ok := r.healthyStats[pv.key()]; ok && stats == pv.fingerprint() {
+			continue
+		}
+		changed = append(changed, pv)
+	}
+	r.logger.Info("checking the volumes which changed since the last pass", "changed", len(changed),
+		"unchanged", len(volumes)-len(changed))
+	return changed
+}
+
+// recordUnchanged remembers the fingerprint of the volume when the pass found
+// it healthy, so it isn't checked again until it changes; the caller holds
+// r.mu
+func (r *runner) recordUnchanged(pv podVolume, check volumeCheck) {
+	if !r.deltaProcessing() {
+		return
+	}
+	if check.checked && !pv.pending && !check.verdict.Abnormal() {
+		r.healthyStats[pv.key()] = pv.fingerprint()
+		return
+	}
+	delete(r.healthyStats, pv.key())
+}
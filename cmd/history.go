@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/healthcheck"
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+)
+
+// recordDetection adds the abnormal volume to the history of the pass
+func (r *runner) recordDetection(key, driver string, verdict healthcheck.Verdict) {
+	if conf.HistoryNamespace == "" {
+		return
+	}
+	r.history = append(r.history, kubernetes.HistoryEntry{
+		Time:     time.Now().UTC(),
+		Kind:     kubernetes.HistoryDetected,
+		Volume:   key,
+		Driver:   driver,
+		Severity: verdict.Severity().String(),
+		Reason:   verdict.Reason(),
+	})
+}
+
+// recordAction adds the recovery action started at the time to the history of
+// the pass
+func (r *runner) recordAction(key, driver, action string, started time.Time, err error) {
+	if conf.HistoryNamespace == "" {
+		return
+	}
+	entry := kubernetes.HistoryEntry{
+		Time:     started.UTC(),
+		Kind:     kubernetes.HistoryRecovered,
+		Volume:   key,
+		Driver:   driver,
+		Action:   action,
+		Result:   resultSucceeded,
+		Duration: time.Since(started).Round(time.Millisecond).String(),
+	}
+	switch {
+	case errors.Is(err, kubernetes.ErrRecoverySkipped):
+		entry.Result = resultSkipped
+		entry.Error = err.Error()
+	case err != nil:
+		entry.Result = resultFailed
+		entry.Error = err.Error()
+	}
+	r.history = append(r.history, entry)
+}
+
+// flushHistory appends the history of the pass to the history ConfigMap
+func (r *runner) flushHistory(ctx context.Context) {
+	if len(r.history) == 0 {
+		return
+	}
+	if err := r.kubeClient.AppendHistory(ctx, conf.HistoryNamespace, r.history, conf.HistorySize); err != nil {
+		r.logger.Error("failed to persist recovery history", "namespace", conf.HistoryNamespace, "error", err)
+		// keep the entries for the next pass, bounded by the history size
+		if len(r.history) > conf.HistorySize {
+			r.history = r.history[len(r.history)-conf.HistorySize:]
+		}
+		return
+	}
+	r.history = r.history[:0]
+}
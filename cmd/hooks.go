@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/hooks"
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+	"github.com/Madhu-1/csi-volume-recovery/internal/recovery"
+)
+
+// hookPayload describes the recovery action of the volume to the hooks
+func hookPayload(phase, action string, target *recovery.Target, pv podVolume) hooks.Payload {
+	return hooks.Payload{
+		Phase:     phase,
+		Time:      time.Now().UTC(),
+		Action:    action,
+		Node:      conf.NodeName,
+		Driver:    target.Driver,
+		Namespace: pv.namespace,
+		Pod:       pv.podName,
+		PVC:       pv.pvcName,
+		Volume:    pv.key(),
+	}
+}
+
+// runPreHook runs the pre-recovery hook, the action isn't run when it fails
+func (r *runner) runPreHook(ctx context.Context, action string, target *recovery.Target, pv podVolume) error {
+	if r.preHook == nil {
+		return nil
+	}
+	return r.preHook.Run(ctx, hookPayload(hooks.PhasePre, action, target, pv))
+}
+
+// runPostHook runs the post-recovery hook with the result of the action, a
+// failure is only logged
+func (r *runner) runPostHook(ctx context.Context, action string, target *recovery.Target, pv podVolume, err error) {
+	if r.postHook == nil {
+		return
+	}
+	payload := hookPayload(hooks.PhasePost, action, target, pv)
+	payload.Result = resultSucceeded
+	switch {
+	case errors.Is(err, kubernetes.ErrRecoverySkipped):
+		payload.Result = resultSkipped
+		payload.Error = err.Error()
+	case err != nil:
+		payload.Result = resultFailed
+		payload.Error = err.Error()
+	}
+	if err := r.postHook.Run(ctx, payload); err != nil {
+		r.logger.Error("post-recovery hook failed", "action", action, "volume", pv.key(), "error", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/recovery"
+)
+
+// defaultDriverRecoveryActions remounts the shares of csi-driver-nfs and
+// csi-driver-smb first: a fresh mount of the share is usually all a stale or
+// reconnected share needs, and scaling owners or cleaning up attachments
+// doesn't help volumes which are never attached
+const defaultDriverRecoveryActions = "nfs.csi.k8s.io=" + recovery.ActionRemount + "|" + recovery.ActionRestart +
+	",smb.csi.k8s.io=" + recovery.ActionRemount + "|" + recovery.ActionRestage + "|" + recovery.ActionRestart
+
+// driverActionLadders parses --driver-recovery-actions into the escalation
+// ladder of each driver. The names are checked against the built-in actions,
+// the ladders only keep the actions enabled with --recovery-actions so the
+// defaults never run an action the operator left out.
+func driverActionLadders(builtins, enabled *recovery.Registry) (map[string]*recovery.Registry, error) {
+	entries, err := conf.DriverRecoveryActionMap()
+	if err != nil {
+		return nil, err
+	}
+	ladders := map[string]*recovery.Registry{}
+	for driver, names := range entries {
+		if _, err := builtins.Subset(names); err != nil {
+			return nil, fmt.Errorf("invalid recovery actions of driver %s: %w", driver, err)
+		}
+		ladder := recovery.NewRegistry()
+		for _, name := range names {
+			if action := enabled.Get(name); action != nil {
+				ladder.Register(action)
+			}
+		}
+		ladders[driver] = ladder
+	}
+	return ladders, nil
+}
+
+// driverLadder returns the escalation ladder of the volumes of the driver
+func (r *runner) driverLadder(driver string) *recovery.Registry {
+	if ladder, ok := r.driverActions[driver]; ok {
+		return ladder
+	}
+	return r.actions
+}
@@ -5,19 +5,36 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	"log/slog"
 
+	"github.com/Madhu-1/csi-volume-recovery/internal/audit"
 	"github.com/Madhu-1/csi-volume-recovery/internal/csi"
+	"github.com/Madhu-1/csi-volume-recovery/internal/healthcheck"
+	"github.com/Madhu-1/csi-volume-recovery/internal/hooks"
 	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+	"github.com/Madhu-1/csi-volume-recovery/internal/notify"
+	"github.com/Madhu-1/csi-volume-recovery/internal/recovery"
+	"github.com/Madhu-1/csi-volume-recovery/internal/repair"
+	"github.com/Madhu-1/csi-volume-recovery/internal/schedule"
+	"github.com/Madhu-1/csi-volume-recovery/internal/tracing"
 	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
 	"github.com/Madhu-1/csi-volume-recovery/pkg"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 var conf = pkg.Config{}
 
+// minLockTTL is the shortest TTL of the owner locks, a shorter one could
+// expire between two renewals delayed by a slow API server
+const minLockTTL = 30 * time.Second
+
 func printVersion() {
 	fmt.Println("Go Version:", runtime.Version())
 	fmt.Println("Compiler:", runtime.Compiler)
@@ -27,12 +44,177 @@ func printVersion() {
 
 func init() {
 	// common flags
-	flag.StringVar(&conf.Endpoint, "endpoints", "", "comma separated list of CSI endpoints")
+	flag.StringVar(&conf.Endpoint, "endpoints", "", "comma separated list of CSI endpoints, alternative endpoints of the same driver can be separated with |; all the endpoints of a driver share one failover client and the --csi-qps and --csi-concurrency limits")
 	flag.StringVar(&conf.KubeletPath, "kubelet-path", "/var/lib/kubelet", "path to kubelet directory")
+	flag.StringVar(&conf.VolumeSource, "volume-source", "api", "where the drivers of the volumes are looked up, api for the PVCs and PVs or kubelet for the kubelet directory")
+	flag.DurationVar(&conf.VolumeCacheTTL, "volume-cache-ttl", 5*time.Minute, "time the driver of a volume is cached for, lookups aren't cached when 0")
+	flag.DurationVar(&conf.VolumeCacheNegativeTTL, "volume-cache-negative-ttl", 30*time.Second, "time failed driver lookups are cached for")
+	flag.BoolVar(&conf.CheckMounts, "check-mounts", false, "check the mounts of the CSI volumes in the kubelet directory against the mount table")
+	flag.BoolVar(&conf.ProbeMounts, "probe-mounts", false, "probe the mounts of the volumes with statfs and recover stale ones, e.g. NFS mounts failing with stale file handles, even when the driver doesn't report volume conditions")
+	flag.StringVar(&conf.HealthCheckers, "health-checkers", "", "comma separated, ordered list of the health checkers run on each volume until one finds it abnormal: kubelet, events, stats, mount, nfs, smb, bind, read-only, device, ceph, io and driver. Defaults to kubelet, stats, driver and the checks enabled with --volume-events, --probe-mounts, --check-shares, --check-bind-mounts, --check-read-only, --check-devices, --check-ceph and --probe-io")
+	flag.StringVar(&conf.SeverityActions, "severity-actions", "warning=alert,degraded=alert,failed=recover", "comma separated list of severity=action entries saying what to do with abnormal volumes of the severities warning, degraded and failed: ignore, alert or recover. Severities which aren't listed are ignored")
+	flag.BoolVar(&conf.VolumeEvents, "volume-events", false, "recover the volumes whose PVC got a VolumeConditionAbnormal event from the CSI external-health-monitor controller")
+	flag.DurationVar(&conf.VolumeEventWindow, "volume-event-window", 10*time.Minute, "how old VolumeConditionAbnormal events may be to trigger a recovery")
+	flag.BoolVar(&conf.ProbeIO, "probe-io", false, "write, read back and delete a small canary file on each filesystem volume, and read the first block of each block volume with O_DIRECT, to detect hung storage")
+	flag.DurationVar(&conf.IOProbeTimeout, "io-probe-timeout", 10*time.Second, "time the I/O probe of a volume may take before the volume is considered hung")
+	flag.BoolVar(&conf.CheckDevices, "check-devices", false, "recover the volumes whose device-mapper device is suspended or has failed multipath paths")
+	flag.BoolVar(&conf.CheckShares, "check-shares", false, "recover the volumes whose NFS or SMB share, e.g. of csi-driver-nfs or csi-driver-smb, has stale file handles, rejected credentials, an expired Kerberos ticket or an unreachable server")
+	flag.BoolVar(&conf.CheckCeph, "check-ceph", false, "recover the ceph-csi volumes whose krbd device is blocklisted or lost its watch according to --kernel-log, or whose CephFS kernel client is blocklisted or lost its MDS sessions according to debugfs, mounted at /sys/kernel/debug")
+	flag.BoolVar(&conf.CheckBindMounts, "check-bind-mounts", false, "recover the filesystem volumes whose target path is no longer bind mounted from their staging path, e.g. after a driver restart recycled the staging mount")
+	flag.BoolVar(&conf.CheckReadOnly, "check-read-only", false, "recover the volumes whose filesystem was remounted read-only by the kernel, e.g. after I/O errors")
+	flag.StringVar(&conf.KernelLogPath, "kernel-log", "", "kernel log file, e.g. /var/log/kern.log, searched for the reason filesystems were remounted read-only and, with --check-ceph, for blocklisted krbd devices")
+	flag.IntVar(&conf.CapacityThreshold, "capacity-threshold", 0, "percentage of used space above which a volume is reported as running out of space, disabled when 0")
+	flag.IntVar(&conf.InodeThreshold, "inode-threshold", 0, "percentage of used inodes above which a volume is reported as running out of inodes, disabled when 0")
+	flag.BoolVar(&conf.AutoExpand, "auto-expand", false, "expand the PVCs whose volumes use more space than --capacity-threshold, if their StorageClass allows it; requires --enable-actions")
+	flag.IntVar(&conf.ExpandPercent, "expand-percent", 20, "percentage the storage request of a PVC grows by with --auto-expand")
+	flag.StringVar(&conf.ExpandLimit, "expand-limit", "", "size PVCs are never expanded beyond with --auto-expand, e.g. 1Ti, unlimited when empty")
+	flag.StringVar(&conf.NodeCondition, "node-condition", "", "type of the node condition, e.g. CSIVolumeUnhealthy, set to True while volumes are abnormal or drivers unhealthy so node-problem-detector consumers like draino can react, disabled when empty")
+	flag.BoolVar(&conf.DetectOrphans, "detect-orphans", false, "report the kubelet directories of pods which no longer exist but still hold CSI volumes")
+	flag.BoolVar(&conf.CleanupOrphans, "cleanup-orphans", false, "unmount and remove the orphaned pod directories found with --detect-orphans by two consecutive passes, so only in daemon mode; nothing is removed while no pod is listed on the node")
+	flag.StringVar(&conf.RepairFilesystems, "repair-filesystems", "", "comma separated list of driver=fstype|fstype entries, e.g. rbd.csi.ceph.com=ext4|xfs, whose filesystems are repaired with fsck or xfs_repair while scaled down owners leave the volume unstaged; only devices identified as the volume, krbd devices of its image or disks whose WWN or serial holds its handle, are repaired, and only while still on the node")
+	flag.DurationVar(&conf.RepairUnmountTimeout, "repair-unmount-timeout", 2*time.Minute, "time to wait for the kubelet to unstage a volume before its filesystem is repaired, the repair is skipped afterwards")
+	flag.DurationVar(&conf.RepairTimeout, "repair-timeout", 10*time.Minute, "time a filesystem repair may take")
 	flag.StringVar(&conf.NodeName, "node-name", "minikube", "node name")
-	flag.StringVar(&conf.KubeconfigPath, "kubeconfig", "kubeconfig", "path to kubeconfig file")
+	flag.StringVar(&conf.KubeconfigPath, "kubeconfig", "", "path to kubeconfig file, the in-cluster config or the default kubeconfig is used when empty")
+	flag.Float64Var(&conf.KubeAPIQPS, "kube-api-qps", 0, "maximum number of requests per second made to the API server, the client-go default is used when 0")
+	flag.IntVar(&conf.KubeAPIBurst, "kube-api-burst", 0, "maximum burst of requests made to the API server, the client-go default is used when 0")
+	flag.Int64Var(&conf.KubeAPIPageSize, "kube-api-page-size", 500, "number of objects listed from the API server at a time when the informers don't serve them, e.g. the pods of the node and the PVCs and PVs of a pass; lists are made at once when 0")
+	flag.DurationVar(&conf.ScaleTimeout, "scale-timeout", 2*time.Minute, "time to wait for a scaled down owner to have no replicas before it is scaled back up")
+	flag.DurationVar(&conf.PodDeletionTimeout, "pod-deletion-timeout", 0, "time to wait for a deleted pod to be gone, deleted pods aren't waited for when 0")
+	flag.DurationVar(&conf.ReplacementTimeout, "replacement-timeout", 0, "time to wait for a restarted pod to be replaced by a running pod, restarted pods aren't waited for when 0")
+	flag.DurationVar(&conf.APITimeout, "api-timeout", 0, "timeout of each request made to the API server, unbounded when 0")
+	flag.StringVar(&conf.ImpersonateUser, "as", "", "user to impersonate for the API requests")
+	flag.StringVar(&conf.ImpersonateGroups, "as-group", "", "comma separated list of groups to impersonate for the API requests")
+	flag.BoolVar(&conf.SkipPermissionCheck, "skip-permission-check", false, "don't check the RBAC permissions of the tool at startup")
+	flag.BoolVar(&conf.KubeletDirect, "kubelet-direct", false, "get the volume stats from the kubelet API of the node, falling back to the API server proxy")
+	flag.IntVar(&conf.KubeletPort, "kubelet-port", 10250, "port of the kubelet API used with --kubelet-direct")
+	flag.StringVar(&conf.KubeletCAFile, "kubelet-ca-file", "", "CA bundle verifying the kubelet serving certificate, required with --kubelet-direct; the service account CA only verifies the API server")
+	flag.StringVar(&conf.DaemonSetPolicy, "daemonset-policy", kubernetes.DaemonSetDeletePod, "how to recover pods owned by a DaemonSet, delete-pod or rollout-restart")
+	flag.StringVar(&conf.JobPolicy, "job-policy", kubernetes.JobSkip, "how to recover pods owned by a Job or CronJob, delete-pod or skip")
+	flag.StringVar(&conf.OwnerPolicies, "owner-policies", "", "comma separated list of Kind.group=policy entries configuring how pods of other owners, e.g. Rollout.argoproj.io, are recovered: scale, delete-pod or skip")
+	flag.IntVar(&conf.StatsRetries, "stats-retries", 3, "number of times getting the kubelet stats is retried with backoff before the pass fails")
+	flag.StringVar(&conf.HPAPolicy, "hpa-policy", kubernetes.HPAPause, "how to scale owners managed by a HorizontalPodAutoscaler, pause the HPA scale up during the recovery or skip")
+	flag.StringVar(&conf.PDBPolicy, "pdb-policy", kubernetes.PDBEvict, "how to restart pods covered by a PodDisruptionBudget: evict through the Eviction API and retry later when refused, wait for the budget before evicting, skip the pods the budget doesn't allow to disrupt, or ignore the budgets; owners are only scaled to zero once the budgets allow all their replicas to be disrupted, a refused scale down fails the action with evict and is skipped with skip")
+	flag.DurationVar(&conf.PDBWaitTimeout, "pdb-wait-timeout", 5*time.Minute, "time to wait for a PodDisruptionBudget to allow a restart with --pdb-policy=wait")
+	flag.StringVar(&conf.VeleroNamespace, "velero-namespace", "", "namespace of the Velero backups and restores, e.g. velero; no action recovers the volumes of pods while a backup or restore covering their namespace is in progress, or when the backups can't be listed; the recovery is retried by a later pass")
+	flag.StringVar(&conf.LockHolder, "lock-holder", "", "identity recorded in the lock annotation of owners under recovery, the node name when empty")
+	flag.DurationVar(&conf.LockTTL, "lock-ttl", 10*time.Minute, "time after which the lock annotation of an owner under recovery expires unless renewed; the lock is renewed every third of it while the recovery runs, so it only expires when the instance holding it is gone, at least 30s")
+	flag.BoolVar(&conf.CleanupVolumeAttachments, "cleanup-volume-attachments", false, "delete VolumeAttachments of recovered volumes which are stuck attaching, detaching or deleting; the finalizer of the external-attacher is only removed from those stuck deleting once the volume is reported detached or the node is gone")
+	flag.DurationVar(&conf.VolumeAttachmentGrace, "volume-attachment-grace", 5*time.Minute, "time a VolumeAttachment may stay in deletion before it is considered stuck")
+	flag.DurationVar(&conf.PendingGracePeriod, "pending-grace-period", 5*time.Minute, "time a pod may stay pending on the node before it is recovered; it is only recovered when a FailedMount or FailedAttachVolume event was recorded on it within that time")
+	flag.BoolVar(&conf.CordonNode, "cordon-node", false, "cordon the node while disruptive recoveries are performed and uncordon it afterwards")
+	flag.StringVar(&conf.NodeTaint, "node-taint", "storage.csi/recovery-degraded:NoSchedule", "taint applied to the node while storage failures persist, in key[=value]:effect format")
+	flag.IntVar(&conf.TaintAfterFailures, "taint-after-failures", 0, "consecutive failed driver health checks or volume recoveries after which the node is tainted, 0 disables tainting; the node is only tainted with --enable-actions, the taint is removed regardless")
+	flag.DurationVar(&conf.RecoveryCooldown, "recovery-cooldown", 5*time.Minute, "time to wait before recovering a volume again, doubled after each consecutive recovery; volumes are recovered on every pass when 0")
+	flag.DurationVar(&conf.RecoveryBackoffMax, "recovery-backoff-max", time.Hour, "maximum time to wait between the recoveries of a volume")
+	flag.IntVar(&conf.RecoveryWorkers, "recovery-workers", 1, "number of volumes recovered in parallel")
+	flag.IntVar(&conf.VolumeWorkers, "volume-workers", 8, "number of volumes whose health is checked in parallel by each pass")
+	flag.DurationVar(&conf.FullResyncInterval, "full-resync-interval", 0, "in daemon mode, only check again the volumes found healthy whose pod or kubelet stats changed since, and all the volumes at this interval; stats frozen by a hung mount go unnoticed until the next full resync. Every pass checks all the volumes when 0")
+	flag.IntVar(&conf.RecoveryRetries, "recovery-retries", 3, "times a failed recovery is retried before the volume is left to the next passes")
+	flag.DurationVar(&conf.VerifyTimeout, "verify-timeout", 2*time.Minute, "time for a recovered volume to pass the health checks again, or for the pod restarted to recover it to be replaced by a ready one, before the recovery is recorded as failed; 0 disables the verification")
+	flag.DurationVar(&conf.RecoveryRetryDelay, "recovery-retry-delay", 30*time.Second, "time to wait before retrying a failed recovery, doubled after each retry up to --recovery-backoff-max")
+	flag.IntVar(&conf.MaxConcurrentRecoveries, "max-concurrent-recoveries", 0, "maximum number of volumes recovered in a pass, the others are recovered in the next passes; unlimited when 0")
+	flag.IntVar(&conf.NamespaceBudget, "namespace-budget", 0, "maximum number of recoveries in a namespace per --namespace-budget-window, unlimited when 0")
+	flag.StringVar(&conf.NamespaceBudgets, "namespace-budgets", "", "comma separated list of namespace=count entries overriding --namespace-budget for the namespaces, 0 is unlimited")
+	flag.DurationVar(&conf.NamespaceBudgetWindow, "namespace-budget-window", time.Hour, "time window of the namespace recovery budgets")
+	flag.StringVar(&conf.MaintenanceWindows, "maintenance-windows", "", "semicolon separated list of windows during which volumes are recovered, each a cron expression followed by a duration, e.g. \"0 22 * * 1-5 6h\"; outside them abnormal volumes are only reported. Volumes are recovered at any time when empty")
+	flag.StringVar(&conf.ProtectedNamespaces, "protected-namespaces", "kube-system,kube-node-lease,kube-public", "comma separated list of critical namespaces whose volumes are only reported, not recovered nor expanded, unless --include-system-namespaces is set")
+	flag.BoolVar(&conf.IncludeSystemNamespaces, "include-system-namespaces", false, "recover the volumes of the --protected-namespaces too")
+	flag.BoolVar(&conf.RemountStaticPods, "remount-static-pods", false, "remount the abnormal volumes of static pods, which can't be recovered by deleting or scaling them; they are only reported otherwise")
+	flag.BoolVar(&conf.EnableActions, "enable-actions", false, "run the recovery actions of --recovery-actions, the cleanup of --cleanup-orphans, the expansions of --auto-expand, the taint of --taint-after-failures and the cordon of --cordon-node; without it the tool only detects and reports abnormal volumes, so a misconfigured rollout can't disrupt workloads. The apply command makes the recoveries of its reviewed plan regardless")
+	flag.BoolVar(&conf.Quarantine, "quarantine", false, "only label the pods and PVCs of abnormal volumes with "+kubernetes.QuarantinedLabel+", annotate them with the reason and record events, leaving the recovery to an operator; with --cordon-node and --enable-actions the node is cordoned until an operator uncordons it")
+	flag.StringVar(&conf.DriverRecoveryActions, "driver-recovery-actions", defaultDriverRecoveryActions, "comma separated list of driver=action|action entries replacing the escalation ladder of --recovery-actions for the volumes of the drivers, limited to the actions of --recovery-actions; the NFS and SMB drivers remount the share first and never scale owners or clean up attachments by default")
+	flag.StringVar(&conf.RecoveryActions, "recovery-actions", strings.Join(recovery.DefaultActions, ","), "comma separated escalation ladder of recovery actions, each tried when the previous one couldn't be verified to have recovered the volume; available actions are remount, restage, restart-pod, scale-owner and cleanup-volume-attachment. remount and restage only handle filesystem volumes the containers mount with HostToContainer or Bidirectional propagation, and are verified inside the containers of the pod, which needs the host PID namespace")
+	flag.DurationVar(&conf.DriverActionTimeout, "driver-action-timeout", 2*time.Minute, "time the driver calls of the remount and restage recovery actions may take")
+	flag.StringVar(&conf.PreRecoveryHook, "pre-recovery-hook", "", "command, or http(s) webhook URL, run before each recovery action with the action, pod and volume as JSON on its standard input or in the request body; the action isn't run when the hook fails")
+	flag.StringVar(&conf.PostRecoveryHook, "post-recovery-hook", "", "command, or http(s) webhook URL, run after each recovery action with the action, pod, volume and result as JSON")
+	flag.DurationVar(&conf.HookTimeout, "hook-timeout", 30*time.Second, "time a recovery hook may take")
+	flag.StringVar(&conf.NotifyWebhooks, "notify-webhooks", "", "comma separated list of webhook URLs the recovery events are posted to as JSON")
+	flag.StringVar(&conf.NotifySlackWebhook, "notify-slack-webhook", "", "Slack incoming webhook URL the recovery events are posted to")
+	flag.StringVar(&conf.NotifyWebhookFiles, "notify-webhook-files", "", "comma separated list of files, such as keys of a mounted Secret, each holding a webhook URL the recovery events are posted to as JSON; the files are read again when they change")
+	flag.StringVar(&conf.NotifySlackWebhookFile, "notify-slack-webhook-file", "", "file, such as a key of a mounted Secret, holding the Slack incoming webhook URL the recovery events are posted to; the file is read again when it changes")
+	flag.StringVar(&conf.NotifyEvents, "notify-events", strings.Join(notify.DefaultEvents, ","), "comma separated list of the recovery events to notify: detected, recovered and failed")
+	flag.StringVar(&conf.NotifyTemplate, "notify-template", notify.DefaultTemplate, "text/template of the notification messages, given the event, node, driver, namespace, pod, pvc, volume, severity, reason, action and error fields")
+	flag.IntVar(&conf.CircuitBreakerThreshold, "circuit-breaker-threshold", 0, "consecutive failed recoveries of a volume or of a driver's volumes after which they aren't recovered anymore until the circuit-open annotation is removed, 0 disables the circuit breaker")
+	flag.StringVar(&conf.HistoryNamespace, "history-namespace", "", "namespace of the ConfigMap persisting the detections and recoveries of the node, the history is only logged when empty")
+	flag.IntVar(&conf.HistorySize, "history-size", 200, "number of entries kept in the history ConfigMap")
+	flag.DurationVar(&conf.Interval, "interval", 0, "interval between recovery passes, runs a single pass and exits when 0")
+	flag.BoolVar(&conf.NodeSummaryEvent, "node-summary-event", false, "record the number of volumes scanned, found unhealthy and recovered by the last pass in an event on the node, shown by kubectl describe node")
+	flag.BoolVar(&conf.VolumeConditionEvents, "volume-condition-events", false, "record VolumeConditionAbnormal and VolumeConditionNormal events on the PVCs of the volumes found abnormal and healthy again, in the format of the CSI external-health-monitor controller, so alerts built on the Kubernetes volume health monitoring fire for them too")
+	flag.BoolVar(&conf.VolumeHealthCRD, "volume-health-crd", false, "maintain a VolumeHealth object per monitored PVC, in its namespace, with the Healthy, RecoveryInProgress and RecoveryFailed conditions of its volume; needs the CRD in deploy/crds")
+	flag.StringVar(&conf.ReportFile, "report-file", "", "file a structured report of each pass, with the abnormal volumes, their verdicts and the recovery actions, is appended to, - for the standard output, disabled when empty")
+	flag.StringVar(&conf.ReportFormat, "report-format", reportJSON, "format of the pass reports, json for a JSON line per pass, yaml for a YAML document per pass or csv for a row per recovery action and per abnormal volume left alone, with a header in new files")
+	flag.StringVar(&conf.AuditLog, "audit-log", "", "file the mutating calls made to the API server and the CSI drivers are appended to as JSON lines, - for the standard output, disabled when empty")
+	flag.StringVar(&conf.ProbeTLSCertFile, "probe-tls-cert-file", "", "certificate the --probe-address endpoints are served over TLS with, along with --probe-tls-key-file; plain HTTP when empty")
+	flag.StringVar(&conf.ProbeTLSKeyFile, "probe-tls-key-file", "", "private key of --probe-tls-cert-file")
+	flag.StringVar(&conf.ProbeClientCAFile, "probe-client-ca-file", "", "CA bundle the client certificates allowed to read /metrics and /status are signed by, needs --probe-tls-cert-file; the probes stay open to the kubelet")
+	flag.StringVar(&conf.ProbeTokenFile, "probe-token-file", "", "file holding the bearer token allowed to read /metrics and /status; the probes stay open to the kubelet")
+	flag.StringVar(&conf.ProbeAddress, "probe-address", ":8081", "address serving the /healthz and /readyz probes, the /metrics and the report of the last pass on /status in daemon mode, disabled when empty")
+	flag.StringVar(&conf.LogLevel, "log-level", "info", "minimum level of the logged messages: debug, info, warn or error")
+	flag.StringVar(&conf.LogFormat, "log-format", logJSON, "format of the logs, json or text")
+	flag.StringVar(&conf.OTLPEndpoint, "otlp-endpoint", "", "OTLP gRPC endpoint (host:port) to export traces to, tracing is disabled when empty")
+	flag.BoolVar(&conf.OTLPInsecure, "otlp-insecure", false, "disable TLS for the OTLP gRPC exporter")
+	flag.Float64Var(&conf.CSIQPS, "csi-qps", 0, "maximum number of calls per second made to each CSI driver, 0 disables the limit")
+	flag.IntVar(&conf.CSIBurst, "csi-burst", 5, "maximum burst of calls made to each CSI driver when --csi-qps is set")
+	flag.IntVar(&conf.CSIConcurrency, "csi-concurrency", 0, "maximum number of calls in flight to each CSI driver, e.g. 1 or 2 on small edge nodes, 0 disables the limit")
+	flag.StringVar(&conf.DriverSecrets, "driver-secrets", "", "comma separated list of driver=namespace/name secrets passed to the node stage and publish calls of the volumes whose PV references none, read at each recovery")
+	flag.StringVar(&conf.RBACServiceAccount, "rbac-service-account", "kube-system/csi-volume-recovery", "namespace/name of the ServiceAccount the rbac command grants the permissions to, its ClusterRole and ClusterRoleBinding are named after it")
+	flag.BoolVar(&conf.RBACOptional, "rbac-optional", false, "include the permissions of the features disabled by default in the ClusterRole printed by the rbac command")
+	flag.StringVar(&conf.PlanFile, "plan", "-", "plan file written by the plan command and read by the apply command, - is the standard output")
+}
 
+// parseFlags parses the command line into conf, it isn't parsed in init so
+// the tests of the package get their own flags
+func parseFlags() {
 	flag.Parse()
+	// the plan and apply commands may be followed by more flags
+	if flag.NArg() > 0 {
+		conf.Mode = flag.Arg(0)
+		_ = flag.CommandLine.Parse(flag.Args()[1:])
+	}
+}
+
+// cleanupPreviousRun undoes what a previous run might have been stopped before
+// undoing: the cordon of the node, the owners scaled down and their HPAs
+// paused
+func (r *runner) cleanupPreviousRun(ctx context.Context) {
+	// a node cordoned in quarantine mode is uncordoned by an operator
+	if conf.CordonNode && !conf.Quarantine {
+		if err := r.kubeClient.UncordonNode(ctx); err != nil {
+			logAndExit(r.logger, "failed to uncordon node", err)
+		}
+	}
+	restored, err := r.kubeClient.RestoreScaledOwners(ctx)
+	for _, owner := range restored {
+		r.logger.Info("restored owner left scaled down", "owner", owner)
+	}
+	if err != nil {
+		r.logger.Error("failed to restore owners left scaled down", "error", err)
+	}
+}
+
+// Formats of the logs
+const (
+	logJSON = "json"
+	logText = "text"
+)
+
+// newLogger returns the logger writing to the standard output in the format
+// and from the level set with --log-format and --log-level
+func newLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(conf.LogLevel)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", conf.LogLevel, err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch conf.LogFormat {
+	case logJSON:
+		return slog.New(slog.NewJSONHandler(os.Stdout, opts)), nil
+	case logText:
+		return slog.New(slog.NewTextHandler(os.Stdout, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be %s or %s", conf.LogFormat, logJSON, logText)
+	}
 }
 
 func logAndExit(logger *slog.Logger, msg string, err error) {
@@ -40,104 +222,443 @@ func logAndExit(logger *slog.Logger, msg string, err error) {
 	os.Exit(1)
 }
 
+// newDriverClient creates a client for the alternative endpoints of a driver,
+// failing over between them when more than one endpoint is given. The calls
+// through all the endpoints share the limits.
+func newDriverClient(endpoints []string, limiter *rate.Limiter, concurrency csi.ConcurrencyLimit, logger *slog.Logger) (csi.Client, error) {
+	clients := make([]csi.Client, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		client, err := csi.NewClient(endpoint, limiter, concurrency, logger)
+		if err != nil {
+			for _, c := range clients {
+				c.Close()
+			}
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	client, err := csi.NewFailoverClient(clients...)
+	if err != nil {
+		return nil, fmt.Errorf("no endpoints in %q: %w", strings.Join(endpoints, "|"), err)
+	}
+	return client, nil
+}
+
+// driverEndpoints groups the endpoints of --endpoint by the driver serving
+// them, in the order they are listed, so all the sockets of a driver share one
+// failover client and its limits
+func driverEndpoints(logger *slog.Logger) (map[string][]string, error) {
+	endpoints := map[string][]string{}
+	for _, group := range strings.Split(conf.Endpoint, ",") {
+		client, err := newDriverClient(strings.Split(group, "|"), nil, nil, logger)
+		if err != nil {
+			return nil, err
+		}
+		name, err := client.GetDriverName(context.Background(), logger)
+		client.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get driver name of %s: %w", group, err)
+		}
+		endpoints[name] = append(endpoints[name], strings.Split(group, "|")...)
+	}
+	return endpoints, nil
+}
+
+// checkPermissions reports the missing RBAC permissions of the tool and
+// exits if any permission the recoveries can't do without is missing.
+func checkPermissions(logger *slog.Logger, kubeClient kubernetes.Client) {
+	missing, err := kubeClient.CheckPermissions(context.Background(), kubernetes.RequiredPermissions)
+	if err != nil {
+		logAndExit(logger, "failed to check permissions", err)
+	}
+	required := 0
+	for _, p := range missing {
+		if p.Optional {
+			logger.Warn("missing optional permission", "permission", p.String(), "reason", p.Reason)
+			continue
+		}
+		logger.Error("missing permission", "permission", p.String(), "reason", p.Reason)
+		required++
+	}
+	if required > 0 {
+		logAndExit(logger, "missing required permissions", fmt.Errorf("%d required permissions are missing", required))
+	}
+}
+
 func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	parseFlags()
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to configure logging:", err)
+		os.Exit(1)
+	}
+
+	// the RBAC objects are printed alone, to be applied as they are
+	if conf.Mode == modeRBAC {
+		if err := writeRBAC(os.Stdout); err != nil {
+			logAndExit(logger, "failed to write RBAC", err)
+		}
+		return
+	}
 
 	printVersion()
 
+	shutdownTracing, err := tracing.Setup(context.Background(), conf.OTLPEndpoint, conf.OTLPInsecure)
+	if err != nil {
+		logAndExit(logger, "failed to setup tracing", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("failed to shutdown tracing", "error", err)
+		}
+	}()
+
 	if conf.NodeName == "" {
 		logAndExit(logger, "node name is required", nil)
 
 	}
-	kubeClient, err := kubernetes.NewClient(conf.KubeconfigPath, conf.NodeName)
+	ownerPolicies, err := conf.OwnerPolicyMap()
+	if err != nil {
+		logAndExit(logger, "failed to parse owner policies", err)
+	}
+	kubeClient, err := kubernetes.NewClient(conf.KubeconfigPath, conf.NodeName, kubernetes.Options{
+		DaemonSetPolicy:    conf.DaemonSetPolicy,
+		JobPolicy:          conf.JobPolicy,
+		OwnerPolicies:      ownerPolicies,
+		HPAPolicy:          conf.HPAPolicy,
+		PDBPolicy:          conf.PDBPolicy,
+		PDBWaitTimeout:     conf.PDBWaitTimeout,
+		LockHolder:         conf.LockHolder,
+		LockTTL:            conf.LockTTL,
+		KubeletDirect:      conf.KubeletDirect,
+		KubeletPort:        conf.KubeletPort,
+		KubeletCAFile:      conf.KubeletCAFile,
+		StatsRetries:       conf.StatsRetries,
+		VolumeEvents:       conf.VolumeEvents,
+		VolumeAttachments:  conf.CleanupVolumeAttachments,
+		VeleroNamespace:    conf.VeleroNamespace,
+		QPS:                float32(conf.KubeAPIQPS),
+		ScaleTimeout:       conf.ScaleTimeout,
+		PodDeletionTimeout: conf.PodDeletionTimeout,
+		ReplacementTimeout: conf.ReplacementTimeout,
+		APITimeout:         conf.APITimeout,
+		ImpersonateUser:    conf.ImpersonateUser,
+		ImpersonateGroups:  conf.ImpersonateGroupList(),
+		Burst:              conf.KubeAPIBurst,
+		PageSize:           conf.KubeAPIPageSize,
+	})
 	if err != nil {
 		logAndExit(logger, "failed to create kubernetes client", err)
 	}
 
-	metrics, err := kubeClient.GetMetrics(context.Background())
+	if !conf.SkipPermissionCheck {
+		checkPermissions(logger, kubeClient)
+	}
+
+	var auditLog *audit.Log
+	if conf.AuditLog != "" {
+		actor := conf.LockHolder
+		if actor == "" {
+			actor = conf.NodeName
+		}
+		auditLog, err = audit.Open(conf.AuditLog, actor, conf.NodeName)
+		if err != nil {
+			logAndExit(logger, "failed to open audit log", err)
+		}
+		defer auditLog.Close()
+		kubeClient = audit.KubeClient(kubeClient, auditLog)
+	}
+
+	secretRefs, err := conf.DriverSecretRefs()
 	if err != nil {
-		logAndExit(logger, "failed to get metrics", err)
+		logAndExit(logger, "failed to parse driver secrets", err)
 	}
-	logger.Info("metrics", "metrics", metrics)
 
-	endpoints := strings.Split(conf.Endpoint, ",")
-	if len(endpoints) == 0 {
-		logAndExit(logger, "no CSI endpoints provided", nil)
+	endpoints, err := driverEndpoints(logger)
+	if err != nil {
+		logAndExit(logger, "failed to connect to the CSI drivers", err)
 	}
 	drivers := make(map[string]csi.Client, len(endpoints))
-	for _, endpoint := range endpoints {
-		client, err := csi.NewClient(endpoint, logger)
+	for drivername, driverEndpoints := range endpoints {
+		// the limits apply to the driver whatever its number of sockets
+		client, err := newDriverClient(driverEndpoints, csi.NewLimiter(conf.CSIQPS, conf.CSIBurst), csi.NewConcurrencyLimit(conf.CSIConcurrency), logger)
 		if err != nil {
 			logAndExit(logger, "failed to create CSI client", err)
 		}
 		defer client.Close()
-		drivername, err := client.GetDriverName(context.Background(), logger)
+		drivers[drivername] = client
+	}
+	if auditLog != nil {
+		for name, client := range drivers {
+			drivers[name] = audit.Driver(client, auditLog)
+		}
+	}
+
+	taint, err := kubernetes.ParseTaint(conf.NodeTaint)
+	if err != nil {
+		logAndExit(logger, "failed to parse node taint", err)
+	}
+
+	repairFilesystems, err := conf.RepairFilesystemMap()
+	if err != nil {
+		logAndExit(logger, "failed to parse repair filesystems", err)
+	}
+	var repairer *repair.Repairer
+	if len(repairFilesystems) > 0 {
+		repairer = repair.NewRepairer(logger, repairFilesystems, conf.RepairUnmountTimeout, conf.RepairTimeout)
+	}
+
+	checkerNames := healthCheckerNames()
+	health, err := newHealthPipeline(logger, checkerNames)
+	if err != nil {
+		logAndExit(logger, "failed to configure health checkers", err)
+	}
+	var verifyCheckers []string
+	for _, name := range checkerNames {
+		if healthcheck.Live(name) {
+			verifyCheckers = append(verifyCheckers, name)
+		}
+	}
+	severityActions, err := severityActionMap()
+	if err != nil {
+		logAndExit(logger, "failed to parse severity actions", err)
+	}
+
+	for name, threshold := range map[string]int{"capacity": conf.CapacityThreshold, "inode": conf.InodeThreshold} {
+		if threshold < 0 || threshold > 100 {
+			logAndExit(logger, "invalid threshold", fmt.Errorf("%s threshold %d is not a percentage", name, threshold))
+		}
+	}
+	if conf.RecoveryCooldown < 0 || conf.RecoveryBackoffMax < conf.RecoveryCooldown {
+		logAndExit(logger, "invalid recovery backoff", fmt.Errorf("cooldown %s must not be negative nor exceed the maximum backoff %s",
+			conf.RecoveryCooldown, conf.RecoveryBackoffMax))
+	}
+	if conf.ReportFormat != reportJSON && conf.ReportFormat != reportYAML && conf.ReportFormat != reportCSV {
+		logAndExit(logger, "invalid report format", fmt.Errorf("--report-format must be %s, %s or %s, got %q", reportJSON, reportYAML, reportCSV, conf.ReportFormat))
+	}
+	if conf.VerifyTimeout < 0 {
+		logAndExit(logger, "invalid verify timeout", fmt.Errorf("--verify-timeout must not be negative, got %s", conf.VerifyTimeout))
+	}
+	if conf.KubeletDirect && conf.KubeletCAFile == "" {
+		logAndExit(logger, "invalid kubelet CA", fmt.Errorf("--kubelet-direct requires --kubelet-ca-file"))
+	}
+	if conf.PendingGracePeriod <= 0 {
+		// the period is also how recent the volume failure events must be
+		logAndExit(logger, "invalid pending grace period", fmt.Errorf("--pending-grace-period must be positive, got %s", conf.PendingGracePeriod))
+	}
+	if conf.LockTTL < minLockTTL {
+		// the lock is renewed every third of the TTL
+		logAndExit(logger, "invalid lock TTL", fmt.Errorf("--lock-ttl must be at least %s, got %s", minLockTTL, conf.LockTTL))
+	}
+	if conf.FullResyncInterval < 0 {
+		logAndExit(logger, "invalid full resync interval", fmt.Errorf("--full-resync-interval must not be negative, got %s", conf.FullResyncInterval))
+	}
+	if (conf.ProbeTLSCertFile == "") != (conf.ProbeTLSKeyFile == "") {
+		logAndExit(logger, "invalid probe TLS", fmt.Errorf("--probe-tls-cert-file and --probe-tls-key-file must be set together"))
+	}
+	if conf.ProbeClientCAFile != "" && conf.ProbeTLSCertFile == "" {
+		logAndExit(logger, "invalid probe TLS", fmt.Errorf("--probe-client-ca-file needs --probe-tls-cert-file"))
+	}
+	if conf.KubeAPIPageSize < 0 {
+		logAndExit(logger, "invalid API page size", fmt.Errorf("--kube-api-page-size must not be negative, got %d", conf.KubeAPIPageSize))
+	}
+	if conf.VolumeWorkers < 1 {
+		logAndExit(logger, "invalid volume workers", fmt.Errorf("--volume-workers must be positive, got %d", conf.VolumeWorkers))
+	}
+	if conf.RecoveryWorkers < 1 || conf.RecoveryRetries < 0 || conf.RecoveryRetryDelay <= 0 {
+		logAndExit(logger, "invalid recovery queue", fmt.Errorf("%d workers, %d retries and retry delay %s must be positive",
+			conf.RecoveryWorkers, conf.RecoveryRetries, conf.RecoveryRetryDelay))
+	}
+	if conf.CircuitBreakerThreshold < 0 {
+		logAndExit(logger, "invalid circuit breaker threshold", fmt.Errorf("%d is negative", conf.CircuitBreakerThreshold))
+	}
+	switch conf.Mode {
+	case "", modePlan, modeApply:
+	default:
+		logAndExit(logger, "invalid command", fmt.Errorf("unknown command %q, expected plan, apply or rbac", conf.Mode))
+	}
+	if !actionsEnabled() && !conf.Quarantine && conf.Mode == "" {
+		logger.Warn("recovery actions are disabled, abnormal volumes are only reported; enable them with --enable-actions")
+	}
+	if conf.Quarantine && conf.Mode == modeApply {
+		logAndExit(logger, "invalid command", fmt.Errorf("apply recovers volumes, it can't run in quarantine mode"))
+	}
+	if conf.MaxConcurrentRecoveries < 0 || conf.NamespaceBudget < 0 {
+		logAndExit(logger, "invalid recovery budget", fmt.Errorf("budgets %d and %d must not be negative",
+			conf.MaxConcurrentRecoveries, conf.NamespaceBudget))
+	}
+	namespaceBudgets, err := conf.NamespaceBudgetMap()
+	if err != nil {
+		logAndExit(logger, "failed to parse namespace budgets", err)
+	}
+	protectedNamespaces := map[string]bool{}
+	if !conf.IncludeSystemNamespaces {
+		for _, namespace := range conf.ProtectedNamespaceList() {
+			protectedNamespaces[namespace] = true
+		}
+	}
+	if conf.HistorySize <= 0 {
+		logAndExit(logger, "invalid history size", fmt.Errorf("%d is not positive", conf.HistorySize))
+	}
+	if conf.DriverActionTimeout <= 0 {
+		logAndExit(logger, "invalid driver action timeout", fmt.Errorf("%s is not positive", conf.DriverActionTimeout))
+	}
+	builtins := recovery.NewDefaultRegistry(logger, kubeClient, conf.DriverActionTimeout)
+	actions, err := builtins.Subset(conf.RecoveryActionList())
+	if err != nil {
+		logAndExit(logger, "failed to parse recovery actions", err)
+	}
+	driverActions, err := driverActionLadders(builtins, actions)
+	if err != nil {
+		logAndExit(logger, "failed to parse driver recovery actions", err)
+	}
+	var preHook, postHook hooks.Hook
+	if conf.PreRecoveryHook != "" {
+		if preHook, err = hooks.Parse(conf.PreRecoveryHook, conf.HookTimeout); err != nil {
+			logAndExit(logger, "failed to parse pre-recovery hook", err)
+		}
+	}
+	if conf.PostRecoveryHook != "" {
+		if postHook, err = hooks.Parse(conf.PostRecoveryHook, conf.HookTimeout); err != nil {
+			logAndExit(logger, "failed to parse post-recovery hook", err)
+		}
+	}
+	notifier, err := newNotifier(logger)
+	if err != nil {
+		logAndExit(logger, "failed to configure notifications", err)
+	}
+	if notifier != nil {
+		notifier.Start()
+		defer notifier.Close()
+	}
+	windows, err := schedule.ParseWindows(conf.MaintenanceWindows)
+	if err != nil {
+		logAndExit(logger, "failed to parse maintenance windows", err)
+	}
+	if conf.ExpandPercent <= 0 {
+		logAndExit(logger, "invalid expand percentage", fmt.Errorf("%d is not positive", conf.ExpandPercent))
+	}
+	var expandLimit resource.Quantity
+	if conf.ExpandLimit != "" {
+		expandLimit, err = resource.ParseQuantity(conf.ExpandLimit)
 		if err != nil {
-			logAndExit(logger, "failed to get driver name", err)
+			logAndExit(logger, "failed to parse expand limit", err)
 		}
-		drivers[drivername] = client
 	}
-	for name, client := range drivers {
-		healthy, err := client.IsHealthy(context.Background(), logger)
+
+	var volumeClient volume.Volume
+	switch conf.VolumeSource {
+	case "api":
+		volumeClient = volume.NewKubeVolumeClient(kubeClient, conf.KubeletPath)
+	case "kubelet":
+		volumeClient = volume.NewLocalHost(conf.KubeletPath)
+	default:
+		logAndExit(logger, "unsupported volume source", fmt.Errorf("%q is neither api nor kubelet", conf.VolumeSource))
+	}
+	if conf.VolumeCacheTTL > 0 {
+		volumeClient = volume.NewCachedVolume(volumeClient, conf.VolumeCacheTTL, conf.VolumeCacheNegativeTTL)
+	}
+
+	r := &runner{
+		logger:                 logger,
+		kubeClient:             kubeClient,
+		volumeClient:           volumeClient,
+		scanner:                volume.NewScanner(conf.KubeletPath),
+		drivers:                drivers,
+		repairer:               repairer,
+		health:                 health,
+		verifyCheckers:         verifyCheckers,
+		severityActions:        severityActions,
+		actions:                actions,
+		driverActions:          driverActions,
+		driverSecrets:          secretRefs,
+		expandLimit:            expandLimit,
+		taint:                  taint,
+		driverFailures:         map[string]int{},
+		volumeFailures:         map[string]int{},
+		attempted:              map[string]bool{},
+		backoff:                map[string]*volumeBackoff{},
+		circuitFailures:        map[string]int{},
+		driverRecoveryFailures: map[string]int{},
+		openDrivers:            map[string]bool{},
+		namespaceBudgets:       namespaceBudgets,
+		protectedNamespaces:    protectedNamespaces,
+		windows:                windows,
+		preHook:                preHook,
+		postHook:               postHook,
+		notifier:               notifier,
+		notified:               map[string]bool{},
+		conditionEvents:        map[string]bool{},
+		namespaceRecoveries:    map[string][]time.Time{},
+		passScopes:             map[string]string{},
+		queue:                  newRecoveryQueue(),
+		work:                   map[string]*recoveryWork{},
+		passOwners:             map[string]string{},
+		abnormal:               map[string]string{},
+		healthyStats:           map[string]string{},
+		metrics:                newRecoveryMetrics(),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// a plan changes nothing, the leftovers are cleaned up when applying
+	if conf.Mode != modePlan {
+		r.cleanupPreviousRun(ctx)
+		r.startWorkers(ctx)
+		defer r.queue.ShutDownWithDrain()
+	}
+
+	switch conf.Mode {
+	case modePlan:
+		r.plan = &recoveryPlan{Node: conf.NodeName, Created: time.Now().UTC()}
+		if err := r.runPass(ctx); err != nil {
+			logAndExit(logger, "plan pass failed", err)
+		}
+		if err := writePlan(conf.PlanFile, r.plan); err != nil {
+			logAndExit(logger, "failed to write plan", err)
+		}
+		return
+	case modeApply:
+		plan, err := readPlan(conf.PlanFile)
 		if err != nil {
-			logger.Error("failed to check if the node service is healthy", "driver", name, "error", err)
-			continue
+			logAndExit(logger, "failed to read plan", err)
 		}
-		if !healthy {
-			logger.Error("node service is not healthy", "driverName", name)
-			continue
+		if err := r.applyPlan(ctx, plan); err != nil {
+			logAndExit(logger, "failed to apply plan", err)
 		}
+		r.drain(ctx)
+		return
 	}
 
-	client := volume.NewKubeVolumeClient(kubeClient)
+	if conf.Interval <= 0 {
+		if err := r.runPass(ctx); err != nil {
+			logAndExit(logger, "recovery pass failed", err)
+		}
+		r.drain(ctx)
+		return
+	}
 
-	for i := range metrics.Pods {
-		podName := metrics.Pods[i].PodRef.Name
-		podUUID := metrics.Pods[i].PodRef.UID
-		for j := range metrics.Pods[i].VolumeStats {
-			pvcRef := metrics.Pods[i].VolumeStats[j].PVCRef
-			if pvcRef == nil {
-				continue
-			}
-			driver, err := client.GetDriverName(context.Background(), podUUID, podName, pvcRef.Name, pvcRef.Namespace)
-			if err != nil {
-				logger.Error("failed to get driver name", "error", err)
-				continue
-			}
-			client, ok := drivers[driver]
-			if !ok {
-				logger.Info("driver not found", "driver", driver)
-				continue
-			}
-			ok, err = client.NodeSupportsVolumeCondition(context.Background(), logger)
-			if err != nil {
-				logger.Error("failed to check if the node supports volume condition", "driver", driver, "error", err)
-				continue
-			}
-			if !ok {
-				logger.Info("node does not support volume condition", "driver", driver)
-				continue
-			}
-			ok, err = client.NodeSupportsStageUnstage(context.Background(), logger)
-			if err != nil {
-				logger.Error("failed to check if the node supports stage unstage", "driver", driver, "error", err)
-				continue
-			}
-			logger.Info("node supports volume condition and stage unstage", "driver", driver)
-			if !ok {
-				logger.Info("node does not support stage unstage", "driver", driver)
-				err = kubeClient.RestartPod(context.Background(), pvcRef.Namespace, podName)
-				if err != nil {
-					logger.Error("failed to restart pod", "error", err)
-				}
-				continue
-			} else {
-				logger.Info("node supports stage unstage", "driver", driver)
-				err = kubeClient.ScaleOwner(pvcRef.Namespace, podName, 0)
-				if err != nil {
-					logger.Error("failed to scale owner", "error", err)
-				}
-			}
+	if conf.ProbeAddress != "" {
+		if err := r.serveProbes(ctx, conf.ProbeAddress); err != nil {
+			logAndExit(logger, "failed to serve probes", err)
+		}
+	}
+	// daemon mode, serve the lookups from informer caches to keep the API
+	// server load independent of the number of volumes
+	if err := kubeClient.StartInformers(ctx); err != nil {
+		logAndExit(logger, "failed to start informers", err)
+	}
+	r.started.Store(true)
+	logger.Info("running in daemon mode", "interval", conf.Interval)
+	for {
+		if err := r.runPass(ctx); err != nil {
+			logger.Error("recovery pass failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(conf.Interval):
 		}
 	}
 }
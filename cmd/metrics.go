@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+	"github.com/Madhu-1/csi-volume-recovery/internal/metrics"
+)
+
+// recoveryMetrics are the metrics of the recovery served on /metrics, all
+// labelled the same way so they can be broken down per driver and namespace
+type recoveryMetrics struct {
+	registry *metrics.Registry
+	// unhealthy is the number of the volumes found abnormal by the last
+	// pass, counted in passUnhealthy during the pass
+	unhealthy     *metrics.Vec
+	passUnhealthy map[driverNamespace]int
+	detections    *metrics.Vec
+	actions       *metrics.Vec
+	duration      *metrics.Vec
+	driverHealthy *metrics.Vec
+	passes        *metrics.Vec
+}
+
+// driverNamespace is the driver and the namespace of a volume
+type driverNamespace struct {
+	driver    string
+	namespace string
+}
+
+func newRecoveryMetrics() *recoveryMetrics {
+	registry := metrics.NewRegistry()
+	return &recoveryMetrics{
+		registry: registry,
+		unhealthy: registry.Gauge("csi_volume_recovery_unhealthy_volumes",
+			"Number of volumes found abnormal by the last pass.", metrics.LabelDriver, metrics.LabelNamespace),
+		passUnhealthy: map[driverNamespace]int{},
+		detections: registry.Counter("csi_volume_recovery_abnormal_volumes_total",
+			"Number of times volumes were found abnormal.", metrics.LabelDriver, metrics.LabelNamespace),
+		actions: registry.Counter("csi_volume_recovery_actions_total",
+			"Number of recovery actions run on volumes, by result.",
+			metrics.LabelDriver, metrics.LabelNamespace, metrics.LabelAction, metrics.LabelResult),
+		duration: registry.Counter("csi_volume_recovery_action_duration_seconds_total",
+			"Time spent in recovery actions, verification included.",
+			metrics.LabelDriver, metrics.LabelNamespace, metrics.LabelAction, metrics.LabelResult),
+		driverHealthy: registry.Gauge("csi_volume_recovery_driver_healthy",
+			"Whether the node service of the CSI driver was healthy in the last pass.", metrics.LabelDriver),
+		passes: registry.Counter("csi_volume_recovery_passes_total",
+			"Number of recovery passes, by result.", metrics.LabelResult),
+	}
+}
+
+// observeAbnormal counts the abnormal volume; the caller holds r.mu
+func (m *recoveryMetrics) observeAbnormal(driver, namespace string) {
+	m.detections.Inc(driver, namespace)
+	m.passUnhealthy[driverNamespace{driver: driver, namespace: namespace}]++
+}
+
+// observeAction counts the recovery action and the time it took
+func (m *recoveryMetrics) observeAction(driver, namespace, action string, seconds float64, err error) {
+	result := resultSucceeded
+	switch {
+	case errors.Is(err, kubernetes.ErrRecoverySkipped):
+		result = resultSkipped
+	case err != nil:
+		result = resultFailed
+	}
+	m.actions.Inc(driver, namespace, action, result)
+	m.duration.Add(seconds, driver, namespace, action, result)
+}
+
+// boolValue is the value of a boolean gauge
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// observePass counts the pass and publishes the volumes it found abnormal;
+// the caller holds r.mu
+func (m *recoveryMetrics) observePass(err error) {
+	result := resultSucceeded
+	if err != nil {
+		result = resultFailed
+	}
+	m.passes.Inc(result)
+	m.unhealthy.Reset()
+	for key, count := range m.passUnhealthy {
+		m.unhealthy.Set(float64(count), key.driver, key.namespace)
+	}
+	clear(m.passUnhealthy)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/Madhu-1/csi-volume-recovery/internal/mount"
+)
+
+// checkMounts cross-checks the mount points of the CSI volumes found in the
+// kubelet directory against the mount table and reports the volumes whose
+// mounts are missing, duplicated or errored, failures the kubelet summary
+// doesn't reveal.
+func (r *runner) checkMounts() {
+	mounts, err := mount.ReadMountInfo(mount.DefaultMountInfoPath)
+	if err != nil {
+		r.logger.Error("failed to read mount table", "error", err)
+		return
+	}
+	inspector := mount.NewInspector(mounts)
+
+	podUIDs, err := r.scanner.Pods()
+	if err != nil {
+		r.logger.Error("failed to list pod directories", "error", err)
+		return
+	}
+	for _, podUID := range podUIDs {
+		volumes, err := r.scanner.PodVolumes(podUID)
+		if err != nil {
+			r.logger.Error("failed to scan pod volumes", "podUID", podUID, "error", err)
+			continue
+		}
+		for _, vol := range volumes {
+			finding := inspector.Check(vol.MountPath)
+			if finding == nil {
+				continue
+			}
+			r.logger.Error("volume mount is unhealthy", "podUID", podUID, "volume", vol.Name,
+				"driver", vol.DriverName, "path", finding.Path, "problem", finding.Problem, "error", finding.Err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// maxConditionVolumes limits the volumes listed in the node condition message
+const maxConditionVolumes = 10
+
+// recordAbnormalVolume remembers the abnormal volume for the node condition
+// of the pass
+func (r *runner) recordAbnormalVolume(key, severity string) {
+	r.abnormal[key] = severity
+}
+
+// updateNodeCondition sets the node condition to true while volumes are
+// abnormal or drivers fail their health checks, and to false once they are
+// healthy again.
+func (r *runner) updateNodeCondition(ctx context.Context) {
+	var problems []string
+	for key, severity := range r.abnormal {
+		problems = append(problems, fmt.Sprintf("volume %s is %s", key, severity))
+	}
+	for driver, failures := range r.driverFailures {
+		if failures > 0 {
+			problems = append(problems, fmt.Sprintf("driver %s is unhealthy", driver))
+		}
+	}
+	clear(r.abnormal)
+
+	condition := v1.NodeCondition{
+		Type:    v1.NodeConditionType(conf.NodeCondition),
+		Status:  v1.ConditionFalse,
+		Reason:  "CSIVolumesHealthy",
+		Message: "all CSI volumes and drivers are healthy",
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		if len(problems) > maxConditionVolumes {
+			problems = append(problems[:maxConditionVolumes], fmt.Sprintf("and %d more", len(problems)-maxConditionVolumes))
+		}
+		condition.Status = v1.ConditionTrue
+		condition.Reason = "CSIVolumesAbnormal"
+		condition.Message = strings.Join(problems, ", ")
+	}
+	if err := r.kubeClient.SetNodeCondition(ctx, condition); err != nil {
+		r.logger.Error("failed to set node condition", "condition", conf.NodeCondition, "error", err)
+	}
+}
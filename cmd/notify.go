@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/healthcheck"
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+	"github.com/Madhu-1/csi-volume-recovery/internal/notify"
+)
+
+// newNotifier returns the notifier of the configured sinks, nil when there is
+// none
+func newNotifier(logger *slog.Logger) (*notify.Notifier, error) {
+	var sinks []notify.Sink
+	for _, url := range conf.NotifyWebhookList() {
+		sinks = append(sinks, notify.Webhook(url))
+	}
+	if conf.NotifySlackWebhook != "" {
+		sinks = append(sinks, notify.Slack(conf.NotifySlackWebhook))
+	}
+	for _, path := range conf.NotifyWebhookFileList() {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("failed to find notification webhook file: %w", err)
+		}
+		sinks = append(sinks, notify.WebhookFile(path))
+	}
+	if conf.NotifySlackWebhookFile != "" {
+		if _, err := os.Stat(conf.NotifySlackWebhookFile); err != nil {
+			return nil, fmt.Errorf("failed to find Slack webhook file: %w", err)
+		}
+		sinks = append(sinks, notify.SlackFile(conf.NotifySlackWebhookFile))
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return notify.NewNotifier(logger, sinks, conf.NotifyEventList(), conf.NotifyTemplate, conf.HookTimeout)
+}
+
+// notification describes the event of the volume
+func notification(event string, pv podVolume, driver string) notify.Notification {
+	return notify.Notification{
+		Event:     event,
+		Node:      conf.NodeName,
+		Driver:    driver,
+		Namespace: pv.namespace,
+		Pod:       pv.podName,
+		PVC:       pv.pvcName,
+		Volume:    pv.key(),
+	}
+}
+
+// notifyDetection notifies the abnormal volume, once until it is healthy
+// again; the caller holds r.mu
+func (r *runner) notifyDetection(pv podVolume, driver string, verdict healthcheck.Verdict) {
+	if r.notifier == nil || r.plan != nil || r.notified[pv.key()] {
+		return
+	}
+	r.notified[pv.key()] = true
+	n := notification(notify.EventDetected, pv, driver)
+	n.Severity = verdict.Severity().String()
+	n.Reason = verdict.Reason()
+	r.notifier.Notify(n)
+}
+
+// notifyAction notifies the outcome of the recovery action, skipped actions
+// aren't notified
+func (r *runner) notifyAction(pv podVolume, driver, action string, err error) {
+	if r.notifier == nil || errors.Is(err, kubernetes.ErrRecoverySkipped) {
+		return
+	}
+	n := notification(notify.EventRecovered, pv, driver)
+	n.Action = action
+	if err != nil {
+		n.Event = notify.EventFailed
+		n.Error = err.Error()
+	}
+	r.notifier.Notify(n)
+}
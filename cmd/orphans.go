@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/mount"
+	v1 "k8s.io/api/core/v1"
+	v1alpha1 "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+)
+
+// checkOrphans finds the pod directories of pods which no longer exist but
+// still hold CSI volumes, the "orphaned pod found" kubelet error, and cleans
+// them up when enabled. A directory is only orphaned when neither the API
+// server nor the kubelet summary of the pass know its pod, and it is only
+// cleaned up once found orphaned by two consecutive passes: a pod created
+// after the pods were listed, or missing from a partial listing, would lose
+// its volumes otherwise.
+func (r *runner) checkOrphans(ctx context.Context, metrics *v1alpha1.Summary) {
+	pods, err := r.kubeClient.ListNodePods(ctx)
+	if err != nil {
+		r.logger.Error("failed to list pods for orphan detection", "error", err)
+		return
+	}
+	if len(pods) == 0 {
+		// a node runs at least the pod of the tool, the node name is wrong
+		// or the listing came back empty
+		r.logger.Warn("no pods listed on the node, not looking for orphans", "node", conf.NodeName)
+		return
+	}
+	existing := make(map[string]bool, len(pods))
+	for i := range pods {
+		existing[string(pods[i].UID)] = true
+		// the kubelet keeps the directory of a static pod under the UID of
+		// its manifest, the hash its mirror pod is annotated with, not
+		// under the UID the API server gave the mirror
+		if hash := pods[i].Annotations[v1.MirrorPodAnnotationKey]; hash != "" {
+			existing[hash] = true
+		}
+	}
+	for i := range metrics.Pods {
+		existing[metrics.Pods[i].PodRef.UID] = true
+	}
+
+	podUIDs, err := r.scanner.Pods()
+	if err != nil {
+		r.logger.Error("failed to list pod directories", "error", err)
+		return
+	}
+	orphans := map[string]bool{}
+	for _, podUID := range podUIDs {
+		if existing[podUID] {
+			continue
+		}
+		volumes, err := r.scanner.PodVolumes(podUID)
+		if err != nil {
+			r.logger.Error("failed to scan pod volumes", "podUID", podUID, "error", err)
+			continue
+		}
+		if len(volumes) == 0 {
+			continue
+		}
+		orphans[podUID] = true
+		r.logger.Info("found orphaned pod directory with CSI volumes", "podUID", podUID, "volumes", len(volumes))
+		if !r.orphans[podUID] || !conf.CleanupOrphans || !actionsEnabled() || r.plan != nil {
+			continue
+		}
+		dir := filepath.Join(conf.KubeletPath, "pods", podUID)
+		if err := r.cleanupOrphan(dir); err != nil {
+			r.logger.Error("failed to clean up orphaned pod directory", "podUID", podUID, "error", err)
+			continue
+		}
+		delete(orphans, podUID)
+		r.logger.Info("cleaned up orphaned pod directory", "podUID", podUID)
+	}
+	r.orphans = orphans
+}
+
+// cleanupOrphan unmounts everything mounted below the pod directory and
+// removes it. The directory is only removed once the mount table confirms
+// nothing is mounted below it anymore, so no volume data can be deleted.
+func (r *runner) cleanupOrphan(dir string) error {
+	mounts, err := mount.ReadMountInfo(mount.DefaultMountInfoPath)
+	if err != nil {
+		return err
+	}
+	for _, path := range mount.MountPointsUnder(mounts, dir) {
+		if err := mount.Unmount(path); err != nil {
+			return err
+		}
+	}
+
+	mounts, err = mount.ReadMountInfo(mount.DefaultMountInfoPath)
+	if err != nil {
+		return err
+	}
+	if mount.NewInspector(mounts).MountedUnder(dir) {
+		return fmt.Errorf("%s still has mounts after unmounting", dir)
+	}
+	return os.RemoveAll(dir)
+}
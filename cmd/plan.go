@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/healthcheck"
+	"github.com/Madhu-1/csi-volume-recovery/internal/recovery"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// Modes selected by the first argument
+const (
+	// modePlan runs a pass without acting and writes the recoveries it
+	// would make to the plan file
+	modePlan = "plan"
+	// modeApply makes exactly the recoveries of the plan file
+	modeApply = "apply"
+)
+
+// recoveryPlan is the structured list of the recoveries a pass would make,
+// written for review before it is applied
+type recoveryPlan struct {
+	Node    string     `json:"node"`
+	Created time.Time  `json:"created"`
+	Items   []planItem `json:"items"`
+}
+
+// planItem is the recovery of a volume
+type planItem struct {
+	Volume     string `json:"volume"`
+	Driver     string `json:"driver"`
+	Namespace  string `json:"namespace"`
+	Pod        string `json:"pod"`
+	PodUID     string `json:"podUID"`
+	PVC        string `json:"pvc,omitempty"`
+	VolumeName string `json:"volumeName,omitempty"`
+	Pending    bool   `json:"pending,omitempty"`
+	Severity   string `json:"severity,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	// Actions is the escalation ladder, tried in order
+	Actions []string `json:"actions"`
+}
+
+// add plans the recovery of the volume with the actions
+func (p *recoveryPlan) add(pv podVolume, driver string, verdict healthcheck.Verdict, actions []recovery.Action) {
+	item := planItem{
+		Volume:     pv.key(),
+		Driver:     driver,
+		Namespace:  pv.namespace,
+		Pod:        pv.podName,
+		PodUID:     pv.podUID,
+		PVC:        pv.pvcName,
+		VolumeName: pv.volumeName,
+		Pending:    pv.pending,
+	}
+	if verdict.Abnormal() {
+		item.Severity = verdict.Severity().String()
+		item.Reason = verdict.Reason()
+	}
+	for _, a := range actions {
+		item.Actions = append(item.Actions, a.Name())
+	}
+	p.Items = append(p.Items, item)
+}
+
+// writePlan writes the plan to the file, or to the standard output for "-"
+func writePlan(path string, plan *recoveryPlan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write plan %s: %w", path, err)
+	}
+	return nil
+}
+
+// readPlan reads the plan file
+func readPlan(path string) (*recoveryPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan %s: %w", path, err)
+	}
+	var plan recoveryPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %w", path, err)
+	}
+	return &plan, nil
+}
+
+// applyPlan makes the recoveries of the plan. The health of the volumes isn't
+// checked again, but the recovery of a pod which was replaced since the plan
+// was made is skipped, and so are the actions which no longer apply.
+func (r *runner) applyPlan(ctx context.Context, plan *recoveryPlan) error {
+	if plan.Node != conf.NodeName {
+		return fmt.Errorf("plan was made for node %s, not %s", plan.Node, conf.NodeName)
+	}
+	defer r.uncordon(context.WithoutCancel(ctx))
+
+	pods, err := r.kubeClient.ListNodePods(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list pods on the node: %w", err)
+	}
+	byUID := make(map[string]*v1.Pod, len(pods))
+	for i := range pods {
+		byUID[string(pods[i].UID)] = &pods[i]
+	}
+	var attachments map[string][]storagev1.VolumeAttachment
+	if conf.CleanupVolumeAttachments {
+		attachments, err = r.volumeAttachments(ctx)
+		if err != nil {
+			r.logger.Error("failed to list volume attachments", "error", err)
+		}
+	}
+	r.mu.Lock()
+	if reporting() {
+		// written once the recoveries are drained
+		r.startReport()
+		r.report.Examined = len(plan.Items)
+	}
+	for _, item := range plan.Items {
+		r.applyItem(ctx, item, byUID[item.PodUID], pods, attachments[item.Driver])
+	}
+	r.mu.Unlock()
+	r.pending.Wait()
+	return nil
+}
+
+// applyItem makes the planned recovery of a volume
+func (r *runner) applyItem(ctx context.Context, item planItem, pod *v1.Pod, pods []v1.Pod, attachments []storagev1.VolumeAttachment) {
+	logger := r.logger.With("volume", item.Volume)
+	if pod == nil || pod.DeletionTimestamp != nil {
+		logger.Info("skipping planned recovery, pod is gone or terminating", "pod", item.Pod, "podUID", item.PodUID)
+		return
+	}
+	if r.protectedNamespaces[item.Namespace] {
+		logger.Warn("skipping planned recovery, namespace is protected", "namespace", item.Namespace)
+		return
+	}
+	client, ok := r.drivers[item.Driver]
+	if !ok {
+		logger.Error("skipping planned recovery, driver not found", "driver", item.Driver)
+		return
+	}
+	pv := podVolume{
+		namespace:  item.Namespace,
+		podName:    item.Pod,
+		podUID:     item.PodUID,
+		pvcName:    item.PVC,
+		volumeName: item.VolumeName,
+		pending:    item.Pending,
+	}
+	if pv.inline() {
+		pv.driver = item.Driver
+	}
+	setPodDetails(&pv, pod, pods)
+	if pv.mirror && !conf.RemountStaticPods {
+		logger.Warn("skipping planned recovery of the volume of a static pod", "pod", item.Pod)
+		return
+	}
+	var info *volume.VolumeInfo
+	if !pv.pending {
+		var err error
+		if info, err = r.locateVolume(ctx, pv); err != nil {
+			logger.Error("skipping planned recovery, failed to locate volume", "error", err)
+			return
+		}
+	}
+	if action := r.recoveredBy(ctx, pv, info); action != "" {
+		logger.Info("skipping planned recovery, pod or volume already recovered", "action", action)
+		return
+	}
+	target, err := r.recoveryTarget(ctx, client, item.Driver, pv, info, attachments)
+	if err != nil {
+		logger.Error("failed to check if the node supports stage unstage", "driver", item.Driver, "error", err)
+		return
+	}
+	var actions []recovery.Action
+	for _, name := range item.Actions {
+		action := r.actions.Get(name)
+		switch {
+		case action == nil:
+			logger.Error("skipping planned action, it isn't enabled", "action", name)
+		case !action.CanHandle(target):
+			logger.Info("skipping planned action, it no longer applies", "action", name)
+		default:
+			actions = append(actions, action)
+		}
+	}
+	logger.Info("applying planned recovery", "actions", item.Actions)
+	r.enqueue(ctx, pv, target, actions)
+}
+
+// actionsEnabled reports whether the recovery actions may run: with
+// --enable-actions, or when applying a plan an operator reviewed
+func actionsEnabled() bool {
+	return conf.EnableActions || conf.Mode == modeApply
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// probeTimeout bounds the checks made by the readiness probe
+const probeTimeout = 5 * time.Second
+
+// serveProbes serves the liveness and readiness probes of the daemon until
+// the context is done. /healthz answers as long as the process serves
+// requests, /readyz once the informers are synced, the API server is reachable
+// and at least one CSI driver is healthy. The metrics are served on /metrics
+// and the report of the last pass on /status, both only to authenticated
+// clients when authentication is configured.
+func (r *runner) serveProbes(ctx context.Context, address string) error {
+	tlsConfig, err := servingTLS()
+	if err != nil {
+		return err
+	}
+	token, err := servingToken()
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		if err := r.ready(req.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.Handle("/metrics", authorized(token, r.metrics.registry))
+	mux.Handle("/status", authorized(token, http.HandlerFunc(r.serveStatus)))
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: probeTimeout, TLSConfig: tlsConfig}
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			// the certificate is in the TLS configuration
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			r.logger.Error("failed to serve probes", "address", address, "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), probeTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	r.logger.Info("serving probes", "address", address, "tls", tlsConfig != nil)
+	return nil
+}
+
+// ready reports why the daemon isn't ready, nil when it is
+func (r *runner) ready(ctx context.Context) error {
+	if !r.started.Load() {
+		return errors.New("informers not synced")
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	if err := r.kubeClient.APIServerReady(ctx); err != nil {
+		return err
+	}
+	if len(r.drivers) == 0 {
+		return errors.New("no CSI driver configured")
+	}
+	var errs []error
+	for name, client := range r.drivers {
+		healthy, err := client.IsHealthy(ctx, r.logger)
+		if err == nil && healthy {
+			return nil
+		}
+		if err == nil {
+			err = errors.New("node service is not healthy")
+		}
+		errs = append(errs, fmt.Errorf("driver %s: %w", name, err))
+	}
+	return fmt.Errorf("no healthy CSI driver: %w", errors.Join(errs...))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/recovery"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+	v1 "k8s.io/api/core/v1"
+)
+
+// publishDetails fills in what the driver needs to stage and publish the
+// volume again. When the PV or the secrets it references can't be found, the
+// actions calling the driver are left out of the recovery.
+func (r *runner) publishDetails(ctx context.Context, target *recovery.Target) {
+	if target.Inline || target.Info == nil {
+		return
+	}
+	pv, err := r.kubeClient.GetPV(ctx, target.Info.PersistentVolumeName)
+	if err != nil {
+		r.logger.Error("failed to get PV, not publishing the volume again", "error", err)
+		return
+	}
+	if pv.Spec.CSI == nil {
+		return
+	}
+	publishContext, err := r.kubeClient.PublishContext(ctx, target.Driver, pv.Name, target.Info.AttachmentID)
+	if err != nil {
+		r.logger.Error("failed to get the publish context, not publishing the volume again", "pv", pv.Name, "error", err)
+		return
+	}
+	podInfo, err := r.kubeClient.PodInfoOnMount(ctx, target.Driver)
+	if err != nil {
+		r.logger.Error("failed to get the CSIDriver, not publishing the volume again", "driver", target.Driver, "error", err)
+		return
+	}
+	// the volume must not be unstaged or unpublished when the driver
+	// can't be given the secrets to stage and publish it again
+	stageSecrets, err := r.volumeSecret(ctx, pv.Spec.CSI.NodeStageSecretRef)
+	if err != nil {
+		r.logger.Error("failed to get the node stage secret, not publishing the volume again", "pv", pv.Name, "error", err)
+		return
+	}
+	publishSecrets, err := r.volumeSecret(ctx, pv.Spec.CSI.NodePublishSecretRef)
+	if err != nil {
+		r.logger.Error("failed to get the node publish secret, not publishing the volume again", "pv", pv.Name, "error", err)
+		return
+	}
+	// the volumes referencing no secret get the secret of their driver
+	if ref, ok := r.driverSecrets[target.Driver]; ok && (stageSecrets == nil || publishSecrets == nil) {
+		secrets, err := r.kubeClient.GetSecret(ctx, ref.Name, ref.Namespace)
+		if err != nil {
+			r.logger.Error("failed to get the driver secret, not publishing the volume again", "driver", target.Driver, "error", err)
+			return
+		}
+		if stageSecrets == nil {
+			stageSecrets = secrets
+		}
+		if publishSecrets == nil {
+			publishSecrets = secrets
+		}
+	}
+	target.PV = pv
+	target.PublishContext = publishContext
+	target.PodInfoOnMount = podInfo
+	target.StageSecrets = stageSecrets
+	target.PublishSecrets = publishSecrets
+}
+
+// volumeSecret returns the data of the secret a PV references, nil when it
+// references none
+func (r *runner) volumeSecret(ctx context.Context, ref *v1.SecretReference) (map[string]string, error) {
+	if ref == nil || ref.Name == "" {
+		return nil, nil
+	}
+	return r.kubeClient.GetSecret(ctx, ref.Name, ref.Namespace)
+}
+
+// sharedPublications returns the publications of the PVC's volume to the
+// other pods on the node, so it is recovered for all of them at once. It
+// returns nil when one of them hasn't published the volume, e.g. a pod still
+// starting, the volume can't be unstaged safely then.
+func (r *runner) sharedPublications(pv podVolume, info *volume.VolumeInfo) []recovery.Publication {
+	if info == nil || pv.inline() || len(pv.sharedBy) == 0 {
+		return nil
+	}
+	var shared []recovery.Publication
+	for _, pod := range pv.sharedBy {
+		volumes, err := r.scanner.PodVolumes(string(pod.UID))
+		if err != nil {
+			r.logger.Error("failed to scan volumes of pod sharing the volume", "pod", pod.Name, "namespace", pod.Namespace, "error", err)
+			return nil
+		}
+		found := false
+		for i := range volumes {
+			if volumes[i].PersistentVolumeName == info.PersistentVolumeName {
+				paths, propagated := containerMounts(pod, claimVolumeName(pod, pv.pvcName))
+				shared = append(shared, recovery.Publication{
+					Namespace:      pod.Namespace,
+					PodName:        pod.Name,
+					PodUID:         string(pod.UID),
+					ServiceAccount: pod.Spec.ServiceAccountName,
+					ReadOnly:       claimReadOnly(pod, pv.pvcName),
+					MountPath:      volumes[i].MountPath,
+					ContainerPaths: paths,
+					Propagated:     propagated,
+				})
+				found = true
+				break
+			}
+		}
+		if !found {
+			r.logger.Info("volume not published to pod sharing it", "pod", pod.Name, "namespace", pod.Namespace,
+				"pv", info.PersistentVolumeName)
+			return nil
+		}
+	}
+	return shared
+}
+
+// claimVolumeName returns the name of the pod volume of the PVC
+func claimVolumeName(pod *v1.Pod, claimName string) string {
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil && vol.PersistentVolumeClaim.ClaimName == claimName {
+			return vol.Name
+		}
+	}
+	return ""
+}
+
+// containerMounts returns where the containers of the pod mount the pod
+// volume, or the paths of its device for block volumes, and whether they all
+// see the mounts made on the host after they started, i.e. mount it with
+// HostToContainer or Bidirectional propagation. Init containers are done by
+// the time a volume is recovered.
+func containerMounts(pod *v1.Pod, volumeName string) ([]string, bool) {
+	var paths []string
+	propagated := true
+	for _, container := range pod.Spec.Containers {
+		for _, m := range container.VolumeMounts {
+			if m.Name != volumeName {
+				continue
+			}
+			paths = append(paths, m.MountPath)
+			if m.MountPropagation == nil || (*m.MountPropagation != v1.MountPropagationHostToContainer &&
+				*m.MountPropagation != v1.MountPropagationBidirectional) {
+				propagated = false
+			}
+		}
+		for _, d := range container.VolumeDevices {
+			if d.Name == volumeName {
+				paths = append(paths, d.DevicePath)
+			}
+		}
+	}
+	return paths, propagated
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/healthcheck"
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+)
+
+// quarantine marks the pod and the PVC of the abnormal volume for a manual
+// recovery instead of recovering it. With --cordon-node and --enable-actions
+// the node is cordoned too, and left cordoned until an operator uncordons it.
+func (r *runner) quarantine(ctx context.Context, pv podVolume, verdict healthcheck.Verdict) {
+	reason := verdict.Reason()
+	if pv.pending {
+		reason = "pod is stuck before its volumes were mounted"
+	}
+	quarantined, err := r.kubeClient.Quarantine(ctx, pv.namespace, pv.podName, pv.pvcName, reason)
+	if err != nil {
+		r.logger.Error("failed to quarantine volume", "volume", pv.key(), "error", err)
+	}
+	if !quarantined {
+		return
+	}
+	r.logger.Warn("quarantined volume, leaving its recovery to an operator", "volume", pv.key(),
+		"label", kubernetes.QuarantinedLabel, "reason", reason)
+	if !conf.CordonNode {
+		return
+	}
+	if !actionsEnabled() {
+		r.logger.Warn("recovery actions are disabled, not cordoning node; enable them with --enable-actions")
+		return
+	}
+	cordoned, err := r.kubeClient.CordonNode(ctx)
+	if err != nil {
+		r.logger.Error("failed to cordon node", "error", err)
+		return
+	}
+	if cordoned {
+		r.logger.Warn("cordoned node with a quarantined volume, uncordon it once the volume is recovered")
+	}
+}
+
+// needsIntervention quarantines the volume an action left half recovered, so
+// an operator finishes its recovery. The node stays cordoned if it was for
+// the recovery, new pods mustn't use the volume in the meantime.
+func (r *runner) needsIntervention(ctx context.Context, pv podVolume, err error) {
+	reason := "recovery failed midway: " + err.Error()
+	if _, err := r.kubeClient.Quarantine(ctx, pv.namespace, pv.podName, pv.pvcName, reason); err != nil {
+		r.logger.Error("failed to mark volume for manual intervention", "volume", pv.key(), "error", err)
+	}
+	r.logger.Warn("volume left for an operator to recover", "volume", pv.key(), "label", kubernetes.QuarantinedLabel)
+
+	r.nodeMu.Lock()
+	defer r.nodeMu.Unlock()
+	if r.cordoned {
+		r.logger.Warn("leaving node cordoned with a partially recovered volume, uncordon it once the volume is recovered")
+		r.cordoned = false
+	}
+}
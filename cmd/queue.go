@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/recovery"
+	"github.com/Madhu-1/csi-volume-recovery/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// recoveryWork is the recovery of a volume decided by a pass or a plan, made
+// by the workers of the recovery queue
+type recoveryWork struct {
+	pv      podVolume
+	target  *recovery.Target
+	actions []recovery.Action
+	// done is called once the recovery was attempted, the pass which
+	// queued it waits for it; nil for the retries
+	done func()
+	// span is the span of the pass or plan which queued the recovery
+	span trace.SpanContext
+}
+
+// newRecoveryQueue returns the queue of the volumes to recover, a failed
+// recovery is retried after a delay doubling with each failure of the volume
+func newRecoveryQueue() workqueue.TypedRateLimitingInterface[string] {
+	return workqueue.NewTypedRateLimitingQueueWithConfig(
+		workqueue.NewTypedItemExponentialFailureRateLimiter[string](conf.RecoveryRetryDelay, conf.RecoveryBackoffMax),
+		workqueue.TypedRateLimitingQueueConfig[string]{Name: "recovery"},
+	)
+}
+
+// startWorkers starts the workers making the queued recoveries, they stop when
+// the queue is shut down
+func (r *runner) startWorkers(ctx context.Context) {
+	for range conf.RecoveryWorkers {
+		go func() {
+			for r.processNext(ctx) {
+			}
+		}()
+	}
+}
+
+// queued reports whether a recovery of the volume is queued or being retried,
+// the caller holds r.mu
+func (r *runner) queued(key string) bool {
+	_, ok := r.work[key]
+	return ok
+}
+
+// enqueue queues the recovery of the volume, the pass or plan waits for it to
+// be attempted. The budgets are spent and the pod or owner marked as recovered
+// right away, so the rest of the pass takes the queued recoveries into
+// account; the caller holds r.mu.
+func (r *runner) enqueue(ctx context.Context, pv podVolume, target *recovery.Target, actions []recovery.Action) {
+	for _, action := range actions {
+		r.markRecovered(action, target)
+	}
+	r.spendBudget(pv.namespace)
+	r.pending.Add(1)
+	r.remaining.Add(1)
+	r.work[pv.key()] = &recoveryWork{
+		pv:      pv,
+		target:  target,
+		actions: actions,
+		done:    r.pending.Done,
+		span:    trace.SpanContextFromContext(ctx),
+	}
+	r.queue.Add(pv.key())
+}
+
+// processNext makes the next queued recovery, it returns false once the queue
+// is shut down
+func (r *runner) processNext(ctx context.Context) bool {
+	key, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(key)
+
+	r.mu.Lock()
+	work := r.work[key]
+	r.mu.Unlock()
+	if work == nil {
+		r.queue.Forget(key)
+		return true
+	}
+	retries := r.queue.NumRequeues(key)
+
+	// the first attempt is part of the pass, the retries are traced apart
+	ctx, span := tracing.StartLinked(ctx, "recovery.attempt", work.span, retries == 0,
+		tracing.VolumeKey.String(key),
+		tracing.RetryKey.Int(retries),
+	)
+	defer span.End()
+	r.startRecovery()
+	recovered, attempted, intervene := false, false, false
+	if retries == 0 || r.retryAllowed(ctx, work) {
+		recovered, attempted, intervene = r.escalate(ctx, work.actions, work.target, work.pv)
+	}
+	r.endRecovery(context.WithoutCancel(ctx), work.done == nil)
+	span.SetAttributes(tracing.RecoveredKey.Bool(recovered))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if work.done != nil {
+		work.done()
+		work.done = nil
+	}
+	if !recovered && attempted && !intervene && retries < conf.RecoveryRetries && ctx.Err() == nil {
+		r.logger.Info("recovery failed, retrying later", "volume", key, "retry", retries+1)
+		r.queue.AddRateLimited(key)
+		return true
+	}
+	if !recovered && attempted && !intervene {
+		r.logger.Warn("giving up recovering volume, leaving it to the next passes", "volume", key, "retries", retries)
+	}
+	r.queue.Forget(key)
+	delete(r.work, key)
+	r.remaining.Done()
+	return true
+}
+
+// drain waits for the queued recoveries, retries included, before a single
+// pass or an apply exits, and persists the history of the retries
+func (r *runner) drain(ctx context.Context) {
+	settled := make(chan struct{})
+	go func() {
+		r.remaining.Wait()
+		close(settled)
+	}()
+	select {
+	case <-settled:
+	case <-ctx.Done():
+	}
+	r.queue.ShutDownWithDrain()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushHistory(context.WithoutCancel(ctx))
+	// report the retries made after the pass, or the applied plan
+	if r.report != nil && len(r.report.Volumes) > 0 {
+		r.writeReport(nil)
+	}
+}
+
+// retryAllowed reports whether the failed recovery of the volume is still to
+// be retried: its pod must still be running and its recovery circuit closed
+func (r *runner) retryAllowed(ctx context.Context, work *recoveryWork) bool {
+	logger := r.logger.With("volume", work.pv.key())
+	pods, err := r.kubeClient.ListNodePods(ctx)
+	if err != nil {
+		logger.Error("failed to list pods on the node, not retrying recovery", "error", err)
+		return false
+	}
+	running := false
+	for i := range pods {
+		if string(pods[i].UID) == work.pv.podUID && pods[i].DeletionTimestamp == nil {
+			running = true
+		}
+	}
+	if !running {
+		logger.Info("pod is gone or terminating, not retrying recovery", "pod", work.pv.podName)
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	open, reason, err := r.circuitOpen(ctx, work.target.Driver, work.pv)
+	if err != nil {
+		logger.Error("failed to check the recovery circuit, not retrying recovery", "error", err)
+		return false
+	}
+	if open {
+		logger.Warn("recovery circuit open, not retrying recovery", "reason", reason)
+		return false
+	}
+	return true
+}
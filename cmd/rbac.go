@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+)
+
+// modeRBAC prints the RBAC objects the tool needs
+const modeRBAC = "rbac"
+
+// rbacRule is a rule of the generated ClusterRole, the permissions on a
+// resource merged
+type rbacRule struct {
+	group    string
+	resource string
+	verbs    []string
+	reasons  []string
+}
+
+// rbacRules returns the rules granting the permissions, in their order
+func rbacRules(permissions []kubernetes.Permission) []*rbacRule {
+	var rules []*rbacRule
+	byResource := map[string]*rbacRule{}
+	for _, p := range permissions {
+		resource := p.Resource
+		if p.Subresource != "" {
+			resource += "/" + p.Subresource
+		}
+		key := p.Group + "/" + resource
+		rule, ok := byResource[key]
+		if !ok {
+			rule = &rbacRule{group: p.Group, resource: resource}
+			byResource[key] = rule
+			rules = append(rules, rule)
+		}
+		rule.verbs = append(rule.verbs, p.Verb)
+		reason := p.Reason
+		if p.Optional {
+			reason += " (optional)"
+		}
+		rule.reasons = append(rule.reasons, p.Verb+": "+reason)
+	}
+	return rules
+}
+
+// writeRBAC writes the ServiceAccount, ClusterRole and ClusterRoleBinding
+// granting the permissions the tool uses, from the same list the permission
+// check at startup reviews. The optional permissions, needed by features
+// disabled by default, are only included with --rbac-optional. The tool
+// reads and recovers objects in any namespace and the node itself, so a
+// ClusterRole is needed rather than Roles.
+func writeRBAC(w io.Writer) error {
+	namespace, name, ok := strings.Cut(conf.RBACServiceAccount, "/")
+	if !ok || namespace == "" || name == "" {
+		return fmt.Errorf("--rbac-service-account must be namespace/name, got %q", conf.RBACServiceAccount)
+	}
+	var permissions []kubernetes.Permission
+	for _, p := range kubernetes.RequiredPermissions {
+		if !p.Optional || conf.RBACOptional {
+			permissions = append(permissions, p)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "apiVersion: v1\nkind: ServiceAccount\nmetadata:\n  name: %q\n  namespace: %q\n", name, namespace)
+	fmt.Fprintf(&b, "---\napiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: %q\nrules:\n", name)
+	for _, rule := range rbacRules(permissions) {
+		for _, reason := range rule.reasons {
+			fmt.Fprintf(&b, "  # %s\n", reason)
+		}
+		fmt.Fprintf(&b, "  - apiGroups: [%q]\n    resources: [%q]\n    verbs: [%s]\n", rule.group, rule.resource, quoteList(rule.verbs))
+	}
+	fmt.Fprintf(&b, "---\napiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRoleBinding\nmetadata:\n  name: %q\n", name)
+	fmt.Fprintf(&b, "roleRef:\n  apiGroup: rbac.authorization.k8s.io\n  kind: ClusterRole\n  name: %q\n", name)
+	fmt.Fprintf(&b, "subjects:\n  - kind: ServiceAccount\n    name: %q\n    namespace: %q\n", name, namespace)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// quoteList returns the strings as the items of a YAML flow sequence
+func quoteList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = fmt.Sprintf("%q", item)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+	"github.com/Madhu-1/csi-volume-recovery/internal/mount"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+)
+
+// repairHook returns the hook repairing the filesystem of the volume while
+// its owner is scaled down, or nil when repairs aren't enabled for the driver
+// and filesystem. The device is taken from the staging mount before the
+// kubelet unstages the volume and identified as the volume's, so the repair
+// never runs on another volume which got its name; volumes whose device can't
+// be identified aren't repaired.
+func (r *runner) repairHook(driver string, info *volume.VolumeInfo) kubernetes.ScaledDownHook {
+	if r.repairer == nil || info == nil || info.Block || info.StagingPath == "" {
+		return nil
+	}
+	mounts, err := mount.ReadMountInfo(mount.DefaultMountInfoPath)
+	if err != nil {
+		r.logger.Error("failed to read mount table", "error", err)
+		return nil
+	}
+	for _, m := range mounts {
+		if filepath.Clean(m.MountPoint) != filepath.Clean(info.StagingPath) {
+			continue
+		}
+		if !r.repairer.Enabled(driver, m.FSType) {
+			return nil
+		}
+		id, err := r.repairer.Identify(m.Source, info.VolumeHandle)
+		if err != nil {
+			r.logger.Info("not repairing the filesystem of the volume", "volume", info.PersistentVolumeName, "device", m.Source, "reason", err)
+			return nil
+		}
+		fsType := m.FSType
+		return func(ctx context.Context) error {
+			return r.repairer.Repair(ctx, driver, id, fsType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/healthcheck"
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Formats of the pass reports
+const (
+	reportJSON = "json"
+	reportYAML = "yaml"
+	reportCSV  = "csv"
+)
+
+// reportColumns is the header of the CSV reports
+var reportColumns = []string{"node", "pass_started", "volume", "driver", "namespace", "pod", "pvc", "severity", "reason",
+	"action", "action_started", "action_duration", "result", "error"}
+
+// passReport summarizes a pass for --report-file
+type passReport struct {
+	Node     string    `json:"node"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Duration string    `json:"duration"`
+	// Examined is the number of volumes checked by the pass, only the
+	// abnormal ones and those recovered are listed
+	Examined int             `json:"examined"`
+	Volumes  []*volumeReport `json:"volumes,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// volumeReport is an abnormal or recovered volume of the pass report
+type volumeReport struct {
+	Volume    string         `json:"volume"`
+	Driver    string         `json:"driver"`
+	Namespace string         `json:"namespace"`
+	Pod       string         `json:"pod"`
+	PVC       string         `json:"pvc,omitempty"`
+	Severity  string         `json:"severity,omitempty"`
+	Reason    string         `json:"reason,omitempty"`
+	Actions   []actionReport `json:"actions,omitempty"`
+}
+
+// actionReport is a recovery action of the pass report
+type actionReport struct {
+	Action   string    `json:"action"`
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// startReport starts the report of the pass, keeping the retries made since
+// the last one; the caller holds r.mu
+func (r *runner) startReport() {
+	if r.report == nil {
+		r.report = &passReport{}
+	}
+	r.report.Node = conf.NodeName
+	r.report.Started = time.Now().UTC()
+}
+
+// volumeReport returns the entry of the volume in the report, adding it if
+// needed
+func (r *runner) volumeReport(pv podVolume, driver string) *volumeReport {
+	for _, v := range r.report.Volumes {
+		if v.Volume == pv.key() {
+			return v
+		}
+	}
+	v := &volumeReport{
+		Volume:    pv.key(),
+		Driver:    driver,
+		Namespace: pv.namespace,
+		Pod:       pv.podName,
+		PVC:       pv.pvcName,
+	}
+	r.report.Volumes = append(r.report.Volumes, v)
+	return v
+}
+
+// reportDetection adds the abnormal volume to the report of the pass
+func (r *runner) reportDetection(pv podVolume, driver string, verdict healthcheck.Verdict) {
+	if r.report == nil {
+		return
+	}
+	v := r.volumeReport(pv, driver)
+	v.Severity = verdict.Severity().String()
+	v.Reason = verdict.Reason()
+}
+
+// reportAction adds the recovery action started at the time to the report of
+// the pass; the caller holds r.mu
+func (r *runner) reportAction(pv podVolume, driver, action string, started time.Time, err error) {
+	if r.report == nil {
+		return
+	}
+	entry := actionReport{
+		Action:   action,
+		Started:  started.UTC(),
+		Duration: time.Since(started).Round(time.Millisecond).String(),
+		Result:   resultSucceeded,
+	}
+	switch {
+	case errors.Is(err, kubernetes.ErrRecoverySkipped):
+		entry.Result = resultSkipped
+		entry.Error = err.Error()
+	case err != nil:
+		entry.Result = resultFailed
+		entry.Error = err.Error()
+	}
+	v := r.volumeReport(pv, driver)
+	v.Actions = append(v.Actions, entry)
+}
+
+// writeReport appends the report of the pass to the report file, keeps it for
+// /status and starts the next one; the caller holds r.mu
+func (r *runner) writeReport(passErr error) {
+	report := r.report
+	r.report = &passReport{}
+	if report.Started.IsZero() {
+		// retries made after a single pass
+		report.Node = conf.NodeName
+		report.Started = time.Now().UTC()
+	}
+	report.Finished = time.Now().UTC()
+	report.Duration = report.Finished.Sub(report.Started).Round(time.Millisecond).String()
+	if passErr != nil {
+		report.Error = passErr.Error()
+	}
+	r.lastReport.Store(report)
+	if conf.ReportFile == "" {
+		return
+	}
+	if err := appendReport(conf.ReportFile, conf.ReportFormat, report, !r.reportHeader); err != nil {
+		r.logger.Error("failed to write pass report", "file", conf.ReportFile, "error", err)
+		return
+	}
+	r.reportHeader = true
+}
+
+// appendReport appends the report to the file, "-" for the standard output,
+// as a JSON line, a YAML document or CSV rows. The CSV header is written
+// first to the standard output when header is set, and to empty files.
+func appendReport(path, format string, report *passReport, header bool) error {
+	var f *os.File
+	if path == "-" {
+		f = os.Stdout
+	} else {
+		var err error
+		f, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open report file %s: %w", path, err)
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat report file %s: %w", path, err)
+		}
+		header = info.Size() == 0
+	}
+	var data []byte
+	var err error
+	switch format {
+	case reportYAML:
+		data, err = yaml.Marshal(report)
+		data = append([]byte("---\n"), data...)
+	case reportCSV:
+		data, err = reportCSVRows(report, header)
+	default:
+		data, err = json.Marshal(report)
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if path == "-" {
+		_, err = f.Write(data)
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write report file %s: %w", path, err)
+	}
+	return nil
+}
+
+// reportCSVRows returns the report as CSV rows, one per recovery action and
+// one per abnormal volume left alone, so the reports of many nodes and passes
+// can be loaded in a spreadsheet
+func reportCSVRows(report *passReport, header bool) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if header {
+		if err := w.Write(reportColumns); err != nil {
+			return nil, err
+		}
+	}
+	started := report.Started.Format(time.RFC3339)
+	for _, v := range report.Volumes {
+		volume := []string{report.Node, started, v.Volume, v.Driver, v.Namespace, v.Pod, v.PVC, v.Severity, v.Reason}
+		if len(v.Actions) == 0 {
+			if err := w.Write(append(volume, "", "", "", "", "")); err != nil {
+				return nil, err
+			}
+		}
+		for _, a := range v.Actions {
+			row := append(slices.Clone(volume), a.Action, a.Started.Format(time.RFC3339), a.Duration, a.Result, a.Error)
+			if err := w.Write(row); err != nil {
+				return nil, err
+			}
+		}
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
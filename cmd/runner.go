@@ -0,0 +1,1102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/audit"
+	"github.com/Madhu-1/csi-volume-recovery/internal/csi"
+	"github.com/Madhu-1/csi-volume-recovery/internal/healthcheck"
+	"github.com/Madhu-1/csi-volume-recovery/internal/hooks"
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+	"github.com/Madhu-1/csi-volume-recovery/internal/notify"
+	"github.com/Madhu-1/csi-volume-recovery/internal/recovery"
+	"github.com/Madhu-1/csi-volume-recovery/internal/repair"
+	"github.com/Madhu-1/csi-volume-recovery/internal/schedule"
+	"github.com/Madhu-1/csi-volume-recovery/internal/tracing"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+	"github.com/Madhu-1/csi-volume-recovery/pkg"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/util/workqueue"
+	v1alpha1 "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+)
+
+// runner holds the clients shared by all the recovery passes
+type runner struct {
+	logger       *slog.Logger
+	kubeClient   kubernetes.Client
+	volumeClient volume.Volume
+	scanner      *volume.Scanner
+	drivers      map[string]csi.Client
+	// repairer repairs the filesystems of the volumes while they are
+	// unstaged, nil when no repairs are configured
+	repairer *repair.Repairer
+	// health runs the health checkers on the volumes, verifyCheckers are
+	// those checking the volumes again after a recovery
+	health         *healthcheck.Pipeline
+	verifyCheckers []string
+	// summary counts what the pass did for the node summary event, the
+	// retries made between passes count for the next pass
+	summary passSummary
+	// report collects the pass report written with --report-file, the
+	// retries made between passes are reported with the next pass;
+	// lastReport is the report of the last pass served on /status
+	report     *passReport
+	lastReport atomic.Pointer[passReport]
+	// reportHeader is set once the CSV header was written to the standard
+	// output
+	reportHeader bool
+	// started is set once the daemon is ready to run its passes
+	started atomic.Bool
+	// severityActions says what to do with abnormal volumes of each
+	// severity
+	severityActions map[healthcheck.Severity]string
+	// actions are the registered ways of recovering a volume
+	actions *recovery.Registry
+	// driverActions replace actions for the volumes of the drivers
+	driverActions map[string]*recovery.Registry
+	// driverSecrets are the secrets of --driver-secrets, read at each
+	// recovery so a rotated secret is picked up
+	driverSecrets map[string]pkg.SecretRef
+	// expandLimit caps the size of expanded PVCs, unlimited when zero
+	expandLimit resource.Quantity
+
+	// cordoned is set when the node was cordoned for a recovery, it is
+	// uncordoned once no recovery is in progress; nodeMu guards them
+	nodeMu     sync.Mutex
+	cordoned   bool
+	recovering int
+
+	// queue holds the keys of the volumes to recover, the recoveries held
+	// in work are made by the workers and retried with a backoff per
+	// volume; pending counts the recoveries not attempted yet, which the
+	// pass waits for, and remaining those not settled yet, retries included
+	queue     workqueue.TypedRateLimitingInterface[string]
+	work      map[string]*recoveryWork
+	pending   sync.WaitGroup
+	remaining sync.WaitGroup
+	// mu guards the state below and the work, the pass holds it while it
+	// checks the volumes and the workers take it to record the outcome of
+	// the recoveries
+	mu sync.Mutex
+
+	// taint is applied to the node while drivers or volumes keep failing,
+	// the consecutive failures are tracked across passes
+	taint          v1.Taint
+	driverFailures map[string]int
+	volumeFailures map[string]int
+	attempted      map[string]bool
+	// backoff tracks the recoveries of the volumes so a volume whose
+	// storage keeps failing isn't recovered on every pass
+	backoff map[string]*volumeBackoff
+	// circuitFailures and driverRecoveryFailures count the consecutive
+	// failed recoveries of the volumes and drivers, their circuit opens
+	// at the threshold; openDrivers is read from the node at each pass
+	circuitFailures        map[string]int
+	driverRecoveryFailures map[string]int
+	openDrivers            map[string]bool
+
+	// passRecoveries counts the volumes recovered in the current pass and
+	// namespaceRecoveries the times of the recent recoveries in each
+	// namespace, they are limited by the recovery budgets
+	passRecoveries      int
+	namespaceRecoveries map[string][]time.Time
+	namespaceBudgets    map[string]int
+	// passScopes maps the pods and owners recovered in the current pass to
+	// the action which recovered them, so each is recovered once however
+	// many of its volumes are abnormal; passOwners caches the owners of the
+	// pods
+	passScopes map[string]string
+	passOwners map[string]string
+	// preHook and postHook are notified before and after each recovery
+	// action, nil when not configured
+	preHook  hooks.Hook
+	postHook hooks.Hook
+	// notifier sends the recovery events, nil when no sink is configured;
+	// notified holds the abnormal volumes already notified, so they are
+	// notified again only once healthy
+	notifier *notify.Notifier
+	notified map[string]bool
+	// conditionEvents holds the volumes with a VolumeConditionAbnormal
+	// event, which get a VolumeConditionNormal one once healthy
+	conditionEvents map[string]bool
+	// orphans holds the UIDs of the orphaned pod directories found by the
+	// last pass, only those found again are cleaned up
+	orphans map[string]bool
+
+	// history holds the detections and recoveries not persisted yet
+	history []kubernetes.HistoryEntry
+
+	// protectedNamespaces are the critical namespaces whose volumes are
+	// only reported
+	protectedNamespaces map[string]bool
+
+	// windows are the maintenance windows disruptive actions are allowed
+	// in, at any time when empty
+	windows schedule.Windows
+
+	// volumeEvents holds the messages of the abnormal volume condition
+	// events of the PVCs, read at the start of the current pass
+	volumeEvents map[string]string
+	// abnormal holds the severity of the volumes found abnormal during the
+	// current pass, reported in the node condition
+	abnormal map[string]string
+	// healthyStats holds the kubelet stats of the volumes found healthy,
+	// they are checked again once their stats change or at the next full
+	// resync, made at lastResync
+	healthyStats map[string]string
+	lastResync   time.Time
+
+	// metrics count the abnormal volumes and the recoveries
+	metrics *recoveryMetrics
+
+	// plan collects the recoveries instead of making them in plan mode, the
+	// pass then changes nothing on the node or in the cluster
+	plan *recoveryPlan
+}
+
+// runPass checks the health of the drivers and recovers the volumes reported
+// in the kubelet summary of the node.
+func (r *runner) runPass(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "recovery.pass", tracing.NodeKey.String(conf.NodeName))
+	defer func() { tracing.End(span, err) }()
+	logger := r.logger
+	// uncordon even when the pass is interrupted
+	defer r.uncordon(context.WithoutCancel(ctx))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer func() { r.metrics.observePass(err) }()
+	if reporting() {
+		r.startReport()
+		defer func() { r.writeReport(err) }()
+	}
+	r.passRecoveries = 0
+	clear(r.passScopes)
+	clear(r.passOwners)
+
+	scrapeCtx, scrapeSpan := tracing.Start(ctx, "kubelet.summary")
+	metrics, err := r.kubeClient.GetMetrics(scrapeCtx)
+	tracing.End(scrapeSpan, err)
+	if err != nil {
+		return fmt.Errorf("failed to get metrics: %w", err)
+	}
+	logger.Info("metrics", "metrics", metrics)
+
+	var attachments map[string][]storagev1.VolumeAttachment
+	if conf.CleanupVolumeAttachments {
+		attachments, err = r.volumeAttachments(ctx)
+		if err != nil {
+			logger.Error("failed to list volume attachments", "error", err)
+		}
+	}
+
+	if r.plan == nil {
+		if conf.NodeSummaryEvent {
+			defer r.recordPassSummary(context.WithoutCancel(ctx))
+		}
+		defer r.updateTaint(context.WithoutCancel(ctx))
+		defer r.flushHistory(context.WithoutCancel(ctx))
+		if conf.NodeCondition != "" {
+			defer r.updateNodeCondition(context.WithoutCancel(ctx))
+		}
+	}
+
+	if r.plan == nil && (conf.CapacityThreshold > 0 || conf.InodeThreshold > 0) {
+		r.checkCapacity(ctx, metrics)
+	}
+	if conf.CheckMounts {
+		r.checkMounts()
+	}
+	r.health.Refresh()
+	if conf.VolumeEvents {
+		r.volumeEvents, err = r.kubeClient.AbnormalVolumeEvents(ctx, conf.VolumeEventWindow)
+		if err != nil {
+			logger.Error("failed to read volume events", "error", err)
+		}
+	}
+	r.pruneBackoff()
+	if conf.CircuitBreakerThreshold > 0 {
+		openDrivers, err := r.kubeClient.OpenDriverCircuits(ctx)
+		if err != nil {
+			logger.Error("failed to read open driver circuits", "error", err)
+		} else {
+			r.openDrivers = openDrivers
+		}
+	}
+	if conf.DetectOrphans {
+		r.checkOrphans(ctx, metrics)
+	}
+
+	for name, client := range r.drivers {
+		healthy, err := client.IsHealthy(ctx, logger)
+		r.recordDriverHealth(name, err == nil && healthy)
+		r.metrics.driverHealthy.Set(boolValue(err == nil && healthy), name)
+		if err != nil {
+			logger.Error("failed to check if the node service is healthy", "driver", name, "error", err)
+			continue
+		}
+		if !healthy {
+			logger.Error("node service is not healthy", "driverName", name)
+			continue
+		}
+	}
+
+	volumes := r.podVolumes(ctx, metrics)
+	volumes = r.deltaVolumes(volumes)
+	r.prefetchVolumes(ctx, volumes)
+	defer r.kubeClient.ForgetVolumes()
+	span.SetAttributes(tracing.VolumesKey.Int(len(volumes)))
+	if r.report != nil {
+		r.report.Examined = len(volumes)
+	}
+	r.summary.scanned = len(volumes)
+	r.evaluateVolumes(ctx, attachments, volumes)
+	// the pass ends once the recoveries it queued were attempted
+	r.mu.Unlock()
+	r.pending.Wait()
+	r.mu.Lock()
+	return nil
+}
+
+// prefetchVolumes lists the PVCs of the namespaces of the volumes at once and
+// gets their PVs for the lookups of the pass, instead of getting them one by
+// one as the volumes are checked; the informers serve them in daemon mode
+func (r *runner) prefetchVolumes(ctx context.Context, volumes []podVolume) {
+	claims := map[string][]string{}
+	for _, pv := range volumes {
+		if !pv.inline() && !slices.Contains(claims[pv.namespace], pv.pvcName) {
+			claims[pv.namespace] = append(claims[pv.namespace], pv.pvcName)
+		}
+	}
+	if len(claims) == 0 {
+		return
+	}
+	if err := r.kubeClient.PrefetchVolumes(ctx, claims); err != nil {
+		// the volumes are looked up one by one
+		r.logger.Error("failed to list the PVCs and PVs of the pass", "error", err)
+	}
+}
+
+// podVolume is a PVC or an inline ephemeral CSI volume used by a pod on the
+// node
+type podVolume struct {
+	namespace string
+	podName   string
+	podUID    string
+	pvcName   string
+	// pending is set for pods stuck before their volumes were mounted,
+	// which have no volume condition to check; pendingSince is when the
+	// pod was scheduled
+	pending      bool
+	pendingSince time.Time
+	// volumeName and driver are set for inline ephemeral volumes, which
+	// have no PVC
+	volumeName string
+	driver     string
+	// health is the volume health reported in the kubelet summary, nil
+	// when the kubelet doesn't monitor it
+	health *v1alpha1.VolumeHealthStats
+	// suspect is why the stats of the volume in the kubelet summary hint
+	// at a dead mount, empty when they look fine; stats are those stats,
+	// compared between passes with --full-resync-interval
+	suspect string
+	stats   string
+	// serviceAccount and readOnly are needed to publish the volume again,
+	// sharedWith is the number of other pods on the node using the PVC,
+	// -1 when the pods of the node are unknown, and sharedBy those pods
+	serviceAccount string
+	readOnly       bool
+	sharedWith     int
+	sharedBy       []*v1.Pod
+	// mirror is set for the mirror pods of static pods
+	mirror bool
+	// containerPaths and propagated tell how the containers of the pod
+	// mount the volume, see recovery.Publication
+	containerPaths []string
+	propagated     bool
+}
+
+// inline reports whether the volume is an inline ephemeral volume
+func (pv podVolume) inline() bool {
+	return pv.pvcName == ""
+}
+
+// key identifies the volume across passes
+func (pv podVolume) key() string {
+	if pv.inline() {
+		return pv.namespace + "/" + pv.podName + "/" + pv.volumeName
+	}
+	return pv.namespace + "/" + pv.pvcName
+}
+
+// podVolumes returns the PVCs of the pods in the kubelet summary, along with
+// the PVCs of the pending pods on the node which never produced volume stats,
+// e.g. because they are stuck in ContainerCreating on a failed mount. Pods
+// which can't benefit from a recovery are left out.
+func (r *runner) podVolumes(ctx context.Context, metrics *v1alpha1.Summary) []podVolume {
+	var volumes []podVolume
+	pods, err := r.kubeClient.ListNodePods(ctx)
+	if err != nil {
+		// recover the volumes of the summary without filtering the pods
+		r.logger.Error("failed to list pods on the node", "error", err)
+	}
+	byUID := make(map[string]*v1.Pod, len(pods))
+	for i := range pods {
+		byUID[string(pods[i].UID)] = &pods[i]
+	}
+
+	seen := map[string]bool{}
+	// the summary reports inline volumes by their pod volume name
+	inlineStats := map[string]*v1alpha1.VolumeStats{}
+	for i := range metrics.Pods {
+		podRef := metrics.Pods[i].PodRef
+		seen[podRef.UID] = true
+		if pod, ok := byUID[podRef.UID]; ok {
+			if recoverable, reason := recoverablePod(pod); !recoverable {
+				r.logger.Info("skipping pod", "pod", pod.Name, "namespace", pod.Namespace, "reason", reason)
+				continue
+			}
+		} else if err == nil {
+			r.logger.Info("skipping pod", "pod", podRef.Name, "namespace", podRef.Namespace, "reason", "pod no longer exists")
+			continue
+		}
+		reported := map[string]bool{}
+		for j := range metrics.Pods[i].VolumeStats {
+			stats := &metrics.Pods[i].VolumeStats[j]
+			pvcRef := stats.PVCRef
+			if pvcRef == nil {
+				inlineStats[podRef.UID+"/"+stats.Name] = stats
+				continue
+			}
+			reported[pvcRef.Name] = true
+			volumes = append(volumes, podVolume{
+				namespace: pvcRef.Namespace,
+				podName:   podRef.Name,
+				podUID:    podRef.UID,
+				pvcName:   pvcRef.Name,
+				health:    stats.VolumeHealthStats,
+				suspect:   zeroStats(stats),
+				stats:     statsFingerprint(stats),
+			})
+		}
+		// the kubelet leaves out the volumes it fails to get stats for,
+		// usually because their mount is dead
+		if pod, ok := byUID[podRef.UID]; ok {
+			for _, vol := range pod.Spec.Volumes {
+				if vol.PersistentVolumeClaim == nil || reported[vol.PersistentVolumeClaim.ClaimName] {
+					continue
+				}
+				volumes = append(volumes, podVolume{
+					namespace: pod.Namespace,
+					podName:   pod.Name,
+					podUID:    podRef.UID,
+					pvcName:   vol.PersistentVolumeClaim.ClaimName,
+					suspect:   "no volume stats in the kubelet summary",
+				})
+			}
+		}
+	}
+
+	// inline ephemeral volumes have no PVC and aren't reported with one in
+	// the summary
+	for i := range pods {
+		pod := &pods[i]
+		if recoverable, _ := recoverablePod(pod); !recoverable {
+			continue
+		}
+		for _, vol := range pod.Spec.Volumes {
+			if vol.CSI == nil {
+				continue
+			}
+			pv := podVolume{
+				namespace:  pod.Namespace,
+				podName:    pod.Name,
+				podUID:     string(pod.UID),
+				pending:    pod.Status.Phase == v1.PodPending,
+				volumeName: vol.Name,
+				driver:     vol.CSI.Driver,
+			}
+			if stats, ok := inlineStats[string(pod.UID)+"/"+vol.Name]; ok {
+				pv.health = stats.VolumeHealthStats
+				pv.suspect = zeroStats(stats)
+				pv.stats = statsFingerprint(stats)
+			} else if seen[string(pod.UID)] && !pv.pending {
+				pv.suspect = "no volume stats in the kubelet summary"
+			}
+			volumes = append(volumes, pv)
+		}
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		if seen[string(pod.UID)] || pod.Status.Phase != v1.PodPending {
+			continue
+		}
+		if recoverable, _ := recoverablePod(pod); !recoverable {
+			continue
+		}
+		for _, vol := range pod.Spec.Volumes {
+			if vol.PersistentVolumeClaim == nil {
+				continue
+			}
+			r.logger.Info("pending pod missing from kubelet summary", "pod", pod.Name, "namespace", pod.Namespace, "pvc", vol.PersistentVolumeClaim.ClaimName)
+			volumes = append(volumes, podVolume{
+				namespace: pod.Namespace,
+				podName:   pod.Name,
+				podUID:    string(pod.UID),
+				pvcName:   vol.PersistentVolumeClaim.ClaimName,
+				pending:   true,
+			})
+		}
+	}
+
+	for i := range volumes {
+		setPodDetails(&volumes[i], byUID[volumes[i].podUID], pods)
+	}
+	return volumes
+}
+
+// setPodDetails sets the details of the pod needed to publish the volume
+// again, the pod is nil when it wasn't found among the pods of the node
+func setPodDetails(pv *podVolume, pod *v1.Pod, pods []v1.Pod) {
+	if pod == nil {
+		pv.sharedWith = -1
+		return
+	}
+	pv.serviceAccount = pod.Spec.ServiceAccountName
+	pv.readOnly = claimReadOnly(pod, pv.pvcName)
+	pv.pendingSince = scheduledAt(pod)
+	pv.mirror = kubernetes.IsMirrorPod(pod)
+	volumeName := pv.volumeName
+	if !pv.inline() {
+		volumeName = claimVolumeName(pod, pv.pvcName)
+	}
+	pv.containerPaths, pv.propagated = containerMounts(pod, volumeName)
+	if pv.inline() {
+		return
+	}
+	for j := range pods {
+		other := &pods[j]
+		if other.UID != pod.UID && other.Namespace == pod.Namespace && other.DeletionTimestamp == nil &&
+			usesClaim(other, pv.pvcName) {
+			pv.sharedWith++
+			pv.sharedBy = append(pv.sharedBy, other)
+		}
+	}
+}
+
+// scheduledAt returns when the pod was bound to the node, when it was created
+// if unknown
+func scheduledAt(pod *v1.Pod) time.Time {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionTrue {
+			return condition.LastTransitionTime.Time
+		}
+	}
+	return pod.CreationTimestamp.Time
+}
+
+// usesClaim reports whether the pod mounts the PVC
+func usesClaim(pod *v1.Pod, claimName string) bool {
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil && vol.PersistentVolumeClaim.ClaimName == claimName {
+			return true
+		}
+	}
+	return false
+}
+
+// claimReadOnly reports whether the pod mounts the PVC read-only
+func claimReadOnly(pod *v1.Pod, claimName string) bool {
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil && vol.PersistentVolumeClaim.ClaimName == claimName {
+			return vol.PersistentVolumeClaim.ReadOnly
+		}
+	}
+	return false
+}
+
+// zeroStats returns why the stats look suspect when the kubelet reported no
+// capacity for the volume, which it does for mounts it can't stat
+func zeroStats(stats *v1alpha1.VolumeStats) string {
+	if stats.CapacityBytes == nil || *stats.CapacityBytes == 0 {
+		return "zero volume stats in the kubelet summary"
+	}
+	return ""
+}
+
+// recoverablePod reports whether restarting the pod or scaling its owner can
+// help, and why not otherwise. Terminating and completed pods are left to
+// their lifecycle, pending pods are only recovered while their containers are
+// still being created, which is where failed mounts leave them, and only once
+// failingToMount confirms the volumes are what they wait for.
+func recoverablePod(pod *v1.Pod) (bool, string) {
+	if pod.DeletionTimestamp != nil {
+		return false, "pod is terminating"
+	}
+	switch pod.Status.Phase {
+	case v1.PodRunning:
+		return true, ""
+	case v1.PodPending:
+		if pod.Spec.NodeName == "" {
+			return false, "pod is not scheduled"
+		}
+		statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			// the containers of pods with init containers wait with
+			// PodInitializing instead
+			waiting := status.State.Waiting
+			if waiting == nil || (waiting.Reason != "ContainerCreating" && waiting.Reason != "PodInitializing") {
+				return false, "pod is pending but not waiting for its volumes"
+			}
+		}
+		return true, ""
+	default:
+		return false, fmt.Sprintf("pod is %s", pod.Status.Phase)
+	}
+}
+
+// volumeCheck is the outcome of the health checks of a volume
+type volumeCheck struct {
+	driver  string
+	client  csi.Client
+	info    *volume.VolumeInfo
+	verdict healthcheck.Verdict
+	// checked is false when the volume couldn't be checked, the reason was
+	// logged
+	checked bool
+}
+
+// evaluateVolumes checks and recovers the volumes, each inside a span the
+// recovery queued for it continues. The CSI and API calls checking the
+// volumes are made by --volume-workers workers, which only read the state of
+// the runner; the volumes are then recovered one by one under r.mu, which
+// the caller holds.
+func (r *runner) evaluateVolumes(ctx context.Context, attachments map[string][]storagev1.VolumeAttachment, volumes []podVolume) {
+	contexts := make([]context.Context, len(volumes))
+	spans := make([]trace.Span, len(volumes))
+	checks := make([]volumeCheck, len(volumes))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(conf.VolumeWorkers, len(volumes)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				checks[i] = r.checkVolume(contexts[i], volumes[i])
+			}
+		}()
+	}
+	for i, pv := range volumes {
+		contexts[i], spans[i] = tracing.Start(ctx, "volume.evaluate",
+			tracing.VolumeKey.String(pv.key()),
+			tracing.NamespaceKey.String(pv.namespace),
+			tracing.PodKey.String(pv.podName),
+			tracing.PVCKey.String(pv.pvcName),
+		)
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	for i, pv := range volumes {
+		if checks[i].checked {
+			r.recoverPodVolume(contexts[i], attachments, pv, checks[i])
+		}
+		r.recordUnchanged(pv, checks[i])
+		spans[i].End()
+	}
+}
+
+// checkVolume finds the driver of the volume and checks its health, pending
+// volumes are only checked to be served by a driver reporting the condition
+// of its volumes once mounted.
+func (r *runner) checkVolume(ctx context.Context, pv podVolume) volumeCheck {
+	logger := r.logger
+	check := volumeCheck{driver: pv.driver}
+	if check.driver == "" {
+		var err error
+		check.driver, err = r.volumeClient.GetDriverName(ctx, pv.podUID, pv.podName, pv.pvcName, pv.namespace)
+		if err != nil {
+			logger.Error("failed to get driver name", "error", err)
+			return check
+		}
+	}
+	client, ok := r.drivers[check.driver]
+	if !ok {
+		logger.Info("driver not found", "driver", check.driver)
+		return check
+	}
+	check.client = client
+	supportsCondition, err := client.NodeSupportsVolumeCondition(ctx, logger)
+	if err != nil {
+		logger.Error("failed to check if the node supports volume condition", "driver", check.driver, "error", err)
+		return check
+	}
+	// pending pods have no mount to check, they are only recovered for
+	// drivers whose volumes can be checked once mounted
+	if !supportsCondition && pv.pending {
+		logger.Info("node does not support volume condition", "driver", check.driver)
+		return check
+	}
+	if pv.pending && !r.failingToMount(ctx, pv) {
+		return check
+	}
+	if !pv.pending {
+		check.verdict, check.info, err = r.volumeHealth(ctx, client, pv, supportsCondition)
+		if err != nil {
+			logger.Error("failed to check volume health", "volume", pv.key(), "error", err)
+			return check
+		}
+		if !check.verdict.Known() {
+			logger.Info("no health signal for volume, node does not support volume condition", "volume", pv.key(), "driver", check.driver)
+			return check
+		}
+	}
+	check.checked = true
+	return check
+}
+
+// failingToMount reports whether the pending pod is stuck on its volumes: it
+// must have been pending for longer than --pending-grace-period, and the
+// kubelet or the attach/detach controller must have recorded a FailedMount
+// or FailedAttachVolume event on it within that period. Pods which are only
+// slow to pull their images or to start, which are pending with the same
+// container states, are left alone.
+func (r *runner) failingToMount(ctx context.Context, pv podVolume) bool {
+	logger := r.logger
+	if pending := time.Since(pv.pendingSince); pending < conf.PendingGracePeriod {
+		logger.Info("pod is pending within the grace period, not recovering it yet", "volume", pv.key(),
+			"pod", pv.podName, "pending", pending.Round(time.Second))
+		return false
+	}
+	message, err := r.kubeClient.VolumeFailureEvent(ctx, pv.namespace, pv.podUID, time.Now().Add(-conf.PendingGracePeriod))
+	if err != nil {
+		logger.Error("failed to read the events of pending pod", "pod", pv.podName, "namespace", pv.namespace, "error", err)
+		return false
+	}
+	if message == "" {
+		logger.Info("pending pod has no recent volume failure event, not recovering it", "volume", pv.key(), "pod", pv.podName)
+		return false
+	}
+	logger.Info("pending pod fails to mount its volumes", "volume", pv.key(), "pod", pv.podName, "event", message)
+	return true
+}
+
+// recoverPodVolume recovers the pod using the checked volume according to the
+// capabilities of the volume's driver.
+func (r *runner) recoverPodVolume(ctx context.Context, attachments map[string][]storagev1.VolumeAttachment, pv podVolume, check volumeCheck) {
+	logger := r.logger
+	driver, client, info, verdict := check.driver, check.client, check.info, check.verdict
+	if !pv.pending {
+		if !verdict.Abnormal() {
+			logger.Info("volume is healthy", "volume", pv.key(), "block", info.Block)
+			r.resetBackoff(pv.key())
+			delete(r.circuitFailures, pv.key())
+			delete(r.notified, pv.key())
+			r.reportHealthy(ctx, pv, driver)
+			return
+		}
+		severity := verdict.Severity()
+		r.recordAbnormalVolume(pv.key(), severity.String())
+		r.recordDetection(pv.key(), driver, verdict)
+		r.notifyDetection(pv, driver, verdict)
+		r.reportDetection(pv, driver, verdict)
+		r.reportAbnormal(ctx, pv, driver, verdict)
+		r.metrics.observeAbnormal(driver, pv.namespace)
+		r.summary.abnormal++
+		switch r.severityActions[severity] {
+		case severityRecover:
+			logger.Info("volume is abnormal", "volume", pv.key(), "block", info.Block,
+				"severity", severity.String(), "reason", verdict.Reason())
+		case severityAlert:
+			logger.Warn("volume is abnormal, not recovering it at this severity", "volume", pv.key(),
+				"block", info.Block, "severity", severity.String(), "reason", verdict.Reason())
+			return
+		default:
+			logger.Info("ignoring abnormal volume at this severity", "volume", pv.key(),
+				"severity", severity.String(), "reason", verdict.Reason())
+			return
+		}
+	}
+	if r.queued(pv.key()) {
+		// keep counting the consecutive failures of the volume
+		r.attempted[pv.key()] = true
+		logger.Info("recovery of volume already queued, waiting for it", "volume", pv.key())
+		return
+	}
+	if action := r.recoveredBy(ctx, pv, info); action != "" {
+		// the failures of the volume are counted with the recovered one
+		r.attempted[pv.key()] = true
+		logger.Info("pod already recovered in this pass, not recovering volume again", "volume", pv.key(),
+			"action", action)
+		return
+	}
+	if r.protectedNamespaces[pv.namespace] {
+		logger.Warn("volume is in a protected namespace, not recovering it", "volume", pv.key(),
+			"namespace", pv.namespace)
+		return
+	}
+	if pv.mirror && !conf.RemountStaticPods {
+		logger.Warn("volume of a static pod is abnormal, it can't be recovered by deleting or scaling the pod, fix it on the node",
+			"volume", pv.key(), "pod", pv.podName, "reason", verdict.Reason())
+		return
+	}
+	optOut, err := r.kubeClient.RecoveryDisabled(ctx, pv.namespace, pv.podName, pv.pvcName)
+	if err != nil {
+		logger.Error("failed to check the recovery opt-out", "volume", pv.key(), "error", err)
+		return
+	}
+	if optOut != "" {
+		logger.Info("recovery disabled", "volume", pv.key(), "object", optOut,
+			"annotation", kubernetes.DisabledAnnotation, "label", kubernetes.QuarantinedLabel)
+		return
+	}
+	if conf.Quarantine && r.plan == nil {
+		r.quarantine(ctx, pv, verdict)
+		return
+	}
+	if !actionsEnabled() && r.plan == nil {
+		logger.Warn("recovery actions are disabled, only reporting the abnormal volume; enable them with --enable-actions",
+			"volume", pv.key(), "reason", verdict.Reason())
+		return
+	}
+	if !r.windows.Contains(time.Now()) {
+		logger.Warn("outside of the maintenance windows, not recovering volume", "volume", pv.key(),
+			"windows", conf.MaintenanceWindows)
+		return
+	}
+	if backingOff, next := r.backingOff(pv.key()); backingOff {
+		// keep counting the consecutive failures of the volume
+		r.attempted[pv.key()] = true
+		logger.Info("volume was recovered recently, backing off", "volume", pv.key(),
+			"attempts", r.backoff[pv.key()].attempts, "next", next.Format(time.RFC3339))
+		return
+	}
+	open, reason, err := r.circuitOpen(ctx, driver, pv)
+	if err != nil {
+		logger.Error("failed to check the recovery circuit", "volume", pv.key(), "error", err)
+		return
+	}
+	if open {
+		logger.Warn("recovery circuit open, not recovering volume", "volume", pv.key(), "reason", reason)
+		return
+	}
+	if allowed, reason := r.withinBudget(pv.namespace); !allowed {
+		logger.Warn("recovery budget exhausted, postponing recovery", "volume", pv.key(), "reason", reason)
+		return
+	}
+	if r.plan == nil && r.deferredForBackup(ctx, pv) {
+		return
+	}
+	target, err := r.recoveryTarget(ctx, client, driver, pv, info, attachments[driver])
+	if err != nil {
+		logger.Error("failed to check if the node supports stage unstage", "driver", driver, "error", err)
+		return
+	}
+	actions := r.driverLadder(driver).Plan(target)
+	if pv.mirror && len(actions) == 0 {
+		logger.Warn("volume of a static pod can't be remounted, fix it on the node", "volume", pv.key(), "pod", pv.podName)
+		return
+	}
+	if r.plan != nil {
+		r.plan.add(pv, driver, verdict, actions)
+		for _, action := range actions {
+			r.markRecovered(action, target)
+		}
+		// the plan respects the budgets as the pass would
+		r.spendBudget(pv.namespace)
+		logger.Info("planned volume recovery", "volume", pv.key(), "driver", driver)
+		return
+	}
+	logger.Info("recovering volume", "volume", pv.key(), "driver", driver)
+	r.enqueue(ctx, pv, target, actions)
+}
+
+// recoveryTarget describes the volume to the recovery actions
+func (r *runner) recoveryTarget(ctx context.Context, client csi.Client, driver string, pv podVolume, info *volume.VolumeInfo,
+	attachments []storagev1.VolumeAttachment) (*recovery.Target, error) {
+	ok, err := client.NodeSupportsStageUnstage(ctx, r.logger)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		r.logger.Info("node supports stage unstage", "driver", driver)
+	} else {
+		r.logger.Info("node does not support stage unstage", "driver", driver)
+	}
+	target := &recovery.Target{
+		Driver:         driver,
+		Namespace:      pv.namespace,
+		PodName:        pv.podName,
+		PodUID:         pv.podUID,
+		ServiceAccount: pv.serviceAccount,
+		PVCName:        pv.pvcName,
+		Inline:         pv.inline(),
+		StageUnstage:   ok,
+		Info:           info,
+		Client:         client,
+		ReadOnly:       pv.readOnly,
+		ContainerPaths: pv.containerPaths,
+		Propagated:     pv.propagated,
+		SharedWith:     pv.sharedWith,
+		Shared:         r.sharedPublications(pv, info),
+		Owner:          r.podOwner(ctx, pv),
+		Mirror:         pv.mirror,
+	}
+	r.publishDetails(ctx, target)
+	if conf.CleanupVolumeAttachments && !pv.inline() {
+		target.Attachments = r.stuckAttachments(ctx, attachments, pv)
+	}
+	if ok && !pv.inline() {
+		target.Hook = r.repairHook(driver, info)
+	}
+	return target, nil
+}
+
+// escalate runs the actions in order until one is verified to have recovered
+// the volume, it reports whether one did, whether any was attempted and
+// whether one left the volume half recovered, for an operator to finish
+func (r *runner) escalate(ctx context.Context, actions []recovery.Action, target *recovery.Target, pv podVolume) (recovered, attempted, intervene bool) {
+	logger := r.logger
+	// checked again for the retries and the applied plans, which start
+	// later than the pass
+	if r.deferredForBackup(ctx, pv) {
+		return false, false, false
+	}
+	for _, action := range actions {
+		err := r.recoverVolume(ctx, action, target, pv)
+		if errors.Is(err, kubernetes.ErrRecoverySkipped) {
+			// the gate which skipped the action, e.g. a budget or a backup
+			// in progress, would be bypassed by a stronger action: the
+			// recovery is deferred to a later pass instead
+			logger.Info("recovery skipped, deferring it", "action", action.Name(), "reason", err)
+			break
+		}
+		attempted = true
+		r.mu.Lock()
+		r.recordCircuit(ctx, target.Driver, pv, err)
+		r.mu.Unlock()
+		if err == nil {
+			logger.Info("volume recovered", "action", action.Name(), "volume", pv.key())
+			recovered = true
+			break
+		}
+		if errors.Is(err, recovery.ErrNeedsIntervention) {
+			// a stronger action can't be trusted with a half recovered
+			// volume
+			logger.Error("recovery failed midway and couldn't be rolled back", "action", action.Name(), "volume", pv.key(), "error", err)
+			r.needsIntervention(ctx, pv, err)
+			intervene = true
+			break
+		}
+		logger.Error("recovery failed, escalating", "action", action.Name(), "volume", pv.key(), "error", err)
+	}
+	if attempted {
+		r.mu.Lock()
+		r.recordRecoveryAttempt(pv.key())
+		r.mu.Unlock()
+	}
+	return recovered, attempted, intervene
+}
+
+// deferredForBackup reports whether the recovery of the volume is deferred
+// while a backup or restore of its namespace is in progress, none of its
+// actions is made then. The recovery is deferred as well when the backups
+// can't be listed.
+func (r *runner) deferredForBackup(ctx context.Context, pv podVolume) bool {
+	err := r.kubeClient.DeferForBackup(ctx, pv.namespace, pv.podName, pv.podUID)
+	if errors.Is(err, kubernetes.ErrRecoverySkipped) {
+		r.logger.Info("recovery deferred", "volume", pv.key(), "reason", err)
+		return true
+	}
+	if err != nil {
+		r.logger.Error("failed to check for a backup in progress, deferring the recovery", "volume", pv.key(), "error", err)
+		return true
+	}
+	return false
+}
+
+// volumeAttachments returns the VolumeAttachments of the node keyed by the
+// attaching driver
+func (r *runner) volumeAttachments(ctx context.Context) (map[string][]storagev1.VolumeAttachment, error) {
+	list, err := r.kubeClient.ListVolumeAttachments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attachments := map[string][]storagev1.VolumeAttachment{}
+	for i := range list {
+		attachments[list[i].Spec.Attacher] = append(attachments[list[i].Spec.Attacher], list[i])
+	}
+	return attachments, nil
+}
+
+// stuckAttachments returns the attachments of the PVC's volume which are
+// stuck in the attach/detach controller.
+func (r *runner) stuckAttachments(ctx context.Context, attachments []storagev1.VolumeAttachment, pv podVolume) []storagev1.VolumeAttachment {
+	if len(attachments) == 0 {
+		return nil
+	}
+	pvc, err := r.kubeClient.GetPVC(ctx, pv.pvcName, pv.namespace)
+	if err != nil {
+		r.logger.Error("failed to get PVC for volume attachment cleanup", "error", err)
+		return nil
+	}
+	var stuck []storagev1.VolumeAttachment
+	for i := range attachments {
+		va := &attachments[i]
+		if va.Spec.Source.PersistentVolumeName == nil || *va.Spec.Source.PersistentVolumeName != pvc.Spec.VolumeName {
+			continue
+		}
+		ok, reason := kubernetes.VolumeAttachmentStuck(va, conf.VolumeAttachmentGrace)
+		if !ok {
+			continue
+		}
+		r.logger.Info("found stuck volume attachment", "volumeAttachment", va.Name, "pv", pvc.Spec.VolumeName, "reason", reason)
+		stuck = append(stuck, *va)
+	}
+	return stuck
+}
+
+// cordon cordons the node before the first disruptive action of the pass, so
+// no new pods using the same volumes are scheduled on a node whose storage is
+// known to be unhealthy.
+func (r *runner) cordon(ctx context.Context) {
+	r.nodeMu.Lock()
+	defer r.nodeMu.Unlock()
+	if !conf.CordonNode || r.cordoned {
+		return
+	}
+	cordoned, err := r.kubeClient.CordonNode(ctx)
+	if err != nil {
+		r.logger.Error("failed to cordon node", "error", err)
+		return
+	}
+	if cordoned {
+		r.logger.Info("cordoned node for recovery")
+		r.cordoned = true
+	}
+}
+
+// uncordon uncordons the node if it was cordoned and no recovery is in
+// progress
+func (r *runner) uncordon(ctx context.Context) {
+	r.nodeMu.Lock()
+	defer r.nodeMu.Unlock()
+	if !r.cordoned || r.recovering > 0 {
+		return
+	}
+	if err := r.kubeClient.UncordonNode(ctx); err != nil {
+		r.logger.Error("failed to uncordon node", "error", err)
+		return
+	}
+	r.logger.Info("uncordoned node after recovery")
+	r.cordoned = false
+}
+
+// startRecovery counts a recovery in progress, the node stays cordoned until
+// it ends
+func (r *runner) startRecovery() {
+	r.nodeMu.Lock()
+	defer r.nodeMu.Unlock()
+	r.recovering++
+}
+
+// endRecovery ends a recovery in progress, the node is uncordoned after the
+// retries as they are made outside of the passes
+func (r *runner) endRecovery(ctx context.Context, retry bool) {
+	r.nodeMu.Lock()
+	r.recovering--
+	r.nodeMu.Unlock()
+	if retry {
+		r.uncordon(ctx)
+	}
+}
+
+// Results of a recovery recorded on the PVC
+const (
+	resultSucceeded = "succeeded"
+	resultFailed    = "failed"
+	resultSkipped   = "skipped"
+)
+
+// recoverVolume runs the recovery action inside a span describing the volume,
+// verifies it took effect and records the outcome on the PVC.
+func (r *runner) recoverVolume(ctx context.Context, action recovery.Action, target *recovery.Target, pv podVolume) error {
+	ctx, span := tracing.Start(ctx, "recovery."+action.Name(),
+		tracing.ActionKey.String(action.Name()),
+		tracing.DriverKey.String(target.Driver),
+		tracing.NamespaceKey.String(pv.namespace),
+		tracing.PodKey.String(pv.podName),
+		tracing.PVCKey.String(pv.pvcName),
+	)
+	if target.PV != nil && target.PV.Spec.CSI != nil {
+		span.SetAttributes(tracing.VolumeIDKey.String(target.PV.Spec.CSI.VolumeHandle))
+	}
+	ctx = audit.WithReason(ctx, fmt.Sprintf("%s recovery of volume %s", action.Name(), pv.key()))
+	r.cordon(ctx)
+	r.reportRecoveryStarted(ctx, pv, target.Driver, action.Name())
+	started := time.Now()
+	err := r.runPreHook(ctx, action.Name(), target, pv)
+	if err != nil {
+		err = fmt.Errorf("pre-recovery hook failed: %w", err)
+	} else {
+		err = action.Execute(ctx, target)
+		if err == nil {
+			err = action.Verify(ctx, target)
+		}
+		if err == nil {
+			err = r.verifyRecovery(ctx, action, target, started)
+		}
+		r.runPostHook(ctx, action.Name(), target, pv, err)
+	}
+	tracing.End(span, err)
+
+	// inline ephemeral volumes have no PVC to record the recovery on
+	if !pv.inline() {
+		r.recordRecovery(ctx, pv.namespace, pv.pvcName, action.Name(), err)
+	}
+	r.reportRecoveryEnded(ctx, pv, target.Driver, action.Name(), err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordVolumeRecovery(pv.key(), err)
+	r.recordAction(pv.key(), target.Driver, action.Name(), started, err)
+	r.reportAction(pv, target.Driver, action.Name(), started, err)
+	r.notifyAction(pv, target.Driver, action.Name(), err)
+	r.metrics.observeAction(target.Driver, pv.namespace, action.Name(), time.Since(started).Seconds(), err)
+	r.summary.count(err)
+	return err
+}
+
+// recordRecovery annotates the PVC with the time, action and result of the
+// recovery so the history is visible on the object itself.
+func (r *runner) recordRecovery(ctx context.Context, namespace, pvcName, action string, err error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	result := resultSucceeded
+	var message *string
+	switch {
+	case errors.Is(err, kubernetes.ErrRecoverySkipped):
+		result = resultSkipped
+	case err != nil:
+		result = resultFailed
+		msg := err.Error()
+		message = &msg
+	}
+	annotations := map[string]*string{
+		kubernetes.LastRecoveryTimeAnnotation: &now,
+		kubernetes.LastActionAnnotation:       &action,
+		kubernetes.LastResultAnnotation:       &result,
+		kubernetes.LastErrorAnnotation:        message,
+	}
+	if err := r.kubeClient.AnnotatePVC(ctx, pvcName, namespace, annotations); err != nil {
+		r.logger.Error("failed to record recovery on PVC", "pvc", pvcName, "namespace", namespace, "error", err)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/csi"
+	"github.com/Madhu-1/csi-volume-recovery/internal/healthcheck"
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+	"github.com/Madhu-1/csi-volume-recovery/internal/recovery"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+	"github.com/Madhu-1/csi-volume-recovery/pkg/csitest"
+	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testDriver = "csitest.example.com"
+	testHandle = "vol-0123456789"
+	testPV     = "pv-1"
+	testPVC    = "data-app-0"
+	testPodUID = "uid-1"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// fakeKube is the API server of the tests, the calls the pipeline isn't
+// expected to make panic
+type fakeKube struct {
+	kubernetes.Client
+	scaled      []string
+	quarantined []string
+	// results are the results of the recoveries recorded on the PVC
+	results []string
+}
+
+func (*fakeKube) GetPVC(_ context.Context, pvcName, namespace string) (*v1.PersistentVolumeClaim, error) {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: pvcName, Namespace: namespace},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: testPV},
+	}, nil
+}
+
+func (*fakeKube) GetPV(_ context.Context, pvName string) (*v1.PersistentVolume, error) {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: pvName},
+		Spec: v1.PersistentVolumeSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{Driver: testDriver, VolumeHandle: testHandle, FSType: "ext4"},
+			},
+		},
+	}, nil
+}
+
+func (*fakeKube) PublishContext(context.Context, string, string, string) (map[string]string, error) {
+	return nil, nil
+}
+
+func (*fakeKube) PodInfoOnMount(context.Context, string) (bool, error) {
+	return false, nil
+}
+
+func (*fakeKube) ScaledOwner(context.Context, string, string) (string, error) {
+	return "StatefulSet/app", nil
+}
+
+func (*fakeKube) DeferForBackup(context.Context, string, string, string) error {
+	return nil
+}
+
+func (k *fakeKube) AnnotatePVC(_ context.Context, _, _ string, annotations map[string]*string) error {
+	k.results = append(k.results, *annotations[kubernetes.LastActionAnnotation]+"="+*annotations[kubernetes.LastResultAnnotation])
+	return nil
+}
+
+func (k *fakeKube) ScaleOwner(_ context.Context, namespace, podName string, _ int32, _ kubernetes.ScaledDownHook) error {
+	k.scaled = append(k.scaled, namespace+"/"+podName)
+	return nil
+}
+
+func (*fakeKube) PodRestarted(context.Context, string, string, string) (bool, error) {
+	return true, nil
+}
+
+func (*fakeKube) ClaimReplacementReady(context.Context, string, string, time.Time) (bool, error) {
+	return true, nil
+}
+
+func (k *fakeKube) Quarantine(_ context.Context, namespace, _, pvcName, _ string) (bool, error) {
+	k.quarantined = append(k.quarantined, namespace+"/"+pvcName)
+	return true, nil
+}
+
+// newTestRunner returns a runner of a node whose kubelet directory holds the
+// staged filesystem volume of the PVC, served by the mock driver
+func newTestRunner(t *testing.T, kube *fakeKube) (*runner, *csitest.Driver) {
+	t.Helper()
+	driver := csitest.NewDriver(testDriver)
+	driver.SetCapabilities(csipbv1.NodeServiceCapability_RPC_VOLUME_CONDITION, csipbv1.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME)
+	endpoint, err := driver.Start()
+	if err != nil {
+		t.Fatalf("failed to start driver: %v", err)
+	}
+	t.Cleanup(driver.Stop)
+	client, err := csi.NewClient(endpoint, nil, nil, testLogger)
+	if err != nil {
+		t.Fatalf("failed to connect to driver: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	kubeletPath := t.TempDir()
+	dir := filepath.Join(volume.NewKubeletPaths(kubeletPath).PodVolumesDir(testPodUID), testPV)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	data := `{"driverName":"` + testDriver + `","specVolID":"` + testPV + `","volumeHandle":"` + testHandle + `","volumeLifecycleMode":"Persistent"}`
+	if err := os.WriteFile(filepath.Join(dir, "vol_data.json"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	health, err := newHealthPipeline(testLogger, []string{healthcheck.SourceDriver})
+	if err != nil {
+		t.Fatalf("failed to build health checkers: %v", err)
+	}
+	r := &runner{
+		logger:                 testLogger,
+		kubeClient:             kube,
+		volumeClient:           volume.NewKubeVolumeClient(kube, kubeletPath),
+		scanner:                volume.NewScanner(kubeletPath),
+		drivers:                map[string]csi.Client{testDriver: client},
+		health:                 health,
+		severityActions:        map[healthcheck.Severity]string{healthcheck.SeverityFailed: severityRecover},
+		actions:                recovery.NewDefaultRegistry(testLogger, kube, 10*time.Second),
+		driverFailures:         map[string]int{},
+		volumeFailures:         map[string]int{},
+		attempted:              map[string]bool{},
+		backoff:                map[string]*volumeBackoff{},
+		circuitFailures:        map[string]int{},
+		driverRecoveryFailures: map[string]int{},
+		openDrivers:            map[string]bool{},
+		notified:               map[string]bool{},
+		conditionEvents:        map[string]bool{},
+		passScopes:             map[string]string{},
+		passOwners:             map[string]string{},
+		metrics:                newRecoveryMetrics(),
+	}
+	return r, driver
+}
+
+// TestPipeline checks the volume with the driver, plans its recovery and
+// escalates it as a pass does. No mount is made on the node, so the driver
+// actions can't be verified and the recoveries escalate to the owner.
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name string
+		// abnormal sets the volume condition the driver reports
+		abnormal bool
+		// failing is the rpc failing in the driver, if any
+		failing          string
+		wantPlan         []string
+		wantRecovered    bool
+		wantIntervention bool
+		wantCalls        []string
+		wantResults      []string
+	}{
+		{
+			name:      "healthy volume",
+			wantCalls: []string{"NodeGetCapabilities", "NodeGetVolumeStats"},
+		},
+		{
+			name:          "escalated to the owner",
+			abnormal:      true,
+			wantPlan:      []string{recovery.ActionRemount, recovery.ActionRestage, recovery.ActionScale},
+			wantRecovered: true,
+			wantCalls: []string{"NodeGetCapabilities", "NodeGetVolumeStats",
+				"NodeUnpublishVolume", "NodePublishVolume",
+				"NodeUnpublishVolume", "NodeUnstageVolume", "NodeStageVolume", "NodePublishVolume"},
+			wantResults: []string{recovery.ActionRemount + "=failed", recovery.ActionRestage + "=failed", recovery.ActionScale + "=succeeded"},
+		},
+		{
+			name:             "left for an operator once unstaged",
+			abnormal:         true,
+			failing:          "NodeStageVolume",
+			wantPlan:         []string{recovery.ActionRemount, recovery.ActionRestage, recovery.ActionScale},
+			wantIntervention: true,
+			wantCalls: []string{"NodeGetCapabilities", "NodeGetVolumeStats",
+				"NodeUnpublishVolume", "NodePublishVolume",
+				"NodeUnpublishVolume", "NodeUnstageVolume", "NodeStageVolume"},
+			wantResults: []string{recovery.ActionRemount + "=failed", recovery.ActionRestage + "=failed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kube := &fakeKube{}
+			r, driver := newTestRunner(t, kube)
+			if tt.abnormal {
+				driver.SetVolumeCondition(testHandle, true, "transport endpoint is not connected")
+			}
+			if tt.failing != "" {
+				driver.SetError(tt.failing, status.Error(codes.Internal, "failed"))
+			}
+			pv := podVolume{namespace: "default", podName: "app-0", podUID: testPodUID, pvcName: testPVC, propagated: true}
+			ctx := context.Background()
+
+			check := r.checkVolume(ctx, pv)
+			if !check.checked {
+				t.Fatal("volume wasn't checked")
+			}
+			if check.verdict.Abnormal() != tt.abnormal {
+				t.Fatalf("verdict abnormal = %t, want %t: %s", check.verdict.Abnormal(), tt.abnormal, check.verdict.Reason())
+			}
+			if tt.abnormal {
+				target, err := r.recoveryTarget(ctx, check.client, check.driver, pv, check.info, nil)
+				if err != nil {
+					t.Fatalf("recoveryTarget() failed: %v", err)
+				}
+				actions := r.driverLadder(check.driver).Plan(target)
+				var plan []string
+				for _, action := range actions {
+					plan = append(plan, action.Name())
+				}
+				if !slices.Equal(plan, tt.wantPlan) {
+					t.Fatalf("plan = %v, want %v", plan, tt.wantPlan)
+				}
+				recovered, attempted, intervene := r.escalate(ctx, actions, target, pv)
+				if recovered != tt.wantRecovered || !attempted || intervene != tt.wantIntervention {
+					t.Errorf("escalate() = recovered %t, attempted %t, intervene %t, want %t, true, %t",
+						recovered, attempted, intervene, tt.wantRecovered, tt.wantIntervention)
+				}
+			}
+			if got := driver.Calls(); !slices.Equal(got, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", got, tt.wantCalls)
+			}
+			if !slices.Equal(kube.results, tt.wantResults) {
+				t.Errorf("recorded results = %v, want %v", kube.results, tt.wantResults)
+			}
+			if (len(kube.scaled) > 0) != tt.wantRecovered {
+				t.Errorf("scaled = %v, want scaled %t", kube.scaled, tt.wantRecovered)
+			}
+			if (len(kube.quarantined) > 0) != tt.wantIntervention {
+				t.Errorf("quarantined = %v, want quarantined %t", kube.quarantined, tt.wantIntervention)
+			}
+		})
+	}
+}
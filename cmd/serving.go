@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// servingTLS returns the TLS configuration of the probe server, nil when it
+// serves plain HTTP. With --probe-client-ca-file client certificates signed
+// by the CA are verified when presented, they are required by authorized.
+func servingTLS() (*tls.Config, error) {
+	if conf.ProbeTLSCertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(conf.ProbeTLSCertFile, conf.ProbeTLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load serving certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if conf.ProbeClientCAFile != "" {
+		pem, err := os.ReadFile(conf.ProbeClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in client CA %s", conf.ProbeClientCAFile)
+		}
+		config.ClientCAs = pool
+		// the kubelet probes have no client certificate
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// servingToken returns the bearer token required on the endpoints exposing
+// the volumes of the node, empty when none is
+func servingToken() (string, error) {
+	if conf.ProbeTokenFile == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(conf.ProbeTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", conf.ProbeTokenFile)
+	}
+	return token, nil
+}
+
+// authorized serves the requests to the handler once authenticated, by a
+// client certificate signed by --probe-client-ca-file or by the bearer token
+// of --probe-token-file; requests are served as they come when neither is
+// configured.
+func authorized(token string, handler http.Handler) http.Handler {
+	if token == "" && conf.ProbeClientCAFile == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := authenticate(req, token); err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// authenticate reports why the request isn't authenticated, nil when it is
+func authenticate(req *http.Request, token string) error {
+	if conf.ProbeClientCAFile != "" && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		return nil
+	}
+	if token != "" {
+		presented, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return errors.New("unauthorized")
+}
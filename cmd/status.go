@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// reporting reports whether the passes are reported, to --report-file or on
+// the /status endpoint of the daemon
+func reporting() bool {
+	return conf.ReportFile != "" || (conf.Interval > 0 && conf.ProbeAddress != "")
+}
+
+// serveStatus serves the report of the last pass as JSON, in the schema of
+// the report file, so the health of the volumes of the node can be pulled
+// without reading the logs
+func (r *runner) serveStatus(w http.ResponseWriter, _ *http.Request) {
+	report := r.lastReport.Load()
+	if report == nil {
+		http.Error(w, "no pass finished yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
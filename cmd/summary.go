@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+)
+
+// passSummary counts the volumes scanned and found abnormal by a pass, and
+// the outcome of its recovery actions
+type passSummary struct {
+	scanned   int
+	abnormal  int
+	succeeded int
+	failed    int
+	skipped   int
+}
+
+// count counts the outcome of a recovery action
+func (s *passSummary) count(err error) {
+	switch {
+	case errors.Is(err, kubernetes.ErrRecoverySkipped):
+		s.skipped++
+	case err != nil:
+		s.failed++
+	default:
+		s.succeeded++
+	}
+}
+
+// String returns the summary as the message of the node event
+func (s *passSummary) String() string {
+	return fmt.Sprintf("scanned %d volumes, %d unhealthy; recovery actions: %d succeeded, %d failed, %d skipped",
+		s.scanned, s.abnormal, s.succeeded, s.failed, s.skipped)
+}
+
+// recordPassSummary records the summary of the pass in the event on the node
+// and starts counting the next pass; the caller holds r.mu
+func (r *runner) recordPassSummary(ctx context.Context) {
+	summary := r.summary
+	r.summary = passSummary{}
+	warning := summary.abnormal > 0 || summary.failed > 0
+	if err := r.kubeClient.RecordPassSummary(ctx, summary.String(), warning); err != nil {
+		r.logger.Error("failed to record the pass summary on the node", "error", err)
+	}
+}
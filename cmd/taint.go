@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+)
+
+// recordDriverHealth counts the consecutive failed health checks of the driver
+func (r *runner) recordDriverHealth(driver string, healthy bool) {
+	if healthy {
+		delete(r.driverFailures, driver)
+		return
+	}
+	r.driverFailures[driver]++
+}
+
+// recordVolumeRecovery counts the consecutive failed recoveries of the volume
+func (r *runner) recordVolumeRecovery(key string, err error) {
+	r.attempted[key] = true
+	switch {
+	case errors.Is(err, kubernetes.ErrRecoverySkipped):
+	case err != nil:
+		r.volumeFailures[key]++
+	default:
+		delete(r.volumeFailures, key)
+	}
+}
+
+// updateTaint taints the node while a driver keeps failing its health checks
+// or a volume can't be recovered after the configured number of attempts, and
+// removes the taint once they are healthy again. The node is only tainted
+// when the actions are enabled, the taint of a previous run is removed
+// regardless.
+func (r *runner) updateTaint(ctx context.Context) {
+	// volumes which weren't recovered in this pass are healthy or gone
+	for key := range r.volumeFailures {
+		if !r.attempted[key] {
+			delete(r.volumeFailures, key)
+		}
+	}
+	clear(r.attempted)
+
+	if conf.TaintAfterFailures <= 0 {
+		return
+	}
+	degraded := false
+	for driver, failures := range r.driverFailures {
+		if failures >= conf.TaintAfterFailures {
+			r.logger.Error("driver keeps failing health checks", "driver", driver, "failures", failures)
+			degraded = true
+		}
+	}
+	for volume, failures := range r.volumeFailures {
+		if failures >= conf.TaintAfterFailures {
+			r.logger.Error("volume can't be recovered", "volume", volume, "failures", failures)
+			degraded = true
+		}
+	}
+
+	if degraded && !actionsEnabled() {
+		r.logger.Warn("recovery actions are disabled, not tainting node; enable them with --enable-actions", "taint", r.taint.ToString())
+		return
+	}
+	if degraded {
+		if err := r.kubeClient.AddNodeTaint(ctx, r.taint); err != nil {
+			r.logger.Error("failed to taint node", "taint", r.taint.ToString(), "error", err)
+		}
+		return
+	}
+	if err := r.kubeClient.RemoveNodeTaint(ctx, r.taint); err != nil {
+		r.logger.Error("failed to remove node taint", "taint", r.taint.ToString(), "error", err)
+	}
+}
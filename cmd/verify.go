@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/healthcheck"
+	"github.com/Madhu-1/csi-volume-recovery/internal/recovery"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// verifyInterval is how often a recovered volume is checked until it is
+// healthy again
+const verifyInterval = 5 * time.Second
+
+// verifyRecovery checks the volume is healthy again after the action
+// recovered it, within --verify-timeout. A volume mounted again on the node
+// must pass the live health checkers, the volume of a restarted or scaled
+// pod must be used by a ready replacement pod. Inline volumes live and die
+// with their pod, and detached volumes are attached again when a pod uses
+// them, they aren't verified further.
+func (r *runner) verifyRecovery(ctx context.Context, action recovery.Action, target *recovery.Target, started time.Time) error {
+	if conf.VerifyTimeout == 0 {
+		return nil
+	}
+	switch action.Name() {
+	case recovery.ActionRemount, recovery.ActionRestage:
+		health, err := newHealthPipeline(r.logger, r.verifyCheckers)
+		if err != nil {
+			return fmt.Errorf("failed to build health checkers to verify the recovery: %w", err)
+		}
+		vol := healthcheck.Volume{Info: target.Info}
+		if target.Client != nil {
+			supported, err := target.Client.NodeSupportsVolumeCondition(ctx, r.logger)
+			if err == nil && supported {
+				vol.Client = target.Client
+			}
+		}
+		var verdict healthcheck.Verdict
+		err = wait.PollUntilContextTimeout(ctx, verifyInterval, conf.VerifyTimeout, true, func(ctx context.Context) (bool, error) {
+			health.Refresh()
+			var err error
+			verdict, err = health.Check(ctx, vol)
+			return err == nil && !verdict.Abnormal(), nil
+		})
+		if err != nil {
+			return fmt.Errorf("%w: volume still abnormal after %s: %s", recovery.ErrNotRecovered, conf.VerifyTimeout, verdict.Reason())
+		}
+	case recovery.ActionRestart, recovery.ActionScale:
+		if target.Inline {
+			return nil
+		}
+		err := wait.PollUntilContextTimeout(ctx, verifyInterval, conf.VerifyTimeout, true, func(ctx context.Context) (bool, error) {
+			ready, err := r.kubeClient.ClaimReplacementReady(ctx, target.Namespace, target.PVCName, started)
+			if err != nil {
+				r.logger.Error("failed to look for the replacement pod", "pvc", target.PVCName, "namespace", target.Namespace, "error", err)
+			}
+			return ready, nil
+		})
+		if err != nil {
+			return fmt.Errorf("%w: no ready pod using PVC %s/%s after %s", recovery.ErrNotRecovered, target.Namespace, target.PVCName, conf.VerifyTimeout)
+		}
+	}
+	return nil
+}
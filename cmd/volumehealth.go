@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/healthcheck"
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reasons of the conditions of the VolumeHealth objects
+const (
+	reasonVolumeHealthy     = "VolumeHealthy"
+	reasonVolumeAbnormal    = "VolumeAbnormal"
+	reasonRecoveryRunning   = "RecoveryRunning"
+	reasonRecoveryDone      = "RecoveryDone"
+	reasonRecoverySkipped   = "RecoverySkipped"
+	reasonRecoverySucceeded = "RecoverySucceeded"
+	reasonRecoveryFailed    = "RecoveryFailed"
+)
+
+// updateVolumeHealth updates the VolumeHealth object of the PVC of the volume
+// with --volume-health-crd. Inline volumes have no PVC, and plan mode changes
+// nothing.
+func (r *runner) updateVolumeHealth(ctx context.Context, pv podVolume, driver string, update func(*kubernetes.VolumeHealthStatus)) {
+	if !conf.VolumeHealthCRD || r.plan != nil || pv.inline() {
+		return
+	}
+	err := r.kubeClient.UpdateVolumeHealth(ctx, pv.namespace, pv.pvcName, func(status *kubernetes.VolumeHealthStatus) {
+		status.Driver = driver
+		update(status)
+	})
+	if err != nil {
+		r.logger.Error("failed to update the VolumeHealth of the volume", "volume", pv.key(), "error", err)
+	}
+}
+
+// reportHealthy reports the volume healthy in its VolumeHealth object, and
+// with a VolumeConditionNormal event on its PVC when it was reported abnormal
+func (r *runner) reportHealthy(ctx context.Context, pv podVolume, driver string) {
+	r.updateVolumeHealth(ctx, pv, driver, func(status *kubernetes.VolumeHealthStatus) {
+		setCondition(status, kubernetes.VolumeHealthy, true, reasonVolumeHealthy, "volume passed the health checks")
+	})
+	if r.conditionEvents[pv.key()] {
+		delete(r.conditionEvents, pv.key())
+		r.recordVolumeCondition(ctx, pv, false, "")
+	}
+}
+
+// reportAbnormal reports the volume abnormal in its VolumeHealth object and
+// with a VolumeConditionAbnormal event on its PVC
+func (r *runner) reportAbnormal(ctx context.Context, pv podVolume, driver string, verdict healthcheck.Verdict) {
+	r.updateVolumeHealth(ctx, pv, driver, func(status *kubernetes.VolumeHealthStatus) {
+		setCondition(status, kubernetes.VolumeHealthy, false, reasonVolumeAbnormal, verdict.Reason())
+	})
+	if r.recordVolumeCondition(ctx, pv, true, verdict.Reason()) {
+		r.conditionEvents[pv.key()] = true
+	}
+}
+
+// recordVolumeCondition records the condition of the volume in an event on its
+// PVC with --volume-condition-events, it reports whether the event was
+// recorded. Inline volumes have no PVC, and plan mode changes nothing.
+func (r *runner) recordVolumeCondition(ctx context.Context, pv podVolume, abnormal bool, message string) bool {
+	if !conf.VolumeConditionEvents || r.plan != nil || pv.inline() {
+		return false
+	}
+	if err := r.kubeClient.RecordVolumeCondition(ctx, pv.namespace, pv.pvcName, abnormal, message); err != nil {
+		r.logger.Error("failed to record the volume condition event", "volume", pv.key(), "error", err)
+		return false
+	}
+	return true
+}
+
+// reportRecoveryStarted reports the recovery action running on the volume in
+// its VolumeHealth object
+func (r *runner) reportRecoveryStarted(ctx context.Context, pv podVolume, driver, action string) {
+	r.updateVolumeHealth(ctx, pv, driver, func(status *kubernetes.VolumeHealthStatus) {
+		status.LastAction = action
+		setCondition(status, kubernetes.VolumeRecoveryInProgress, true, reasonRecoveryRunning, action+" recovery running")
+	})
+}
+
+// reportRecoveryEnded reports the outcome of the recovery action in the
+// VolumeHealth object of the volume, a skipped action leaves the last outcome
+func (r *runner) reportRecoveryEnded(ctx context.Context, pv podVolume, driver, action string, err error) {
+	r.updateVolumeHealth(ctx, pv, driver, func(status *kubernetes.VolumeHealthStatus) {
+		switch {
+		case errors.Is(err, kubernetes.ErrRecoverySkipped):
+			setCondition(status, kubernetes.VolumeRecoveryInProgress, false, reasonRecoverySkipped, err.Error())
+		case err != nil:
+			setCondition(status, kubernetes.VolumeRecoveryInProgress, false, reasonRecoveryDone, action+" recovery done")
+			setCondition(status, kubernetes.VolumeRecoveryFailed, true, reasonRecoveryFailed, err.Error())
+		default:
+			setCondition(status, kubernetes.VolumeRecoveryInProgress, false, reasonRecoveryDone, action+" recovery done")
+			setCondition(status, kubernetes.VolumeRecoveryFailed, false, reasonRecoverySucceeded, action+" recovered the volume")
+		}
+	})
+}
+
+// setCondition sets the condition of the VolumeHealth status, its transition
+// time only changes with its status
+func setCondition(status *kubernetes.VolumeHealthStatus, conditionType string, value bool, reason, message string) {
+	condition := metav1.Condition{Type: conditionType, Status: metav1.ConditionFalse, Reason: reason, Message: message}
+	if value {
+		condition.Status = metav1.ConditionTrue
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+}
@@ -0,0 +1,118 @@
+// Package audit records every mutating call the tool makes to the API server
+// and the CSI drivers in an append-only log of JSON lines, recording who made
+// which change to what, when and why, for reviews after incidents.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Results of an audited call
+const (
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+)
+
+// Entry is a line of the audit log
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Actor is the identity of the tool making the call, Node the node it
+	// runs on
+	Actor string `json:"actor"`
+	Node  string `json:"node"`
+	// Operation is the call, e.g. NodeStageVolume or ScaleOwner
+	Operation string `json:"operation"`
+	// Kind, Namespace and Name identify the object changed, the name of
+	// CSI volumes is their volume ID
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Reason is why the call was made, empty when unknown
+	Reason string `json:"reason,omitempty"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Log appends the audit entries to a file or the standard output
+type Log struct {
+	actor string
+	node  string
+
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// Open opens the audit log at the path, "-" for the standard output. Entries
+// are appended to an existing file.
+func Open(path, actor, node string) (*Log, error) {
+	l := &Log{actor: actor, node: node, w: os.Stdout}
+	if path == "-" {
+		return l, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	l.w = f
+	l.closer = f
+	return l, nil
+}
+
+// Close closes the file of the audit log
+func (l *Log) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// Record appends the call and its outcome to the log, the reason is taken
+// from the context. A failure to write the log doesn't fail the call, it is
+// reported on the standard error.
+func (l *Log) Record(ctx context.Context, operation, kind, namespace, name string, err error) {
+	entry := Entry{
+		Time:      time.Now().UTC(),
+		Actor:     l.actor,
+		Node:      l.node,
+		Operation: operation,
+		Kind:      kind,
+		Namespace: namespace,
+		Name:      name,
+		Reason:    reasonFrom(ctx),
+		Result:    ResultSucceeded,
+	}
+	if err != nil {
+		entry.Result = ResultFailed
+		entry.Error = err.Error()
+	}
+	line, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode audit entry: %v\n", marshalErr)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write audit entry: %v\n", err)
+	}
+}
+
+type reasonKey struct{}
+
+// WithReason returns a context recording why the calls made with it are made
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+// reasonFrom returns the reason recorded in the context, empty if none
+func reasonFrom(ctx context.Context) string {
+	reason, _ := ctx.Value(reasonKey{}).(string)
+	return reason
+}
@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/csi"
+)
+
+// driver records the calls changing the mounts of the volumes in the audit
+// log
+type driver struct {
+	csi.Client
+	log *Log
+}
+
+var _ csi.Client = &driver{}
+
+// Driver returns the CSI client recording its mutating calls in the log
+func Driver(c csi.Client, l *Log) csi.Client {
+	return &driver{Client: c, log: l}
+}
+
+func (d *driver) NodeStageVolume(ctx context.Context, logger *slog.Logger, req csi.StageRequest) error {
+	err := d.Client.NodeStageVolume(ctx, logger, req)
+	d.log.Record(ctx, "NodeStageVolume", "CSIVolume", "", req.VolumeID, err)
+	return err
+}
+
+func (d *driver) NodeUnstageVolume(ctx context.Context, logger *slog.Logger, volumeID, stagingTargetPath string) error {
+	err := d.Client.NodeUnstageVolume(ctx, logger, volumeID, stagingTargetPath)
+	d.log.Record(ctx, "NodeUnstageVolume", "CSIVolume", "", volumeID, err)
+	return err
+}
+
+func (d *driver) NodePublishVolume(ctx context.Context, logger *slog.Logger, req csi.PublishRequest) error {
+	err := d.Client.NodePublishVolume(ctx, logger, req)
+	d.log.Record(ctx, "NodePublishVolume", "CSIVolume", "", req.VolumeID, err)
+	return err
+}
+
+func (d *driver) NodeUnpublishVolume(ctx context.Context, logger *slog.Logger, volumeID, targetPath string) error {
+	err := d.Client.NodeUnpublishVolume(ctx, logger, volumeID, targetPath)
+	d.log.Record(ctx, "NodeUnpublishVolume", "CSIVolume", "", volumeID, err)
+	return err
+}
@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// kubeClient records the mutating calls of the client in the audit log
+type kubeClient struct {
+	kubernetes.Client
+	log *Log
+}
+
+var _ kubernetes.Client = &kubeClient{}
+
+// KubeClient returns the client recording its mutating calls in the log
+func KubeClient(c kubernetes.Client, l *Log) kubernetes.Client {
+	return &kubeClient{Client: c, log: l}
+}
+
+func (c *kubeClient) AnnotatePVC(ctx context.Context, pvcName, namespace string, annotations map[string]*string) error {
+	err := c.Client.AnnotatePVC(ctx, pvcName, namespace, annotations)
+	c.log.Record(ctx, "AnnotatePVC", "PersistentVolumeClaim", namespace, pvcName, err)
+	return err
+}
+
+func (c *kubeClient) OpenVolumeCircuit(ctx context.Context, namespace, podName, pvcName, message string) error {
+	err := c.Client.OpenVolumeCircuit(ctx, namespace, podName, pvcName, message)
+	c.log.Record(WithReason(ctx, message), "OpenVolumeCircuit", "PersistentVolumeClaim", namespace, pvcName, err)
+	return err
+}
+
+func (c *kubeClient) OpenDriverCircuit(ctx context.Context, driver, message string) error {
+	err := c.Client.OpenDriverCircuit(ctx, driver, message)
+	c.log.Record(WithReason(ctx, message), "OpenDriverCircuit", "Node", "", c.log.node, err)
+	return err
+}
+
+func (c *kubeClient) AppendHistory(ctx context.Context, namespace string, entries []kubernetes.HistoryEntry, limit int) error {
+	err := c.Client.AppendHistory(ctx, namespace, entries, limit)
+	c.log.Record(ctx, "AppendHistory", "ConfigMap", namespace, "", err)
+	return err
+}
+
+func (c *kubeClient) Quarantine(ctx context.Context, namespace, podName, pvcName, reason string) (bool, error) {
+	quarantined, err := c.Client.Quarantine(ctx, namespace, podName, pvcName, reason)
+	if quarantined || err != nil {
+		c.log.Record(WithReason(ctx, reason), "Quarantine", "Pod", namespace, podName, err)
+	}
+	return quarantined, err
+}
+
+func (c *kubeClient) ExpandPVC(ctx context.Context, pvcName, namespace string, percent int, limit resource.Quantity) (*resource.Quantity, error) {
+	size, err := c.Client.ExpandPVC(ctx, pvcName, namespace, percent, limit)
+	if size != nil || err != nil {
+		c.log.Record(ctx, "ExpandPVC", "PersistentVolumeClaim", namespace, pvcName, err)
+	}
+	return size, err
+}
+
+func (c *kubeClient) DeleteVolumeAttachment(ctx context.Context, va *storagev1.VolumeAttachment) error {
+	err := c.Client.DeleteVolumeAttachment(ctx, va)
+	c.log.Record(ctx, "DeleteVolumeAttachment", "VolumeAttachment", "", va.Name, err)
+	return err
+}
+
+func (c *kubeClient) CordonNode(ctx context.Context) (bool, error) {
+	cordoned, err := c.Client.CordonNode(ctx)
+	if cordoned || err != nil {
+		c.log.Record(ctx, "CordonNode", "Node", "", c.log.node, err)
+	}
+	return cordoned, err
+}
+
+func (c *kubeClient) UncordonNode(ctx context.Context) error {
+	err := c.Client.UncordonNode(ctx)
+	c.log.Record(ctx, "UncordonNode", "Node", "", c.log.node, err)
+	return err
+}
+
+func (c *kubeClient) AddNodeTaint(ctx context.Context, taint v1.Taint) error {
+	err := c.Client.AddNodeTaint(ctx, taint)
+	c.log.Record(ctx, "AddNodeTaint", "Node", "", c.log.node, err)
+	return err
+}
+
+func (c *kubeClient) RemoveNodeTaint(ctx context.Context, taint v1.Taint) error {
+	err := c.Client.RemoveNodeTaint(ctx, taint)
+	c.log.Record(ctx, "RemoveNodeTaint", "Node", "", c.log.node, err)
+	return err
+}
+
+func (c *kubeClient) SetNodeCondition(ctx context.Context, condition v1.NodeCondition) error {
+	err := c.Client.SetNodeCondition(ctx, condition)
+	c.log.Record(ctx, "SetNodeCondition", "Node", "", c.log.node, err)
+	return err
+}
+
+func (c *kubeClient) RestoreScaledOwners(ctx context.Context) ([]string, error) {
+	restored, err := c.Client.RestoreScaledOwners(ctx)
+	for _, owner := range restored {
+		namespace, name, _ := strings.Cut(owner, "/")
+		c.log.Record(WithReason(ctx, "owner left scaled down by an interrupted recovery"), "RestoreScaledOwner", "Owner", namespace, name, nil)
+	}
+	if err != nil {
+		c.log.Record(ctx, "RestoreScaledOwners", "Owner", "", "", err)
+	}
+	return restored, err
+}
+
+func (c *kubeClient) ScaleOwner(ctx context.Context, namespace, podName string, replicaCount int32, hook kubernetes.ScaledDownHook) error {
+	err := c.Client.ScaleOwner(ctx, namespace, podName, replicaCount, hook)
+	c.log.Record(ctx, "ScaleOwner", "Pod", namespace, podName, err)
+	return err
+}
+
+func (c *kubeClient) RestartPod(ctx context.Context, namespace, podName string) error {
+	err := c.Client.RestartPod(ctx, namespace, podName)
+	c.log.Record(ctx, "RestartPod", "Pod", namespace, podName, err)
+	return err
+}
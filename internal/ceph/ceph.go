@@ -0,0 +1,228 @@
+// Package ceph inspects the kernel clients backing the volumes of ceph-csi,
+// the krbd devices of RBD volumes and the CephFS kernel mounts, for the
+// failures the generic checks miss: clients blocklisted by the cluster, RBD
+// images whose watch is lost for good and dead CephFS MDS sessions. Those
+// volumes often still answer a stat from the cache while every write fails
+// or hangs.
+package ceph
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultDebugfsPath is where debugfs is mounted, the CephFS kernel clients
+// publish their state below its ceph directory
+const DefaultDebugfsPath = "/sys/kernel/debug"
+
+// Problems found with a Ceph kernel client
+const (
+	// ProblemBlocklisted means the cluster blocklisted the client, e.g.
+	// after it was fenced, every request to the OSDs fails until the volume
+	// is mapped or mounted again with a new client
+	ProblemBlocklisted = "blocklisted"
+	// ProblemWatchLost means the watch of the RBD image header could not be
+	// registered again, the image no longer sees the lock and resize
+	// notifications of other clients
+	ProblemWatchLost = "watch-lost"
+	// ProblemSessionClosed means the MDS closed or rejected the session of
+	// the CephFS client, the mount fails or hangs
+	ProblemSessionClosed = "session-closed"
+	// ProblemSessionHung means the MDS session of the CephFS client stopped
+	// answering, it may still recover
+	ProblemSessionHung = "session-hung"
+)
+
+// Finding is a problem found with a Ceph kernel client
+type Finding struct {
+	Problem string
+	Message string
+}
+
+// IsRBD reports whether the driver is the ceph-csi RBD driver, whose name may
+// be prefixed, e.g. openshift-storage.rbd.csi.ceph.com
+func IsRBD(driver string) bool {
+	return driver == "rbd.csi.ceph.com" || strings.HasSuffix(driver, ".rbd.csi.ceph.com")
+}
+
+// IsCephFS reports whether the driver is the ceph-csi CephFS driver, whose
+// name may be prefixed
+func IsCephFS(driver string) bool {
+	return driver == "cephfs.csi.ceph.com" || strings.HasSuffix(driver, ".cephfs.csi.ceph.com")
+}
+
+// rbdMessage matches the krbd kernel messages naming the device, e.g.
+// "rbd: rbd0: failed to reregister watch: -108"
+var rbdMessage = regexp.MustCompile(`rbd: (rbd[0-9]+): (.*)`)
+
+// ReadKernelLog reads the krbd messages of a kernel log file, e.g.
+// /var/log/kern.log, about blocklisted clients and lost watches. The last
+// problem of each device is returned, keyed by the device name. A device
+// mapped again, which krbd logs with its capacity, has no problem until a
+// new one is logged, so a recycled device name isn't blamed for the failure
+// of a previous image.
+func ReadKernelLog(path string) (map[string]Finding, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open kernel log %s: %w", path, err)
+	}
+	defer f.Close()
+
+	findings := map[string]Finding{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		match := rbdMessage.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		device, message := match[1], match[2]
+		lower := strings.ToLower(message)
+		switch {
+		case strings.HasPrefix(lower, "capacity "):
+			delete(findings, device)
+		// EBLOCKLISTED is ESHUTDOWN, older kernels say blacklisted
+		case strings.HasSuffix(lower, ": -108") || strings.Contains(lower, "blocklisted") ||
+			strings.Contains(lower, "blacklisted"):
+			findings[device] = Finding{Problem: ProblemBlocklisted, Message: message}
+		// other errors are retried by krbd, ENOENT means the header is gone
+		case strings.HasPrefix(lower, "failed to reregister watch: -2"):
+			findings[device] = Finding{Problem: ProblemWatchLost, Message: message}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read kernel log %s: %w", path, err)
+	}
+	return findings, nil
+}
+
+// Client is the state of a CephFS kernel client read from debugfs
+type Client struct {
+	// ID is the name of the debugfs directory of the client,
+	// <fsid>.client<global id>
+	ID   string
+	FSID string
+	// Name is the Ceph user of the client, e.g. csi-cephfs-node
+	Name        string
+	Blocklisted bool
+	// Sessions holds the state of the session with each MDS, e.g. open or
+	// closed, keyed by the MDS rank, e.g. mds.0
+	Sessions map[string]string
+}
+
+// ReadClients reads the state of the CephFS kernel clients from debugfs. The
+// directories of RBD clients, which have no MDS sessions, are skipped. No
+// client is returned when debugfs or its ceph directory aren't mounted.
+func ReadClients(debugfs string) ([]Client, error) {
+	dir := filepath.Join(debugfs, "ceph")
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Ceph clients in %s: %w", dir, err)
+	}
+	var clients []Client
+	for _, entry := range entries {
+		sessions, err := os.ReadFile(filepath.Join(dir, entry.Name(), "mds_sessions"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sessions of Ceph client %s: %w", entry.Name(), err)
+		}
+		client := parseSessions(entry.Name(), string(sessions))
+		// kernels before 5.11 have no status file, blocklisted clients
+		// show up as closed sessions there
+		status, err := os.ReadFile(filepath.Join(dir, entry.Name(), "status"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read status of Ceph client %s: %w", entry.Name(), err)
+		}
+		client.Blocklisted = parseBlocklisted(string(status))
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// parseSessions parses the mds_sessions file of the client:
+//
+//	global_id 4567
+//	name "csi-cephfs-node"
+//	mds.0 open
+func parseSessions(id, data string) Client {
+	client := Client{ID: id, Sessions: map[string]string{}}
+	client.FSID, _, _ = strings.Cut(id, ".client")
+	for _, line := range strings.Split(data, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		switch {
+		case key == "name":
+			client.Name = strings.Trim(value, `"`)
+		case strings.HasPrefix(key, "mds."):
+			client.Sessions[key] = strings.TrimSpace(value)
+		}
+	}
+	return client
+}
+
+// parseBlocklisted reads the status file of the client:
+//
+//	instance: client.4567 (0)10.0.0.1:0/123
+//	blocklisted: false
+func parseBlocklisted(data string) bool {
+	for _, line := range strings.Split(data, "\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(key) == "blocklisted" {
+			return strings.TrimSpace(value) == "true"
+		}
+	}
+	return false
+}
+
+// Check returns the problems of the client
+func (c Client) Check() []Finding {
+	var findings []Finding
+	if c.Blocklisted {
+		findings = append(findings, Finding{Problem: ProblemBlocklisted, Message: "client " + c.ID + " is blocklisted"})
+	}
+	for rank, state := range c.Sessions {
+		switch state {
+		case "closed", "rejected":
+			findings = append(findings, Finding{Problem: ProblemSessionClosed, Message: fmt.Sprintf("session of client %s with %s is %s", c.ID, rank, state)})
+		case "hung":
+			findings = append(findings, Finding{Problem: ProblemSessionHung, Message: fmt.Sprintf("session of client %s with %s is hung", c.ID, rank)})
+		}
+	}
+	return findings
+}
+
+// Serves reports whether the client may serve the CephFS mount with the
+// source and the super options, i.e. it is the client of the same Ceph user
+// and, when the source names it, of the same cluster. The kernel shares a
+// client between the mounts of a user unless they are mounted with noshare,
+// the mounts don't tell which one they use.
+func (c Client) Serves(source, superOptions string) bool {
+	name := ""
+	for _, option := range strings.Split(superOptions, ",") {
+		if value, ok := strings.CutPrefix(option, "name="); ok {
+			name = value
+		}
+	}
+	// the device syntax of kernels from 5.17 is <name>@<fsid>.<fs name>=/path
+	if user, rest, ok := strings.Cut(source, "@"); ok && !strings.Contains(user, ":") {
+		name = user
+		if fsid, _, ok := strings.Cut(rest, "."); ok && fsid != c.FSID {
+			return false
+		}
+	}
+	if name == "" {
+		// the kernel defaults to the guest user
+		name = "guest"
+	}
+	return name == c.Name
+}
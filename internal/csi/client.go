@@ -3,62 +3,186 @@ package csi
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net"
+	"strings"
+	"sync"
 
+	"github.com/Madhu-1/csi-volume-recovery/internal/tracing"
 	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 type Client interface {
 	NodeSupportsStageUnstage(ctx context.Context, logger *slog.Logger) (bool, error)
 	NodeSupportsVolumeCondition(ctx context.Context, logger *slog.Logger) (bool, error)
+	NodeGetVolumeStats(ctx context.Context, logger *slog.Logger, volumeID, volumePath, stagingTargetPath string) (*csipbv1.NodeGetVolumeStatsResponse, error)
 	GetDriverName(ctx context.Context, logger *slog.Logger) (string, error)
 	IsHealthy(ctx context.Context, logger *slog.Logger) (bool, error)
+	NodeStageVolume(ctx context.Context, logger *slog.Logger, req StageRequest) error
+	NodeUnstageVolume(ctx context.Context, logger *slog.Logger, volumeID, stagingTargetPath string) error
+	NodePublishVolume(ctx context.Context, logger *slog.Logger, req PublishRequest) error
+	NodeUnpublishVolume(ctx context.Context, logger *slog.Logger, volumeID, targetPath string) error
 	Close() error
 }
 
 type client struct {
 	grpcClient *grpc.ClientConn
+	endpoint   string
+	driverName string
 	csipbv1.NodeClient
 	csipbv1.IdentityClient
+
+	// capabilities caches the node capabilities of the driver for as long
+	// as the connection stays ready, nil when unknown; stop stops watching
+	// the connection
+	mu           sync.Mutex
+	capabilities []*csipbv1.NodeServiceCapability
+	stop         context.CancelFunc
 }
 
 var _ Client = &client{}
 
-func newGrpcConn(addr string, logger *slog.Logger) (*grpc.ClientConn, error) {
+func newGrpcConn(addr string, limiter *rate.Limiter, concurrency ConcurrencyLimit, logger *slog.Logger) (*grpc.ClientConn, error) {
 	network := "unix"
 	logger.Info("creating new gRPC connection", "protocol", network, "endpoint", addr)
 
+	interceptors := []grpc.UnaryClientInterceptor{tracing.UnaryClientInterceptor()}
+	if limiter != nil {
+		interceptors = append(interceptors, rateLimitInterceptor(limiter))
+	}
+	if concurrency != nil {
+		interceptors = append(interceptors, concurrencyInterceptor(concurrency))
+	}
+
+	// the endpoint is a socket path, optionally prefixed with unix://. Use the
+	// passthrough resolver so it reaches the dialer untouched instead of being
+	// resolved as a DNS name.
 	return grpc.NewClient(
-		string(addr),
+		"passthrough:///"+strings.TrimPrefix(addr, "unix://"),
 		grpc.WithAuthority("localhost"),
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithContextDialer(func(ctx context.Context, target string) (net.Conn, error) {
 			return (&net.Dialer{}).DialContext(ctx, network, target)
 		}),
+		grpc.WithChainUnaryInterceptor(interceptors...),
 	)
 }
 
-func NewClient(addr string, logger *slog.Logger) (Client, error) {
-	conn, err := newGrpcConn(addr, logger)
+// rateLimitInterceptor blocks every call until the limiter allows it, so a
+// single pass over many volumes can't flood the driver with requests.
+func rateLimitInterceptor(limiter *rate.Limiter) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := limiter.Wait(ctx); err != nil {
+			return fmt.Errorf("rate limit wait for %s failed: %w", method, err)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// NewLimiter returns a token bucket limiter for the calls to a driver, or nil
+// when qps is not positive and calls should not be limited.
+func NewLimiter(qps float64, burst int) *rate.Limiter {
+	if qps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(qps), burst)
+}
+
+// ConcurrencyLimit limits the calls in flight to a driver
+type ConcurrencyLimit chan struct{}
+
+// NewConcurrencyLimit returns a limit of n calls in flight to a driver, or nil
+// when n is not positive and calls should not be limited.
+func NewConcurrencyLimit(n int) ConcurrencyLimit {
+	if n <= 0 {
+		return nil
+	}
+	return make(ConcurrencyLimit, n)
+}
+
+// concurrencyInterceptor blocks every call until fewer calls than the limit
+// are in flight, so a driver on a small node isn't overwhelmed by parallel
+// checks.
+func concurrencyInterceptor(limit ConcurrencyLimit) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		select {
+		case limit <- struct{}{}:
+		case <-ctx.Done():
+			return fmt.Errorf("concurrency limit wait for %s failed: %w", method, ctx.Err())
+		}
+		defer func() { <-limit }()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// NewClient creates a client for the CSI driver listening on addr. All the
+// calls made through the client are limited by limiter and concurrency when
+// they are not nil.
+func NewClient(addr string, limiter *rate.Limiter, concurrency ConcurrencyLimit, logger *slog.Logger) (Client, error) {
+	conn, err := newGrpcConn(addr, limiter, concurrency, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	return &client{
+	ctx, stop := context.WithCancel(context.Background())
+	c := &client{
 		grpcClient:     conn,
+		endpoint:       addr,
 		NodeClient:     csipbv1.NewNodeClient(conn),
 		IdentityClient: csipbv1.NewIdentityClient(conn),
-	}, nil
+		stop:           stop,
+	}
+	go c.watchConnection(ctx, logger)
+	return c, nil
 }
 
+// Close stops watching the connection and closes it, the client is kept for
+// the whole run so the connection and the capabilities are reused by every
+// pass
 func (c *client) Close() error {
+	c.stop()
 	return c.grpcClient.Close()
 }
 
-func (c *client) GetDriverName(ctx context.Context, logger *slog.Logger) (string, error) {
+// watchConnection forgets the capabilities of the driver whenever the
+// connection stops being ready, e.g. because the driver restarted and may have
+// been upgraded, so they are read again once it is back
+func (c *client) watchConnection(ctx context.Context, logger *slog.Logger) {
+	state := c.grpcClient.GetState()
+	for c.grpcClient.WaitForStateChange(ctx, state) {
+		previous := state
+		state = c.grpcClient.GetState()
+		if previous == connectivity.Ready && state != connectivity.Ready {
+			logger.Info("connection to the driver lost, forgetting its capabilities", "endpoint", c.endpoint, "state", state.String())
+			c.mu.Lock()
+			c.capabilities = nil
+			c.mu.Unlock()
+		}
+	}
+}
+
+// startSpan starts a span for the given rpc carrying the driver details
+func (c *client) startSpan(ctx context.Context, rpc string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "csi."+rpc,
+		tracing.EndpointKey.String(c.endpoint),
+		tracing.DriverKey.String(c.driverName),
+	)
+}
+
+func (c *client) GetDriverName(ctx context.Context, logger *slog.Logger) (name string, err error) {
+	ctx, span := c.startSpan(ctx, "GetPluginInfo")
+	defer func() { tracing.End(span, err) }()
+
 	logger.Info("calling GetPluginInfo rpc to get the driver name")
 	resp, err := c.IdentityClient.GetPluginInfo(ctx, &csipbv1.GetPluginInfoRequest{})
 	if err != nil {
@@ -67,10 +191,15 @@ func (c *client) GetDriverName(ctx context.Context, logger *slog.Logger) (string
 	if resp == nil {
 		return "", errors.New("response is nil")
 	}
+	c.driverName = resp.Name
+	span.SetAttributes(tracing.DriverKey.String(resp.Name))
 	return resp.Name, nil
 }
 
-func (c *client) IsHealthy(ctx context.Context, logger *slog.Logger) (bool, error) {
+func (c *client) IsHealthy(ctx context.Context, logger *slog.Logger) (healthy bool, err error) {
+	ctx, span := c.startSpan(ctx, "Probe")
+	defer func() { tracing.End(span, err) }()
+
 	logger.Info("calling NodeGetInfo rpc to check if the node service is healthy")
 	resp, err := c.IdentityClient.Probe(ctx, &csipbv1.ProbeRequest{})
 	if err != nil {
@@ -85,32 +214,55 @@ func (c *client) nodeGetCapabilities(ctx context.Context) ([]*csipbv1.NodeServic
 	if c.NodeClient == nil {
 		return []*csipbv1.NodeServiceCapability{}, errors.New("nodeclient is nil")
 	}
-
 	req := &csipbv1.NodeGetCapabilitiesRequest{}
 	resp, err := c.NodeClient.NodeGetCapabilities(ctx, req)
 	if err != nil {
 		return []*csipbv1.NodeServiceCapability{}, err
 	}
-	return resp.GetCapabilities(), nil
+	capabilities := resp.GetCapabilities()
+	if capabilities == nil {
+		capabilities = []*csipbv1.NodeServiceCapability{}
+	}
+	c.mu.Lock()
+	c.capabilities = capabilities
+	c.mu.Unlock()
+	return capabilities, nil
 }
 
-func (c *client) nodeSupportsCapability(ctx context.Context, logger *slog.Logger, capabilityType csipbv1.NodeServiceCapability_RPC_Type) (bool, error) {
+// nodeSupportsCapability reports whether the node service has the capability,
+// the capabilities are only read again once the connection was lost
+func (c *client) nodeSupportsCapability(ctx context.Context, logger *slog.Logger, capabilityType csipbv1.NodeServiceCapability_RPC_Type) (supported bool, err error) {
+	c.mu.Lock()
+	cached := c.capabilities
+	c.mu.Unlock()
+	if cached != nil {
+		return hasCapability(cached, capabilityType), nil
+	}
+
+	ctx, span := c.startSpan(ctx, "NodeGetCapabilities")
+	span.SetAttributes(attribute.String("csi.capability", capabilityType.String()))
+	defer func() { tracing.End(span, err) }()
+
 	logger.Info("calling NodeGetCapabilities rpc to determine if the node service",
 		"capability", capabilityType)
 	capabilities, err := c.nodeGetCapabilities(ctx)
 	if err != nil {
 		return false, err
 	}
+	return hasCapability(capabilities, capabilityType), nil
+}
 
+// hasCapability reports whether the capabilities include the RPC
+func hasCapability(capabilities []*csipbv1.NodeServiceCapability, capabilityType csipbv1.NodeServiceCapability_RPC_Type) bool {
 	for _, capability := range capabilities {
 		if capability == nil || capability.GetRpc() == nil {
 			continue
 		}
 		if capability.GetRpc().GetType() == capabilityType {
-			return true, nil
+			return true
 		}
 	}
-	return false, nil
+	return false
 }
 
 func (c *client) NodeSupportsVolumeCondition(ctx context.Context, logger *slog.Logger) (bool, error) {
@@ -120,3 +272,112 @@ func (c *client) NodeSupportsVolumeCondition(ctx context.Context, logger *slog.L
 func (c *client) NodeSupportsStageUnstage(ctx context.Context, logger *slog.Logger) (bool, error) {
 	return c.nodeSupportsCapability(ctx, logger, csipbv1.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME)
 }
+
+// NodeGetVolumeStats returns the stats and the condition of the volume. The
+// volume path is the mount point of filesystem volumes or the device file of
+// block volumes, the staging target path is optional.
+func (c *client) NodeGetVolumeStats(ctx context.Context, logger *slog.Logger, volumeID, volumePath, stagingTargetPath string) (resp *csipbv1.NodeGetVolumeStatsResponse, err error) {
+	ctx, span := c.startSpan(ctx, "NodeGetVolumeStats")
+	span.SetAttributes(tracing.VolumeIDKey.String(volumeID))
+	defer func() { tracing.End(span, err) }()
+
+	logger.Info("calling NodeGetVolumeStats rpc", "volumeID", volumeID, "volumePath", volumePath, "stagingTargetPath", stagingTargetPath)
+	resp, err = c.NodeClient.NodeGetVolumeStats(ctx, &csipbv1.NodeGetVolumeStatsRequest{
+		VolumeId:          volumeID,
+		VolumePath:        volumePath,
+		StagingTargetPath: stagingTargetPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, errors.New("response is nil")
+	}
+	return resp, nil
+}
+
+// StageRequest holds the details needed to stage a volume on the node
+type StageRequest struct {
+	VolumeID          string
+	StagingTargetPath string
+	VolumeCapability  *csipbv1.VolumeCapability
+	PublishContext    map[string]string
+	VolumeContext     map[string]string
+	// Secrets are the node stage secrets of the volume
+	Secrets map[string]string
+}
+
+// PublishRequest holds the details needed to publish a volume to a pod
+type PublishRequest struct {
+	VolumeID          string
+	StagingTargetPath string
+	TargetPath        string
+	VolumeCapability  *csipbv1.VolumeCapability
+	Readonly          bool
+	PublishContext    map[string]string
+	VolumeContext     map[string]string
+	// Secrets are the node publish secrets of the volume
+	Secrets map[string]string
+}
+
+func (c *client) NodeStageVolume(ctx context.Context, logger *slog.Logger, req StageRequest) (err error) {
+	ctx, span := c.startSpan(ctx, "NodeStageVolume")
+	span.SetAttributes(tracing.VolumeIDKey.String(req.VolumeID))
+	defer func() { tracing.End(span, err) }()
+
+	logger.Info("calling NodeStageVolume rpc", "volumeID", req.VolumeID, "stagingTargetPath", req.StagingTargetPath)
+	_, err = c.NodeClient.NodeStageVolume(ctx, &csipbv1.NodeStageVolumeRequest{
+		VolumeId:          req.VolumeID,
+		StagingTargetPath: req.StagingTargetPath,
+		VolumeCapability:  req.VolumeCapability,
+		PublishContext:    req.PublishContext,
+		VolumeContext:     req.VolumeContext,
+		Secrets:           req.Secrets,
+	})
+	return err
+}
+
+func (c *client) NodeUnstageVolume(ctx context.Context, logger *slog.Logger, volumeID, stagingTargetPath string) (err error) {
+	ctx, span := c.startSpan(ctx, "NodeUnstageVolume")
+	span.SetAttributes(tracing.VolumeIDKey.String(volumeID))
+	defer func() { tracing.End(span, err) }()
+
+	logger.Info("calling NodeUnstageVolume rpc", "volumeID", volumeID, "stagingTargetPath", stagingTargetPath)
+	_, err = c.NodeClient.NodeUnstageVolume(ctx, &csipbv1.NodeUnstageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingTargetPath,
+	})
+	return err
+}
+
+func (c *client) NodePublishVolume(ctx context.Context, logger *slog.Logger, req PublishRequest) (err error) {
+	ctx, span := c.startSpan(ctx, "NodePublishVolume")
+	span.SetAttributes(tracing.VolumeIDKey.String(req.VolumeID))
+	defer func() { tracing.End(span, err) }()
+
+	logger.Info("calling NodePublishVolume rpc", "volumeID", req.VolumeID, "targetPath", req.TargetPath)
+	_, err = c.NodeClient.NodePublishVolume(ctx, &csipbv1.NodePublishVolumeRequest{
+		VolumeId:          req.VolumeID,
+		StagingTargetPath: req.StagingTargetPath,
+		TargetPath:        req.TargetPath,
+		VolumeCapability:  req.VolumeCapability,
+		Readonly:          req.Readonly,
+		PublishContext:    req.PublishContext,
+		VolumeContext:     req.VolumeContext,
+		Secrets:           req.Secrets,
+	})
+	return err
+}
+
+func (c *client) NodeUnpublishVolume(ctx context.Context, logger *slog.Logger, volumeID, targetPath string) (err error) {
+	ctx, span := c.startSpan(ctx, "NodeUnpublishVolume")
+	span.SetAttributes(tracing.VolumeIDKey.String(volumeID))
+	defer func() { tracing.End(span, err) }()
+
+	logger.Info("calling NodeUnpublishVolume rpc", "volumeID", volumeID, "targetPath", targetPath)
+	_, err = c.NodeClient.NodeUnpublishVolume(ctx, &csipbv1.NodeUnpublishVolumeRequest{
+		VolumeId:   volumeID,
+		TargetPath: targetPath,
+	})
+	return err
+}
@@ -0,0 +1,143 @@
+package csi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failoverClient sends the calls to the first reachable client out of a list
+// of clients talking to the same driver through different endpoints, e.g. the
+// old and new socket paths across a driver upgrade.
+type failoverClient struct {
+	mu      sync.Mutex
+	active  int
+	clients []Client
+}
+
+var _ Client = &failoverClient{}
+
+// ErrNoClients is returned when a failover client is created without clients
+var ErrNoClients = errors.New("no CSI clients to fail over between")
+
+// NewFailoverClient returns a client that fails over between the given
+// clients when the active one is unavailable. The clients must all belong to
+// the same driver.
+func NewFailoverClient(clients ...Client) (Client, error) {
+	switch len(clients) {
+	case 0:
+		return nil, ErrNoClients
+	case 1:
+		return clients[0], nil
+	}
+	return &failoverClient{
+		clients: clients,
+	}, nil
+}
+
+// isUnavailable reports whether the error means the endpoint can't be reached
+// and the call should be retried on another endpoint.
+func isUnavailable(err error) bool {
+	return status.Code(err) == codes.Unavailable
+}
+
+// do calls fn with the active client and fails over to the next clients until
+// one of them is reachable. The reachable client becomes the active one.
+func (f *failoverClient) do(fn func(Client) error) error {
+	f.mu.Lock()
+	start := f.active
+	f.mu.Unlock()
+
+	var err error
+	for i := range f.clients {
+		idx := (start + i) % len(f.clients)
+		err = fn(f.clients[idx])
+		if isUnavailable(err) {
+			continue
+		}
+		if idx != start {
+			f.mu.Lock()
+			f.active = idx
+			f.mu.Unlock()
+		}
+		return err
+	}
+	return err
+}
+
+func (f *failoverClient) Close() error {
+	var errs []error
+	for _, c := range f.clients {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (f *failoverClient) GetDriverName(ctx context.Context, logger *slog.Logger) (name string, err error) {
+	err = f.do(func(c Client) error {
+		name, err = c.GetDriverName(ctx, logger)
+		return err
+	})
+	return name, err
+}
+
+func (f *failoverClient) IsHealthy(ctx context.Context, logger *slog.Logger) (healthy bool, err error) {
+	err = f.do(func(c Client) error {
+		healthy, err = c.IsHealthy(ctx, logger)
+		return err
+	})
+	return healthy, err
+}
+
+func (f *failoverClient) NodeSupportsStageUnstage(ctx context.Context, logger *slog.Logger) (supported bool, err error) {
+	err = f.do(func(c Client) error {
+		supported, err = c.NodeSupportsStageUnstage(ctx, logger)
+		return err
+	})
+	return supported, err
+}
+
+func (f *failoverClient) NodeSupportsVolumeCondition(ctx context.Context, logger *slog.Logger) (supported bool, err error) {
+	err = f.do(func(c Client) error {
+		supported, err = c.NodeSupportsVolumeCondition(ctx, logger)
+		return err
+	})
+	return supported, err
+}
+
+func (f *failoverClient) NodeGetVolumeStats(ctx context.Context, logger *slog.Logger, volumeID, volumePath, stagingTargetPath string) (resp *csipbv1.NodeGetVolumeStatsResponse, err error) {
+	err = f.do(func(c Client) error {
+		resp, err = c.NodeGetVolumeStats(ctx, logger, volumeID, volumePath, stagingTargetPath)
+		return err
+	})
+	return resp, err
+}
+
+func (f *failoverClient) NodeStageVolume(ctx context.Context, logger *slog.Logger, req StageRequest) error {
+	return f.do(func(c Client) error {
+		return c.NodeStageVolume(ctx, logger, req)
+	})
+}
+
+func (f *failoverClient) NodeUnstageVolume(ctx context.Context, logger *slog.Logger, volumeID, stagingTargetPath string) error {
+	return f.do(func(c Client) error {
+		return c.NodeUnstageVolume(ctx, logger, volumeID, stagingTargetPath)
+	})
+}
+
+func (f *failoverClient) NodePublishVolume(ctx context.Context, logger *slog.Logger, req PublishRequest) error {
+	return f.do(func(c Client) error {
+		return c.NodePublishVolume(ctx, logger, req)
+	})
+}
+
+func (f *failoverClient) NodeUnpublishVolume(ctx context.Context, logger *slog.Logger, volumeID, targetPath string) error {
+	return f.do(func(c Client) error {
+		return c.NodeUnpublishVolume(ctx, logger, volumeID, targetPath)
+	})
+}
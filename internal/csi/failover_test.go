@@ -0,0 +1,119 @@
+package csi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// fakeClient is a client of one endpoint answering the stage calls with err
+// and counting them, the other calls panic
+type fakeClient struct {
+	Client
+	err    error
+	calls  int
+	closed bool
+}
+
+func (f *fakeClient) NodeStageVolume(context.Context, *slog.Logger, StageRequest) error {
+	f.calls++
+	return f.err
+}
+
+func (f *fakeClient) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestNewFailoverClient(t *testing.T) {
+	if _, err := NewFailoverClient(); !errors.Is(err, ErrNoClients) {
+		t.Errorf("NewFailoverClient() = %v, want %v", err, ErrNoClients)
+	}
+	single := &fakeClient{}
+	client, err := NewFailoverClient(single)
+	if err != nil {
+		t.Fatalf("NewFailoverClient() failed: %v", err)
+	}
+	if client != single {
+		t.Error("a single client is wrapped")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	failed := status.Error(codes.Internal, "stage failed")
+	tests := []struct {
+		name      string
+		errs      []error
+		want      error
+		wantCalls []int
+		// wantActive is the client the next call goes to first
+		wantActive int
+	}{
+		{
+			name:      "active client reachable",
+			errs:      []error{nil, nil},
+			wantCalls: []int{1, 0},
+		},
+		{
+			name:       "fails over to the reachable client",
+			errs:       []error{unavailable, nil, nil},
+			wantCalls:  []int{1, 1, 0},
+			wantActive: 1,
+		},
+		{
+			// the driver answered, another endpoint would answer the same
+			name:      "other errors don't fail over",
+			errs:      []error{failed, nil},
+			want:      failed,
+			wantCalls: []int{1, 0},
+		},
+		{
+			name:      "no client reachable",
+			errs:      []error{unavailable, unavailable},
+			want:      unavailable,
+			wantCalls: []int{1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var clients []Client
+			var fakes []*fakeClient
+			for _, err := range tt.errs {
+				fake := &fakeClient{err: err}
+				fakes = append(fakes, fake)
+				clients = append(clients, fake)
+			}
+			client, err := NewFailoverClient(clients...)
+			if err != nil {
+				t.Fatalf("NewFailoverClient() failed: %v", err)
+			}
+
+			if err := client.NodeStageVolume(context.Background(), testLogger, StageRequest{}); !errors.Is(err, tt.want) {
+				t.Errorf("NodeStageVolume() = %v, want %v", err, tt.want)
+			}
+			for i, fake := range fakes {
+				if fake.calls != tt.wantCalls[i] {
+					t.Errorf("client %d called %d times, want %d", i, fake.calls, tt.wantCalls[i])
+				}
+			}
+			if active := client.(*failoverClient).active; active != tt.wantActive {
+				t.Errorf("active client = %d, want %d", active, tt.wantActive)
+			}
+
+			client.Close()
+			for i, fake := range fakes {
+				if !fake.closed {
+					t.Errorf("client %d not closed", i)
+				}
+			}
+		})
+	}
+}
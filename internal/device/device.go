@@ -0,0 +1,169 @@
+// Package device inspects the device-mapper and multipath state of the block
+// devices backing the volumes, failures which often precede I/O errors the
+// kubelet never reports.
+package device
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultSysfsPath is where sysfs is mounted
+const DefaultSysfsPath = "/sys"
+
+// Problems found with a device
+const (
+	// ProblemSuspended means the device-mapper device is suspended and
+	// queues or fails all I/O
+	ProblemSuspended = "suspended"
+	// ProblemFailedPath means a device below the device-mapper device, e.g.
+	// a multipath path, is offline
+	ProblemFailedPath = "failed-path"
+	// ProblemNoActivePath means none of the devices below the device-mapper
+	// device are running
+	ProblemNoActivePath = "no-active-path"
+)
+
+// Finding is a problem found with a device
+type Finding struct {
+	// Device is the kernel name of the device, e.g. dm-0 or sdb
+	Device  string
+	Problem string
+	// State is the state of failed paths as reported by the SCSI layer
+	State string
+}
+
+// Checker inspects block devices through sysfs
+type Checker struct {
+	sysfs string
+	byID  string
+}
+
+// NewChecker returns a checker reading the sysfs mounted at the path
+func NewChecker(sysfs string) *Checker {
+	return &Checker{
+		sysfs: sysfs,
+		byID:  DefaultByIDPath,
+	}
+}
+
+// Check returns the problems of the block device with the major:minor number,
+// e.g. read from the mount table, and of the devices below it. Devices which
+// aren't device-mapper devices, and numbers which aren't block devices like
+// those of NFS filesystems, have no problems.
+func (c *Checker) Check(number string) ([]Finding, error) {
+	link := filepath.Join(c.sysfs, "dev", "block", number)
+	target, err := filepath.EvalSymlinks(link)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", link, err)
+	}
+	return c.check(filepath.Base(target))
+}
+
+// Number returns the major:minor number of the block device the path refers
+// to, a device file or a file of a filesystem on the device
+func Number(path string) (string, error) {
+	major, minor, err := deviceNumber(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d", major, minor), nil
+}
+
+// Names returns the kernel names of the block device with the major:minor
+// number and of the devices it is stacked on, e.g. dm-0 and rbd0 for an
+// encrypted RBD image. Numbers which aren't block devices, e.g. of NFS
+// filesystems, have no names.
+func (c *Checker) Names(number string) ([]string, error) {
+	link := filepath.Join(c.sysfs, "dev", "block", number)
+	target, err := filepath.EvalSymlinks(link)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", link, err)
+	}
+	return c.stack(filepath.Base(target))
+}
+
+// stack returns the name of the device and recursively of the devices it is
+// stacked on
+func (c *Checker) stack(name string) ([]string, error) {
+	names := []string{name}
+	slaves, err := os.ReadDir(filepath.Join(c.sysfs, "block", name, "slaves"))
+	if errors.Is(err, os.ErrNotExist) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices below %s: %w", name, err)
+	}
+	for _, slave := range slaves {
+		below, err := c.stack(slave.Name())
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, below...)
+	}
+	return names, nil
+}
+
+// check inspects the device and recursively the devices it is stacked on
+func (c *Checker) check(name string) ([]Finding, error) {
+	dir := filepath.Join(c.sysfs, "block", name)
+	suspended, err := readAttribute(filepath.Join(dir, "dm", "suspended"))
+	if errors.Is(err, os.ErrNotExist) {
+		// not a device-mapper device
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	if suspended == "1" {
+		findings = append(findings, Finding{Device: name, Problem: ProblemSuspended})
+	}
+	slaves, err := os.ReadDir(filepath.Join(dir, "slaves"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices below %s: %w", name, err)
+	}
+	active := 0
+	for _, slave := range slaves {
+		state, err := readAttribute(filepath.Join(c.sysfs, "block", slave.Name(), "device", "state"))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// not a SCSI device, e.g. another device-mapper device
+			below, err := c.check(slave.Name())
+			if err != nil {
+				return nil, err
+			}
+			findings = append(findings, below...)
+			active++
+		case err != nil:
+			return nil, err
+		case state == "running":
+			active++
+		default:
+			findings = append(findings, Finding{Device: slave.Name(), Problem: ProblemFailedPath, State: state})
+		}
+	}
+	if len(slaves) > 0 && active == 0 {
+		findings = append(findings, Finding{Device: name, Problem: ProblemNoActivePath})
+	}
+	return findings, nil
+}
+
+// readAttribute reads a sysfs attribute
+func readAttribute(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
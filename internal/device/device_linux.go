@@ -0,0 +1,21 @@
+package device
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// deviceNumber returns the number of the block device the path refers to,
+// the device itself for device files or the device of its filesystem
+func deviceNumber(path string) (uint32, uint32, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, 0, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	dev := uint64(st.Dev)
+	if st.Mode&unix.S_IFMT == unix.S_IFBLK {
+		dev = uint64(st.Rdev)
+	}
+	return unix.Major(dev), unix.Minor(dev), nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package device
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// deviceNumber isn't supported outside of Linux
+func deviceNumber(path string) (uint32, uint32, error) {
+	return 0, 0, fmt.Errorf("failed to get the device of %s: not supported on %s", path, runtime.GOOS)
+}
@@ -0,0 +1,150 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultByIDPath is where udev links the block devices by their WWN or
+// serial
+const DefaultByIDPath = "/dev/disk/by-id"
+
+// ErrUnidentified is returned when nothing ties a device to a volume, the
+// device can't be found again once its name may have been reused
+var ErrUnidentified = errors.New("device can't be identified as the volume")
+
+// minHandleToken is the shortest part of a volume handle matched against the
+// identifiers of a device, shorter ones could match the wrong device
+const minHandleToken = 8
+
+// Identity identifies the device of a volume independently of its kernel
+// name, which the kernel hands to the next device attached once the volume is
+// detached or unmapped
+type Identity struct {
+	// ByID is the name of the /dev/disk/by-id link of the device naming the
+	// volume, e.g. its WWN or serial
+	ByID string
+	// RBDImage is the pool/image of a krbd device
+	RBDImage string
+}
+
+func (id Identity) String() string {
+	if id.RBDImage != "" {
+		return "rbd image " + id.RBDImage
+	}
+	return id.ByID
+}
+
+// Identify returns the identity of the device at the path, which must name the
+// volume with the handle: the image of a krbd device, or a /dev/disk/by-id
+// link built from the WWN or serial the volume handle is part of, as cloud
+// disks have. ErrUnidentified is returned otherwise.
+func (c *Checker) Identify(path, volumeHandle string) (Identity, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return Identity{}, fmt.Errorf("failed to resolve device %s: %w", path, err)
+	}
+	name := filepath.Base(resolved)
+	if strings.HasPrefix(name, "rbd") {
+		image, err := c.rbdImage(filepath.Join(c.sysfs, "block", name, "device"))
+		if err != nil {
+			return Identity{}, err
+		}
+		if rbdImageMatches(image, volumeHandle) {
+			return Identity{RBDImage: image}, nil
+		}
+		return Identity{}, fmt.Errorf("%w: %s maps %s, not volume %s", ErrUnidentified, path, image, volumeHandle)
+	}
+	entries, err := os.ReadDir(c.byID)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Identity{}, fmt.Errorf("failed to list %s: %w", c.byID, err)
+	}
+	for _, entry := range entries {
+		target, err := filepath.EvalSymlinks(filepath.Join(c.byID, entry.Name()))
+		if err != nil || target != resolved {
+			continue
+		}
+		if idMatches(entry.Name(), volumeHandle) {
+			return Identity{ByID: entry.Name()}, nil
+		}
+	}
+	return Identity{}, fmt.Errorf("%w: no WWN or serial of %s names volume %s", ErrUnidentified, path, volumeHandle)
+}
+
+// Find returns the path of the device with the identity, empty when it is no
+// longer on the node
+func (c *Checker) Find(id Identity) (string, error) {
+	if id.RBDImage == "" {
+		path, err := filepath.EvalSymlinks(filepath.Join(c.byID, id.ByID))
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", id.ByID, err)
+		}
+		return path, nil
+	}
+	dir := filepath.Join(c.sysfs, "bus", "rbd", "devices")
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		image, err := c.rbdImage(filepath.Join(dir, entry.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			// unmapped meanwhile
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if image == id.RBDImage {
+			return "/dev/rbd" + entry.Name(), nil
+		}
+	}
+	return "", nil
+}
+
+// rbdImage returns the pool/image mapped by the krbd device with the sysfs
+// directory
+func (c *Checker) rbdImage(dir string) (string, error) {
+	pool, err := readAttribute(filepath.Join(dir, "pool"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read pool of rbd device: %w", err)
+	}
+	name, err := readAttribute(filepath.Join(dir, "name"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read image of rbd device: %w", err)
+	}
+	return pool + "/" + name, nil
+}
+
+// rbdImageMatches reports whether the pool/image is the one of the volume:
+// ceph-csi names the images of the volumes it provisions csi-vol-<uuid> and
+// ends their handles with the uuid, static volumes are named by their handle
+func rbdImageMatches(image, volumeHandle string) bool {
+	_, name, _ := strings.Cut(image, "/")
+	if name == volumeHandle {
+		return true
+	}
+	uuid, ok := strings.CutPrefix(name, "csi-vol-")
+	return ok && len(uuid) >= minHandleToken && strings.HasSuffix(volumeHandle, "-"+uuid)
+}
+
+// idMatches reports whether the by-id name is built from the last part of the
+// volume handle, e.g. nvme-Amazon_Elastic_Block_Store_vol0123456789abcdef0 for
+// vol-0123456789abcdef0, or google-pvc-1234 for
+// projects/p/zones/z/disks/pvc-1234
+func idMatches(name, volumeHandle string) bool {
+	token := volumeHandle[strings.LastIndex(volumeHandle, "/")+1:]
+	if len(token) < minHandleToken {
+		return false
+	}
+	return strings.Contains(name, token) || strings.Contains(name, strings.ReplaceAll(token, "-", ""))
+}
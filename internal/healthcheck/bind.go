@@ -0,0 +1,98 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/mount"
+)
+
+// SourceBind is the check of the bind mount between the staging and target
+// paths
+const SourceBind = "bind"
+
+// fileID identifies a file by its device and inode
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// bindChecker verifies that the target path of a filesystem volume is still
+// bind mounted from its staging path, i.e. both are on the same device. When
+// a driver restart recycles the global mount, the pod mounts are left
+// dangling on the old filesystem and never see the new one.
+type bindChecker struct {
+	logger  *slog.Logger
+	timeout time.Duration
+
+	// inspector holds the mount table read by the last Refresh
+	inspector *mount.Inspector
+}
+
+func (*bindChecker) Name() string {
+	return SourceBind
+}
+
+// Refresh reads the mount table
+func (c *bindChecker) Refresh() {
+	mounts, err := mount.ReadMountInfo(mount.DefaultMountInfoPath)
+	if err != nil {
+		c.logger.Error("failed to read mount table", "error", err)
+		c.inspector = nil
+		return
+	}
+	c.inspector = mount.NewInspector(mounts)
+}
+
+func (c *bindChecker) Check(_ context.Context, vol Volume) (*Signal, error) {
+	info := vol.Info
+	// some drivers stage volumes without mounting anything on the staging
+	// path, there is nothing to compare the target with then
+	if info.Block || info.StagingPath == "" || c.inspector == nil ||
+		c.inspector.Mounted(info.StagingPath) == 0 || c.inspector.Mounted(info.MountPath) == 0 {
+		return nil, nil
+	}
+	staging, err := statID(info.StagingPath, c.timeout)
+	if err != nil {
+		return &Signal{Source: SourceBind, Severity: SeverityFailed, Message: err.Error()}, nil
+	}
+	target, err := statID(info.MountPath, c.timeout)
+	if err != nil {
+		return &Signal{Source: SourceBind, Severity: SeverityFailed, Message: err.Error()}, nil
+	}
+	// drivers may publish a sub directory of the staging mount, only the
+	// device can be compared
+	if staging.dev != target.dev {
+		return &Signal{
+			Source:   SourceBind,
+			Severity: SeverityFailed,
+			Message:  fmt.Sprintf("%s is no longer on the filesystem mounted on %s", info.MountPath, info.StagingPath),
+		}, nil
+	}
+	return &Signal{Source: SourceBind, Message: "target path is bind mounted from the staging path"}, nil
+}
+
+// statID returns the identity of the file, bounded by the timeout since stat
+// blocks on unreachable network filesystems
+func statID(path string, timeout time.Duration) (fileID, error) {
+	type result struct {
+		id  fileID
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		id, err := fileIDOf(path)
+		done <- result{id, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return fileID{}, fmt.Errorf("failed to stat %s: %w", path, r.err)
+		}
+		return r.id, nil
+	case <-time.After(timeout):
+		return fileID{}, fmt.Errorf("%w: %s after %s", mount.ErrNotResponding, path, timeout)
+	}
+}
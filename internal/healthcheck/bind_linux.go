@@ -0,0 +1,14 @@
+package healthcheck
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// fileIDOf returns the device and inode of the file
+func fileIDOf(path string) (fileID, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return fileID{}, err
+	}
+	return fileID{dev: uint64(st.Dev), ino: st.Ino}, nil
+}
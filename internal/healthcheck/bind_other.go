@@ -0,0 +1,13 @@
+//go:build !linux
+
+package healthcheck
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// fileIDOf isn't supported outside of Linux
+func fileIDOf(string) (fileID, error) {
+	return fileID{}, fmt.Errorf("comparing bind mounts is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,161 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/ceph"
+	"github.com/Madhu-1/csi-volume-recovery/internal/device"
+	"github.com/Madhu-1/csi-volume-recovery/internal/mount"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+)
+
+// SourceCeph is the check of the Ceph kernel clients of ceph-csi volumes
+const SourceCeph = "ceph"
+
+// cephChecker checks the kernel clients of the ceph-csi volumes: the krbd
+// device of RBD volumes, found blocklisted or without watch in the kernel
+// log, and the CephFS client of kernel CephFS mounts, blocklisted or with
+// dead MDS sessions according to debugfs. Volumes mapped with rbd-nbd or
+// mounted with ceph-fuse are left to the mount and I/O checks.
+type cephChecker struct {
+	logger        *slog.Logger
+	kernelLogPath string
+	debugfsPath   string
+	devices       *device.Checker
+
+	// mounts, rbd and clients hold the mount table, the krbd problems and
+	// the CephFS clients read by the last Refresh
+	mounts  map[string]mount.Mount
+	rbd     map[string]ceph.Finding
+	clients []ceph.Client
+}
+
+func (*cephChecker) Name() string {
+	return SourceCeph
+}
+
+// Refresh reads the mount table, the kernel log and the CephFS clients
+func (c *cephChecker) Refresh() {
+	mounts, err := mount.ReadMountInfo(mount.DefaultMountInfoPath)
+	if err != nil {
+		c.logger.Error("failed to read mount table", "error", err)
+	}
+	c.mounts = map[string]mount.Mount{}
+	for _, m := range mounts {
+		c.mounts[filepath.Clean(m.MountPoint)] = m
+	}
+	c.rbd = nil
+	if c.kernelLogPath != "" {
+		if c.rbd, err = ceph.ReadKernelLog(c.kernelLogPath); err != nil {
+			c.logger.Error("failed to read kernel log", "error", err)
+		}
+	}
+	if c.clients, err = ceph.ReadClients(c.debugfsPath); err != nil {
+		c.logger.Error("failed to read CephFS clients", "error", err)
+	}
+}
+
+func (c *cephChecker) Check(_ context.Context, vol Volume) (*Signal, error) {
+	switch {
+	case ceph.IsRBD(vol.Info.DriverName):
+		return c.checkRBD(vol.Info)
+	case ceph.IsCephFS(vol.Info.DriverName):
+		return c.checkCephFS(vol.Info), nil
+	}
+	return nil, nil
+}
+
+// checkRBD looks up the krbd device backing the volume, below the dm-crypt
+// device of encrypted volumes, in the kernel log
+func (c *cephChecker) checkRBD(info *volume.VolumeInfo) (*Signal, error) {
+	if c.rbd == nil {
+		return nil, nil
+	}
+	var number string
+	if info.Block {
+		// the device file can be stat'ed even when the cluster is gone
+		var err error
+		if number, err = device.Number(info.MountPath); err != nil {
+			return nil, fmt.Errorf("failed to find the device of volume %s: %w", info.VolumeHandle, err)
+		}
+	} else {
+		// the mount table tells the device without touching the mount
+		m, ok := c.mount(info)
+		if !ok {
+			return nil, nil
+		}
+		number = m.Device
+	}
+	names, err := c.devices.Names(number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the device of volume %s: %w", info.VolumeHandle, err)
+	}
+	signal := &Signal{Source: SourceCeph, Message: "RBD client is healthy"}
+	found := false
+	for _, name := range names {
+		if !strings.HasPrefix(name, "rbd") {
+			continue
+		}
+		found = true
+		if finding, ok := c.rbd[name]; ok {
+			signal.Severity = SeverityFailed
+			signal.Message = fmt.Sprintf("%s is %s: %s", name, finding.Problem, finding.Message)
+		}
+	}
+	if !found {
+		// mapped with rbd-nbd
+		return nil, nil
+	}
+	return signal, nil
+}
+
+// checkCephFS checks the clients which may serve the kernel CephFS mount of
+// the volume
+func (c *cephChecker) checkCephFS(info *volume.VolumeInfo) *Signal {
+	m, ok := c.mount(info)
+	if !ok || m.FSType != "ceph" {
+		return nil
+	}
+	signal := &Signal{Source: SourceCeph, Message: "CephFS client is healthy"}
+	var problems []string
+	for _, client := range c.clients {
+		if !client.Serves(m.Source, m.SuperOptions) {
+			continue
+		}
+		for _, finding := range client.Check() {
+			problems = append(problems, finding.Message)
+			signal.Severity = max(signal.Severity, cephSeverity(finding.Problem))
+		}
+	}
+	if len(problems) > 0 {
+		signal.Message = strings.Join(problems, ", ")
+	}
+	return signal
+}
+
+// mount returns the mount of the filesystem volume, the staging mount shares
+// the filesystem of the publish one
+func (c *cephChecker) mount(info *volume.VolumeInfo) (mount.Mount, bool) {
+	for _, path := range []string{info.MountPath, info.StagingPath} {
+		if path == "" {
+			continue
+		}
+		if m, ok := c.mounts[filepath.Clean(path)]; ok {
+			return m, true
+		}
+	}
+	return mount.Mount{}, false
+}
+
+// cephSeverity grades the problems of the Ceph clients, a hung MDS session
+// may still recover
+func cephSeverity(problem string) Severity {
+	if problem == ceph.ProblemSessionHung {
+		return SeverityDegraded
+	}
+	return SeverityFailed
+}
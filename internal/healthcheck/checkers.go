@@ -0,0 +1,354 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/device"
+	"github.com/Madhu-1/csi-volume-recovery/internal/mount"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+)
+
+// DefaultCheckers is the order the built-in checkers run in by default, the
+// cheap local checks first and the driver last
+var DefaultCheckers = []string{SourceKubelet, SourceEvents, SourceStats, SourceMount, SourceNFS, SourceSMB, SourceBind, SourceReadOnly, SourceDevice, SourceCeph, SourceIO, SourceDriver}
+
+// liveCheckers are the built-in checkers reading the current state of the
+// volume, the others report what the kubelet or the health monitor saw
+// earlier. The I/O probe is left out, a volume whose probe hangs isn't
+// probed again.
+var liveCheckers = map[string]bool{
+	SourceMount:    true,
+	SourceNFS:      true,
+	SourceSMB:      true,
+	SourceBind:     true,
+	SourceReadOnly: true,
+	SourceDevice:   true,
+	SourceCeph:     true,
+	SourceDriver:   true,
+}
+
+// Live reports whether the checker reads the current state of the volume, so
+// it can verify a recovery right after it was made
+func Live(name string) bool {
+	return liveCheckers[name]
+}
+
+// Options configures the built-in checkers
+type Options struct {
+	Logger *slog.Logger
+	// ProbeTimeout bounds the probe of the mount checker, and the query of
+	// the mount and the dial of the server of the NFS and SMB checkers
+	ProbeTimeout time.Duration
+	// IOTimeout bounds the probe of the I/O checker
+	IOTimeout time.Duration
+	// KernelLogPath is searched by the read-only checker for the reason
+	// filesystems were remounted read-only, when set
+	KernelLogPath string
+	// SysfsPath is read by the device and Ceph checkers
+	SysfsPath string
+	// DebugfsPath is read by the Ceph checker for the state of the CephFS
+	// clients, the Ceph checker reads the kernel log for the RBD clients
+	DebugfsPath string
+}
+
+// NewChecker returns the built-in checker with the name
+func NewChecker(name string, opts Options) (HealthChecker, error) {
+	switch name {
+	case SourceKubelet:
+		return kubeletChecker{}, nil
+	case SourceEvents:
+		return eventsChecker{}, nil
+	case SourceStats:
+		return statsChecker{timeout: opts.ProbeTimeout}, nil
+	case SourceDriver:
+		return driverChecker{logger: opts.Logger}, nil
+	case SourceMount:
+		return mountChecker{timeout: opts.ProbeTimeout}, nil
+	case SourceNFS:
+		return &shareChecker{source: SourceNFS, logger: opts.Logger, timeout: opts.ProbeTimeout, matches: mount.IsNFS}, nil
+	case SourceSMB:
+		return &shareChecker{source: SourceSMB, logger: opts.Logger, timeout: opts.ProbeTimeout, matches: mount.IsSMB}, nil
+	case SourceBind:
+		return &bindChecker{logger: opts.Logger, timeout: opts.ProbeTimeout}, nil
+	case SourceReadOnly:
+		return &readOnlyChecker{logger: opts.Logger, kernelLogPath: opts.KernelLogPath}, nil
+	case SourceIO:
+		return &ioChecker{timeout: opts.IOTimeout, hung: map[string]bool{}}, nil
+	case SourceDevice:
+		return &deviceChecker{logger: opts.Logger, devices: device.NewChecker(opts.SysfsPath)}, nil
+	case SourceCeph:
+		return &cephChecker{
+			logger:        opts.Logger,
+			kernelLogPath: opts.KernelLogPath,
+			debugfsPath:   opts.DebugfsPath,
+			devices:       device.NewChecker(opts.SysfsPath),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown health checker %q", name)
+}
+
+// kubeletChecker reads the volume health the kubelet reports in its summary
+type kubeletChecker struct{}
+
+func (kubeletChecker) Name() string {
+	return SourceKubelet
+}
+
+func (kubeletChecker) Check(_ context.Context, vol Volume) (*Signal, error) {
+	if vol.KubeletHealth == nil {
+		return nil, nil
+	}
+	return &Signal{
+		Source:   SourceKubelet,
+		Severity: severityOf(vol.KubeletHealth.Abnormal),
+		Message:  "volume health reported by the kubelet",
+	}, nil
+}
+
+// eventsChecker reports the PVCs the CSI external-health-monitor controller
+// recorded an abnormal volume condition event on
+type eventsChecker struct{}
+
+func (eventsChecker) Name() string {
+	return SourceEvents
+}
+
+func (eventsChecker) Check(_ context.Context, vol Volume) (*Signal, error) {
+	if vol.Event == "" {
+		return nil, nil
+	}
+	return &Signal{Source: SourceEvents, Severity: SeverityFailed, Message: vol.Event}, nil
+}
+
+// statsChecker confirms with the mount table and a probe of the mount that
+// volumes with missing or zero kubelet stats have a dead mount
+type statsChecker struct {
+	timeout time.Duration
+}
+
+func (statsChecker) Name() string {
+	return SourceStats
+}
+
+func (c statsChecker) Check(_ context.Context, vol Volume) (*Signal, error) {
+	if vol.Suspect == "" || vol.Info.Block {
+		return nil, nil
+	}
+	mounts, err := mount.ReadMountInfo(mount.DefaultMountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	// the kubelet may just have failed to get the stats this once
+	signal := &Signal{Source: SourceStats, Severity: SeverityWarning, Message: vol.Suspect + " but the mount is fine"}
+	if finding := mount.NewInspector(mounts).Check(vol.Info.MountPath); finding != nil {
+		// stacked mounts still serve the volume
+		signal.Severity = SeverityFailed
+		if finding.Problem == mount.ProblemDuplicated {
+			signal.Severity = SeverityDegraded
+		}
+		signal.Message = fmt.Sprintf("%s and the mount is %s", vol.Suspect, finding.Problem)
+	} else if err := mount.Probe(vol.Info.MountPath, c.timeout); err != nil {
+		signal.Severity = SeverityFailed
+		signal.Message = fmt.Sprintf("%s and %s", vol.Suspect, err)
+	}
+	return signal, nil
+}
+
+// driverChecker asks the driver for the volume condition
+type driverChecker struct {
+	logger *slog.Logger
+}
+
+func (driverChecker) Name() string {
+	return SourceDriver
+}
+
+func (c driverChecker) Check(ctx context.Context, vol Volume) (*Signal, error) {
+	if vol.Client == nil {
+		return nil, nil
+	}
+	info := vol.Info
+	resp, err := vol.Client.NodeGetVolumeStats(ctx, c.logger, info.VolumeHandle, info.MountPath, info.StagingPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats of volume %s: %w", info.VolumeHandle, err)
+	}
+	condition := resp.GetVolumeCondition()
+	return &Signal{
+		Source:   SourceDriver,
+		Severity: severityOf(condition.GetAbnormal()),
+		Message:  condition.GetMessage(),
+	}, nil
+}
+
+// mountChecker probes the mount of filesystem volumes, stale NFS mounts are
+// often reported as healthy, or not at all by drivers without volume
+// conditions
+type mountChecker struct {
+	timeout time.Duration
+}
+
+func (mountChecker) Name() string {
+	return SourceMount
+}
+
+func (c mountChecker) Check(_ context.Context, vol Volume) (*Signal, error) {
+	if vol.Info.Block {
+		return nil, nil
+	}
+	signal := &Signal{Source: SourceMount, Message: "mount is reachable"}
+	if err := mount.Probe(vol.Info.MountPath, c.timeout); err != nil {
+		signal.Severity, signal.Message = SeverityFailed, err.Error()
+	}
+	return signal, nil
+}
+
+// readOnlyChecker finds the filesystems the kernel remounted read-only,
+// which drivers often keep reporting as healthy although the volume needs to
+// be staged again
+type readOnlyChecker struct {
+	logger        *slog.Logger
+	kernelLogPath string
+
+	// readOnly holds the mounts remounted read-only by the kernel, found by
+	// the last Refresh
+	readOnly map[string]mount.ReadOnlyMount
+}
+
+func (*readOnlyChecker) Name() string {
+	return SourceReadOnly
+}
+
+// Refresh reads the mount table and the kernel log
+func (c *readOnlyChecker) Refresh() {
+	mounts, err := mount.ReadMountInfo(mount.DefaultMountInfoPath)
+	if err != nil {
+		c.logger.Error("failed to read mount table", "error", err)
+		c.readOnly = nil
+		return
+	}
+	var messages map[string]string
+	if c.kernelLogPath != "" {
+		messages, err = mount.ReadKernelLog(c.kernelLogPath)
+		if err != nil {
+			// the mount table alone is enough to find the mounts
+			c.logger.Error("failed to read kernel log", "error", err)
+		}
+	}
+	c.readOnly = mount.ReadOnlyMounts(mounts, messages)
+}
+
+func (c *readOnlyChecker) Check(_ context.Context, vol Volume) (*Signal, error) {
+	if vol.Info.Block {
+		return nil, nil
+	}
+	signal := &Signal{Source: SourceReadOnly, Message: "filesystem is writable"}
+	if m, ok := c.remountedReadOnly(vol.Info); ok {
+		signal.Severity = SeverityFailed
+		signal.Message = fmt.Sprintf("filesystem of %s on %s was remounted read-only", m.Source, m.MountPoint)
+		if m.Message != "" {
+			signal.Message += ": " + m.Message
+		}
+	}
+	return signal, nil
+}
+
+// remountedReadOnly returns the mount of the filesystem volume if the kernel
+// remounted it read-only. The staging mount is checked as well as the publish
+// one since both share the filesystem.
+func (c *readOnlyChecker) remountedReadOnly(info *volume.VolumeInfo) (mount.ReadOnlyMount, bool) {
+	for _, path := range []string{info.MountPath, info.StagingPath} {
+		if path == "" {
+			continue
+		}
+		if m, ok := c.readOnly[filepath.Clean(path)]; ok {
+			return m, true
+		}
+	}
+	return mount.ReadOnlyMount{}, false
+}
+
+// deviceChecker checks the device-mapper device backing the volume
+type deviceChecker struct {
+	logger  *slog.Logger
+	devices *device.Checker
+
+	// mounts holds the mount table read by the last Refresh
+	mounts map[string]mount.Mount
+}
+
+func (*deviceChecker) Name() string {
+	return SourceDevice
+}
+
+// Refresh reads the mount table
+func (c *deviceChecker) Refresh() {
+	mounts, err := mount.ReadMountInfo(mount.DefaultMountInfoPath)
+	if err != nil {
+		c.logger.Error("failed to read mount table", "error", err)
+	}
+	c.mounts = map[string]mount.Mount{}
+	for _, m := range mounts {
+		c.mounts[filepath.Clean(m.MountPoint)] = m
+	}
+}
+
+func (c *deviceChecker) Check(_ context.Context, vol Volume) (*Signal, error) {
+	var number string
+	if vol.Info.Block {
+		// the device file can be stat'ed even when its device hangs
+		var err error
+		if number, err = device.Number(vol.Info.MountPath); err != nil {
+			return nil, fmt.Errorf("failed to find the device of volume %s: %w", vol.Info.VolumeHandle, err)
+		}
+	} else {
+		// the mount table tells the device without touching a mount
+		// which may hang on a suspended device
+		m, ok := c.mount(vol.Info)
+		if !ok {
+			return nil, nil
+		}
+		number = m.Device
+	}
+	findings, err := c.devices.Check(number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check the device of volume %s: %w", vol.Info.VolumeHandle, err)
+	}
+	signal := &Signal{Source: SourceDevice, Message: "device is healthy"}
+	if len(findings) > 0 {
+		var problems []string
+		for _, f := range findings {
+			problems = append(problems, f.Device+" "+f.Problem)
+			signal.Severity = max(signal.Severity, deviceSeverity(f.Problem))
+		}
+		signal.Message = strings.Join(problems, ", ")
+	}
+	return signal, nil
+}
+
+// mount returns the mount of the filesystem volume, the staging mount shares
+// the filesystem of the publish one
+func (c *deviceChecker) mount(info *volume.VolumeInfo) (mount.Mount, bool) {
+	for _, path := range []string{info.MountPath, info.StagingPath} {
+		if path == "" {
+			continue
+		}
+		if m, ok := c.mounts[filepath.Clean(path)]; ok {
+			return m, true
+		}
+	}
+	return mount.Mount{}, false
+}
+
+// deviceSeverity grades the device problems, a failed path of a multipath
+// device which still has active paths only degrades the volume
+func deviceSeverity(problem string) Severity {
+	if problem == device.ProblemFailedPath {
+		return SeverityDegraded
+	}
+	return SeverityFailed
+}
@@ -0,0 +1,156 @@
+// Package healthcheck merges the health signals of a CSI volume, reported by
+// the kubelet, by the driver and found by checking its mount locally, into a
+// single verdict used to decide whether the volume needs recovering. Each
+// signal comes from a HealthChecker, new detection mechanisms are added by
+// implementing one and adding it to the pipeline.
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/csi"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+	v1alpha1 "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+)
+
+// Names of the built-in checkers, the sources of their signals
+const (
+	// SourceKubelet is the volume health the kubelet reports in its summary
+	SourceKubelet = "kubelet"
+	// SourceEvents is the abnormal volume condition event the CSI
+	// external-health-monitor controller recorded on the PVC
+	SourceEvents = "events"
+	// SourceStats is the confirmation of missing or zero kubelet stats
+	SourceStats = "stats"
+	// SourceDriver is the volume condition of NodeGetVolumeStats
+	SourceDriver = "driver"
+	// SourceMount is the probe of the mount
+	SourceMount = "mount"
+	// SourceReadOnly is the check for filesystems remounted read-only
+	SourceReadOnly = "read-only"
+	// SourceDevice is the check of the device-mapper device
+	SourceDevice = "device"
+)
+
+// Signal is the health of a volume according to one source
+type Signal struct {
+	Source   string
+	Severity Severity
+	Message  string
+}
+
+// Abnormal reports whether the source found the volume abnormal
+func (s Signal) Abnormal() bool {
+	return s.Severity > SeverityNone
+}
+
+// Verdict is the health of a volume merged from all its signals
+type Verdict struct {
+	Signals []Signal
+}
+
+// Known reports whether any source reported on the volume
+func (v Verdict) Known() bool {
+	return len(v.Signals) > 0
+}
+
+// Abnormal reports whether any source found the volume abnormal
+func (v Verdict) Abnormal() bool {
+	return v.Severity() > SeverityNone
+}
+
+// Severity is the highest severity of the signals
+func (v Verdict) Severity() Severity {
+	severity := SeverityNone
+	for _, s := range v.Signals {
+		severity = max(severity, s.Severity)
+	}
+	return severity
+}
+
+// Reason describes the abnormal signals
+func (v Verdict) Reason() string {
+	var reasons []string
+	for _, s := range v.Signals {
+		if s.Abnormal() {
+			reasons = append(reasons, s.Source+" ("+s.Severity.String()+"): "+s.Message)
+		}
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// Volume is the volume to check
+type Volume struct {
+	Info *volume.VolumeInfo
+	// KubeletHealth is the health in the kubelet summary, nil when the
+	// kubelet doesn't monitor volume health
+	KubeletHealth *v1alpha1.VolumeHealthStats
+	// Client is the driver of the volume, nil when the driver doesn't
+	// report volume conditions
+	Client csi.Client
+	// Event is the message of a recent abnormal volume condition event of
+	// the PVC, empty when there is none
+	Event string
+	// Suspect is why the kubelet stats of the volume hint at a dead mount,
+	// empty when they look fine
+	Suspect string
+}
+
+// HealthChecker detects one kind of volume failure
+type HealthChecker interface {
+	// Name identifies the checker, it is the source of its signals
+	Name() string
+	// Check returns the health of the volume, nil when the checker doesn't
+	// apply to the volume, e.g. a mount check for a block volume
+	Check(ctx context.Context, vol Volume) (*Signal, error)
+}
+
+// Refresher is implemented by the checkers reading node state shared by all
+// the volumes, which is refreshed once per pass
+type Refresher interface {
+	Refresh()
+}
+
+// Pipeline runs an ordered list of checkers on each volume
+type Pipeline struct {
+	checkers []HealthChecker
+}
+
+// NewPipeline returns a pipeline running the checkers in order
+func NewPipeline(checkers ...HealthChecker) *Pipeline {
+	return &Pipeline{
+		checkers: checkers,
+	}
+}
+
+// Refresh refreshes the node state of the checkers
+func (p *Pipeline) Refresh() {
+	for _, c := range p.checkers {
+		if r, ok := c.(Refresher); ok {
+			r.Refresh()
+		}
+	}
+}
+
+// Check runs the checkers in order until one finds the volume failed, so the
+// checkers at the end of the list, e.g. the driver, aren't queried for
+// volumes already known to be failed.
+func (p *Pipeline) Check(ctx context.Context, vol Volume) (Verdict, error) {
+	var verdict Verdict
+	for _, c := range p.checkers {
+		signal, err := c.Check(ctx, vol)
+		if err != nil {
+			return verdict, fmt.Errorf("%s check failed: %w", c.Name(), err)
+		}
+		if signal == nil {
+			continue
+		}
+		verdict.Signals = append(verdict.Signals, *signal)
+		if signal.Severity == SeverityFailed {
+			break
+		}
+	}
+	return verdict, nil
+}
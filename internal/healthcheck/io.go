@@ -0,0 +1,124 @@
+package healthcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SourceIO is the active I/O probe of the volume
+const SourceIO = "io"
+
+// canaryName is the file the I/O probe writes to filesystem volumes
+const canaryName = ".csi-volume-recovery-probe"
+
+// ioChecker writes, reads back and deletes a canary file on filesystem
+// volumes and reads the first block of block volumes with O_DIRECT, catching
+// hung or read-only storage passive stats miss. Read-only filesystems are
+// only read. A probe that doesn't return within the timeout keeps blocking
+// in the background, the volume is reported abnormal until it returns
+// instead of piling up more blocked probes.
+type ioChecker struct {
+	timeout time.Duration
+
+	mu sync.Mutex
+	// hung holds the paths whose last probe hasn't returned yet
+	hung map[string]bool
+}
+
+func (*ioChecker) Name() string {
+	return SourceIO
+}
+
+func (c *ioChecker) Check(_ context.Context, vol Volume) (*Signal, error) {
+	path := vol.Info.MountPath
+	c.mu.Lock()
+	if c.hung[path] {
+		c.mu.Unlock()
+		return &Signal{Source: SourceIO, Severity: SeverityFailed, Message: "previous I/O probe is still blocked"}, nil
+	}
+	c.hung[path] = true
+	c.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		if vol.Info.Block {
+			err = readDirect(path)
+		} else {
+			err = probeFilesystem(path)
+		}
+		c.mu.Lock()
+		delete(c.hung, path)
+		c.mu.Unlock()
+		done <- err
+	}()
+
+	signal := &Signal{Source: SourceIO, Message: "I/O succeeded"}
+	select {
+	case err := <-done:
+		if err != nil {
+			signal.Severity, signal.Message = SeverityFailed, err.Error()
+		}
+	case <-time.After(c.timeout):
+		signal.Severity, signal.Message = SeverityFailed, fmt.Sprintf("I/O on %s didn't complete within %s", path, c.timeout)
+	}
+	return signal, nil
+}
+
+// probeFilesystem writes, syncs, reads back and removes the canary file, or
+// lists the directory of read-only filesystems
+func probeFilesystem(dir string) error {
+	readOnly, err := isReadOnly(dir)
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		f, err := os.Open(dir)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", dir, err)
+		}
+		defer f.Close()
+		if _, err := f.Readdirnames(1); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read directory %s: %w", dir, err)
+		}
+		return nil
+	}
+
+	path := filepath.Join(dir, canaryName)
+	content := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	// the canary must not be left behind in the application's volume
+	defer os.Remove(path)
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	read, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if !bytes.Equal(read, content) {
+		return fmt.Errorf("%s reads back different content than written", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	return nil
+}
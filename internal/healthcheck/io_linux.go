@@ -0,0 +1,40 @@
+package healthcheck
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// directIOAlignment is the alignment of O_DIRECT buffers and reads
+const directIOAlignment = 4096
+
+// isReadOnly reports whether the filesystem is mounted read-only
+func isReadOnly(path string) (bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return false, fmt.Errorf("failed to statfs %s: %w", path, err)
+	}
+	return st.Flags&unix.ST_RDONLY != 0, nil
+}
+
+// readDirect reads the first block of the device bypassing the page cache,
+// so the read reaches the storage
+func readDirect(path string) error {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECT, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	buf := make([]byte, 2*directIOAlignment)
+	offset := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1))
+	if offset != 0 {
+		offset = directIOAlignment - offset
+	}
+	if _, err := unix.Pread(fd, buf[offset:offset+directIOAlignment], 0); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
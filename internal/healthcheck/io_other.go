@@ -0,0 +1,28 @@
+//go:build !linux
+
+package healthcheck
+
+import (
+	"fmt"
+	"os"
+)
+
+// isReadOnly can't tell read-only filesystems outside of Linux, their probe
+// fails to write
+func isReadOnly(string) (bool, error) {
+	return false, nil
+}
+
+// readDirect reads the first block of the device through the page cache
+// outside of Linux
+func readDirect(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.Read(make([]byte, 4096)); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
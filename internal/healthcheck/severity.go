@@ -0,0 +1,55 @@
+package healthcheck
+
+import (
+	"fmt"
+)
+
+// Severity grades how badly a volume is affected
+type Severity int
+
+// Severities from healthy to unusable
+const (
+	// SeverityNone means the volume is healthy
+	SeverityNone Severity = iota
+	// SeverityWarning means something looks off but the volume works
+	SeverityWarning
+	// SeverityDegraded means the volume works with reduced redundancy or
+	// performance, e.g. a failed multipath path
+	SeverityDegraded
+	// SeverityFailed means the volume can't be used
+	SeverityFailed
+)
+
+var severityNames = map[Severity]string{
+	SeverityNone:     "none",
+	SeverityWarning:  "warning",
+	SeverityDegraded: "degraded",
+	SeverityFailed:   "failed",
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// ParseSeverity parses the name of an abnormal severity: warning, degraded
+// or failed
+func ParseSeverity(name string) (Severity, error) {
+	for s, n := range severityNames {
+		if n == name && s != SeverityNone {
+			return s, nil
+		}
+	}
+	return SeverityNone, fmt.Errorf("unknown severity %q, expected warning, degraded or failed", name)
+}
+
+// severityOf returns failed for abnormal volumes and none otherwise, for the
+// sources which only tell whether the volume is abnormal
+func severityOf(abnormal bool) Severity {
+	if abnormal {
+		return SeverityFailed
+	}
+	return SeverityNone
+}
@@ -0,0 +1,148 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/mount"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+)
+
+// Sources of the checks of the network shares
+const (
+	// SourceNFS is the check of the NFS mounts, e.g. of csi-driver-nfs
+	SourceNFS = "nfs"
+	// SourceSMB is the check of the SMB mounts, e.g. of csi-driver-smb
+	SourceSMB = "smb"
+)
+
+// shareChecker checks the NFS or SMB mount of filesystem volumes, whatever
+// their driver: the mount is queried, which tells stale file handles of
+// removed or recreated exports and credentials the server rejected, and the
+// server is dialed, which tells a server gone from a mount answering from the
+// cache or hung on it.
+type shareChecker struct {
+	source  string
+	logger  *slog.Logger
+	timeout time.Duration
+	// matches reports whether the mount is of the checked kind
+	matches func(mount.Mount) bool
+
+	// mounts holds the mount table read by the last Refresh
+	mounts map[string]mount.Mount
+
+	mu sync.Mutex
+	// servers holds the result of dialing each server since the last
+	// Refresh, the volumes of a server share it
+	servers map[string]error
+}
+
+func (c *shareChecker) Name() string {
+	return c.source
+}
+
+// Refresh reads the mount table and forgets the servers dialed
+func (c *shareChecker) Refresh() {
+	mounts, err := mount.ReadMountInfo(mount.DefaultMountInfoPath)
+	if err != nil {
+		c.logger.Error("failed to read mount table", "error", err)
+	}
+	c.mounts = map[string]mount.Mount{}
+	for _, m := range mounts {
+		if c.matches(m) {
+			c.mounts[filepath.Clean(m.MountPoint)] = m
+		}
+	}
+	c.mu.Lock()
+	c.servers = map[string]error{}
+	c.mu.Unlock()
+}
+
+func (c *shareChecker) Check(_ context.Context, vol Volume) (*Signal, error) {
+	if vol.Info.Block {
+		return nil, nil
+	}
+	m, ok := c.mount(vol.Info)
+	if !ok {
+		return nil, nil
+	}
+	signal := &Signal{Source: c.source, Message: "share is reachable"}
+	queryErr := mount.Query(vol.Info.MountPath, c.timeout)
+	switch {
+	case queryErr == nil:
+	case errors.Is(queryErr, syscall.ESTALE):
+		signal.Severity = SeverityFailed
+		signal.Message = fmt.Sprintf("stale file handle, the export %s was removed or recreated", m.Source)
+		return signal, nil
+	case mount.IsExpired(queryErr):
+		signal.Severity = SeverityFailed
+		signal.Message = fmt.Sprintf("Kerberos ticket of the mount of %s expired", m.Source)
+		return signal, nil
+	case mount.IsDenied(queryErr):
+		signal.Severity = SeverityFailed
+		signal.Message = fmt.Sprintf("server of %s rejected the credentials of the mount: %s", m.Source, queryErr)
+		return signal, nil
+	case errors.Is(queryErr, mount.ErrNotResponding) || mount.IsStale(queryErr):
+		signal.Severity = SeverityFailed
+		signal.Message = queryErr.Error()
+	default:
+		return nil, queryErr
+	}
+	address, ok := mount.ServerAddress(m)
+	if !ok {
+		return signal, nil
+	}
+	if err := c.dial(address); err != nil {
+		if signal.Severity == SeverityNone {
+			// the mount still answers, e.g. from the cache of a soft
+			// mount, but the next uncached access fails or hangs
+			signal.Severity = SeverityDegraded
+			signal.Message = fmt.Sprintf("server of %s is unreachable: %s", m.Source, err)
+		} else {
+			signal.Message += fmt.Sprintf(", server of %s is unreachable: %s", m.Source, err)
+		}
+	}
+	return signal, nil
+}
+
+// dial connects to the server once per Refresh
+func (c *shareChecker) dial(address string) error {
+	c.mu.Lock()
+	err, ok := c.servers[address]
+	c.mu.Unlock()
+	if ok {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", address, c.timeout)
+	if err == nil {
+		conn.Close()
+	}
+	c.mu.Lock()
+	if c.servers == nil {
+		c.servers = map[string]error{}
+	}
+	c.servers[address] = err
+	c.mu.Unlock()
+	return err
+}
+
+// mount returns the share mounted for the volume, on the publish path or on
+// the staging path of drivers which stage the share
+func (c *shareChecker) mount(info *volume.VolumeInfo) (mount.Mount, bool) {
+	for _, path := range []string{info.MountPath, info.StagingPath} {
+		if path == "" {
+			continue
+		}
+		if m, ok := c.mounts[filepath.Clean(path)]; ok {
+			return m, true
+		}
+	}
+	return mount.Mount{}, false
+}
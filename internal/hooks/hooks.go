@@ -0,0 +1,120 @@
+// Package hooks runs the commands and webhooks configured to be notified
+// before and after each recovery action, e.g. to quiesce an application
+// before its pod is bounced.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Phases of a recovery action the hooks run in
+const (
+	PhasePre  = "pre"
+	PhasePost = "post"
+)
+
+// Payload describes the recovery action to the hook, as JSON on the standard
+// input of commands and in the body of webhooks
+type Payload struct {
+	Phase     string    `json:"phase"`
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Node      string    `json:"node"`
+	Driver    string    `json:"driver"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	PVC       string    `json:"pvc,omitempty"`
+	Volume    string    `json:"volume"`
+	// Result and Error are set in the post phase
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Hook is notified of a recovery action
+type Hook interface {
+	Run(ctx context.Context, payload Payload) error
+}
+
+// Parse returns the hook of the spec, a webhook for http and https URLs,
+// otherwise a command and its arguments separated by spaces. The hook is
+// bounded by the timeout.
+func Parse(spec string, timeout time.Duration) (Hook, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, errors.New("empty hook")
+	}
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return &webhook{url: spec, client: &http.Client{Timeout: timeout}}, nil
+	}
+	return &command{args: strings.Fields(spec), timeout: timeout}, nil
+}
+
+// command runs a command with the payload as JSON on its standard input and
+// in CSI_RECOVERY_* environment variables
+type command struct {
+	args    []string
+	timeout time.Duration
+}
+
+func (c *command) Run(ctx context.Context, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode hook payload: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.args[0], c.args[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"CSI_RECOVERY_PHASE="+payload.Phase,
+		"CSI_RECOVERY_ACTION="+payload.Action,
+		"CSI_RECOVERY_NODE="+payload.Node,
+		"CSI_RECOVERY_DRIVER="+payload.Driver,
+		"CSI_RECOVERY_NAMESPACE="+payload.Namespace,
+		"CSI_RECOVERY_POD="+payload.Pod,
+		"CSI_RECOVERY_PVC="+payload.PVC,
+		"CSI_RECOVERY_VOLUME="+payload.Volume,
+		"CSI_RECOVERY_RESULT="+payload.Result,
+		"CSI_RECOVERY_ERROR="+payload.Error,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s hook %s failed: %w: %s", payload.Phase, c.args[0], err, out)
+	}
+	return nil
+}
+
+// webhook posts the payload as JSON to a URL, any status but 2xx fails
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+func (w *webhook) Run(ctx context.Context, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode hook payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build %s hook request: %w", payload.Phase, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %w", payload.Phase, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s hook failed: %s", payload.Phase, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Annotations recording the last recovery of a PVC
+const (
+	annotationPrefix = "csi-recovery.io/"
+
+	LastRecoveryTimeAnnotation = annotationPrefix + "last-recovery-time"
+	LastActionAnnotation       = annotationPrefix + "last-action"
+	LastResultAnnotation       = annotationPrefix + "last-result"
+	LastErrorAnnotation        = annotationPrefix + "last-error"
+)
+
+// UsageWarningAnnotation reports that the space or inodes of the volume of a
+// PVC are running out
+const UsageWarningAnnotation = annotationPrefix + "usage-warning"
+
+// annotationsPatch returns a merge patch setting the annotations, nil values
+// remove the annotation
+func annotationsPatch(annotations map[string]*string) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": annotations,
+		},
+	})
+}
+
+// AnnotatePVC sets the annotations on the PVC, nil values remove the
+// annotation.
+func (c *client) AnnotatePVC(ctx context.Context, pvcName, namespace string, annotations map[string]*string) error {
+	patch, err := annotationsPatch(annotations)
+	if err != nil {
+		return fmt.Errorf("failed to build annotations patch: %w", err)
+	}
+	_, err = c.CoreV1().PersistentVolumeClaims(namespace).Patch(ctx, pvcName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to annotate PVC %s in namespace %s: %w", pvcName, namespace, err)
+	}
+	return nil
+}
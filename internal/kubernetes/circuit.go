@@ -0,0 +1,115 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Annotations recording the open recovery circuits, removing them resets
+// the circuit
+const (
+	// CircuitOpenAnnotation is set on the PVC, or the pod of an inline
+	// volume, whose recoveries kept failing, to the time the circuit opened
+	CircuitOpenAnnotation = annotationPrefix + "circuit-open"
+	// CircuitOpenDriversAnnotation is set on the node to the comma separated
+	// drivers whose recoveries kept failing
+	CircuitOpenDriversAnnotation = annotationPrefix + "circuit-open-drivers"
+)
+
+// CircuitOpenReason is the reason of the events recorded when a circuit opens
+const CircuitOpenReason = "RecoveryCircuitOpen"
+
+// VolumeCircuitOpen reports whether the recovery circuit of the volume is
+// open. The PVC name is empty for inline ephemeral volumes, whose circuit is
+// on their pod.
+func (c *client) VolumeCircuitOpen(ctx context.Context, namespace, podName, pvcName string) (bool, error) {
+	if pvcName == "" {
+		pod, err := c.getPod(ctx, namespace, podName)
+		if err != nil {
+			return false, err
+		}
+		_, open := pod.Annotations[CircuitOpenAnnotation]
+		return open, nil
+	}
+	pvc, err := c.GetPVC(ctx, pvcName, namespace)
+	if err != nil {
+		return false, err
+	}
+	_, open := pvc.Annotations[CircuitOpenAnnotation]
+	return open, nil
+}
+
+// OpenVolumeCircuit stops the recoveries of the volume until the annotation
+// is removed, and records a warning event explaining why
+func (c *client) OpenVolumeCircuit(ctx context.Context, namespace, podName, pvcName, message string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	patch, err := annotationsPatch(map[string]*string{CircuitOpenAnnotation: &now})
+	if err != nil {
+		return fmt.Errorf("failed to build annotations patch: %w", err)
+	}
+	ref := v1.ObjectReference{Namespace: namespace}
+	if pvcName == "" {
+		pod, err := c.CoreV1().Pods(namespace).Patch(ctx, podName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+		if err != nil {
+			return fmt.Errorf("failed to annotate pod %s in namespace %s: %w", podName, namespace, err)
+		}
+		ref.Kind, ref.APIVersion, ref.Name, ref.UID = "Pod", "v1", pod.Name, pod.UID
+	} else {
+		pvc, err := c.CoreV1().PersistentVolumeClaims(namespace).Patch(ctx, pvcName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+		if err != nil {
+			return fmt.Errorf("failed to annotate PVC %s in namespace %s: %w", pvcName, namespace, err)
+		}
+		ref.Kind, ref.APIVersion, ref.Name, ref.UID = "PersistentVolumeClaim", "v1", pvc.Name, pvc.UID
+	}
+	return c.recordWarning(ctx, ref, CircuitOpenReason, message)
+}
+
+// OpenDriverCircuits returns the drivers whose recovery circuit is open on
+// the node
+func (c *client) OpenDriverCircuits(ctx context.Context) (map[string]bool, error) {
+	node, err := c.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %w", c.nodeName, err)
+	}
+	drivers := map[string]bool{}
+	for _, driver := range strings.Split(node.Annotations[CircuitOpenDriversAnnotation], ",") {
+		if driver = strings.TrimSpace(driver); driver != "" {
+			drivers[driver] = true
+		}
+	}
+	return drivers, nil
+}
+
+// OpenDriverCircuit stops the recoveries of the driver's volumes on the node
+// until the driver is removed from the annotation, and records a warning
+// event explaining why
+func (c *client) OpenDriverCircuit(ctx context.Context, driver, message string) error {
+	open, err := c.OpenDriverCircuits(ctx)
+	if err != nil {
+		return err
+	}
+	open[driver] = true
+	drivers := make([]string, 0, len(open))
+	for d := range open {
+		drivers = append(drivers, d)
+	}
+	slices.Sort(drivers)
+	value := strings.Join(drivers, ",")
+	patch, err := annotationsPatch(map[string]*string{CircuitOpenDriversAnnotation: &value})
+	if err != nil {
+		return fmt.Errorf("failed to build annotations patch: %w", err)
+	}
+	node, err := c.CoreV1().Nodes().Patch(ctx, c.nodeName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to annotate node %s: %w", c.nodeName, err)
+	}
+	ref := v1.ObjectReference{Kind: "Node", APIVersion: "v1", Name: node.Name, UID: node.UID}
+	return c.recordWarning(ctx, ref, CircuitOpenReason, message)
+}
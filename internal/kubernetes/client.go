@@ -2,54 +2,302 @@ package kubernetes
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"runtime"
+	"sync"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/scale"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/retry"
 	v1alpha1 "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
 )
 
+// FieldManager is the field manager of the writes of the tool, attributing its
+// changes in the managed fields of the objects and in server-side apply
+// conflicts
+const FieldManager = "csi-volume-recovery"
+
+// userAgent returns the user agent of the requests of the tool, attributing
+// them in the audit logs of the API server
+func userAgent() string {
+	return fmt.Sprintf("%s (%s/%s) %s", FieldManager, runtime.GOOS, runtime.GOARCH, runtime.Version())
+}
+
 type Client interface {
+	CheckPermissions(ctx context.Context, permissions []Permission) ([]Permission, error)
+	APIServerReady(ctx context.Context) error
+	StartInformers(ctx context.Context) error
 	GetMetrics(context.Context) (*v1alpha1.Summary, error)
 	GetPVC(ctx context.Context, pvcName, namespace string) (*v1.PersistentVolumeClaim, error)
 	GetPV(ctx context.Context, pvName string) (*v1.PersistentVolume, error)
-	findTopOwner(namespace string, ownerRefs []metav1.OwnerReference) (string, string, error)
-	ScaleOwner(namespace string, podName string, replicaCount int32) error
+	PrefetchVolumes(ctx context.Context, claims map[string][]string) error
+	ForgetVolumes()
+	ListNodePods(ctx context.Context) ([]v1.Pod, error)
+	PodRestarted(ctx context.Context, namespace, podName, podUID string) (bool, error)
+	GetSecret(ctx context.Context, name, namespace string) (map[string]string, error)
+	AnnotatePVC(ctx context.Context, pvcName, namespace string, annotations map[string]*string) error
+	VolumeCircuitOpen(ctx context.Context, namespace, podName, pvcName string) (bool, error)
+	OpenVolumeCircuit(ctx context.Context, namespace, podName, pvcName, message string) error
+	OpenDriverCircuits(ctx context.Context) (map[string]bool, error)
+	OpenDriverCircuit(ctx context.Context, driver, message string) error
+	AppendHistory(ctx context.Context, namespace string, entries []HistoryEntry, limit int) error
+	RecoveryDisabled(ctx context.Context, namespace, podName, pvcName string) (string, error)
+	Quarantine(ctx context.Context, namespace, podName, pvcName, reason string) (bool, error)
+	AbnormalVolumeEvents(ctx context.Context, window time.Duration) (map[string]string, error)
+	VolumeFailureEvent(ctx context.Context, namespace, podUID string, since time.Time) (string, error)
+	ExpandPVC(ctx context.Context, pvcName, namespace string, percent int, limit resource.Quantity) (*resource.Quantity, error)
+	ListVolumeAttachments(ctx context.Context) ([]storagev1.VolumeAttachment, error)
+	VolumeAttachmentGone(ctx context.Context, name string, uid types.UID) (bool, error)
+	DeleteVolumeAttachment(ctx context.Context, va *storagev1.VolumeAttachment) error
+	PublishContext(ctx context.Context, driver, pvName, attachmentID string) (map[string]string, error)
+	PodInfoOnMount(ctx context.Context, driver string) (bool, error)
+	CordonNode(ctx context.Context) (bool, error)
+	UncordonNode(ctx context.Context) error
+	AddNodeTaint(ctx context.Context, taint v1.Taint) error
+	RemoveNodeTaint(ctx context.Context, taint v1.Taint) error
+	SetNodeCondition(ctx context.Context, condition v1.NodeCondition) error
+	RecordPassSummary(ctx context.Context, message string, warning bool) error
+	RecordVolumeCondition(ctx context.Context, namespace, pvcName string, abnormal bool, message string) error
+	DeferForBackup(ctx context.Context, namespace, podName, podUID string) error
+	UpdateVolumeHealth(ctx context.Context, namespace, pvcName string, update func(*VolumeHealthStatus)) error
+	findTopOwner(ctx context.Context, namespace string, ownerRefs []metav1.OwnerReference) (*metav1.OwnerReference, error)
+	RestoreScaledOwners(ctx context.Context) ([]string, error)
+	ScaleOwner(ctx context.Context, namespace string, podName string, replicaCount int32, hook ScaledDownHook) error
+	ScaledOwner(ctx context.Context, namespace, podName string) (string, error)
 	RestartPod(ctx context.Context, namespace, podName string) error
+	ClaimReplacementReady(ctx context.Context, namespace, pvcName string, since time.Time) (bool, error)
+}
+
+// Options configures how the client recovers the workloads
+type Options struct {
+	// DaemonSetPolicy is either DaemonSetDeletePod or DaemonSetRolloutRestart
+	DaemonSetPolicy string
+	// JobPolicy is either JobDeletePod or JobSkip
+	JobPolicy string
+	// OwnerPolicies maps the Kind.group of other owners, e.g. custom
+	// workloads, to OwnerScale, OwnerDeletePod or OwnerSkip
+	OwnerPolicies map[string]string
+	// HPAPolicy is either HPAPause or HPASkip
+	HPAPolicy string
+	// PDBPolicy is PDBEvict, PDBWait, PDBSkip or PDBIgnore
+	PDBPolicy string
+	// PDBWaitTimeout is how long PDBWait waits for the budgets to allow a
+	// pod to be restarted, 5 minutes when unset
+	PDBWaitTimeout time.Duration
+	// LockHolder identifies the tool in the lock annotations of the owners,
+	// the node name when unset so a restarted instance recognizes its locks
+	LockHolder string
+	// LockTTL is how long the lock on an owner is valid unless renewed, 10
+	// minutes when unset
+	LockTTL time.Duration
+	// ScaleTimeout is how long to wait for a scaled down owner to have no
+	// replicas, 2 minutes when unset
+	ScaleTimeout time.Duration
+	// PodDeletionTimeout is how long to wait for a deleted pod to be gone,
+	// deleted pods aren't waited for when unset
+	PodDeletionTimeout time.Duration
+	// ReplacementTimeout is how long to wait for a restarted pod to be
+	// replaced by a running pod, restarted pods aren't waited for when unset
+	ReplacementTimeout time.Duration
+	// APITimeout bounds every request made to the API server, unbounded
+	// when unset
+	APITimeout time.Duration
+	// ImpersonateUser and ImpersonateGroups make the requests on behalf of
+	// another identity
+	ImpersonateUser   string
+	ImpersonateGroups []string
+	// KubeletDirect fetches the kubelet stats from the kubelet itself
+	// instead of through the API server proxy
+	KubeletDirect bool
+	// KubeletPort is the port of the kubelet API, 10250 when unset
+	KubeletPort int
+	// KubeletCAFile is the CA bundle verifying the kubelet serving
+	// certificate, which the cluster CA of the service account doesn't sign
+	// on most clusters
+	KubeletCAFile string
+	// QPS and Burst limit the requests made to the API server, the
+	// client-go defaults are used when unset
+	QPS   float32
+	Burst int
+	// StatsRetries is the number of times getting the kubelet stats is
+	// retried before giving up
+	StatsRetries int
+	// PageSize is the number of objects listed at a time, lists are made
+	// at once when 0
+	PageSize int64
+	// VolumeEvents caches the abnormal volume condition events of the PVCs
+	// along with the other informers
+	VolumeEvents bool
+	// VolumeAttachments caches the VolumeAttachments along with the other
+	// informers
+	VolumeAttachments bool
+	// VeleroNamespace is where Velero keeps its backups and restores, the
+	// volumes of pods aren't recovered while one covering their namespace
+	// is in progress. Velero isn't checked when unset
+	VeleroNamespace string
 }
+
 type client struct {
 	*kubernetes.Clientset
-	nodeName string
-	timeout  time.Duration
+	config             *rest.Config
+	scaleClient        scale.ScalesGetter
+	dynamicClient      dynamic.Interface
+	mapper             meta.ResettableRESTMapper
+	nodeName           string
+	scaleTimeout       time.Duration
+	podDeletionTimeout time.Duration
+	replacementTimeout time.Duration
+	daemonSetPolicy    string
+	jobPolicy          string
+	ownerPolicies      map[string]string
+	hpaPolicy          string
+	pdbPolicy          string
+	pdbWaitTimeout     time.Duration
+	lockHolder         string
+	lockTTL            time.Duration
+	kubeletDirect      bool
+	kubeletPort        int
+	kubeletCAFile      string
+	statsRetries       int
+	pageSize           int64
+	volumeEvents       bool
+	volumeAttachments  bool
+	veleroNamespace    string
+
+	// scaledMu serializes the updates of the owners scaled down on the node
+	scaledMu sync.Mutex
+
+	// listers are set once the informers are started
+	pvcLister   corelisters.PersistentVolumeClaimLister
+	pvLister    corelisters.PersistentVolumeLister
+	podLister   corelisters.PodLister
+	eventLister corelisters.EventLister
+	vaLister    storagelisters.VolumeAttachmentLister
+	// prefetched serves the lookups of a pass without informers
+	prefetched prefetched
 }
 
 var _ Client = &client{}
 
-func NewClient(kubeconfigpath, nodeName string) (Client, error) {
-	var config *rest.Config
-	var err error
+// restConfig loads the kubeconfig at the path. Without a path the in-cluster
+// config is used, falling back to the default kubeconfig loading rules, i.e.
+// $KUBECONFIG or ~/.kube/config, when not running in a cluster.
+func restConfig(kubeconfigpath string) (*rest.Config, error) {
 	if kubeconfigpath != "" {
-		if _, err = os.Stat(kubeconfigpath); err != nil {
+		if _, err := os.Stat(kubeconfigpath); err != nil {
 			return nil, fmt.Errorf("error fetching kubeconfig path: %s %w", kubeconfigpath, err)
 		}
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfigpath)
+		config, err := clientcmd.BuildConfigFromFlags("", kubeconfigpath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to build config from kubeconfig: %w", err)
 		}
-	} else {
-		config, err = rest.InClusterConfig()
-		if err != nil {
-			return nil, fmt.Errorf("failed to build in cluster config: %w", err)
+		return config, nil
+	}
+
+	config, inClusterErr := rest.InClusterConfig()
+	if inClusterErr == nil {
+		return config, nil
+	}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build in cluster config (%v) or config from the default kubeconfig: %w", inClusterErr, err)
+	}
+	return config, nil
+}
+
+func NewClient(kubeconfigpath, nodeName string, opts Options) (Client, error) {
+	switch opts.DaemonSetPolicy {
+	case "":
+		opts.DaemonSetPolicy = DaemonSetDeletePod
+	case DaemonSetDeletePod, DaemonSetRolloutRestart:
+	default:
+		return nil, fmt.Errorf("unsupported DaemonSet policy: %s", opts.DaemonSetPolicy)
+	}
+	switch opts.JobPolicy {
+	case "":
+		opts.JobPolicy = JobSkip
+	case JobDeletePod, JobSkip:
+	default:
+		return nil, fmt.Errorf("unsupported Job policy: %s", opts.JobPolicy)
+	}
+	switch opts.HPAPolicy {
+	case "":
+		opts.HPAPolicy = HPAPause
+	case HPAPause, HPASkip:
+	default:
+		return nil, fmt.Errorf("unsupported HPA policy: %s", opts.HPAPolicy)
+	}
+	switch opts.PDBPolicy {
+	case "":
+		opts.PDBPolicy = PDBEvict
+	case PDBEvict, PDBWait, PDBSkip, PDBIgnore:
+	default:
+		return nil, fmt.Errorf("unsupported PDB policy: %s", opts.PDBPolicy)
+	}
+	for kind, policy := range opts.OwnerPolicies {
+		switch policy {
+		case OwnerScale, OwnerDeletePod, OwnerSkip:
+		default:
+			return nil, fmt.Errorf("unsupported policy %s for owner kind %s", policy, kind)
+		}
+	}
+
+	for name, timeout := range map[string]time.Duration{
+		"scale":        opts.ScaleTimeout,
+		"pod deletion": opts.PodDeletionTimeout,
+		"replacement":  opts.ReplacementTimeout,
+		"API":          opts.APITimeout,
+		"PDB wait":     opts.PDBWaitTimeout,
+	} {
+		if timeout < 0 {
+			return nil, fmt.Errorf("%s timeout must not be negative: %s", name, timeout)
+		}
+	}
+	if opts.ScaleTimeout == 0 {
+		opts.ScaleTimeout = defaultScaleTimeout
+	}
+	if opts.PDBWaitTimeout == 0 {
+		opts.PDBWaitTimeout = defaultPDBWaitTimeout
+	}
+	if opts.StatsRetries < 0 {
+		return nil, fmt.Errorf("stats retries must not be negative: %d", opts.StatsRetries)
+	}
+
+	config, err := restConfig(kubeconfigpath)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Timeout = opts.APITimeout
+	if opts.ImpersonateUser != "" || len(opts.ImpersonateGroups) > 0 {
+		config.Impersonate = rest.ImpersonationConfig{
+			UserName: opts.ImpersonateUser,
+			Groups:   opts.ImpersonateGroups,
 		}
 	}
+	if opts.QPS > 0 {
+		config.QPS = opts.QPS
+	}
+	if opts.Burst > 0 {
+		config.Burst = opts.Burst
+	}
+	config.UserAgent = userAgent()
 
 	// creates the clientset
 	clientset, err := kubernetes.NewForConfig(config)
@@ -57,29 +305,68 @@ func NewClient(kubeconfigpath, nodeName string) (Client, error) {
 		return nil, fmt.Errorf("failed to create clientset: %w", err)
 	}
 
-	return &client{
-		clientset,
-		nodeName,
-		2 * time.Minute,
-	}, nil
-}
-
-func (c *client) GetMetrics(ctx context.Context) (*v1alpha1.Summary, error) {
-	url := fmt.Sprintf("/api/v1/nodes/%s/proxy/stats/summary", c.nodeName)
-	summary := &v1alpha1.Summary{}
-	result, err := c.Clientset.NodeV1().RESTClient().Get().AbsPath(url).DoRaw(ctx)
+	// the scale client resolves the scale subresource of any owner kind,
+	// including custom resources, through discovery
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery()))
+	scaleClient, err := scale.NewForConfig(config, mapper, dynamic.LegacyAPIPathResolverFunc,
+		scale.NewDiscoveryScaleKindResolver(clientset.Discovery()))
 	if err != nil {
-		return summary, err
+		return nil, fmt.Errorf("failed to create scale client: %w", err)
 	}
-	if err := json.Unmarshal(result, summary); err != nil {
-		return summary, err
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
 	}
 
-	return summary, nil
+	if opts.LockHolder == "" {
+		opts.LockHolder = nodeName
+	}
+	if opts.LockTTL == 0 {
+		opts.LockTTL = defaultLockTTL
+	}
+	if opts.KubeletPort == 0 {
+		opts.KubeletPort = defaultKubeletPort
+	}
+
+	return &client{
+		Clientset:          clientset,
+		config:             config,
+		scaleClient:        scaleClient,
+		dynamicClient:      dynamicClient,
+		mapper:             mapper,
+		nodeName:           nodeName,
+		scaleTimeout:       opts.ScaleTimeout,
+		podDeletionTimeout: opts.PodDeletionTimeout,
+		replacementTimeout: opts.ReplacementTimeout,
+		daemonSetPolicy:    opts.DaemonSetPolicy,
+		jobPolicy:          opts.JobPolicy,
+		ownerPolicies:      opts.OwnerPolicies,
+		hpaPolicy:          opts.HPAPolicy,
+		pdbPolicy:          opts.PDBPolicy,
+		pdbWaitTimeout:     opts.PDBWaitTimeout,
+		lockHolder:         opts.LockHolder,
+		lockTTL:            opts.LockTTL,
+		kubeletDirect:      opts.KubeletDirect,
+		kubeletPort:        opts.KubeletPort,
+		kubeletCAFile:      opts.KubeletCAFile,
+		statsRetries:       opts.StatsRetries,
+		pageSize:           opts.PageSize,
+		volumeEvents:       opts.VolumeEvents,
+		volumeAttachments:  opts.VolumeAttachments,
+		veleroNamespace:    opts.VeleroNamespace,
+	}, nil
 }
 
 func (c *client) GetPVC(ctx context.Context, pvcName, namespace string) (*v1.PersistentVolumeClaim, error) {
-	pvc, err := c.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	var pvc *v1.PersistentVolumeClaim
+	var err error
+	if c.pvcLister != nil {
+		pvc, err = c.pvcLister.PersistentVolumeClaims(namespace).Get(pvcName)
+	} else if prefetched, ok := c.prefetchedPVC(pvcName, namespace); ok {
+		return prefetched, nil
+	} else {
+		pvc, err = c.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get PVC %s in namespace %s: %w", pvcName, namespace, err)
 	}
@@ -87,213 +374,165 @@ func (c *client) GetPVC(ctx context.Context, pvcName, namespace string) (*v1.Per
 }
 
 func (c *client) GetPV(ctx context.Context, pvName string) (*v1.PersistentVolume, error) {
-	pv, err := c.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+	var pv *v1.PersistentVolume
+	var err error
+	if c.pvLister != nil {
+		pv, err = c.pvLister.Get(pvName)
+	} else if prefetched, ok := c.prefetchedPV(pvName); ok {
+		return prefetched, nil
+	} else {
+		pv, err = c.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get PV %s: %w", pvName, err)
 	}
 	return pv, nil
 }
 
-func (c *client) RestartPod(ctx context.Context, namespace, podName string) error {
+// GetSecret returns the data of the secret as a string map, the format CSI
+// drivers expect their secrets in.
+func (c *client) GetSecret(ctx context.Context, name, namespace string) (map[string]string, error) {
+	secret, err := c.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s in namespace %s: %w", name, namespace, err)
+	}
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	return data, nil
+}
+
+func (c *client) RestartPod(ctx context.Context, namespace, podName string) (retErr error) {
 	// check if there a owner for the pod , if there is a owner then delete the owner and let the owner recreate the pod
 	// if not return error saying no owner exists to take care of the pod
-	pod, err := c.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	pod, err := c.getPod(ctx, namespace, podName)
 	if err != nil {
-		return fmt.Errorf("failed to get pod %s in namespace %s: %w", podName, namespace, err)
+		return err
 	}
-	ownerName, _, err := c.findTopOwner(namespace, pod.OwnerReferences)
+	owner, err := c.findTopOwner(ctx, namespace, pod.OwnerReferences)
 	if err != nil {
 		return fmt.Errorf("failed to find top owner for pod %s in namespace %s: %w", podName, namespace, err)
 	}
-	if ownerName == "" {
+	if owner == nil {
 		return fmt.Errorf("no owner found for pod %s in namespace %s", podName, namespace)
 	}
-	err = c.CoreV1().Pods(namespace).Delete(ctx, podName, metav1.DeleteOptions{})
+	unlock, err := c.lockOwner(ctx, namespace, owner)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// release the lock even when the recovery is interrupted
+		if err := unlock(context.WithoutCancel(ctx)); err != nil {
+			retErr = errors.Join(retErr, err)
+		}
+	}()
+	switch {
+	case owner.Kind == kindDaemonSet:
+		return c.recoverDaemonSetPod(ctx, owner.Name, pod)
+	case isJobKind(owner.Kind):
+		return c.recoverJobPod(ctx, owner.Name, owner.Kind, pod)
+	}
+	if c.ownerPolicy(owner) == OwnerSkip {
+		return fmt.Errorf("%w: pod %s in namespace %s is owned by %s %s", ErrRecoverySkipped, podName, namespace, owner.Kind, owner.Name)
+	}
+	deletedAt := time.Now()
+	err = c.deletePod(ctx, pod)
 	if err != nil {
 		return fmt.Errorf("failed to delete pod %s in namespace %s: %w", podName, namespace, err)
 	}
+	if c.replacementTimeout > 0 {
+		// confirm the restart brought the workload back
+		if err := c.waitForReplacement(ctx, pod, deletedAt); err != nil {
+			return fmt.Errorf("pod %s in namespace %s was not replaced: %w", podName, namespace, err)
+		}
+	}
 	return nil
 }
 
-// Function to find the top owner recursively
-func (c *client) findTopOwner(namespace string, ownerRefs []metav1.OwnerReference) (string, string, error) {
-	if len(ownerRefs) == 0 {
-		return "", "", nil
-	}
+// maxOwnerDepth bounds the owner chain followed by findTopOwner
+const maxOwnerDepth = 10
 
-	ownerRef := ownerRefs[0] // Assume first owner for simplicity
+// findTopOwner follows the controller references, e.g. Pod to ReplicaSet to
+// Deployment, and returns the reference of the top-level controller. It
+// returns nil if there is no controller.
+func (c *client) findTopOwner(ctx context.Context, namespace string, ownerRefs []metav1.OwnerReference) (*metav1.OwnerReference, error) {
+	ownerRef := controllerRef(ownerRefs)
+	if ownerRef == nil {
+		return nil, nil
+	}
 
-	switch ownerRef.Kind {
-	case "ReplicaSet":
-		rs, err := c.AppsV1().ReplicaSets(namespace).Get(context.TODO(), ownerRef.Name, metav1.GetOptions{})
+	for range maxOwnerDepth {
+		obj, err := c.getOwnerObject(ctx, namespace, ownerRef)
 		if err != nil {
-			return "", "", err
+			return nil, fmt.Errorf("failed to resolve controller %s %s: %w", ownerRef.Kind, ownerRef.Name, err)
 		}
-		return c.findTopOwner(namespace, rs.OwnerReferences)
-
-	case "StatefulSet":
-		// StatefulSet is typically a top-level owner
-		return ownerRef.Name, "StatefulSet", nil
-
-	case "Deployment":
-		// Deployment is a top-level owner
-		return ownerRef.Name, "Deployment", nil
-
-	case "DaemonSet":
-		// DaemonSet is typically a top owner as well
-		return ownerRef.Name, "DaemonSet", nil
+		parent := controllerRef(obj.GetOwnerReferences())
+		if parent == nil {
+			return ownerRef, nil
+		}
+		ownerRef = parent
+	}
+	return nil, fmt.Errorf("owner chain of %s %s is deeper than %d", ownerRef.Kind, ownerRef.Name, maxOwnerDepth)
+}
 
-	default:
-		// If it's not a known controller, return this owner as the top one
-		return ownerRef.Name, ownerRef.Kind, nil
+// controllerRef returns the reference with controller=true, as objects can
+// have multiple owners but only one of them is their controller
+func controllerRef(ownerRefs []metav1.OwnerReference) *metav1.OwnerReference {
+	for i := range ownerRefs {
+		if ownerRefs[i].Controller != nil && *ownerRefs[i].Controller {
+			return &ownerRefs[i]
+		}
 	}
+	return nil
 }
 
 // Function to scale the owner and wait for replicas
-func (c *client) ScaleOwner(namespace string, podName string, replicaCount int32) error {
-	pod, err := c.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
+func (c *client) ScaleOwner(ctx context.Context, namespace string, podName string, replicaCount int32, hook ScaledDownHook) (retErr error) {
+	pod, err := c.getPod(ctx, namespace, podName)
 	if err != nil {
-		return fmt.Errorf("failed to get pod %s in namespace %s: %w", podName, namespace, err)
+		return err
 	}
 	ownerRefs := pod.OwnerReferences
-	ownerName, kind, err := c.findTopOwner(namespace, ownerRefs)
+	owner, err := c.findTopOwner(ctx, namespace, ownerRefs)
 	if err != nil {
 		return fmt.Errorf("failed to find top owner: %w", err)
 	}
-
-	// Get the scaling client for the appropriate type (Deployment, StatefulSet, etc.)
-	switch kind {
-	case "Deployment":
-		return c.scaleDeployment(ownerName, namespace, replicaCount)
-
-	case "StatefulSet":
-		return c.scaleStateFulSet(ownerName, namespace, replicaCount)
+	if owner == nil {
+		return fmt.Errorf("no owner found for pod %s in namespace %s", podName, namespace)
 	}
-
-	return fmt.Errorf("unsupported owner kind: %s", kind)
-}
-
-// Scale deployment function
-func (c *client) scaleDeployment(name, namespace string, count int32) error {
-	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		// Get the current deployment
-		deployment, err := c.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-		if err != nil {
-			return err
-		}
-
-		// Save the original replica count before scaling
-		originalReplicas := deployment.Spec.Replicas
-		if count != 0 {
-			originalReplicas = &count
-		}
-
-		deployment.Spec.Replicas = int32Ptr(int32(count))
-		if count == 0 {
-			_, err = c.AppsV1().Deployments(namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
-			if err != nil {
-				return err
-			}
-			err = c.waitForReplicasToBeZero(namespace, name, "Deployment")
-			if err != nil {
-				// If there was an error, revert the changes
-				deployment.Spec.Replicas = originalReplicas
-				_, err = c.AppsV1().Deployments(namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
-				if err != nil {
-					return fmt.Errorf("failed to revert changes: %w", err)
-				}
-				return fmt.Errorf("failed to scale down the deployment: %w", err)
-			}
-		}
-		deployment.Spec.Replicas = originalReplicas
-		_, err = c.AppsV1().Deployments(namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to revert back the replicas in deployment: %w", err)
-		}
-		return nil
-	})
-}
-
-// Scale deployment function
-func (c *client) scaleStateFulSet(name, namespace string, count int32) error {
-	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		// Get the current deployment
-		sts, err := c.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
-		if err != nil {
-			return err
-		}
-
-		// Save the original replica count before scaling
-		originalReplicas := sts.Spec.Replicas
-		if count != 0 {
-			originalReplicas = &count
+	unlock, err := c.lockOwner(ctx, namespace, owner)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// release the lock even when the recovery is interrupted
+		if err := unlock(context.WithoutCancel(ctx)); err != nil {
+			retErr = errors.Join(retErr, err)
 		}
+	}()
 
-		sts.Spec.Replicas = int32Ptr(int32(count))
-
-		if count == 0 {
-			_, err = c.AppsV1().StatefulSets(namespace).Update(context.TODO(), sts, metav1.UpdateOptions{})
-			if err != nil {
-				return err
-			}
-			err = c.waitForReplicasToBeZero(namespace, name, "StatefulSets")
-			if err != nil {
-				// If there was an error, revert the changes
-				sts.Spec.Replicas = originalReplicas
-				_, err = c.AppsV1().StatefulSets(namespace).Update(context.TODO(), sts, metav1.UpdateOptions{})
-				if err != nil {
-					return fmt.Errorf("failed to revert changes: %w", err)
-				}
-				return fmt.Errorf("failed to scale down the StatefulSets: %w", err)
-			}
-		}
-		sts.Spec.Replicas = originalReplicas
-		_, err = c.AppsV1().StatefulSets(namespace).Update(context.TODO(), sts, metav1.UpdateOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to revert back the replicas in StatefulSets: %w", err)
-		}
-		return nil
-	})
-}
+	switch owner.Kind {
+	case kindDaemonSet:
+		// DaemonSets can't be scaled to zero, recover the pod instead
+		return c.recoverDaemonSetPod(ctx, owner.Name, pod)
 
-// Wait until the replicas of the deployment or statefulset are 0
-func (c *client) waitForReplicasToBeZero(namespace, ownerName, kind string) error {
-	timeout := c.timeout
-	ctx := context.TODO()
+	case kindJob, kindCronJob:
+		// Jobs run to completion and can't be scaled, retry the pod instead
+		return c.recoverJobPod(ctx, owner.Name, owner.Kind, pod)
+	}
 
-	return wait.PollUntilContextTimeout(ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
-		ready, err := c.checkReplicas(namespace, ownerName, kind)
-		if err != nil {
-			return false, err
-		}
-		if ready {
-			return true, nil
-		}
-		return false, nil
-	})
+	// any other owner, including standalone ReplicaSets and legacy
+	// ReplicationControllers, is recovered according to the policy of its
+	// kind, scaled through its scale subresource by default
+	return c.recoverOwnedPod(ctx, pod, owner, replicaCount, hook)
 }
 
-// Check the number of replicas of the owner (Deployment/StatefulSet)
-func (c *client) checkReplicas(namespace, ownerName, kind string) (bool, error) {
-	switch kind {
-	case "Deployment":
-		deployment, err := c.AppsV1().Deployments(namespace).Get(context.TODO(), ownerName, metav1.GetOptions{})
-		if err != nil {
-			return false, err
-		}
-		return deployment.Status.Replicas == 0, nil
-
-	case "StatefulSet":
-		statefulset, err := c.AppsV1().StatefulSets(namespace).Get(context.TODO(), ownerName, metav1.GetOptions{})
-		if err != nil {
-			return false, err
-		}
-		return statefulset.Status.Replicas == 0, nil
+// APIServerReady checks the API server is reachable and ready to serve
+// requests, through its readyz endpoint
+func (c *client) APIServerReady(ctx context.Context) error {
+	if err := c.Discovery().RESTClient().Get().AbsPath("/readyz").Do(ctx).Error(); err != nil {
+		return fmt.Errorf("API server not ready: %w", err)
 	}
-	return false, fmt.Errorf("unsupported kind: %s", kind)
-}
-
-// Helper function to get a pointer to an int32
-func int32Ptr(i int32) *int32 {
-	return &i
+	return nil
 }
@@ -0,0 +1,70 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Policies to recover the pods owned by a DaemonSet, which can't be scaled
+const (
+	// DaemonSetDeletePod deletes only the affected pod, the DaemonSet
+	// controller recreates it on the same node.
+	DaemonSetDeletePod = "delete-pod"
+	// DaemonSetRolloutRestart restarts all the pods of the DaemonSet.
+	DaemonSetRolloutRestart = "rollout-restart"
+)
+
+// restartedAtAnnotation is the pod template annotation kubectl sets to
+// trigger a rollout restart
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// recoverDaemonSetPod recovers a pod owned by the DaemonSet according to the
+// configured DaemonSet policy.
+func (c *client) recoverDaemonSetPod(ctx context.Context, name string, pod *v1.Pod) error {
+	switch c.daemonSetPolicy {
+	case DaemonSetRolloutRestart:
+		return c.rolloutRestartDaemonSet(ctx, name, pod.Namespace)
+	default:
+		// only touch the pod when it runs on this node, other nodes are
+		// taken care of by their own instance
+		if pod.Spec.NodeName != c.nodeName {
+			return fmt.Errorf("pod %s in namespace %s runs on node %s, not on %s", pod.Name, pod.Namespace, pod.Spec.NodeName, c.nodeName)
+		}
+		err := c.deletePod(ctx, pod)
+		if err != nil {
+			return fmt.Errorf("failed to delete pod %s of DaemonSet %s in namespace %s: %w", pod.Name, name, pod.Namespace, err)
+		}
+		return nil
+	}
+}
+
+// rolloutRestartDaemonSet restarts all the pods of the DaemonSet the same way
+// kubectl rollout restart does.
+func (c *client) rolloutRestartDaemonSet(ctx context.Context, name, namespace string) error {
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`,
+		restartedAtAnnotation, time.Now().Format(time.RFC3339))
+	_, err := c.AppsV1().DaemonSets(namespace).Patch(ctx, name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to restart DaemonSet %s in namespace %s: %w", name, namespace, err)
+	}
+	return nil
+}
+
+// daemonSetRestarted reports whether the DaemonSet controller observed the
+// generation of the DaemonSet carrying the restartedAt annotation, i.e. it
+// started replacing the pods. The pod being verified may be replaced last.
+func (c *client) daemonSetRestarted(ctx context.Context, name, namespace string) (bool, error) {
+	ds, err := c.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get DaemonSet %s in namespace %s: %w", name, namespace, err)
+	}
+	if _, ok := ds.Spec.Template.Annotations[restartedAtAnnotation]; !ok {
+		return false, nil
+	}
+	return ds.Status.ObservedGeneration >= ds.Generation, nil
+}
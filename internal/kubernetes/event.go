@@ -0,0 +1,110 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// eventComponent is the source component of the events recorded by the tool
+const eventComponent = "csi-volume-recovery"
+
+// recordWarning records a warning event about the object. Events of cluster
+// scoped objects, such as the node, are recorded in the default namespace.
+func (c *client) recordWarning(ctx context.Context, ref v1.ObjectReference, reason, message string) error {
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: ref.Name + ".",
+			Namespace:    namespace,
+		},
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        message,
+		Type:           v1.EventTypeWarning,
+		Source:         v1.EventSource{Component: eventComponent, Host: c.nodeName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := c.CoreV1().Events(namespace).Create(ctx, event, metav1.CreateOptions{FieldManager: FieldManager}); err != nil {
+		return fmt.Errorf("failed to record %s event on %s %s: %w", reason, ref.Kind, ref.Name, err)
+	}
+	return nil
+}
+
+// PassSummaryReason is the reason of the event summarizing the last pass on
+// the node
+const PassSummaryReason = "RecoveryPassSummary"
+
+// RecordPassSummary records the summary of the last pass in an event on the
+// node, shown by kubectl describe node. The event is updated by every pass
+// instead of piling up an event per pass. Volume problems make it a warning.
+func (c *client) RecordPassSummary(ctx context.Context, message string, warning bool) error {
+	node, err := c.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s: %w", c.nodeName, err)
+	}
+	eventType := v1.EventTypeNormal
+	if warning {
+		eventType = v1.EventTypeWarning
+	}
+	ref := v1.ObjectReference{
+		Kind:       "Node",
+		APIVersion: "v1",
+		Name:       node.Name,
+		UID:        node.UID,
+	}
+	return c.recordAggregated(ctx, c.nodeName+"."+eventComponent+"-summary", ref, eventType, PassSummaryReason, message)
+}
+
+// recordAggregated records the event with the name about the object, or
+// updates it when it was recorded before, counting the occurrences like the
+// event recorder aggregates repeated events. Events of cluster scoped objects
+// are recorded in the default namespace.
+func (c *client) recordAggregated(ctx context.Context, name string, ref v1.ObjectReference, eventType, reason, message string) error {
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	events := c.CoreV1().Events(namespace)
+	now := metav1.Now()
+	event, err := events.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		event = &v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: namespace},
+			InvolvedObject: ref,
+			Reason:         reason,
+			Message:        message,
+			Type:           eventType,
+			Source:         v1.EventSource{Component: eventComponent, Host: c.nodeName},
+			FirstTimestamp: now,
+			LastTimestamp:  now,
+			Count:          1,
+		}
+		if _, err := events.Create(ctx, event, metav1.CreateOptions{FieldManager: FieldManager}); err != nil {
+			return fmt.Errorf("failed to record %s event on %s %s: %w", reason, ref.Kind, ref.Name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get %s event of %s %s: %w", reason, ref.Kind, ref.Name, err)
+	}
+	// the object may have been recreated with the same name
+	event.InvolvedObject.UID = ref.UID
+	event.Message = message
+	event.Type = eventType
+	event.LastTimestamp = now
+	event.Count++
+	if _, err := events.Update(ctx, event, metav1.UpdateOptions{FieldManager: FieldManager}); err != nil {
+		return fmt.Errorf("failed to update %s event on %s %s: %w", reason, ref.Kind, ref.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Reasons of the events the CSI external-health-monitor controller records
+// on PVCs, the volume health monitoring of KEP-1432
+const (
+	// VolumeConditionAbnormalReason is the reason of the warnings about
+	// PVCs whose volume condition is abnormal
+	VolumeConditionAbnormalReason = "VolumeConditionAbnormal"
+	// VolumeConditionNormalReason is the reason of the events about PVCs
+	// whose volume is healthy again
+	VolumeConditionNormalReason = "VolumeConditionNormal"
+)
+
+// Reasons of the events recorded on pods whose volumes fail to attach or to
+// mount, by the attach/detach controller and the kubelet
+const (
+	failedAttachVolumeReason = "FailedAttachVolume"
+	failedMountReason        = "FailedMount"
+)
+
+// volumeConditionNormalMessage is the message of the upstream events about
+// volumes healthy again
+const volumeConditionNormalMessage = "The Volume returns to the healthy state"
+
+// volumeEventSelector selects the abnormal volume condition events of PVCs
+func volumeEventSelector() string {
+	return fields.AndSelectors(
+		fields.OneTermEqualSelector("reason", VolumeConditionAbnormalReason),
+		fields.OneTermEqualSelector("involvedObject.kind", "PersistentVolumeClaim"),
+	).String()
+}
+
+// AbnormalVolumeEvents returns the messages of the abnormal volume condition
+// events recorded on PVCs within the window, keyed by namespace/name. The
+// events are served from the informer cache when started with VolumeEvents.
+func (c *client) AbnormalVolumeEvents(ctx context.Context, window time.Duration) (map[string]string, error) {
+	var events []*v1.Event
+	if c.eventLister != nil {
+		cached, err := c.eventLister.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list volume events: %w", err)
+		}
+		events = cached
+	} else {
+		items, err := listPages(ctx, c, metav1.ListOptions{FieldSelector: volumeEventSelector()},
+			func(ctx context.Context, opts metav1.ListOptions) ([]v1.Event, string, error) {
+				list, err := c.CoreV1().Events(metav1.NamespaceAll).List(ctx, opts)
+				if err != nil {
+					return nil, "", err
+				}
+				return list.Items, list.Continue, nil
+			})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list volume events: %w", err)
+		}
+		for i := range items {
+			events = append(events, &items[i])
+		}
+	}
+
+	since := time.Now().Add(-window)
+	latest := map[string]time.Time{}
+	messages := map[string]string{}
+	for _, event := range events {
+		// the events the tool records itself only repeat its own verdict
+		if event.Source.Component == eventComponent {
+			continue
+		}
+		last := eventTime(event)
+		if last.Before(since) {
+			continue
+		}
+		key := event.InvolvedObject.Namespace + "/" + event.InvolvedObject.Name
+		if last.After(latest[key]) {
+			latest[key] = last
+			messages[key] = event.Message
+		}
+	}
+	return messages, nil
+}
+
+// VolumeFailureEvent returns the message of the latest FailedMount or
+// FailedAttachVolume event recorded on the pod since the time, empty when
+// there is none.
+func (c *client) VolumeFailureEvent(ctx context.Context, namespace, podUID string, since time.Time) (string, error) {
+	list, err := c.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.AndSelectors(
+			fields.OneTermEqualSelector("involvedObject.kind", "Pod"),
+			fields.OneTermEqualSelector("involvedObject.uid", podUID),
+		).String(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list events of pod %s in namespace %s: %w", podUID, namespace, err)
+	}
+	var latest time.Time
+	message := ""
+	for i := range list.Items {
+		event := &list.Items[i]
+		if event.Reason != failedMountReason && event.Reason != failedAttachVolumeReason {
+			continue
+		}
+		if last := eventTime(event); !last.Before(since) && last.After(latest) {
+			latest = last
+			message = event.Message
+		}
+	}
+	return message, nil
+}
+
+// RecordVolumeCondition records the condition of the volume of the PVC in an
+// event in the format of the CSI external-health-monitor controller, so the
+// alerts built on the volume health monitoring also fire for the problems the
+// tool finds: a VolumeConditionAbnormal warning with the reason the volume is
+// abnormal, or a VolumeConditionNormal event once it is healthy again. Each
+// event is updated while the condition lasts instead of piling up.
+func (c *client) RecordVolumeCondition(ctx context.Context, namespace, pvcName string, abnormal bool, message string) error {
+	pvc, err := c.GetPVC(ctx, pvcName, namespace)
+	if err != nil {
+		return err
+	}
+	ref := v1.ObjectReference{
+		Kind:       "PersistentVolumeClaim",
+		APIVersion: "v1",
+		Namespace:  namespace,
+		Name:       pvcName,
+		UID:        pvc.UID,
+	}
+	if abnormal {
+		return c.recordAggregated(ctx, pvcName+"."+eventComponent+"-abnormal", ref, v1.EventTypeWarning, VolumeConditionAbnormalReason, message)
+	}
+	return c.recordAggregated(ctx, pvcName+"."+eventComponent+"-normal", ref, v1.EventTypeNormal, VolumeConditionNormalReason, volumeConditionNormalMessage)
+}
+
+// eventTime returns when the event was last seen, events are recorded with
+// either the legacy timestamps or the event time and series
+func eventTime(event *v1.Event) time.Time {
+	switch {
+	case event.Series != nil:
+		return event.Series.LastObservedTime.Time
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
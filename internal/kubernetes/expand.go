@@ -0,0 +1,65 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ExpandPVC grows the storage request of the PVC by the percentage, up to the
+// limit when it isn't zero. It returns the new request, or nil when the PVC
+// wasn't expanded because an expansion is still in progress, the limit is
+// reached or its StorageClass doesn't allow expansion.
+func (c *client) ExpandPVC(ctx context.Context, pvcName, namespace string, percent int, limit resource.Quantity) (*resource.Quantity, error) {
+	pvc, err := c.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PVC %s in namespace %s: %w", pvcName, namespace, err)
+	}
+	requested := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	capacity := pvc.Status.Capacity[v1.ResourceStorage]
+	if capacity.Cmp(requested) < 0 {
+		// the previous expansion didn't complete yet
+		return nil, nil
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return nil, nil
+	}
+	sc, err := c.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get StorageClass %s: %w", *pvc.Spec.StorageClassName, err)
+	}
+	if sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion {
+		return nil, nil
+	}
+
+	size := resource.NewQuantity(requested.Value()+requested.Value()*int64(percent)/100, requested.Format)
+	if !limit.IsZero() && size.Cmp(limit) > 0 {
+		limited := limit.DeepCopy()
+		size = &limited
+	}
+	if size.Cmp(requested) <= 0 {
+		return nil, nil
+	}
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"resources": map[string]any{
+				"requests": map[string]any{
+					string(v1.ResourceStorage): size.String(),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.CoreV1().PersistentVolumeClaims(namespace).Patch(ctx, pvcName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand PVC %s in namespace %s to %s: %w", pvcName, namespace, size, err)
+	}
+	return size, nil
+}
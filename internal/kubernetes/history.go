@@ -0,0 +1,105 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// Kinds of history entries
+const (
+	HistoryDetected  = "detected"
+	HistoryRecovered = "recovered"
+)
+
+// historyKey is the ConfigMap key holding the history as a JSON array
+const historyKey = "history.json"
+
+// HistoryNodeLabel labels the history ConfigMaps with their node
+const HistoryNodeLabel = annotationPrefix + "history-node"
+
+// HistoryEntry is an abnormal volume detected on the node or a recovery
+// action taken on it
+type HistoryEntry struct {
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Volume string    `json:"volume"`
+	Driver string    `json:"driver,omitempty"`
+	// Severity and Reason describe a detection
+	Severity string `json:"severity,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// Action, Result, Error and Duration describe a recovery
+	Action   string `json:"action,omitempty"`
+	Result   string `json:"result,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// historyConfigMapName returns the name of the history ConfigMap of the node
+func historyConfigMapName(nodeName string) string {
+	return "csi-volume-recovery-history-" + nodeName
+}
+
+// AppendHistory appends the entries to the history ConfigMap of the node in
+// the namespace, creating it if needed. Only the latest entries up to the
+// limit are kept so the ConfigMap stays well below the size limit.
+func (c *client) AppendHistory(ctx context.Context, namespace string, entries []HistoryEntry, limit int) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	name := historyConfigMapName(c.nodeName)
+	configMaps := c.CoreV1().ConfigMaps(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		create := apierrors.IsNotFound(err)
+		if create {
+			cm = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					Labels:    map[string]string{HistoryNodeLabel: c.nodeName},
+				},
+			}
+		} else if err != nil {
+			return fmt.Errorf("failed to get history ConfigMap %s in namespace %s: %w", name, namespace, err)
+		}
+
+		var history []HistoryEntry
+		if data := cm.Data[historyKey]; data != "" {
+			// a corrupted history is replaced rather than blocking new
+			// entries
+			if err := json.Unmarshal([]byte(data), &history); err != nil {
+				history = nil
+			}
+		}
+		history = append(history, entries...)
+		if limit > 0 && len(history) > limit {
+			history = history[len(history)-limit:]
+		}
+		data, err := json.Marshal(history)
+		if err != nil {
+			return fmt.Errorf("failed to encode history: %w", err)
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[historyKey] = string(data)
+
+		if create {
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{FieldManager: FieldManager})
+			if apierrors.IsAlreadyExists(err) {
+				// created concurrently, retry as an update
+				return apierrors.NewConflict(v1.Resource("configmaps"), name, err)
+			}
+		} else {
+			_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{FieldManager: FieldManager})
+		}
+		return err
+	})
+}
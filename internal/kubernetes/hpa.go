@@ -0,0 +1,100 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+)
+
+// Policies to handle owners managed by a HorizontalPodAutoscaler when
+// scaling them to zero
+const (
+	// HPAPause disables the scale up of the HPA during the recovery and
+	// restores its behavior afterwards.
+	HPAPause = "pause"
+	// HPASkip doesn't scale owners managed by an HPA.
+	HPASkip = "skip"
+)
+
+// PausedHPABehaviorAnnotation holds the behavior of an HPA paused during a
+// recovery, so it can be restored even if the tool is interrupted
+const PausedHPABehaviorAnnotation = annotationPrefix + "paused-hpa-behavior"
+
+// findHPA returns the HPA scaling the owner, or nil if there is none
+func (c *client) findHPA(ctx context.Context, namespace string, owner *metav1.OwnerReference) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	list, err := c.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list HPAs in namespace %s: %w", namespace, err)
+	}
+	ownerGroup := schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind).Group
+	for i := range list.Items {
+		ref := list.Items[i].Spec.ScaleTargetRef
+		if ref.Kind != owner.Kind || ref.Name != owner.Name {
+			continue
+		}
+		if schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind).Group == ownerGroup {
+			return &list.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// pauseHPA disables the scale up of the HPA so it doesn't fight the scale
+// down. The original behavior is saved in an annotation.
+func (c *client) pauseHPA(ctx context.Context, namespace, name string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		hpa, err := c.AutoscalingV2().HorizontalPodAutoscalers(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get HPA %s in namespace %s: %w", name, namespace, err)
+		}
+		if _, ok := hpa.Annotations[PausedHPABehaviorAnnotation]; ok {
+			// already paused by a previous recovery
+			return nil
+		}
+		behavior, err := json.Marshal(hpa.Spec.Behavior)
+		if err != nil {
+			return fmt.Errorf("failed to encode behavior of HPA %s: %w", name, err)
+		}
+		if hpa.Annotations == nil {
+			hpa.Annotations = map[string]string{}
+		}
+		hpa.Annotations[PausedHPABehaviorAnnotation] = string(behavior)
+		if hpa.Spec.Behavior == nil {
+			hpa.Spec.Behavior = &autoscalingv2.HorizontalPodAutoscalerBehavior{}
+		}
+		if hpa.Spec.Behavior.ScaleUp == nil {
+			hpa.Spec.Behavior.ScaleUp = &autoscalingv2.HPAScalingRules{}
+		}
+		disabled := autoscalingv2.DisabledPolicySelect
+		hpa.Spec.Behavior.ScaleUp.SelectPolicy = &disabled
+		_, err = c.AutoscalingV2().HorizontalPodAutoscalers(namespace).Update(ctx, hpa, metav1.UpdateOptions{FieldManager: FieldManager})
+		return err
+	})
+}
+
+// resumeHPA restores the behavior saved by pauseHPA
+func (c *client) resumeHPA(ctx context.Context, namespace, name string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		hpa, err := c.AutoscalingV2().HorizontalPodAutoscalers(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get HPA %s in namespace %s: %w", name, namespace, err)
+		}
+		saved, ok := hpa.Annotations[PausedHPABehaviorAnnotation]
+		if !ok {
+			return nil
+		}
+		var behavior *autoscalingv2.HorizontalPodAutoscalerBehavior
+		if err := json.Unmarshal([]byte(saved), &behavior); err != nil {
+			return fmt.Errorf("failed to decode saved behavior of HPA %s: %w", name, err)
+		}
+		hpa.Spec.Behavior = behavior
+		delete(hpa.Annotations, PausedHPABehaviorAnnotation)
+		_, err = c.AutoscalingV2().HorizontalPodAutoscalers(namespace).Update(ctx, hpa, metav1.UpdateOptions{FieldManager: FieldManager})
+		return err
+	})
+}
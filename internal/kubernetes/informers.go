@@ -0,0 +1,149 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// informerResync is the resync period of the shared informers
+const informerResync = 10 * time.Minute
+
+// StartInformers starts shared informers for the pods scheduled on the node,
+// the PVCs, the PVs and optionally the abnormal volume events and the
+// VolumeAttachments and waits for their caches to sync. Once started the
+// lookups of these objects are served from the caches instead of the API
+// server.
+func (c *client) StartInformers(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactory(c.Clientset, informerResync)
+	nodeFactory := informers.NewSharedInformerFactoryWithOptions(c.Clientset, informerResync,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", c.nodeName).String()
+		}))
+
+	pvcInformer := factory.Core().V1().PersistentVolumeClaims()
+	pvInformer := factory.Core().V1().PersistentVolumes()
+	podInformer := nodeFactory.Core().V1().Pods()
+
+	// the informers must be requested before starting the factories
+	synced := []cache.InformerSynced{
+		pvcInformer.Informer().HasSynced,
+		pvInformer.Informer().HasSynced,
+		podInformer.Informer().HasSynced,
+	}
+	var eventLister corelisters.EventLister
+	if c.volumeEvents {
+		eventFactory := informers.NewSharedInformerFactoryWithOptions(c.Clientset, informerResync,
+			informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+				opts.FieldSelector = volumeEventSelector()
+			}))
+		eventInformer := eventFactory.Core().V1().Events()
+		synced = append(synced, eventInformer.Informer().HasSynced)
+		eventLister = eventInformer.Lister()
+		eventFactory.Start(ctx.Done())
+	}
+	var vaLister storagelisters.VolumeAttachmentLister
+	if c.volumeAttachments {
+		vaInformer := factory.Storage().V1().VolumeAttachments()
+		synced = append(synced, vaInformer.Informer().HasSynced)
+		vaLister = vaInformer.Lister()
+	}
+	factory.Start(ctx.Done())
+	nodeFactory.Start(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("failed to sync informer caches for node %s", c.nodeName)
+	}
+
+	c.pvcLister = pvcInformer.Lister()
+	c.pvLister = pvInformer.Lister()
+	c.podLister = podInformer.Lister()
+	c.eventLister = eventLister
+	c.vaLister = vaLister
+	return nil
+}
+
+// getPod returns the pod from the informer cache when available, otherwise
+// from the API server.
+func (c *client) getPod(ctx context.Context, namespace, podName string) (*v1.Pod, error) {
+	var pod *v1.Pod
+	var err error
+	if c.podLister != nil {
+		pod, err = c.podLister.Pods(namespace).Get(podName)
+	} else {
+		pod, err = c.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s in namespace %s: %w", podName, namespace, err)
+	}
+	return pod, nil
+}
+
+// PodRestarted reports whether the restart of the pod with the UID took
+// effect: the pod is deleted or being deleted, or with the rollout-restart
+// DaemonSet policy the DaemonSet controller observed the restart of its
+// DaemonSet, which replaces the pods in turn. It reads the API server, the
+// informer cache may not have seen a deletion made a moment ago.
+func (c *client) PodRestarted(ctx context.Context, namespace, podName, podUID string) (bool, error) {
+	pod, err := c.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get pod %s in namespace %s: %w", podName, namespace, err)
+	}
+	// a pod of the same name is a replacement, e.g. of a StatefulSet
+	if string(pod.UID) != podUID || pod.DeletionTimestamp != nil {
+		return true, nil
+	}
+	if c.daemonSetPolicy != DaemonSetRolloutRestart {
+		return false, nil
+	}
+	owner, err := c.findTopOwner(ctx, namespace, pod.OwnerReferences)
+	if err != nil {
+		return false, fmt.Errorf("failed to find top owner for pod %s in namespace %s: %w", podName, namespace, err)
+	}
+	if owner == nil || owner.Kind != kindDaemonSet {
+		return false, nil
+	}
+	return c.daemonSetRestarted(ctx, owner.Name, namespace)
+}
+
+// ListNodePods returns the pods scheduled on the node from the informer cache
+// when available, otherwise from the API server.
+func (c *client) ListNodePods(ctx context.Context) ([]v1.Pod, error) {
+	if c.podLister != nil {
+		// the pod informer only watches the pods of the node
+		cached, err := c.podLister.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods on node %s: %w", c.nodeName, err)
+		}
+		pods := make([]v1.Pod, 0, len(cached))
+		for _, pod := range cached {
+			pods = append(pods, *pod)
+		}
+		return pods, nil
+	}
+	pods, err := listPages(ctx, c, metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("spec.nodeName", c.nodeName).String()},
+		func(ctx context.Context, opts metav1.ListOptions) ([]v1.Pod, string, error) {
+			list, err := c.CoreV1().Pods(metav1.NamespaceAll).List(ctx, opts)
+			if err != nil {
+				return nil, "", err
+			}
+			return list.Items, list.Continue, nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods on node %s: %w", c.nodeName, err)
+	}
+	return pods, nil
+}
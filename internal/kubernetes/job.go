@@ -0,0 +1,38 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Policies to recover the pods owned by a Job or a CronJob
+const (
+	// JobDeletePod deletes the pod and lets the Job controller retry it.
+	JobDeletePod = "delete-pod"
+	// JobSkip leaves the pod alone.
+	JobSkip = "skip"
+)
+
+// ErrRecoverySkipped is returned when a pod is deliberately not recovered
+var ErrRecoverySkipped = errors.New("recovery skipped")
+
+// isJobKind reports whether the owner kind is a Job or a CronJob
+func isJobKind(kind string) bool {
+	return kind == kindJob || kind == kindCronJob
+}
+
+// recoverJobPod recovers a pod owned by a Job or a CronJob according to the
+// configured Job policy.
+func (c *client) recoverJobPod(ctx context.Context, name, kind string, pod *v1.Pod) error {
+	if c.jobPolicy != JobDeletePod {
+		return fmt.Errorf("%w: pod %s in namespace %s is owned by %s %s", ErrRecoverySkipped, pod.Name, pod.Namespace, kind, name)
+	}
+	err := c.deletePod(ctx, pod)
+	if err != nil {
+		return fmt.Errorf("failed to delete pod %s of %s %s in namespace %s: %w", pod.Name, kind, name, pod.Namespace, err)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	v1alpha1 "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+)
+
+// defaultKubeletPort is the port the kubelet serves its API on
+const defaultKubeletPort = 10250
+
+// statsBackoff is the backoff between the attempts to get the stats summary
+var statsBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Cap:      30 * time.Second,
+}
+
+// GetMetrics returns the kubelet stats summary of the node, retrying with
+// backoff as the kubelet or the API server may be briefly unavailable.
+func (c *client) GetMetrics(ctx context.Context) (*v1alpha1.Summary, error) {
+	backoff := statsBackoff
+	backoff.Steps = c.statsRetries + 1
+
+	var summary *v1alpha1.Summary
+	var lastErr error
+	attempts := 0
+	err := wait.ExponentialBackoffWithContext(ctx, backoff, func(ctx context.Context) (bool, error) {
+		attempts++
+		summary, lastErr = c.getSummary(ctx)
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		if lastErr != nil {
+			return nil, fmt.Errorf("failed to get stats summary of node %s after %d attempts: %w", c.nodeName, attempts, lastErr)
+		}
+		return nil, err
+	}
+	return summary, nil
+}
+
+// getSummary returns the kubelet stats summary of the node. When direct
+// kubelet access is enabled the kubelet is queried first, falling back to the
+// API server proxy which some clusters disable.
+func (c *client) getSummary(ctx context.Context) (*v1alpha1.Summary, error) {
+	if c.kubeletDirect {
+		summary, directErr := c.kubeletSummary(ctx)
+		if directErr == nil {
+			return summary, nil
+		}
+		summary, err := c.proxySummary(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get stats from kubelet (%v) and through the API server proxy: %w", directErr, err)
+		}
+		return summary, nil
+	}
+	return c.proxySummary(ctx)
+}
+
+// proxySummary fetches the stats summary through the API server node proxy
+func (c *client) proxySummary(ctx context.Context) (*v1alpha1.Summary, error) {
+	url := fmt.Sprintf("/api/v1/nodes/%s/proxy/stats/summary", c.nodeName)
+	stream, err := c.Clientset.NodeV1().RESTClient().Get().AbsPath(url).Stream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	return decodeSummary(stream)
+}
+
+// kubeletSummary fetches the stats summary from the kubelet of the node,
+// authenticating with the credentials of the client, i.e. the service account
+// token when running in the cluster.
+func (c *client) kubeletSummary(ctx context.Context) (*v1alpha1.Summary, error) {
+	address, err := c.kubeletAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	config := rest.CopyConfig(c.config)
+	config.Host = "https://" + net.JoinHostPort(address, strconv.Itoa(c.kubeletPort))
+	config.APIPath = ""
+	// the kubelet serving certificate isn't signed by the API server CA
+	config.TLSClientConfig.CAFile = c.kubeletCAFile
+	config.TLSClientConfig.CAData = nil
+	httpClient, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubelet client: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.Host+"/stats/summary", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats from kubelet %s: %w", config.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, fmt.Errorf("kubelet %s returned %s: %s", config.Host, resp.Status, body)
+	}
+	return decodeSummary(resp.Body)
+}
+
+// maxErrorBody is how much of an error response of the kubelet is reported
+const maxErrorBody = 4 << 10
+
+// podSummary is the part of the stats of a pod in the summary the recovery
+// uses, the stats of the containers and the network are skipped
+type podSummary struct {
+	PodRef      v1alpha1.PodReference  `json:"podRef"`
+	VolumeStats []v1alpha1.VolumeStats `json:"volume,omitempty"`
+}
+
+// decodeSummary decodes the stats summary as it is read, pod by pod, keeping
+// only the references and the volume stats of the pods. The summary of a
+// dense node is several MB, mostly container stats, which are neither held
+// in memory whole nor decoded.
+func decodeSummary(r io.Reader) (*v1alpha1.Summary, error) {
+	summary := &v1alpha1.Summary{}
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return nil, err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode stats summary: %w", err)
+		}
+		switch token {
+		case "node":
+			var node struct {
+				NodeName string `json:"nodeName"`
+			}
+			if err := decoder.Decode(&node); err != nil {
+				return nil, fmt.Errorf("failed to decode node stats: %w", err)
+			}
+			summary.Node.NodeName = node.NodeName
+		case "pods":
+			if err := expectDelim(decoder, '['); err != nil {
+				return nil, err
+			}
+			for decoder.More() {
+				var pod podSummary
+				if err := decoder.Decode(&pod); err != nil {
+					return nil, fmt.Errorf("failed to decode pod stats: %w", err)
+				}
+				summary.Pods = append(summary.Pods, v1alpha1.PodStats{PodRef: pod.PodRef, VolumeStats: pod.VolumeStats})
+			}
+			if err := expectDelim(decoder, ']'); err != nil {
+				return nil, err
+			}
+		default:
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return nil, fmt.Errorf("failed to decode stats summary: %w", err)
+			}
+		}
+	}
+	if err := expectDelim(decoder, '}'); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// expectDelim reads the delimiter from the decoder
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("failed to decode stats summary: %w", err)
+	}
+	if token != delim {
+		return fmt.Errorf("failed to decode stats summary: expected %s, got %v", delim, token)
+	}
+	return nil
+}
+
+// kubeletAddress returns the internal address of the node, or its hostname
+// if the node doesn't report one.
+func (c *client) kubeletAddress(ctx context.Context) (string, error) {
+	node, err := c.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get node %s: %w", c.nodeName, err)
+	}
+	for _, addrType := range []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeHostName} {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == addrType {
+				return addr.Address, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("node %s has no internal address", c.nodeName)
+}
@@ -0,0 +1,137 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// Annotations locking an owner while a recovery is in flight, so two
+// instances of the tool, or the tool and a human, don't perform conflicting
+// recoveries
+const (
+	LockHolderAnnotation = annotationPrefix + "lock-holder"
+	LockExpiryAnnotation = annotationPrefix + "lock-expiry"
+)
+
+// defaultLockTTL is how long the lock on an owner is valid by default
+const defaultLockTTL = 10 * time.Minute
+
+// lockedByOther returns the holder of the lock set in the annotations when
+// it is held by someone else and hasn't expired. A lock without a valid expiry
+// is held until removed by hand.
+func (c *client) lockedByOther(annotations map[string]string) (string, bool) {
+	holder := annotations[LockHolderAnnotation]
+	if holder == "" || holder == c.lockHolder {
+		return "", false
+	}
+	expiry, err := time.Parse(time.RFC3339, annotations[LockExpiryAnnotation])
+	return holder, err != nil || time.Now().Before(expiry)
+}
+
+// lockOwner sets the lock annotations on the owner and returns a function
+// releasing the lock. Owners locked by another holder are skipped until the
+// lock expires. The lock is renewed until released, so a recovery outlasting
+// the TTL, e.g. waiting for a PDB or repairing a filesystem, keeps it.
+func (c *client) lockOwner(ctx context.Context, namespace string, owner *metav1.OwnerReference) (func(context.Context) error, error) {
+	resource, err := c.ownerResource(namespace, owner)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := resource.Get(ctx, owner.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s in namespace %s: %w", owner.Kind, owner.Name, namespace, err)
+	}
+
+	annotations := obj.GetAnnotations()
+	if holder, locked := c.lockedByOther(annotations); locked {
+		return nil, fmt.Errorf("%w: %s %s in namespace %s is locked by %s until %s", ErrRecoverySkipped,
+			owner.Kind, owner.Name, namespace, holder, annotations[LockExpiryAnnotation])
+	}
+
+	expiry := time.Now().Add(c.lockTTL).UTC().Format(time.RFC3339)
+	// the resource version makes the patch fail if another holder took the
+	// lock in the meantime
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"resourceVersion": obj.GetResourceVersion(),
+			"annotations": map[string]string{
+				LockHolderAnnotation: c.lockHolder,
+				LockExpiryAnnotation: expiry,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build lock patch: %w", err)
+	}
+	_, err = resource.Patch(ctx, owner.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s %s in namespace %s: %w", owner.Kind, owner.Name, namespace, err)
+	}
+
+	renewCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		c.renewLock(renewCtx, resource, owner.Name)
+	}()
+
+	unlock := func(ctx context.Context) error {
+		stop()
+		<-renewed
+		patch, err := annotationsPatch(map[string]*string{
+			LockHolderAnnotation: nil,
+			LockExpiryAnnotation: nil,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to build unlock patch: %w", err)
+		}
+		_, err = resource.Patch(ctx, owner.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+		if err != nil {
+			return fmt.Errorf("failed to unlock %s %s in namespace %s: %w", owner.Kind, owner.Name, namespace, err)
+		}
+		return nil
+	}
+	return unlock, nil
+}
+
+// renewLock pushes the expiry of the lock on the owner forward every third of
+// the TTL until the context is done. It stops if the lock was taken over,
+// which only happens once it expired, e.g. while the API server was
+// unreachable; the failed renewals are tried again on the next tick.
+func (c *client) renewLock(ctx context.Context, resource dynamic.ResourceInterface, name string) {
+	ticker := time.NewTicker(c.lockTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		obj, err := resource.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			continue
+		}
+		if obj.GetAnnotations()[LockHolderAnnotation] != c.lockHolder {
+			return
+		}
+		patch, err := json.Marshal(map[string]any{
+			"metadata": map[string]any{
+				"resourceVersion": obj.GetResourceVersion(),
+				"annotations": map[string]string{
+					LockExpiryAnnotation: time.Now().Add(c.lockTTL).UTC().Format(time.RFC3339),
+				},
+			},
+		})
+		if err != nil {
+			continue
+		}
+		// best effort, a conflict is retried on the next tick
+		_, _ = resource.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	}
+}
@@ -0,0 +1,147 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+)
+
+// CordonedAnnotation marks a node cordoned by the tool, so it only uncordons
+// nodes it cordoned itself
+const CordonedAnnotation = annotationPrefix + "cordoned"
+
+// CordonNode marks the node unschedulable. It returns false without changing
+// anything if the node is already unschedulable.
+func (c *client) CordonNode(ctx context.Context) (bool, error) {
+	node, err := c.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get node %s: %w", c.nodeName, err)
+	}
+	if node.Spec.Unschedulable {
+		return false, nil
+	}
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:"true"}},"spec":{"unschedulable":true}}`, CordonedAnnotation)
+	_, err = c.CoreV1().Nodes().Patch(ctx, c.nodeName, types.MergePatchType, []byte(patch), metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return false, fmt.Errorf("failed to cordon node %s: %w", c.nodeName, err)
+	}
+	return true, nil
+}
+
+// UncordonNode marks the node schedulable again if it was cordoned by
+// CordonNode.
+func (c *client) UncordonNode(ctx context.Context) error {
+	node, err := c.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s: %w", c.nodeName, err)
+	}
+	if _, ok := node.Annotations[CordonedAnnotation]; !ok {
+		return nil
+	}
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:null}},"spec":{"unschedulable":false}}`, CordonedAnnotation)
+	_, err = c.CoreV1().Nodes().Patch(ctx, c.nodeName, types.MergePatchType, []byte(patch), metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to uncordon node %s: %w", c.nodeName, err)
+	}
+	return nil
+}
+
+// ParseTaint parses a taint in the key[=value]:effect format used by kubectl
+func ParseTaint(s string) (v1.Taint, error) {
+	keyValue, effect, ok := strings.Cut(s, ":")
+	if !ok || effect == "" {
+		return v1.Taint{}, fmt.Errorf("invalid taint %q, expected key[=value]:effect", s)
+	}
+	switch v1.TaintEffect(effect) {
+	case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+	default:
+		return v1.Taint{}, fmt.Errorf("invalid taint effect %q", effect)
+	}
+	key, value, _ := strings.Cut(keyValue, "=")
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return v1.Taint{}, fmt.Errorf("invalid taint key %q: %s", key, strings.Join(errs, ", "))
+	}
+	return v1.Taint{Key: key, Value: value, Effect: v1.TaintEffect(effect)}, nil
+}
+
+// AddNodeTaint adds the taint to the node unless it is already there
+func (c *client) AddNodeTaint(ctx context.Context, taint v1.Taint) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := c.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get node %s: %w", c.nodeName, err)
+		}
+		for i := range node.Spec.Taints {
+			if node.Spec.Taints[i].MatchTaint(&taint) {
+				return nil
+			}
+		}
+		now := metav1.Now()
+		taint.TimeAdded = &now
+		node.Spec.Taints = append(node.Spec.Taints, taint)
+		_, err = c.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{FieldManager: FieldManager})
+		return err
+	})
+}
+
+// RemoveNodeTaint removes the taint from the node if it is there
+func (c *client) RemoveNodeTaint(ctx context.Context, taint v1.Taint) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := c.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get node %s: %w", c.nodeName, err)
+		}
+		taints := make([]v1.Taint, 0, len(node.Spec.Taints))
+		for i := range node.Spec.Taints {
+			if !node.Spec.Taints[i].MatchTaint(&taint) {
+				taints = append(taints, node.Spec.Taints[i])
+			}
+		}
+		if len(taints) == len(node.Spec.Taints) {
+			return nil
+		}
+		node.Spec.Taints = taints
+		_, err = c.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{FieldManager: FieldManager})
+		return err
+	})
+}
+
+// SetNodeCondition sets the condition in the status of the node, the way
+// node-problem-detector reports problems, so tools reacting to its conditions
+// see them as well. The transition time is kept while the status doesn't
+// change.
+func (c *client) SetNodeCondition(ctx context.Context, condition v1.NodeCondition) error {
+	node, err := c.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s: %w", c.nodeName, err)
+	}
+	now := metav1.Now()
+	condition.LastHeartbeatTime = now
+	condition.LastTransitionTime = now
+	for _, existing := range node.Status.Conditions {
+		if existing.Type == condition.Type && existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+	}
+	patch, err := json.Marshal(map[string]any{
+		"status": map[string]any{
+			"conditions": []v1.NodeCondition{condition},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	// conditions are merged by type
+	_, err = c.CoreV1().Nodes().Patch(ctx, c.nodeName, types.StrategicMergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager}, "status")
+	if err != nil {
+		return fmt.Errorf("failed to set condition %s on node %s: %w", condition.Type, c.nodeName, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DisabledAnnotation opts the Pods, PVCs and namespaces annotated with "true"
+// out of automated recovery, their volumes are still checked and reported
+const DisabledAnnotation = annotationPrefix + "disabled"
+
+// optedOut reports whether the annotations disable recovery
+func optedOut(annotations map[string]string) bool {
+	disabled, err := strconv.ParseBool(annotations[DisabledAnnotation])
+	return err == nil && disabled
+}
+
+// RecoveryDisabled returns the object opting the volume of the pod out of
+// recovery, or an empty string when recovery is allowed. Quarantined pods and
+// PVCs are left to an operator until the label is removed. The PVC name is
+// empty for inline ephemeral volumes.
+func (c *client) RecoveryDisabled(ctx context.Context, namespace, podName, pvcName string) (string, error) {
+	pod, err := c.getPod(ctx, namespace, podName)
+	if err != nil {
+		return "", err
+	}
+	if optedOut(pod.Annotations) {
+		return "pod " + namespace + "/" + podName, nil
+	}
+	if pod.Labels[QuarantinedLabel] == "true" {
+		return "quarantined pod " + namespace + "/" + podName, nil
+	}
+	if pvcName != "" {
+		pvc, err := c.GetPVC(ctx, pvcName, namespace)
+		if err != nil {
+			return "", err
+		}
+		if optedOut(pvc.Annotations) {
+			return "PVC " + namespace + "/" + pvcName, nil
+		}
+		if pvc.Labels[QuarantinedLabel] == "true" {
+			return "quarantined PVC " + namespace + "/" + pvcName, nil
+		}
+	}
+	ns, err := c.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get namespace %s: %w", namespace, err)
+	}
+	if optedOut(ns.Annotations) {
+		return "namespace " + namespace, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,161 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// Policies to recover the pods owned by any other kind, configured per kind
+const (
+	// OwnerScale scales the owner through its scale subresource.
+	OwnerScale = "scale"
+	// OwnerDeletePod deletes the pod and lets the owner recreate it.
+	OwnerDeletePod = "delete-pod"
+	// OwnerSkip leaves the pod alone.
+	OwnerSkip = "skip"
+)
+
+// Kinds of the owners which are recovered differently than through their
+// scale subresource
+const (
+	kindDaemonSet = "DaemonSet"
+	kindJob       = "Job"
+	kindCronJob   = "CronJob"
+)
+
+// ownerKey returns the Kind.group key, or just Kind for the core group, the
+// owner policies are configured with, e.g. Rollout.argoproj.io.
+func ownerKey(apiVersion, kind string) string {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil || gv.Group == "" {
+		return kind
+	}
+	return kind + "." + gv.Group
+}
+
+// ownerPolicy returns the policy configured for the owner kind, owners are
+// scaled by default.
+func (c *client) ownerPolicy(owner *metav1.OwnerReference) string {
+	if policy, ok := c.ownerPolicies[ownerKey(owner.APIVersion, owner.Kind)]; ok {
+		return policy
+	}
+	return OwnerScale
+}
+
+// ownerMapping maps the owner reference to the resource serving it
+func (c *client) ownerMapping(owner *metav1.OwnerReference) (*meta.RESTMapping, error) {
+	gvk := schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind)
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// the kind might have been installed after the discovery cache was
+		// filled, refresh it and try again
+		c.mapper.Reset()
+		mapping, err = c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find resource for %s: %w", gvk, err)
+	}
+	return mapping, nil
+}
+
+// ownerResource returns the dynamic client of the owner's resource
+func (c *client) ownerResource(namespace string, owner *metav1.OwnerReference) (dynamic.ResourceInterface, error) {
+	mapping, err := c.ownerMapping(owner)
+	if err != nil {
+		return nil, err
+	}
+	resource := c.dynamicClient.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return resource.Namespace(namespace), nil
+	}
+	return resource, nil
+}
+
+// getOwnerObject fetches the owner of any kind through the dynamic client
+func (c *client) getOwnerObject(ctx context.Context, namespace string, owner *metav1.OwnerReference) (*unstructured.Unstructured, error) {
+	resource, err := c.ownerResource(namespace, owner)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := resource.Get(ctx, owner.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s in namespace %s: %w", owner.Kind, owner.Name, namespace, err)
+	}
+	return obj, nil
+}
+
+// annotateOwner sets the annotations on the owner, nil values remove the
+// annotation
+func (c *client) annotateOwner(ctx context.Context, namespace string, owner *metav1.OwnerReference, annotations map[string]*string) error {
+	resource, err := c.ownerResource(namespace, owner)
+	if err != nil {
+		return err
+	}
+	patch, err := annotationsPatch(annotations)
+	if err != nil {
+		return fmt.Errorf("failed to build annotations patch: %w", err)
+	}
+	_, err = resource.Patch(ctx, owner.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to annotate %s %s in namespace %s: %w", owner.Kind, owner.Name, namespace, err)
+	}
+	return nil
+}
+
+// recoverOwnedPod recovers the pod according to the policy configured for
+// the kind of its owner.
+func (c *client) recoverOwnedPod(ctx context.Context, pod *v1.Pod, owner *metav1.OwnerReference, count int32, hook ScaledDownHook) error {
+	switch c.ownerPolicy(owner) {
+	case OwnerSkip:
+		return fmt.Errorf("%w: pod %s in namespace %s is owned by %s %s", ErrRecoverySkipped, pod.Name, pod.Namespace, owner.Kind, owner.Name)
+	case OwnerDeletePod:
+		err := c.deletePod(ctx, pod)
+		if err != nil {
+			return fmt.Errorf("failed to delete pod %s of %s %s in namespace %s: %w", pod.Name, owner.Kind, owner.Name, pod.Namespace, err)
+		}
+		return nil
+	default:
+		return c.scaleOwner(ctx, pod, owner, count, hook)
+	}
+}
+
+// ScaledOwner returns the owner ScaleOwner recovers as a whole along with the
+// pod, as Kind.group/name, so the pods sharing it are recovered only once. It
+// returns an empty string when only the pod itself is recovered, e.g. for the
+// pods of Jobs or without an owner.
+func (c *client) ScaledOwner(ctx context.Context, namespace, podName string) (string, error) {
+	pod, err := c.getPod(ctx, namespace, podName)
+	if err != nil {
+		return "", err
+	}
+	if IsMirrorPod(pod) {
+		// owned by its node, which isn't recovered
+		return "", nil
+	}
+	owner, err := c.findTopOwner(ctx, namespace, pod.OwnerReferences)
+	if err != nil {
+		return "", fmt.Errorf("failed to find top owner for pod %s in namespace %s: %w", podName, namespace, err)
+	}
+	if owner == nil {
+		return "", nil
+	}
+	switch {
+	case owner.Kind == kindDaemonSet:
+		if c.daemonSetPolicy != DaemonSetRolloutRestart {
+			return "", nil
+		}
+	case isJobKind(owner.Kind):
+		return "", nil
+	case c.ownerPolicy(owner) != OwnerScale:
+		return "", nil
+	}
+	return ownerKey(owner.APIVersion, owner.Kind) + "/" + owner.Name, nil
+}
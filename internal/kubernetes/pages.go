@@ -0,0 +1,27 @@
+package kubernetes
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// listPages lists the objects with the options, c.pageSize objects at a time
+// so large lists don't load the API server at once; all at once when the
+// page size is 0. list returns the objects of a page and the continue token
+// of the next one.
+func listPages[T any](ctx context.Context, c *client, opts metav1.ListOptions, list func(context.Context, metav1.ListOptions) ([]T, string, error)) ([]T, error) {
+	opts.Limit = c.pageSize
+	var items []T
+	for {
+		page, next, err := list(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+		if next == "" {
+			return items, nil
+		}
+		opts.Continue = next
+	}
+}
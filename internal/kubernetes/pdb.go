@@ -0,0 +1,199 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Policies to restart the pods covered by a PodDisruptionBudget, and to scale
+// their owners to zero, see allowScaleDown
+const (
+	// PDBEvict evicts the pod through the Eviction API, which refuses to
+	// disrupt it when a budget doesn't allow it; the recovery fails then
+	// and is retried later.
+	PDBEvict = "evict"
+	// PDBWait waits for the budgets to allow the disruption, up to the PDB
+	// wait timeout, before evicting the pod.
+	PDBWait = "wait"
+	// PDBSkip leaves the pod alone when a budget doesn't allow its
+	// disruption.
+	PDBSkip = "skip"
+	// PDBIgnore deletes the pod regardless of the budgets.
+	PDBIgnore = "ignore"
+)
+
+// ErrDisruptionBlocked is returned when a PodDisruptionBudget doesn't allow a
+// pod to be restarted
+var ErrDisruptionBlocked = errors.New("disruption not allowed by PodDisruptionBudget")
+
+// pdbBlockedReason is the reason of the events recorded on the pods a budget
+// kept from being restarted
+const pdbBlockedReason = "RecoveryBlockedByPDB"
+
+// defaultPDBWaitTimeout is how long the wait policy waits for the budgets
+const defaultPDBWaitTimeout = 5 * time.Minute
+
+// scaleDownBlockingPDBs returns the names of the PodDisruptionBudgets covering
+// the pod which don't allow the replicas of its owner to be disrupted at once,
+// as scaling the owner to zero does
+func (c *client) scaleDownBlockingPDBs(ctx context.Context, pod *v1.Pod, replicas int32) ([]string, error) {
+	list, err := c.PolicyV1().PodDisruptionBudgets(pod.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PodDisruptionBudgets in namespace %s: %w", pod.Namespace, err)
+	}
+	var blocking []string
+	for i := range list.Items {
+		pdb := &list.Items[i]
+		if pdb.Spec.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		if pdb.Status.DisruptionsAllowed < replicas {
+			blocking = append(blocking, pdb.Name)
+		}
+	}
+	return blocking, nil
+}
+
+// allowScaleDown applies the PDB policy to scaling the owner of the pod from
+// the replicas to zero, which bypasses the Eviction API: the scale down is
+// skipped with the skip policy, waited for with the wait policy and refused
+// with the evict policy when a budget doesn't allow all the replicas to be
+// disrupted.
+func (c *client) allowScaleDown(ctx context.Context, pod *v1.Pod, owner *metav1.OwnerReference, replicas int32) error {
+	if c.pdbPolicy == PDBIgnore || replicas == 0 {
+		return nil
+	}
+	var blocking []string
+	var err error
+	if c.pdbPolicy == PDBWait {
+		err = wait.PollUntilContextTimeout(ctx, 5*time.Second, c.pdbWaitTimeout, true, func(ctx context.Context) (bool, error) {
+			var err error
+			blocking, err = c.scaleDownBlockingPDBs(ctx, pod, replicas)
+			return len(blocking) == 0, err
+		})
+		if err != nil && len(blocking) > 0 {
+			return c.disruptionBlocked(ctx, pod, fmt.Errorf("%w %s to scale %s %s down from %d replicas after waiting %s",
+				ErrDisruptionBlocked, strings.Join(blocking, ","), owner.Kind, owner.Name, replicas, c.pdbWaitTimeout))
+		}
+		return err
+	}
+	blocking, err = c.scaleDownBlockingPDBs(ctx, pod, replicas)
+	if err != nil || len(blocking) == 0 {
+		return err
+	}
+	err = fmt.Errorf("%w %s to scale %s %s down from %d replicas", ErrDisruptionBlocked, strings.Join(blocking, ","), owner.Kind, owner.Name, replicas)
+	if c.pdbPolicy == PDBSkip {
+		err = fmt.Errorf("%w: %w", ErrRecoverySkipped, err)
+	}
+	return c.disruptionBlocked(ctx, pod, err)
+}
+
+// blockingPDBs returns the names of the PodDisruptionBudgets which don't allow
+// the pod to be disrupted. Like the Eviction API, unhealthy pods may be
+// disrupted as long as the budget is met by the healthy ones.
+func (c *client) blockingPDBs(ctx context.Context, pod *v1.Pod) ([]string, error) {
+	list, err := c.PolicyV1().PodDisruptionBudgets(pod.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PodDisruptionBudgets in namespace %s: %w", pod.Namespace, err)
+	}
+	ready := podReady(pod)
+	var blocking []string
+	for i := range list.Items {
+		pdb := &list.Items[i]
+		if pdb.Spec.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		if pdb.Status.DisruptionsAllowed > 0 {
+			continue
+		}
+		if !ready && (pdb.Spec.UnhealthyPodEvictionPolicy != nil && *pdb.Spec.UnhealthyPodEvictionPolicy == policyv1.AlwaysAllow ||
+			pdb.Status.CurrentHealthy >= pdb.Status.DesiredHealthy) {
+			continue
+		}
+		blocking = append(blocking, pdb.Name)
+	}
+	return blocking, nil
+}
+
+// podReady reports whether the pod has the Ready condition
+func podReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// disruptPod evicts or deletes the pod according to the PDB policy
+func (c *client) disruptPod(ctx context.Context, pod *v1.Pod) error {
+	options := metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(pod.UID))}
+	switch c.pdbPolicy {
+	case PDBIgnore:
+		return c.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, options)
+	case PDBSkip:
+		blocking, err := c.blockingPDBs(ctx, pod)
+		if err != nil {
+			return err
+		}
+		if len(blocking) > 0 {
+			return c.disruptionBlocked(ctx, pod, fmt.Errorf("%w: %w %s", ErrRecoverySkipped, ErrDisruptionBlocked, strings.Join(blocking, ",")))
+		}
+	case PDBWait:
+		var blocking []string
+		err := wait.PollUntilContextTimeout(ctx, 5*time.Second, c.pdbWaitTimeout, true, func(ctx context.Context) (bool, error) {
+			var err error
+			blocking, err = c.blockingPDBs(ctx, pod)
+			return len(blocking) == 0, err
+		})
+		if err != nil && len(blocking) > 0 {
+			return c.disruptionBlocked(ctx, pod, fmt.Errorf("%w %s after waiting %s", ErrDisruptionBlocked, strings.Join(blocking, ","), c.pdbWaitTimeout))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	eviction := &policyv1.Eviction{
+		ObjectMeta:    metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		DeleteOptions: &options,
+	}
+	err := c.CoreV1().Pods(pod.Namespace).EvictV1(ctx, eviction)
+	if apierrors.IsTooManyRequests(err) {
+		return c.disruptionBlocked(ctx, pod, fmt.Errorf("%w: %w", ErrDisruptionBlocked, err))
+	}
+	return err
+}
+
+// disruptionBlocked reports the pod a budget kept from being restarted with an
+// event, and returns the error. The event is best effort, the error is
+// recorded on the PVC anyway.
+func (c *client) disruptionBlocked(ctx context.Context, pod *v1.Pod, err error) error {
+	ref := v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}
+	message := fmt.Sprintf("not restarting pod to recover its volumes: %v", err)
+	_ = c.recordWarning(ctx, ref, pdbBlockedReason, message)
+	return err
+}
@@ -0,0 +1,176 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// deletePod evicts or deletes the pod according to the PDB policy and, when a
+// pod deletion timeout is configured, waits for it to be gone so the
+// replacement doesn't race the old pod for the volume.
+func (c *client) deletePod(ctx context.Context, pod *v1.Pod) error {
+	err := c.disruptPod(ctx, pod)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if c.podDeletionTimeout <= 0 {
+		return nil
+	}
+	return wait.PollUntilContextTimeout(ctx, 2*time.Second, c.podDeletionTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := c.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		// a pod with the same name but another UID is the replacement
+		return current.UID != pod.UID, nil
+	})
+}
+
+// podListWatch lists and watches the pods matching the label selector
+func (c *client) podListWatch(ctx context.Context, namespace, selector string) *cache.ListWatch {
+	pods := c.CoreV1().Pods(namespace)
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.LabelSelector = selector
+			return pods.List(ctx, opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.LabelSelector = selector
+			return pods.Watch(ctx, opts)
+		},
+	}
+}
+
+// waitForPodsGone watches the pods matching the label selector until none of
+// them is left, or the timeout expires.
+func (c *client) waitForPodsGone(ctx context.Context, namespace, selector string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	remaining := map[types.UID]bool{}
+	precondition := func(store cache.Store) (bool, error) {
+		for _, obj := range store.List() {
+			remaining[obj.(*v1.Pod).UID] = true
+		}
+		return len(remaining) == 0, nil
+	}
+	_, err := watchtools.UntilWithSync(ctx, c.podListWatch(ctx, namespace, selector), &v1.Pod{}, precondition, func(event watch.Event) (bool, error) {
+		pod, ok := event.Object.(*v1.Pod)
+		if !ok {
+			return false, nil
+		}
+		if event.Type == watch.Deleted {
+			delete(remaining, pod.UID)
+		} else {
+			remaining[pod.UID] = true
+		}
+		return len(remaining) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("%d pods matching %s are still running: %w", len(remaining), selector, err)
+	}
+	return nil
+}
+
+// waitForReplacement watches the pods sharing the labels of the deleted pod
+// until the pod is gone and a replacement created by the same controller since
+// the deletion is running, or the replacement timeout expires.
+func (c *client) waitForReplacement(ctx context.Context, pod *v1.Pod, deletedAt time.Time) error {
+	owner := controllerRef(pod.OwnerReferences)
+	if owner == nil {
+		return fmt.Errorf("pod %s in namespace %s has no controller to replace it", pod.Name, pod.Namespace)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.replacementTimeout)
+	defer cancel()
+	lw := c.podListWatch(ctx, pod.Namespace, labels.SelectorFromSet(pod.Labels).String())
+	// the running siblings of the pod don't replace it, creation timestamps
+	// have a precision of a second
+	since := deletedAt.Truncate(time.Second)
+	terminated, replaced := false, false
+	precondition := func(store cache.Store) (bool, error) {
+		// the pod might be gone before the watch started
+		terminated = true
+		for _, obj := range store.List() {
+			if obj.(*v1.Pod).UID == pod.UID {
+				terminated = false
+			}
+		}
+		return false, nil
+	}
+	_, err := watchtools.UntilWithSync(ctx, lw, &v1.Pod{}, precondition, func(event watch.Event) (bool, error) {
+		current, ok := event.Object.(*v1.Pod)
+		if !ok {
+			return false, nil
+		}
+		if current.UID == pod.UID {
+			if event.Type == watch.Deleted {
+				terminated = true
+			}
+		} else if ref := controllerRef(current.OwnerReferences); ref != nil && ref.UID == owner.UID &&
+			event.Type != watch.Deleted && current.Status.Phase == v1.PodRunning &&
+			!current.CreationTimestamp.Time.Before(since) {
+			replaced = true
+		}
+		return terminated && replaced, nil
+	})
+	if err != nil {
+		return fmt.Errorf("pod %s in namespace %s terminated: %t, replacement running: %t: %w",
+			pod.Name, pod.Namespace, terminated, replaced, err)
+	}
+	return nil
+}
+
+// ClaimReplacementReady reports whether a ready pod created at or after the
+// time uses the PVC, on any node, confirming the pod restarted to recover the
+// volume was replaced
+func (c *client) ClaimReplacementReady(ctx context.Context, namespace, pvcName string, since time.Time) (bool, error) {
+	list, err := c.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to list pods in namespace %s: %w", namespace, err)
+	}
+	// creation timestamps have a precision of a second
+	since = since.Truncate(time.Second)
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if pod.DeletionTimestamp != nil || pod.CreationTimestamp.Time.Before(since) || !podReady(pod) {
+			continue
+		}
+		for _, vol := range pod.Spec.Volumes {
+			if vol.PersistentVolumeClaim != nil && vol.PersistentVolumeClaim.ClaimName == pvcName {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// IsMirrorPod reports whether the pod is the mirror of a static pod the
+// kubelet runs from a manifest, such pods are owned by their node
+func IsMirrorPod(pod *v1.Pod) bool {
+	if _, ok := pod.Annotations[v1.MirrorPodAnnotationKey]; ok {
+		return true
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "Node" && ref.APIVersion == "v1" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// prefetched holds the PVCs and PVs listed at the start of a pass, nil when
+// none were
+type prefetched struct {
+	mu   sync.Mutex
+	pvcs corelisters.PersistentVolumeClaimLister
+	pvs  corelisters.PersistentVolumeLister
+}
+
+// PrefetchVolumes lists the PVCs of the namespaces of the claims, keyed by
+// namespace, in one go and gets the PVs bound to the claims, so the lookups
+// of a pass are served from memory until ForgetVolumes. Objects missing, e.g.
+// created since, are still looked up. Nothing is fetched once the informers
+// are started, their listers serve the lookups.
+func (c *client) PrefetchVolumes(ctx context.Context, claims map[string][]string) error {
+	if c.pvcLister != nil {
+		return nil
+	}
+	pvcs := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	var bound []string
+	for namespace, names := range claims {
+		items, err := listPages(ctx, c, metav1.ListOptions{}, func(ctx context.Context, opts metav1.ListOptions) ([]v1.PersistentVolumeClaim, string, error) {
+			list, err := c.CoreV1().PersistentVolumeClaims(namespace).List(ctx, opts)
+			if err != nil {
+				return nil, "", err
+			}
+			return list.Items, list.Continue, nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list PVCs in namespace %s: %w", namespace, err)
+		}
+		for i := range items {
+			if err := pvcs.Add(&items[i]); err != nil {
+				return fmt.Errorf("failed to index PVC %s in namespace %s: %w", items[i].Name, namespace, err)
+			}
+			if volumeName := items[i].Spec.VolumeName; volumeName != "" && slices.Contains(names, items[i].Name) &&
+				!slices.Contains(bound, volumeName) {
+				bound = append(bound, volumeName)
+			}
+		}
+	}
+	// the PVs of the cluster may be many more than those of the node
+	volumes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, name := range bound {
+		pv, err := c.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get PV %s: %w", name, err)
+		}
+		if err := volumes.Add(pv); err != nil {
+			return fmt.Errorf("failed to index PV %s: %w", name, err)
+		}
+	}
+
+	c.prefetched.mu.Lock()
+	defer c.prefetched.mu.Unlock()
+	c.prefetched.pvcs = corelisters.NewPersistentVolumeClaimLister(pvcs)
+	c.prefetched.pvs = corelisters.NewPersistentVolumeLister(volumes)
+	return nil
+}
+
+// ForgetVolumes drops the PVCs and PVs listed by PrefetchVolumes, the
+// following lookups go to the API server again
+func (c *client) ForgetVolumes() {
+	c.prefetched.mu.Lock()
+	defer c.prefetched.mu.Unlock()
+	c.prefetched.pvcs = nil
+	c.prefetched.pvs = nil
+}
+
+// prefetchedPVC returns the PVC listed by PrefetchVolumes, false when it
+// wasn't
+func (c *client) prefetchedPVC(pvcName, namespace string) (*v1.PersistentVolumeClaim, bool) {
+	c.prefetched.mu.Lock()
+	lister := c.prefetched.pvcs
+	c.prefetched.mu.Unlock()
+	if lister == nil {
+		return nil, false
+	}
+	pvc, err := lister.PersistentVolumeClaims(namespace).Get(pvcName)
+	return pvc, err == nil
+}
+
+// prefetchedPV returns the PV listed by PrefetchVolumes, false when it wasn't
+func (c *client) prefetchedPV(pvName string) (*v1.PersistentVolume, bool) {
+	c.prefetched.mu.Lock()
+	lister := c.prefetched.pvs
+	c.prefetched.mu.Unlock()
+	if lister == nil {
+		return nil, false
+	}
+	pv, err := lister.Get(pvName)
+	return pv, err == nil
+}
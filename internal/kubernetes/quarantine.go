@@ -0,0 +1,88 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// QuarantinedLabel marks the pods and PVCs of abnormal volumes left for a
+// manual recovery in quarantine mode, so they can be listed with a selector
+const QuarantinedLabel = annotationPrefix + "quarantined"
+
+// Annotations recording why and when a volume was quarantined
+const (
+	QuarantineReasonAnnotation = annotationPrefix + "quarantine-reason"
+	QuarantineTimeAnnotation   = annotationPrefix + "quarantine-time"
+)
+
+// QuarantinedReason is the reason of the events recorded on quarantined pods
+// and PVCs
+const QuarantinedReason = "VolumeQuarantined"
+
+// quarantinePatch returns a merge patch labeling and annotating a quarantined
+// object
+func quarantinePatch(reason string) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"labels": map[string]string{QuarantinedLabel: "true"},
+			"annotations": map[string]string{
+				QuarantineReasonAnnotation: reason,
+				QuarantineTimeAnnotation:   time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	})
+}
+
+// Quarantine labels and annotates the pod and the PVC of the abnormal volume
+// and records a warning event on each, the PVC name is empty for inline
+// ephemeral volumes. Objects already quarantined are left as they are, so
+// the events aren't repeated on every pass. It reports whether any object
+// was quarantined.
+func (c *client) Quarantine(ctx context.Context, namespace, podName, pvcName, reason string) (bool, error) {
+	patch, err := quarantinePatch(reason)
+	if err != nil {
+		return false, fmt.Errorf("failed to build quarantine patch: %w", err)
+	}
+	message := "volume left for a manual recovery: " + reason
+	quarantined := false
+	var errs []error
+
+	pod, err := c.getPod(ctx, namespace, podName)
+	if err != nil {
+		return false, err
+	}
+	if pod.Labels[QuarantinedLabel] != "true" {
+		pod, err = c.CoreV1().Pods(namespace).Patch(ctx, podName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+		if err != nil {
+			return false, fmt.Errorf("failed to quarantine pod %s in namespace %s: %w", podName, namespace, err)
+		}
+		quarantined = true
+		ref := v1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: namespace, Name: pod.Name, UID: pod.UID}
+		errs = append(errs, c.recordWarning(ctx, ref, QuarantinedReason, message))
+	}
+	if pvcName == "" {
+		return quarantined, errors.Join(errs...)
+	}
+
+	pvc, err := c.GetPVC(ctx, pvcName, namespace)
+	if err != nil {
+		return quarantined, errors.Join(append(errs, err)...)
+	}
+	if pvc.Labels[QuarantinedLabel] != "true" {
+		pvc, err = c.CoreV1().PersistentVolumeClaims(namespace).Patch(ctx, pvcName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+		if err != nil {
+			return quarantined, errors.Join(append(errs, fmt.Errorf("failed to quarantine PVC %s in namespace %s: %w", pvcName, namespace, err))...)
+		}
+		quarantined = true
+		ref := v1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Namespace: namespace, Name: pvc.Name, UID: pvc.UID}
+		errs = append(errs, c.recordWarning(ctx, ref, QuarantinedReason, message))
+	}
+	return quarantined, errors.Join(errs...)
+}
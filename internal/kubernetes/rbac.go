@@ -0,0 +1,129 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Permission is an access to the API the tool needs
+type Permission struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	// Reason explains what the permission is needed for
+	Reason string
+	// Optional permissions are only needed by features which are disabled
+	// by default
+	Optional bool
+}
+
+// String returns the permission in the verb resource/subresource.group form
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	return p.Verb + " " + resource
+}
+
+// RequiredPermissions lists the permissions used by the tool
+var RequiredPermissions = []Permission{
+	{Verb: "get", Resource: "nodes", Subresource: "proxy", Reason: "read the kubelet volume stats"},
+	{Verb: "get", Resource: "pods", Reason: "find the pods using the volumes and verify restarted pods are gone"},
+	{Verb: "list", Resource: "pods", Reason: "find the pods on the node"},
+	{Verb: "watch", Resource: "pods", Reason: "cache the pods on the node in daemon mode"},
+	{Verb: "delete", Resource: "pods", Reason: "restart the pods using unhealthy volumes"},
+	{Verb: "create", Resource: "pods", Subresource: "eviction", Reason: "restart the pods within their PodDisruptionBudgets"},
+	{Verb: "get", Resource: "persistentvolumeclaims", Reason: "find the driver of the volumes"},
+	{Verb: "list", Resource: "persistentvolumeclaims", Reason: "list the PVCs of a pass at once, or cache them in daemon mode"},
+	{Verb: "watch", Resource: "persistentvolumeclaims", Reason: "cache the PVCs in daemon mode"},
+	{Verb: "patch", Resource: "persistentvolumeclaims", Reason: "record the recoveries on the PVCs"},
+	{Verb: "get", Resource: "persistentvolumes", Reason: "find the driver of the volumes"},
+	{Verb: "get", Resource: "namespaces", Reason: "honour the recovery opt-out annotation of the namespaces"},
+	{Verb: "list", Resource: "persistentvolumes", Reason: "cache the PVs in daemon mode"},
+	{Verb: "watch", Resource: "persistentvolumes", Reason: "cache the PVs in daemon mode"},
+	{Verb: "get", Group: "apps", Resource: "replicasets", Reason: "resolve and lock the owners of the pods"},
+	{Verb: "patch", Group: "apps", Resource: "replicasets", Reason: "lock standalone ReplicaSets during recoveries"},
+	{Verb: "get", Group: "apps", Resource: "deployments", Reason: "resolve and lock the owners of the pods"},
+	{Verb: "patch", Group: "apps", Resource: "deployments", Reason: "lock the owners during recoveries"},
+	{Verb: "get", Group: "apps", Resource: "statefulsets", Reason: "resolve and lock the owners of the pods"},
+	{Verb: "patch", Group: "apps", Resource: "statefulsets", Reason: "lock the owners during recoveries"},
+	{Verb: "get", Group: "apps", Resource: "daemonsets", Reason: "resolve and lock the owners of the pods and verify their rollout restarts"},
+	{Verb: "patch", Group: "apps", Resource: "daemonsets", Reason: "lock and restart DaemonSets"},
+	{Verb: "get", Group: "apps", Resource: "deployments", Subresource: "scale", Reason: "scale Deployments"},
+	{Verb: "patch", Group: "apps", Resource: "deployments", Subresource: "scale", Reason: "scale Deployments"},
+	{Verb: "get", Group: "apps", Resource: "statefulsets", Subresource: "scale", Reason: "scale StatefulSets"},
+	{Verb: "patch", Group: "apps", Resource: "statefulsets", Subresource: "scale", Reason: "scale StatefulSets"},
+	{Verb: "get", Group: "apps", Resource: "replicasets", Subresource: "scale", Reason: "scale standalone ReplicaSets"},
+	{Verb: "patch", Group: "apps", Resource: "replicasets", Subresource: "scale", Reason: "scale standalone ReplicaSets"},
+	{Verb: "get", Resource: "replicationcontrollers", Reason: "resolve and lock ReplicationControllers", Optional: true},
+	{Verb: "patch", Resource: "replicationcontrollers", Reason: "lock ReplicationControllers during recoveries", Optional: true},
+	{Verb: "get", Resource: "replicationcontrollers", Subresource: "scale", Reason: "scale ReplicationControllers", Optional: true},
+	{Verb: "patch", Resource: "replicationcontrollers", Subresource: "scale", Reason: "scale ReplicationControllers", Optional: true},
+	{Verb: "list", Group: "autoscaling", Resource: "horizontalpodautoscalers", Reason: "find the HPAs of scaled owners"},
+	{Verb: "get", Group: "autoscaling", Resource: "horizontalpodautoscalers", Reason: "pause the HPAs of scaled owners"},
+	{Verb: "update", Group: "autoscaling", Resource: "horizontalpodautoscalers", Reason: "pause the HPAs of scaled owners"},
+	{Verb: "list", Group: "policy", Resource: "poddisruptionbudgets", Reason: "wait for or skip the pods a PodDisruptionBudget protects", Optional: true},
+	{Verb: "get", Group: "batch", Resource: "jobs", Reason: "resolve the owners of the pods", Optional: true},
+	{Verb: "patch", Group: "batch", Resource: "jobs", Reason: "lock Jobs during recoveries", Optional: true},
+	{Verb: "get", Group: "batch", Resource: "cronjobs", Reason: "resolve the owners of the pods", Optional: true},
+	{Verb: "get", Resource: "nodes", Reason: "track the owners scaled down on the node, cordon and taint it, reach the kubelet directly, or check the node of stuck VolumeAttachments"},
+	{Verb: "update", Resource: "nodes", Reason: "taint the node", Optional: true},
+	{Verb: "patch", Resource: "nodes", Reason: "track the owners scaled down on the node and cordon it"},
+	{Verb: "patch", Resource: "pods", Reason: "open the recovery circuit of inline volumes and quarantine pods", Optional: true},
+	{Verb: "create", Resource: "events", Reason: "report open recovery circuits and the volume condition of the PVCs", Optional: true},
+	{Verb: "get", Resource: "events", Reason: "update the summary event of the passes on the node and the volume condition events of the PVCs", Optional: true},
+	{Verb: "update", Resource: "events", Reason: "update the summary event of the passes on the node and the volume condition events of the PVCs", Optional: true},
+	{Verb: "get", Resource: "configmaps", Reason: "persist the recovery history", Optional: true},
+	{Verb: "create", Resource: "configmaps", Reason: "persist the recovery history", Optional: true},
+	{Verb: "update", Resource: "configmaps", Reason: "persist the recovery history", Optional: true},
+	{Verb: "patch", Resource: "nodes", Subresource: "status", Reason: "report abnormal volumes as a node condition", Optional: true},
+	{Verb: "get", Group: "storage.k8s.io", Resource: "storageclasses", Reason: "check that PVCs can be expanded when running out of space", Optional: true},
+	{Verb: "list", Resource: "events", Reason: "read the abnormal volume condition events of the PVCs and the volume failure events of the pending pods", Optional: true},
+	{Verb: "watch", Resource: "events", Reason: "cache the abnormal volume condition events in daemon mode", Optional: true},
+	{Verb: "get", Resource: "secrets", Reason: "pass the driver secrets and the node stage and publish secrets of the PVs to the CSI calls", Optional: true},
+	{Verb: "list", Group: "storage.k8s.io", Resource: "volumeattachments", Reason: "clean up stuck VolumeAttachments", Optional: true},
+	{Verb: "watch", Group: "storage.k8s.io", Resource: "volumeattachments", Reason: "cache the VolumeAttachments in daemon mode", Optional: true},
+	{Verb: "delete", Group: "storage.k8s.io", Resource: "volumeattachments", Reason: "clean up stuck VolumeAttachments", Optional: true},
+	{Verb: "patch", Group: "storage.k8s.io", Resource: "volumeattachments", Reason: "clean up stuck VolumeAttachments", Optional: true},
+	{Verb: "get", Group: "storage.k8s.io", Resource: "volumeattachments", Reason: "stage and publish attachable volumes again and verify stuck VolumeAttachments are cleaned up", Optional: true},
+	{Verb: "get", Group: "csi-recovery.io", Resource: "volumehealths", Reason: "report the health of the volumes in VolumeHealth objects", Optional: true},
+	{Verb: "create", Group: "csi-recovery.io", Resource: "volumehealths", Reason: "report the health of the volumes in VolumeHealth objects", Optional: true},
+	{Verb: "update", Group: "csi-recovery.io", Resource: "volumehealths", Subresource: "status", Reason: "report the health of the volumes in VolumeHealth objects", Optional: true},
+	{Verb: "list", Group: "velero.io", Resource: "backups", Reason: "defer the restarts while Velero backs up the namespace", Optional: true},
+	{Verb: "list", Group: "velero.io", Resource: "restores", Reason: "defer the restarts while Velero restores the namespace", Optional: true},
+	{Verb: "get", Group: "storage.k8s.io", Resource: "csidrivers", Reason: "pass the pod information to the drivers asking for it when publishing volumes again", Optional: true},
+}
+
+// CheckPermissions reviews the permissions with SelfSubjectAccessReviews and
+// returns the ones the tool isn't allowed to use.
+func (c *client) CheckPermissions(ctx context.Context, permissions []Permission) ([]Permission, error) {
+	var missing []Permission
+	for _, p := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Verb:        p.Verb,
+					Group:       p.Group,
+					Resource:    p.Resource,
+					Subresource: p.Subresource,
+				},
+			},
+		}
+		result, err := c.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to review permission %s: %w", p, err)
+		}
+		if !result.Status.Allowed {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,200 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+// ScaledOwnersAnnotation lists on the node the owners scaled down by the
+// recoveries made on it, so the next start of the tool finds those an
+// interruption left scaled down without listing every workload of the cluster
+const ScaledOwnersAnnotation = annotationPrefix + "scaled-owners"
+
+// scaledOwner is an owner of any kind scaled down by a recovery on the node,
+// the replica count to restore is in its OriginalReplicasAnnotation
+type scaledOwner struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	// HPA is the HPA paused while the owner is scaled down, if any
+	HPA string `json:"hpa,omitempty"`
+}
+
+func (o scaledOwner) ref() *metav1.OwnerReference {
+	return &metav1.OwnerReference{APIVersion: o.APIVersion, Kind: o.Kind, Name: o.Name}
+}
+
+// updateScaledOwners updates the list of the owners scaled down on the node.
+// The recoveries update it concurrently, the resource version of the node
+// fails the patch of a stale list.
+func (c *client) updateScaledOwners(ctx context.Context, update func([]scaledOwner) []scaledOwner) error {
+	c.scaledMu.Lock()
+	defer c.scaledMu.Unlock()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := c.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get node %s: %w", c.nodeName, err)
+		}
+		owners, err := parseScaledOwners(node.Annotations[ScaledOwnersAnnotation])
+		if err != nil {
+			return err
+		}
+		owners = update(owners)
+		var value *string
+		if len(owners) > 0 {
+			data, err := json.Marshal(owners)
+			if err != nil {
+				return fmt.Errorf("failed to encode scaled owners: %w", err)
+			}
+			value = new(string)
+			*value = string(data)
+		}
+		patch, err := json.Marshal(map[string]any{
+			"metadata": map[string]any{
+				"resourceVersion": node.ResourceVersion,
+				"annotations":     map[string]*string{ScaledOwnersAnnotation: value},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to build annotations patch: %w", err)
+		}
+		_, err = c.CoreV1().Nodes().Patch(ctx, c.nodeName, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+		return err
+	})
+}
+
+// parseScaledOwners decodes the ScaledOwnersAnnotation of the node
+func parseScaledOwners(value string) ([]scaledOwner, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var owners []scaledOwner
+	if err := json.Unmarshal([]byte(value), &owners); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", ScaledOwnersAnnotation, err)
+	}
+	return owners, nil
+}
+
+// recordScaledOwner adds the owner to the list of the node before it is
+// scaled down
+func (c *client) recordScaledOwner(ctx context.Context, owner scaledOwner) error {
+	err := c.updateScaledOwners(ctx, func(owners []scaledOwner) []scaledOwner {
+		owners = slices.DeleteFunc(owners, owner.same)
+		return append(owners, owner)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record scaled down %s %s on node %s: %w", owner.Kind, owner.Name, c.nodeName, err)
+	}
+	return nil
+}
+
+// forgetScaledOwner removes the owner from the list of the node once it is
+// restored
+func (c *client) forgetScaledOwner(ctx context.Context, owner scaledOwner) error {
+	err := c.updateScaledOwners(ctx, func(owners []scaledOwner) []scaledOwner {
+		return slices.DeleteFunc(owners, owner.same)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to forget scaled down %s %s on node %s: %w", owner.Kind, owner.Name, c.nodeName, err)
+	}
+	return nil
+}
+
+// same reports whether both entries are the same owner
+func (o scaledOwner) same(other scaledOwner) bool {
+	return o.APIVersion == other.APIVersion && o.Kind == other.Kind && o.Namespace == other.Namespace && o.Name == other.Name
+}
+
+// RestoreScaledOwners scales the owners the recoveries on the node left
+// scaled down, of any kind with a scale subresource, back to the replica count
+// recorded in their annotation, and resumes their paused HPAs, unless another
+// instance is recovering them. It returns the restored owners as
+// namespace/name.
+func (c *client) RestoreScaledOwners(ctx context.Context) ([]string, error) {
+	node, err := c.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %w", c.nodeName, err)
+	}
+	owners, err := parseScaledOwners(node.Annotations[ScaledOwnersAnnotation])
+	if err != nil {
+		return nil, err
+	}
+	var restored []string
+	var errs []error
+	for _, owner := range owners {
+		done, err := c.restoreScaledOwner(ctx, owner)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore %s %s in namespace %s: %w", owner.Kind, owner.Name, owner.Namespace, err))
+			continue
+		}
+		if !done {
+			continue
+		}
+		if err := c.forgetScaledOwner(ctx, owner); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		restored = append(restored, owner.Namespace+"/"+owner.Name)
+	}
+	return restored, errors.Join(errs...)
+}
+
+// restoreScaledOwner scales the owner back up and resumes its HPA, it reports
+// whether the owner is done with, false when another instance holds its lock
+func (c *client) restoreScaledOwner(ctx context.Context, owner scaledOwner) (bool, error) {
+	ref := owner.ref()
+	obj, err := c.getOwnerObject(ctx, owner.Namespace, ref)
+	if apierrors.IsNotFound(err) {
+		// deleted meanwhile, only its HPA may be left paused
+		return true, c.resumeScaledHPA(ctx, owner)
+	}
+	if err != nil {
+		return false, err
+	}
+	annotations := obj.GetAnnotations()
+	if _, locked := c.lockedByOther(annotations); locked {
+		return false, nil
+	}
+	if value, ok := annotations[OriginalReplicasAnnotation]; ok {
+		replicas, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return false, fmt.Errorf("invalid original replicas %q: %w", value, err)
+		}
+		mapping, err := c.ownerMapping(ref)
+		if err != nil {
+			return false, err
+		}
+		if err := c.patchReplicas(ctx, owner.Namespace, mapping.Resource, owner.Name, int32(replicas)); err != nil {
+			return false, err
+		}
+		if err := c.annotateOwner(ctx, owner.Namespace, ref, map[string]*string{OriginalReplicasAnnotation: nil}); err != nil {
+			return false, err
+		}
+	}
+	return true, c.resumeScaledHPA(ctx, owner)
+}
+
+// resumeScaledHPA resumes the HPA paused while the owner was scaled down
+func (c *client) resumeScaledHPA(ctx context.Context, owner scaledOwner) error {
+	if owner.HPA == "" {
+		return nil
+	}
+	err := c.resumeHPA(ctx, owner.Namespace, owner.HPA)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resume HPA %s: %w", owner.HPA, err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+// OriginalReplicasAnnotation records the replica count of an owner while it
+// is scaled down for a recovery
+const OriginalReplicasAnnotation = annotationPrefix + "original-replicas"
+
+// ErrOwnerScaledDown is returned when an owner scaled down for a recovery
+// couldn't be scaled back up, it is restored on the next start of the tool
+// or by an operator
+var ErrOwnerScaledDown = errors.New("owner left scaled down")
+
+// ScaledDownHook runs while an owner is scaled down to zero, once its pods
+// are gone and before it is scaled back up, e.g. to repair the filesystem of
+// the volume the kubelet unstaged meanwhile. The owner is scaled back up even
+// when the hook fails.
+type ScaledDownHook func(ctx context.Context) error
+
+// scaleOwner scales the owner through its scale subresource. When scaling to
+// zero it applies the PDB policy to the disruption of all the replicas, the
+// pod's among them, waits for the replicas to go away, runs the hook if any
+// and then restores the original replica count, so the pods are recreated.
+// Only the replica count is patched so changes made to the owner by other
+// controllers are left alone.
+func (c *client) scaleOwner(ctx context.Context, pod *v1.Pod, owner *metav1.OwnerReference, count int32, hook ScaledDownHook) (retErr error) {
+	namespace := pod.Namespace
+	mapping, err := c.ownerMapping(owner)
+	if err != nil {
+		return err
+	}
+	resource := mapping.Resource
+	scales := c.scaleClient.Scales(namespace)
+
+	// Get the current scale of the owner
+	current, err := scales.Get(ctx, resource.GroupResource(), owner.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get scale of %s %s: %w", owner.Kind, owner.Name, err)
+	}
+
+	// Save the original replica count before scaling
+	originalReplicas := current.Spec.Replicas
+	if count != 0 {
+		originalReplicas = count
+	}
+
+	if count == 0 {
+		if err := c.allowScaleDown(ctx, pod, owner, current.Spec.Replicas); err != nil {
+			return err
+		}
+		hpa, err := c.findHPA(ctx, namespace, owner)
+		if err != nil {
+			return err
+		}
+		if hpa != nil && c.hpaPolicy == HPASkip {
+			return fmt.Errorf("%w: %s %s in namespace %s is managed by HPA %s", ErrRecoverySkipped, owner.Kind, owner.Name, namespace, hpa.Name)
+		}
+		// record the owner on the node before touching it so the next start
+		// restores it whatever its kind, see RestoreScaledOwners
+		scaled := scaledOwner{APIVersion: owner.APIVersion, Kind: owner.Kind, Namespace: namespace, Name: owner.Name}
+		if hpa != nil {
+			scaled.HPA = hpa.Name
+		}
+		if err := c.recordScaledOwner(ctx, scaled); err != nil {
+			return err
+		}
+		defer func() {
+			// kept on failure, the owner or its HPA may be left as
+			// scaled down or paused
+			if retErr == nil {
+				retErr = c.forgetScaledOwner(context.WithoutCancel(ctx), scaled)
+			}
+		}()
+		if hpa != nil {
+			if err := c.pauseHPA(ctx, namespace, hpa.Name); err != nil {
+				return fmt.Errorf("failed to pause HPA %s: %w", hpa.Name, err)
+			}
+			defer func() {
+				// resume even when the recovery is interrupted
+				if err := c.resumeHPA(context.WithoutCancel(ctx), namespace, hpa.Name); err != nil {
+					retErr = errors.Join(retErr, fmt.Errorf("failed to resume HPA %s: %w", hpa.Name, err))
+				}
+			}()
+		}
+
+		// persist the original count so a crash can't leave the owner
+		// scaled down, see RestoreScaledOwners
+		replicas := strconv.Itoa(int(originalReplicas))
+		if err := c.annotateOwner(ctx, namespace, owner, map[string]*string{OriginalReplicasAnnotation: &replicas}); err != nil {
+			return err
+		}
+		if err := c.patchReplicas(ctx, namespace, resource, owner.Name, 0); err != nil {
+			return fmt.Errorf("failed to scale down the %s %s: %w", owner.Kind, owner.Name, err)
+		}
+		waitErr := c.waitForReplicasToBeZero(ctx, namespace, resource.GroupResource(), owner.Name)
+		if waitErr != nil {
+			// If there was an error, revert the changes
+			if err := c.restoreReplicas(ctx, namespace, resource, owner, originalReplicas); err != nil {
+				return fmt.Errorf("failed to revert changes: %w", err)
+			}
+			return errors.Join(fmt.Errorf("failed to scale down the %s %s: %w", owner.Kind, owner.Name, waitErr),
+				c.annotateOwner(context.WithoutCancel(ctx), namespace, owner, map[string]*string{OriginalReplicasAnnotation: nil}))
+		}
+		if hook != nil {
+			if err := hook(ctx); err != nil {
+				retErr = fmt.Errorf("failed to run the scaled down hook of %s %s: %w", owner.Kind, owner.Name, err)
+			}
+		}
+	}
+	if err := c.restoreReplicas(ctx, namespace, resource, owner, originalReplicas); err != nil {
+		return errors.Join(retErr, fmt.Errorf("failed to revert back the replicas in %s %s: %w", owner.Kind, owner.Name, err))
+	}
+	if count == 0 {
+		return errors.Join(retErr, c.annotateOwner(context.WithoutCancel(ctx), namespace, owner, map[string]*string{OriginalReplicasAnnotation: nil}))
+	}
+	return retErr
+}
+
+// patchReplicas sets the replica count of the scale subresource
+func (c *client) patchReplicas(ctx context.Context, namespace string, resource schema.GroupVersionResource, name string, replicas int32) error {
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"replicas": replicas,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.scaleClient.Scales(namespace).Patch(ctx, resource, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	return err
+}
+
+// restoreReplicas scales the owner back to its original replica count,
+// retrying transient failures, even when the recovery is interrupted. The
+// error wraps ErrOwnerScaledDown when the owner is left scaled down.
+func (c *client) restoreReplicas(ctx context.Context, namespace string, resource schema.GroupVersionResource, owner *metav1.OwnerReference, replicas int32) error {
+	ctx = context.WithoutCancel(ctx)
+	err := retry.OnError(retry.DefaultBackoff, func(error) bool { return true }, func() error {
+		return c.patchReplicas(ctx, namespace, resource, owner.Name, replicas)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrOwnerScaledDown, err)
+	}
+	return nil
+}
+
+// defaultScaleTimeout is how long to wait for a scaled down owner by default
+const defaultScaleTimeout = 2 * time.Minute
+
+// waitForReplicasToBeZero waits until the pods of the owner are gone. The
+// pods are watched through the selector of the scale subresource, owners
+// which don't expose a selector are polled until their replicas are 0.
+func (c *client) waitForReplicasToBeZero(ctx context.Context, namespace string, resource schema.GroupResource, name string) error {
+	current, err := c.scaleClient.Scales(namespace).Get(ctx, resource, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if current.Status.Selector != "" {
+		return c.waitForPodsGone(ctx, namespace, current.Status.Selector, c.scaleTimeout)
+	}
+	return wait.PollUntilContextTimeout(ctx, 2*time.Second, c.scaleTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := c.scaleClient.Scales(namespace).Get(ctx, resource, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return current.Status.Replicas == 0, nil
+	})
+}
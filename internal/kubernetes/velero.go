@@ -0,0 +1,111 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Velero resources whose operations in progress defer the recoveries
+var (
+	VeleroBackupResource  = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "backups"}
+	VeleroRestoreResource = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "restores"}
+)
+
+// veleroDeferredReason is the reason of the events recorded on the pods left
+// alone while a backup or restore of their namespace is in progress
+const veleroDeferredReason = "RecoveryDeferredForBackup"
+
+// veleroDone are the terminal phases of the backups and restores, any other
+// phase, including an empty one of an object not yet picked up, is in
+// progress
+var veleroDone = map[string]bool{
+	"Completed":        true,
+	"PartiallyFailed":  true,
+	"Failed":           true,
+	"FailedValidation": true,
+	"Deleting":         true,
+}
+
+// veleroOperationInProgress returns the backup or restore in progress in the
+// Velero namespace which covers the namespace, empty when there is none or
+// Velero isn't installed. Restarting a pod in the middle of a backup of its
+// volumes may leave an inconsistent copy or fail the backup.
+func (c *client) veleroOperationInProgress(ctx context.Context, namespace string) (string, error) {
+	if c.veleroNamespace == "" {
+		return "", nil
+	}
+	for _, resource := range []schema.GroupVersionResource{VeleroBackupResource, VeleroRestoreResource} {
+		list, err := c.dynamicClient.Resource(resource).Namespace(c.veleroNamespace).List(ctx, metav1.ListOptions{})
+		if apierrors.IsNotFound(err) {
+			// the CRD isn't installed
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to list Velero %s in namespace %s: %w", resource.Resource, c.veleroNamespace, err)
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+			if veleroDone[phase] || !veleroCovers(obj, resource, namespace) {
+				continue
+			}
+			return fmt.Sprintf("%s %s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName()), nil
+		}
+	}
+	return "", nil
+}
+
+// veleroCovers reports whether the backup or restore includes the namespace.
+// No included namespaces means all of them. The namespaces of a restore are
+// those of the backup, mapped to the namespaces they are restored into.
+func veleroCovers(obj *unstructured.Unstructured, resource schema.GroupVersionResource, namespace string) bool {
+	included, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "includedNamespaces")
+	excluded, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "excludedNamespaces")
+	source := namespace
+	if resource == VeleroRestoreResource {
+		mapping, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "namespaceMapping")
+		for from, to := range mapping {
+			if to == namespace {
+				source = from
+			}
+		}
+	}
+	if slices.Contains(excluded, source) || slices.Contains(excluded, "*") {
+		return false
+	}
+	return len(included) == 0 || slices.Contains(included, source) || slices.Contains(included, "*")
+}
+
+// DeferForBackup returns a skipped error, recorded as an event on the pod, if
+// a backup or restore of its namespace is in progress. It is checked before
+// any action of the recovery of a volume of the pod, the whole recovery is
+// tried again by a later pass: remounting the volume under the backup breaks
+// it as much as restarting the pod.
+func (c *client) DeferForBackup(ctx context.Context, namespace, podName, podUID string) error {
+	operation, err := c.veleroOperationInProgress(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	if operation == "" {
+		return nil
+	}
+	err = fmt.Errorf("%w: %s of namespace %s is in progress", ErrRecoverySkipped, operation, namespace)
+	ref := v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  namespace,
+		Name:       podName,
+		UID:        types.UID(podUID),
+	}
+	// best effort, the error is recorded on the PVC anyway
+	_ = c.recordWarning(ctx, ref, veleroDeferredReason, fmt.Sprintf("not recovering the volumes of the pod: %v", err))
+	return err
+}
@@ -0,0 +1,201 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// VolumeAttachmentGone reports whether the VolumeAttachment with the UID is
+// deleted or being deleted, the external-attacher removes it once the volume
+// is detached. It reads the API server, the informer cache may not have seen a
+// deletion made a moment ago.
+func (c *client) VolumeAttachmentGone(ctx context.Context, name string, uid types.UID) (bool, error) {
+	va, err := c.StorageV1().VolumeAttachments().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get VolumeAttachment %s: %w", name, err)
+	}
+	// recreated under the same name by the attach/detach controller
+	return va.UID != uid || va.DeletionTimestamp != nil, nil
+}
+
+// ListVolumeAttachments returns the VolumeAttachments of the node from the
+// informer cache when available, otherwise from the API server, which can't
+// select them by node.
+func (c *client) ListVolumeAttachments(ctx context.Context) ([]storagev1.VolumeAttachment, error) {
+	if c.vaLister != nil {
+		cached, err := c.vaLister.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list VolumeAttachments: %w", err)
+		}
+		var attachments []storagev1.VolumeAttachment
+		for _, va := range cached {
+			if va.Spec.NodeName == c.nodeName {
+				attachments = append(attachments, *va)
+			}
+		}
+		return attachments, nil
+	}
+	items, err := listPages(ctx, c, metav1.ListOptions{}, func(ctx context.Context, opts metav1.ListOptions) ([]storagev1.VolumeAttachment, string, error) {
+		list, err := c.StorageV1().VolumeAttachments().List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		return list.Items, list.Continue, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VolumeAttachments: %w", err)
+	}
+	var attachments []storagev1.VolumeAttachment
+	for i := range items {
+		if items[i].Spec.NodeName == c.nodeName {
+			attachments = append(attachments, items[i])
+		}
+	}
+	return attachments, nil
+}
+
+// DeleteVolumeAttachment deletes the VolumeAttachment. If it is already being
+// deleted but held by the finalizer of the external-attacher, which failed to
+// detach the volume, the finalizer is removed so the deletion can complete,
+// but only once the volume is known to be detached: the node is gone, or the
+// attacher recorded the volume as detached without error. Removing it earlier
+// would let the volume be attached to another node while still attached to
+// this one. The finalizers of other controllers are left alone.
+func (c *client) DeleteVolumeAttachment(ctx context.Context, va *storagev1.VolumeAttachment) error {
+	if va.DeletionTimestamp == nil {
+		err := c.StorageV1().VolumeAttachments().Delete(ctx, va.Name, metav1.DeleteOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to delete VolumeAttachment %s: %w", va.Name, err)
+		}
+		return nil
+	}
+	finalizer := attacherFinalizer(va.Spec.Attacher)
+	if !slices.Contains(va.Finalizers, finalizer) {
+		return fmt.Errorf("VolumeAttachment %s is held by finalizers %v, not by the external-attacher", va.Name, va.Finalizers)
+	}
+	detached, err := c.attachmentDetached(ctx, va)
+	if err != nil {
+		return err
+	}
+	if !detached {
+		return fmt.Errorf("not removing the finalizer of VolumeAttachment %s, node %s still exists and the volume isn't reported detached",
+			va.Name, va.Spec.NodeName)
+	}
+	remaining := slices.DeleteFunc(slices.Clone(va.Finalizers), func(f string) bool { return f == finalizer })
+	// the resource version fails the patch when the attachment changed
+	// since it was found stuck
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"finalizers":      remaining,
+			"resourceVersion": va.ResourceVersion,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode finalizers of VolumeAttachment %s: %w", va.Name, err)
+	}
+	_, err = c.StorageV1().VolumeAttachments().Patch(ctx, va.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to remove finalizer %s of VolumeAttachment %s: %w", finalizer, va.Name, err)
+	}
+	return nil
+}
+
+// attachmentDetached reports whether the volume of the VolumeAttachment is
+// known to be detached from its node: the node object is gone, or the
+// attacher reports the volume detached with no detach error
+func (c *client) attachmentDetached(ctx context.Context, va *storagev1.VolumeAttachment) (bool, error) {
+	if !va.Status.Attached && va.Status.DetachError == nil {
+		return true, nil
+	}
+	_, err := c.CoreV1().Nodes().Get(ctx, va.Spec.NodeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get node %s of VolumeAttachment %s: %w", va.Spec.NodeName, va.Name, err)
+	}
+	return false, nil
+}
+
+// driverNameChars are the characters the external-attacher replaces in the
+// driver name of its finalizer
+var driverNameChars = regexp.MustCompile("[^a-zA-Z0-9-]")
+
+// attacherFinalizer returns the finalizer the external-attacher of the driver
+// puts on the VolumeAttachments it attached
+func attacherFinalizer(driver string) string {
+	name := driverNameChars.ReplaceAllString(driver, "-")
+	if name != "" && name[len(name)-1] == '-' {
+		// names must end with an alphanumeric character
+		name += "X"
+	}
+	return "external-attacher/" + name
+}
+
+// VolumeAttachmentStuck reports whether the VolumeAttachment is wedged in the
+// attach/detach flow, either failing to attach or detach, or still being
+// deleted after the grace period, and the reason why.
+func VolumeAttachmentStuck(va *storagev1.VolumeAttachment, grace time.Duration) (bool, string) {
+	if va.DeletionTimestamp != nil && time.Since(va.DeletionTimestamp.Time) > grace {
+		return true, fmt.Sprintf("deletion pending since %s", va.DeletionTimestamp.Time.Format(time.RFC3339))
+	}
+	if va.Status.DetachError != nil {
+		return true, "detach error: " + va.Status.DetachError.Message
+	}
+	if va.Status.AttachError != nil {
+		return true, "attach error: " + va.Status.AttachError.Message
+	}
+	return false, ""
+}
+
+// attachmentName returns the name the kubelet and the attach/detach
+// controller give the VolumeAttachment of the PV on the node
+func attachmentName(pvName, driver, nodeName string) string {
+	return fmt.Sprintf("csi-%x", sha256.Sum256([]byte(pvName+driver+nodeName)))
+}
+
+// PublishContext returns the attachment metadata of the PV's VolumeAttachment
+// on the node, which the driver expects in the NodeStageVolume and
+// NodePublishVolume calls, or nil when the volume isn't attachable. The
+// attachment ID recorded by the kubelet is used when known.
+func (c *client) PublishContext(ctx context.Context, driver, pvName, attachmentID string) (map[string]string, error) {
+	name := attachmentID
+	if name == "" {
+		name = attachmentName(pvName, driver, c.nodeName)
+	}
+	va, err := c.StorageV1().VolumeAttachments().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VolumeAttachment %s: %w", name, err)
+	}
+	return va.Status.AttachmentMetadata, nil
+}
+
+// PodInfoOnMount reports whether the CSIDriver object of the driver asks for
+// the pod information in the volume context of NodePublishVolume
+func (c *client) PodInfoOnMount(ctx context.Context, driver string) (bool, error) {
+	csiDriver, err := c.StorageV1().CSIDrivers().Get(ctx, driver, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get CSIDriver %s: %w", driver, err)
+	}
+	return csiDriver.Spec.PodInfoOnMount != nil && *csiDriver.Spec.PodInfoOnMount, nil
+}
@@ -0,0 +1,101 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// VolumeHealthResource is the VolumeHealth custom resource reporting the
+// health of a PVC as seen from the node, named after the PVC, see
+// deploy/crds/csi-recovery.io_volumehealths.yaml
+var VolumeHealthResource = schema.GroupVersionResource{Group: "csi-recovery.io", Version: "v1alpha1", Resource: "volumehealths"}
+
+// volumeHealthKind is the kind of the VolumeHealth objects
+const volumeHealthKind = "VolumeHealth"
+
+// Conditions of the VolumeHealth objects
+const (
+	// VolumeHealthy is true while the volume passes the health checks
+	VolumeHealthy = "Healthy"
+	// VolumeRecoveryInProgress is true while a recovery action runs
+	VolumeRecoveryInProgress = "RecoveryInProgress"
+	// VolumeRecoveryFailed is true when the last recovery action failed
+	VolumeRecoveryFailed = "RecoveryFailed"
+)
+
+// VolumeHealthStatus is the status of a VolumeHealth object
+type VolumeHealthStatus struct {
+	// Node is the node which last observed the volume, Driver its driver
+	Node   string `json:"node,omitempty"`
+	Driver string `json:"driver,omitempty"`
+	// LastObserved is when the volume was last checked or recovered
+	LastObserved metav1.Time `json:"lastObserved,omitempty"`
+	// LastAction is the last recovery action run on the volume
+	LastAction string             `json:"lastAction,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// UpdateVolumeHealth updates the status of the VolumeHealth object of the
+// PVC with the function, creating the object owned by the PVC if needed so
+// it goes away with it. The node and observation time are set.
+func (c *client) UpdateVolumeHealth(ctx context.Context, namespace, pvcName string, update func(*VolumeHealthStatus)) error {
+	resource := c.dynamicClient.Resource(VolumeHealthResource).Namespace(namespace)
+	obj, err := resource.Get(ctx, pvcName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		obj, err = c.createVolumeHealth(ctx, namespace, pvcName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get VolumeHealth %s in namespace %s: %w", pvcName, namespace, err)
+	}
+
+	var status VolumeHealthStatus
+	if raw, ok := obj.Object["status"].(map[string]any); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &status); err != nil {
+			return fmt.Errorf("failed to decode status of VolumeHealth %s in namespace %s: %w", pvcName, namespace, err)
+		}
+	}
+	update(&status)
+	status.Node = c.nodeName
+	status.LastObserved = metav1.Now()
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return fmt.Errorf("failed to encode status of VolumeHealth %s in namespace %s: %w", pvcName, namespace, err)
+	}
+	obj.Object["status"] = raw
+	if _, err := resource.UpdateStatus(ctx, obj, metav1.UpdateOptions{FieldManager: FieldManager}); err != nil {
+		return fmt.Errorf("failed to update status of VolumeHealth %s in namespace %s: %w", pvcName, namespace, err)
+	}
+	return nil
+}
+
+// createVolumeHealth creates the VolumeHealth object of the PVC, owned by it
+func (c *client) createVolumeHealth(ctx context.Context, namespace, pvcName string) (*unstructured.Unstructured, error) {
+	pvc, err := c.GetPVC(ctx, pvcName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(VolumeHealthResource.GroupVersion().String())
+	obj.SetKind(volumeHealthKind)
+	obj.SetNamespace(namespace)
+	obj.SetName(pvcName)
+	obj.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: "v1",
+		Kind:       "PersistentVolumeClaim",
+		Name:       pvc.Name,
+		UID:        pvc.UID,
+	}})
+	obj.Object["spec"] = map[string]any{"pvcName": pvcName}
+	created, err := c.dynamicClient.Resource(VolumeHealthResource).Namespace(namespace).Create(ctx, obj, metav1.CreateOptions{FieldManager: FieldManager})
+	if apierrors.IsAlreadyExists(err) {
+		// created meanwhile by the instance of another node
+		return c.dynamicClient.Resource(VolumeHealthResource).Namespace(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	}
+	return created, err
+}
@@ -0,0 +1,159 @@
+// Package metrics holds the counters and gauges of the recovery, served in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Label names shared by the metrics, so dashboards can break them down the
+// same way
+const (
+	LabelDriver    = "csi_driver"
+	LabelNamespace = "namespace"
+	LabelAction    = "recovery_action"
+	LabelResult    = "result"
+)
+
+// Registry holds the metrics and serves them
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*Vec
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Vec is a counter or a gauge with a value per combination of the values of
+// its labels
+type Vec struct {
+	registry *Registry
+	name     string
+	help     string
+	kind     string
+	labels   []string
+	values   map[string]*sample
+}
+
+// sample is the value of a metric for the values of its labels
+type sample struct {
+	labels []string
+	value  float64
+}
+
+// Counter registers a counter with the labels
+func (r *Registry) Counter(name, help string, labels ...string) *Vec {
+	return r.register(name, help, "counter", labels)
+}
+
+// Gauge registers a gauge with the labels
+func (r *Registry) Gauge(name, help string, labels ...string) *Vec {
+	return r.register(name, help, "gauge", labels)
+}
+
+func (r *Registry) register(name, help, kind string, labels []string) *Vec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v := &Vec{registry: r, name: name, help: help, kind: kind, labels: labels, values: map[string]*sample{}}
+	r.metrics = append(r.metrics, v)
+	return v
+}
+
+// Inc adds one to the metric of the label values
+func (v *Vec) Inc(labels ...string) {
+	v.Add(1, labels...)
+}
+
+// Add adds the value to the metric of the label values
+func (v *Vec) Add(value float64, labels ...string) {
+	v.registry.mu.Lock()
+	defer v.registry.mu.Unlock()
+	v.sample(labels).value += value
+}
+
+// Set sets the metric of the label values
+func (v *Vec) Set(value float64, labels ...string) {
+	v.registry.mu.Lock()
+	defer v.registry.mu.Unlock()
+	v.sample(labels).value = value
+}
+
+// Reset removes the values of all the label values, e.g. before setting the
+// gauges of what was observed last
+func (v *Vec) Reset() {
+	v.registry.mu.Lock()
+	defer v.registry.mu.Unlock()
+	clear(v.values)
+}
+
+// sample returns the sample of the label values, the caller holds the lock
+// of the registry
+func (v *Vec) sample(labels []string) *sample {
+	if len(labels) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", v.name, len(v.labels), len(labels)))
+	}
+	key := strings.Join(labels, "\xff")
+	s, ok := v.values[key]
+	if !ok {
+		s = &sample{labels: slices.Clone(labels)}
+		v.values[key] = s
+	}
+	return s
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var b strings.Builder
+	for _, v := range r.metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n", v.name, escape(v.help, false))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", v.name, v.kind)
+		keys := make([]string, 0, len(v.values))
+		for key := range v.values {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			s := v.values[key]
+			b.WriteString(v.name)
+			if len(v.labels) > 0 {
+				b.WriteByte('{')
+				for i, label := range v.labels {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", label, escape(s.labels[i], true))
+				}
+				b.WriteByte('}')
+			}
+			fmt.Fprintf(&b, " %s\n", strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+// escape escapes the help text or the label value
+func escape(s string, quoted bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quoted {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
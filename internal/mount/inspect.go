@@ -0,0 +1,104 @@
+package mount
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Problems found with the mount of a volume
+const (
+	// ProblemMissing means nothing is mounted on the mount point
+	ProblemMissing = "missing"
+	// ProblemDuplicated means several mounts are stacked on the mount point
+	ProblemDuplicated = "duplicated"
+	// ProblemErrored means the mount can't be accessed anymore, e.g. the
+	// connection of a network or FUSE filesystem was lost
+	ProblemErrored = "errored"
+)
+
+// Finding is a problem found with an expected mount point
+type Finding struct {
+	Path    string
+	Problem string
+	// Err is the error accessing errored mounts
+	Err error
+}
+
+// Inspector checks expected mount points against the mount table
+type Inspector struct {
+	mounts map[string]int
+}
+
+// NewInspector returns an inspector of the mount table
+func NewInspector(mounts []Mount) *Inspector {
+	counts := make(map[string]int, len(mounts))
+	for _, m := range mounts {
+		counts[filepath.Clean(m.MountPoint)]++
+	}
+	return &Inspector{
+		mounts: counts,
+	}
+}
+
+// Check returns the problem with the mount point, or nil if it is mounted
+// once and accessible.
+func (i *Inspector) Check(path string) *Finding {
+	path = filepath.Clean(path)
+	if _, err := os.Stat(path); IsStale(err) {
+		return &Finding{Path: path, Problem: ProblemErrored, Err: err}
+	}
+	switch count := i.Mounted(path); {
+	case count == 0:
+		return &Finding{Path: path, Problem: ProblemMissing}
+	case count > 1:
+		return &Finding{Path: path, Problem: ProblemDuplicated}
+	}
+	return nil
+}
+
+// IsStale reports whether the error means the mount is still in the mount
+// table but its filesystem can't be reached
+func IsStale(err error) bool {
+	return errors.Is(err, syscall.ENOTCONN) || errors.Is(err, syscall.ESTALE) ||
+		errors.Is(err, syscall.EIO) || errors.Is(err, syscall.EHOSTDOWN)
+}
+
+// Mounted reports how many mounts are stacked on the mount point
+func (i *Inspector) Mounted(path string) int {
+	return i.mounts[filepath.Clean(path)]
+}
+
+// MountedUnder reports whether anything is mounted on or below the directory
+func (i *Inspector) MountedUnder(dir string) bool {
+	for path := range i.mounts {
+		if under(path, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// MountPointsUnder returns the mount points on or below the directory, the
+// deepest first so they can be unmounted in order
+func MountPointsUnder(mounts []Mount, dir string) []string {
+	var paths []string
+	for _, m := range mounts {
+		if path := filepath.Clean(m.MountPoint); under(path, dir) {
+			paths = append(paths, path)
+		}
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		return len(paths[i]) > len(paths[j])
+	})
+	return paths
+}
+
+// under reports whether the path is the directory or below it
+func under(path, dir string) bool {
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
@@ -0,0 +1,118 @@
+// Package mount inspects the mount table of the node to find CSI volumes
+// whose mounts are missing, duplicated or no longer reachable.
+package mount
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultMountInfoPath is the mount table of the tool's mount namespace,
+// which sees the kubelet mounts through the bidirectionally propagated
+// kubelet directory
+const DefaultMountInfoPath = "/proc/self/mountinfo"
+
+// Mount is an entry of the mount table
+type Mount struct {
+	ID         int
+	ParentID   int
+	Root       string
+	MountPoint string
+	Options    string
+	FSType     string
+	Source     string
+	// SuperOptions are the options of the filesystem, shared by all its
+	// mounts
+	SuperOptions string
+	// Device is the major:minor number of the device of the filesystem,
+	// e.g. 98:0
+	Device string
+}
+
+// ReadMountInfo reads the mount table in the mountinfo format
+func ReadMountInfo(path string) ([]Mount, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mount table %s: %w", path, err)
+	}
+	defer f.Close()
+	return ParseMountInfo(f)
+}
+
+// ParseMountInfo parses the mountinfo format described in proc(5):
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func ParseMountInfo(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		// the optional fields end with a single hyphen
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 6 || sep < 0 || len(fields) < sep+3 {
+			return nil, fmt.Errorf("invalid mountinfo line %q", line)
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount id in line %q: %w", line, err)
+		}
+		parentID, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent mount id in line %q: %w", line, err)
+		}
+		var superOptions string
+		if len(fields) > sep+3 {
+			superOptions = fields[sep+3]
+		}
+		mounts = append(mounts, Mount{
+			ID:         id,
+			ParentID:   parentID,
+			Root:       unescape(fields[3]),
+			MountPoint: unescape(fields[4]),
+			Options:    fields[5],
+			FSType:     fields[sep+1],
+			Source:     unescape(fields[sep+2]),
+
+			SuperOptions: superOptions,
+			Device:       fields[2],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	return mounts, nil
+}
+
+// unescape decodes the octal escapes, e.g. \040 for a space, the kernel uses
+// for white space and backslashes in paths
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,55 @@
+package mount
+
+import (
+	"net"
+	"strings"
+)
+
+// Ports the NFS and SMB servers listen on unless the mount says otherwise
+const (
+	nfsPort = "2049"
+	smbPort = "445"
+)
+
+// IsNFS reports whether the mount is an NFS mount
+func IsNFS(m Mount) bool {
+	return m.FSType == "nfs" || m.FSType == "nfs4"
+}
+
+// IsSMB reports whether the mount is an SMB mount of the cifs kernel client
+func IsSMB(m Mount) bool {
+	return m.FSType == "cifs" || m.FSType == "smb3"
+}
+
+// ServerAddress returns the host:port of the server of the NFS or SMB mount.
+// The address the kernel resolved, the addr option of the filesystem, is
+// preferred to the host name of the source, e.g. server:/export or
+// //server/share.
+func ServerAddress(m Mount) (string, bool) {
+	var host, port string
+	switch {
+	case IsNFS(m):
+		port = nfsPort
+		if i := strings.LastIndex(m.Source, ":/"); i > 0 {
+			host = strings.Trim(m.Source[:i], "[]")
+		}
+	case IsSMB(m):
+		port = smbPort
+		host, _, _ = strings.Cut(strings.TrimPrefix(m.Source, "//"), "/")
+	default:
+		return "", false
+	}
+	for _, option := range strings.Split(m.SuperOptions, ",") {
+		key, value, _ := strings.Cut(option, "=")
+		switch {
+		case key == "addr" && value != "":
+			host = value
+		case key == "port" && value != "" && value != "0":
+			port = value
+		}
+	}
+	if host == "" {
+		return "", false
+	}
+	return net.JoinHostPort(host, port), true
+}
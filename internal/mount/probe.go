@@ -0,0 +1,51 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotResponding is returned when probing a mount doesn't return in time,
+// typically a hard NFS mount whose server can't be reached
+var ErrNotResponding = errors.New("mount is not responding")
+
+// Probe checks that the filesystem mounted on the path can still be reached.
+// It returns an error when the mount is stale, e.g. an NFS mount failing with
+// "Stale file handle", or doesn't answer within the timeout. A probe which
+// doesn't return keeps blocking in the background, the kernel doesn't allow
+// interrupting it.
+func Probe(path string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- statfs(path)
+	}()
+	select {
+	case err := <-done:
+		if IsStale(err) {
+			return fmt.Errorf("mount %s is stale: %w", path, err)
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("%w: %s after %s", ErrNotResponding, path, timeout)
+	}
+}
+
+// Query queries the filesystem mounted on the path like Probe, but returns
+// every error of the query, e.g. the permission errors of network filesystems
+// whose credentials were rejected, not only the stale mounts
+func Query(path string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- statfs(path)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to query mount %s: %w", path, err)
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("%w: %s after %s", ErrNotResponding, path, timeout)
+	}
+}
@@ -0,0 +1,27 @@
+package mount
+
+import (
+	"errors"
+	"syscall"
+)
+
+// IsDenied reports whether the error means the server of the network
+// filesystem rejected the credentials of the mount, e.g. an expired Kerberos
+// ticket or a changed SMB password
+func IsDenied(err error) bool {
+	return errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EKEYEXPIRED) ||
+		errors.Is(err, syscall.EKEYREJECTED)
+}
+
+// IsExpired reports whether the error means the Kerberos ticket of the mount
+// expired
+func IsExpired(err error) bool {
+	return errors.Is(err, syscall.EKEYEXPIRED)
+}
+
+// statfs queries the filesystem mounted on the path, which reaches the server
+// of network filesystems
+func statfs(path string) error {
+	var st syscall.Statfs_t
+	return syscall.Statfs(path, &st)
+}
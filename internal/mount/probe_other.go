@@ -0,0 +1,26 @@
+//go:build !linux
+
+package mount
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// IsDenied reports whether the error is a permission error, outside of Linux
+// there are no key errors
+func IsDenied(err error) bool {
+	return errors.Is(err, syscall.EACCES)
+}
+
+// IsExpired is always false outside of Linux
+func IsExpired(error) bool {
+	return false
+}
+
+// statfs falls back to a stat of the path outside of Linux
+func statfs(path string) error {
+	_, err := os.Stat(path)
+	return err
+}
@@ -0,0 +1,55 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultProcPath is where procfs is mounted, the processes of the pods are
+// only visible from the host PID namespace
+const DefaultProcPath = "/proc"
+
+// PodProcesses returns the PIDs of the processes in the cgroup of the pod with
+// the UID, e.g. /kubepods/burstable/pod<uid>/<container> with the cgroupfs
+// driver or kubepods-burstable-pod<uid>.slice with the systemd driver, which
+// writes the dashes of the UID as underscores. Processes exiting while they
+// are listed are left out.
+func PodProcesses(proc, podUID string) ([]int, error) {
+	entries, err := os.ReadDir(proc)
+	if err != nil {
+		return nil, err
+	}
+	patterns := []string{"pod" + podUID, "pod" + strings.ReplaceAll(podUID, "-", "_")}
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(proc, entry.Name(), "cgroup"))
+		if err != nil {
+			continue
+		}
+		for _, pattern := range patterns {
+			if strings.Contains(string(data), pattern) {
+				pids = append(pids, pid)
+				break
+			}
+		}
+	}
+	return pids, nil
+}
+
+// TopMount returns the mount on top of the mount point, the last one mounted
+// there
+func TopMount(mounts []Mount, path string) (Mount, bool) {
+	path = filepath.Clean(path)
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if filepath.Clean(mounts[i].MountPoint) == path {
+			return mounts[i], true
+		}
+	}
+	return Mount{}, false
+}
@@ -0,0 +1,87 @@
+package mount
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// kernelFSMessage matches the ext4 and xfs kernel messages naming the device,
+// e.g. "EXT4-fs (sdb): Remounting filesystem read-only" or
+// "XFS (dm-1): Filesystem has been shut down due to log error"
+var kernelFSMessage = regexp.MustCompile(`(?:EXT4-fs|XFS)(?: [a-z]+)? \((?:device )?([^)]+)\)[^:]*: (.*)`)
+
+// ReadOnlyMount is a read-write mount whose filesystem was switched to
+// read-only by the kernel
+type ReadOnlyMount struct {
+	Mount
+	// Message is the kernel message about the filesystem, if any was found
+	Message string
+}
+
+// RemountedReadOnly reports whether the kernel switched the filesystem of the
+// mount to read-only, e.g. ext4 mounted with errors=remount-ro after I/O
+// errors: the mount is still read-write but its superblock is read-only.
+// Mounts that were read-only from the start aren't reported.
+func RemountedReadOnly(m Mount) bool {
+	return hasOption(m.SuperOptions, "ro") && !hasOption(m.Options, "ro")
+}
+
+// hasOption reports whether the comma separated options contain the option
+func hasOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadOnlyMounts returns the mounts remounted read-only by the kernel keyed by
+// their mount point. The kernel messages, keyed by device name, explain why
+// and are attached to the mounts of the device.
+func ReadOnlyMounts(mounts []Mount, messages map[string]string) map[string]ReadOnlyMount {
+	readOnly := map[string]ReadOnlyMount{}
+	for _, m := range mounts {
+		if !RemountedReadOnly(m) {
+			continue
+		}
+		readOnly[filepath.Clean(m.MountPoint)] = ReadOnlyMount{
+			Mount:   m,
+			Message: messages[filepath.Base(m.Source)],
+		}
+	}
+	return readOnly
+}
+
+// ReadKernelLog reads the ext4 and xfs messages of a kernel log file, e.g.
+// /var/log/kern.log, about filesystems remounted read-only or shut down. The
+// last message of each device is returned, keyed by the device name.
+func ReadKernelLog(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open kernel log %s: %w", path, err)
+	}
+	defer f.Close()
+
+	messages := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		match := kernelFSMessage.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		message := strings.ToLower(match[2])
+		if strings.Contains(message, "read-only") || strings.Contains(message, "shut down") ||
+			strings.Contains(message, "shutting down") {
+			messages[match[1]] = match[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read kernel log %s: %w", path, err)
+	}
+	return messages, nil
+}
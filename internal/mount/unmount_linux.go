@@ -0,0 +1,15 @@
+package mount
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Unmount lazily unmounts the mount point, so unreachable filesystems which
+// would block a regular unmount are detached as well
+func Unmount(path string) error {
+	if err := syscall.Unmount(path, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to unmount %s: %w", path, err)
+	}
+	return nil
+}
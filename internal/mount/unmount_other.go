@@ -0,0 +1,13 @@
+//go:build !linux
+
+package mount
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Unmount isn't supported outside of Linux
+func Unmount(path string) error {
+	return fmt.Errorf("failed to unmount %s: unmounting is not supported on %s", path, runtime.GOOS)
+}
@@ -0,0 +1,232 @@
+// Package notify sends notifications of the recovery events, the detection of
+// abnormal volumes and the outcome of the recovery actions, to webhooks and
+// Slack channels.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Events notified
+const (
+	// EventDetected is sent when a volume is found abnormal
+	EventDetected = "detected"
+	// EventRecovered is sent when an action recovered a volume
+	EventRecovered = "recovered"
+	// EventFailed is sent when an action failed to recover a volume
+	EventFailed = "failed"
+)
+
+// DefaultEvents are the events notified by default
+var DefaultEvents = []string{EventDetected, EventRecovered, EventFailed}
+
+// DefaultTemplate is the default template of the notification messages
+const DefaultTemplate = `{{if eq .Event "detected"}}Volume {{.Volume}} is abnormal ({{.Severity}}): {{.Reason}}` +
+	`{{else if eq .Event "recovered"}}Volume {{.Volume}} recovered with {{.Action}}` +
+	`{{else}}Recovery of volume {{.Volume}} with {{.Action}} failed: {{.Error}}{{end}}` +
+	` [node {{.Node}}, pod {{.Namespace}}/{{.Pod}}{{if .PVC}}, PVC {{.PVC}}{{end}}, driver {{.Driver}}]`
+
+// queueSize bounds the notifications waiting to be sent, the others are
+// dropped
+const queueSize = 100
+
+// Notification describes a recovery event
+type Notification struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Node      string    `json:"node"`
+	Driver    string    `json:"driver"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	PVC       string    `json:"pvc,omitempty"`
+	Volume    string    `json:"volume"`
+	// Severity and Reason are set for detections
+	Severity string `json:"severity,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// Action is set for the outcome of actions, Error for failures
+	Action string `json:"action,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Message is the notification rendered with the template
+	Message string `json:"message"`
+}
+
+// Sink delivers the notifications
+type Sink interface {
+	Send(ctx context.Context, n Notification) error
+}
+
+// Notifier renders the notifications of the selected events and sends them to
+// the sinks in the background, so the recoveries never wait for them
+type Notifier struct {
+	logger   *slog.Logger
+	sinks    []Sink
+	events   map[string]bool
+	template *template.Template
+	timeout  time.Duration
+	queue    chan Notification
+	done     chan struct{}
+}
+
+// NewNotifier returns a notifier sending the events to the sinks, each send
+// bounded by the timeout, with messages rendered by the text/template
+func NewNotifier(logger *slog.Logger, sinks []Sink, events []string, tmpl string, timeout time.Duration) (*Notifier, error) {
+	t, err := template.New("notification").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse notification template: %w", err)
+	}
+	selected := map[string]bool{}
+	for _, event := range events {
+		switch event {
+		case EventDetected, EventRecovered, EventFailed:
+			selected[event] = true
+		default:
+			return nil, fmt.Errorf("unknown notification event %q, must be one of %s", event, strings.Join(DefaultEvents, ","))
+		}
+	}
+	return &Notifier{
+		logger:   logger,
+		sinks:    sinks,
+		events:   selected,
+		template: t,
+		timeout:  timeout,
+		queue:    make(chan Notification, queueSize),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Start sends the queued notifications until the notifier is closed
+func (n *Notifier) Start() {
+	go func() {
+		defer close(n.done)
+		for notification := range n.queue {
+			n.send(notification)
+		}
+	}()
+}
+
+// Close sends the notifications still queued and stops the notifier
+func (n *Notifier) Close() {
+	close(n.queue)
+	<-n.done
+}
+
+// Notify queues the notification if its event is selected, it is dropped
+// when too many are waiting
+func (n *Notifier) Notify(notification Notification) {
+	if !n.events[notification.Event] {
+		return
+	}
+	if notification.Time.IsZero() {
+		notification.Time = time.Now().UTC()
+	}
+	var message bytes.Buffer
+	if err := n.template.Execute(&message, notification); err != nil {
+		n.logger.Error("failed to render notification", "event", notification.Event, "volume", notification.Volume, "error", err)
+		return
+	}
+	notification.Message = message.String()
+	select {
+	case n.queue <- notification:
+	default:
+		n.logger.Warn("too many notifications waiting, dropping one", "event", notification.Event, "volume", notification.Volume)
+	}
+}
+
+// send delivers the notification to every sink
+func (n *Notifier) send(notification Notification) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	for _, sink := range n.sinks {
+		if err := sink.Send(ctx, notification); err != nil {
+			n.logger.Error("failed to send notification", "event", notification.Event, "volume", notification.Volume, "error", err)
+		}
+	}
+}
+
+// Webhook returns a sink posting the notifications as JSON to the URL
+func Webhook(url string) Sink {
+	return &webhook{url: url, client: &http.Client{}}
+}
+
+// WebhookFile returns a sink posting the notifications as JSON to the URL
+// read from the file, read again when the file changes
+func WebhookFile(path string) Sink {
+	return &webhook{file: &secretFile{path: path}, client: &http.Client{}}
+}
+
+// Slack returns a sink posting the messages of the notifications to a Slack
+// incoming webhook URL
+func Slack(url string) Sink {
+	return &slack{webhook: webhook{url: url, client: &http.Client{}}}
+}
+
+// SlackFile returns a sink posting the messages of the notifications to the
+// Slack incoming webhook URL read from the file, read again when the file
+// changes
+func SlackFile(path string) Sink {
+	return &slack{webhook: webhook{file: &secretFile{path: path}, client: &http.Client{}}}
+}
+
+// webhook posts the notification as JSON, any status but 2xx fails. The URL
+// is read from the file when it is set
+type webhook struct {
+	url    string
+	file   *secretFile
+	client *http.Client
+}
+
+// target returns the URL the notifications are posted to
+func (w *webhook) target() (string, error) {
+	if w.file == nil {
+		return w.url, nil
+	}
+	return w.file.read()
+}
+
+func (w *webhook) Send(ctx context.Context, n Notification) error {
+	return w.post(ctx, n)
+}
+
+// post posts the body as JSON to the URL
+func (w *webhook) post(ctx context.Context, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	url, err := w.target()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to build notification request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("failed to post notification: " + resp.Status)
+	}
+	return nil
+}
+
+// slack posts the message of the notification to a Slack incoming webhook
+type slack struct {
+	webhook
+}
+
+func (s *slack) Send(ctx context.Context, n Notification) error {
+	return s.post(ctx, map[string]string{"text": n.Message})
+}
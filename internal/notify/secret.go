@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretFile reads a secret from a file, such as a key of a Secret mounted in
+// the pod, and reads it again when the file changes so that rotated secrets
+// are picked up without a restart
+type secretFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+// read returns the trimmed content of the file, the previous content while the
+// file is unchanged
+func (s *secretFile) read() (string, error) {
+	// os.Stat follows the symlinks of the projected volumes, which are swapped
+	// when the Secret is updated
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat secret file %s: %w", s.path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.value != "" && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.value, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file %s: %w", s.path, err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", errors.New("secret file " + s.path + " is empty")
+	}
+	s.modTime, s.size, s.value = info.ModTime(), info.Size(), value
+	return value, nil
+}
@@ -0,0 +1,161 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+)
+
+// Names of the built-in actions
+const (
+	// ActionDetach deletes the stuck VolumeAttachments of the volume
+	ActionDetach = "cleanup-volume-attachment"
+	// ActionRestart deletes the pod so it is recreated with fresh mounts
+	ActionRestart = "restart-pod"
+	// ActionScale scales the owner of the pod down and back up so the
+	// volume is unstaged in between
+	ActionScale = "scale-owner"
+)
+
+// ErrNotRecovered is returned when the verification of an action fails
+var ErrNotRecovered = errors.New("recovery did not take effect")
+
+// DefaultActions are the built-in actions, from the least to the most
+// disruptive
+var DefaultActions = []string{ActionRemount, ActionRestage, ActionRestart, ActionScale, ActionDetach}
+
+// NewDefaultRegistry returns the registry of the built-in actions, an
+// escalation ladder: the volume is published again, then staged again, then
+// the pod is restarted, or its owner scaled when the driver stages volumes,
+// and the stuck attachments are cleaned up last. The calls to the driver are
+// bounded by the timeout.
+func NewDefaultRegistry(logger *slog.Logger, client kubernetes.Client, driverTimeout time.Duration) *Registry {
+	return NewRegistry(
+		&remount{logger: logger, timeout: driverTimeout},
+		&restage{logger: logger, timeout: driverTimeout},
+		&restart{client: client},
+		&scale{client: client},
+		&detach{logger: logger, client: client},
+	)
+}
+
+// podRestarted reports an error if the pod with the UID is still running and
+// not being deleted, and the rollout restart of its DaemonSet wasn't observed
+func podRestarted(ctx context.Context, client kubernetes.Client, t *Target) error {
+	restarted, err := client.PodRestarted(ctx, t.Namespace, t.PodName, t.PodUID)
+	if err != nil {
+		return err
+	}
+	if !restarted {
+		return fmt.Errorf("%w: pod %s/%s is still running", ErrNotRecovered, t.Namespace, t.PodName)
+	}
+	return nil
+}
+
+// restart recovers volumes without a staging mount, and inline volumes, by
+// recreating the pod
+type restart struct {
+	client kubernetes.Client
+}
+
+func (*restart) Name() string {
+	return ActionRestart
+}
+
+func (*restart) CanHandle(t *Target) bool {
+	return (!t.StageUnstage || t.Inline) && !t.Mirror
+}
+
+func (a *restart) Execute(ctx context.Context, t *Target) error {
+	return a.client.RestartPod(ctx, t.Namespace, t.PodName)
+}
+
+func (a *restart) Verify(ctx context.Context, t *Target) error {
+	return podRestarted(ctx, a.client, t)
+}
+
+func (*restart) Scope(t *Target) string {
+	return PodScope(t.Namespace, t.PodUID)
+}
+
+// scale recovers staged volumes by scaling the owner of the pod to zero, so
+// the kubelet unstages the volume, and back up
+type scale struct {
+	client kubernetes.Client
+}
+
+func (*scale) Name() string {
+	return ActionScale
+}
+
+func (*scale) CanHandle(t *Target) bool {
+	return t.StageUnstage && !t.Inline && !t.Mirror
+}
+
+func (a *scale) Execute(ctx context.Context, t *Target) error {
+	err := a.client.ScaleOwner(ctx, t.Namespace, t.PodName, 0, t.Hook)
+	if errors.Is(err, kubernetes.ErrOwnerScaledDown) {
+		return fmt.Errorf("%w: %w", ErrNeedsIntervention, err)
+	}
+	return err
+}
+
+func (a *scale) Verify(ctx context.Context, t *Target) error {
+	return podRestarted(ctx, a.client, t)
+}
+
+// Scope is the owner when it is scaled, the pods of Jobs and of the owners
+// recovered by deleting the pod are recovered alone
+func (*scale) Scope(t *Target) string {
+	if t.Owner == "" {
+		return PodScope(t.Namespace, t.PodUID)
+	}
+	return OwnerScope(t.Namespace, t.Owner)
+}
+
+// detach deletes the VolumeAttachments of the volume stuck in the
+// attach/detach controller, so the volume can be attached again
+type detach struct {
+	logger *slog.Logger
+	client kubernetes.Client
+}
+
+func (*detach) Name() string {
+	return ActionDetach
+}
+
+func (*detach) CanHandle(t *Target) bool {
+	return !t.Inline && !t.Mirror && len(t.Attachments) > 0
+}
+
+func (a *detach) Execute(ctx context.Context, t *Target) error {
+	var errs []error
+	for i := range t.Attachments {
+		va := &t.Attachments[i]
+		a.logger.Info("cleaning up stuck volume attachment", "volumeAttachment", va.Name)
+		if err := a.client.DeleteVolumeAttachment(ctx, va); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Verify checks that the stuck attachments are gone or being deleted, the
+// attach/detach controller may have recreated them under the same name
+func (a *detach) Verify(ctx context.Context, t *Target) error {
+	for i := range t.Attachments {
+		va := &t.Attachments[i]
+		gone, err := a.client.VolumeAttachmentGone(ctx, va.Name, va.UID)
+		if err != nil {
+			return err
+		}
+		if !gone {
+			return fmt.Errorf("%w: volume attachment %s still exists", ErrNotRecovered, va.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/csi"
+	"github.com/Madhu-1/csi-volume-recovery/internal/device"
+	"github.com/Madhu-1/csi-volume-recovery/internal/mount"
+	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Names of the actions calling the driver
+const (
+	// ActionRemount unpublishes and publishes the volume to the pod again
+	ActionRemount = "remount"
+	// ActionRestage unpublishes and unstages the volume, then stages and
+	// publishes it again
+	ActionRestage = "restage"
+)
+
+// Keys of the pod information passed to the drivers asking for it in their
+// CSIDriver object, as the kubelet does
+const (
+	podNameKey        = "csi.storage.k8s.io/pod.name"
+	podNamespaceKey   = "csi.storage.k8s.io/pod.namespace"
+	podUIDKey         = "csi.storage.k8s.io/pod.uid"
+	serviceAccountKey = "csi.storage.k8s.io/serviceAccount.name"
+	ephemeralKey      = "csi.storage.k8s.io/ephemeral"
+)
+
+// verifyProbeTimeout bounds the probe of a mount made by an action
+const verifyProbeTimeout = 10 * time.Second
+
+// accessModes maps the access modes of a PV to the CSI access modes the
+// kubelet uses for drivers without the single node multi writer capability
+var accessModes = map[v1.PersistentVolumeAccessMode]csipbv1.VolumeCapability_AccessMode_Mode{
+	v1.ReadWriteOnce:    csipbv1.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+	v1.ReadWriteOncePod: csipbv1.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+	v1.ReadOnlyMany:     csipbv1.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+	v1.ReadWriteMany:    csipbv1.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+}
+
+// volumeCapability returns the capability the volume was staged and published
+// with
+func volumeCapability(pv *v1.PersistentVolume, block bool) *csipbv1.VolumeCapability {
+	capability := &csipbv1.VolumeCapability{
+		AccessMode: &csipbv1.VolumeCapability_AccessMode{},
+	}
+	if len(pv.Spec.AccessModes) > 0 {
+		capability.AccessMode.Mode = accessModes[pv.Spec.AccessModes[0]]
+	}
+	if block {
+		capability.AccessType = &csipbv1.VolumeCapability_Block{Block: &csipbv1.VolumeCapability_BlockVolume{}}
+		return capability
+	}
+	capability.AccessType = &csipbv1.VolumeCapability_Mount{Mount: &csipbv1.VolumeCapability_MountVolume{
+		FsType:     pv.Spec.CSI.FSType,
+		MountFlags: pv.Spec.MountOptions,
+	}}
+	return capability
+}
+
+// stageRequest returns the NodeStageVolume request of the target
+func stageRequest(t *Target) csi.StageRequest {
+	return csi.StageRequest{
+		VolumeID:          t.PV.Spec.CSI.VolumeHandle,
+		StagingTargetPath: t.Info.StagingPath,
+		VolumeCapability:  volumeCapability(t.PV, t.Info.Block),
+		PublishContext:    t.PublishContext,
+		VolumeContext:     t.PV.Spec.CSI.VolumeAttributes,
+		Secrets:           t.StageSecrets,
+	}
+}
+
+// publishRequest returns the NodePublishVolume request of the volume of the
+// target to the pod
+func publishRequest(t *Target, p Publication) csi.PublishRequest {
+	volumeContext := t.PV.Spec.CSI.VolumeAttributes
+	if t.PodInfoOnMount {
+		volumeContext = make(map[string]string, len(t.PV.Spec.CSI.VolumeAttributes)+5)
+		for k, v := range t.PV.Spec.CSI.VolumeAttributes {
+			volumeContext[k] = v
+		}
+		volumeContext[podNameKey] = p.PodName
+		volumeContext[podNamespaceKey] = p.Namespace
+		volumeContext[podUIDKey] = p.PodUID
+		volumeContext[serviceAccountKey] = p.ServiceAccount
+		volumeContext[ephemeralKey] = "false"
+	}
+	return csi.PublishRequest{
+		VolumeID:          t.PV.Spec.CSI.VolumeHandle,
+		StagingTargetPath: t.Info.StagingPath,
+		TargetPath:        p.MountPath,
+		VolumeCapability:  volumeCapability(t.PV, t.Info.Block),
+		Readonly:          p.ReadOnly || t.PV.Spec.CSI.ReadOnly,
+		PublishContext:    t.PublishContext,
+		VolumeContext:     volumeContext,
+		Secrets:           t.PublishSecrets,
+	}
+}
+
+// canCallDriver reports whether the target has what the driver needs to
+// publish the volume again. Inline volumes are left out, their volume
+// context is in the pod.
+func canCallDriver(t *Target) bool {
+	return !t.Inline && t.Info != nil && t.Info.MountPath != "" && t.Client != nil &&
+		t.PV != nil && t.PV.Spec.CSI != nil
+}
+
+// verifyMounted checks that the path is mounted and responds, block volumes
+// are only checked for their device file
+func verifyMounted(path string, block bool) error {
+	if block {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%w: %w", ErrNotRecovered, err)
+		}
+		return nil
+	}
+	mounts, err := mount.ReadMountInfo(mount.DefaultMountInfoPath)
+	if err != nil {
+		return err
+	}
+	if mount.NewInspector(mounts).Mounted(path) == 0 {
+		return fmt.Errorf("%w: %s is not mounted", ErrNotRecovered, path)
+	}
+	if err := mount.Probe(path, verifyProbeTimeout); err != nil {
+		return fmt.Errorf("%w: %w", ErrNotRecovered, err)
+	}
+	return nil
+}
+
+// reachesContainers reports whether the running containers of the pods see
+// the volume once published again behind them. Block volumes are device files
+// the containers keep pointing at as long as the device number holds, which
+// the verification checks.
+func reachesContainers(block bool, publications []Publication) bool {
+	if block {
+		return true
+	}
+	for _, p := range publications {
+		if !p.Propagated {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyInPod checks that the running containers of the pod see the volume
+// published on the host: the mount at each container path must be on the
+// device of the host mount, and respond. The containers are found through the
+// cgroups of their processes, which needs the host PID namespace; the volume
+// isn't verified when no container could be checked, a stronger action
+// restarts the pod then.
+func verifyInPod(p Publication, block bool) error {
+	if len(p.ContainerPaths) == 0 {
+		return nil
+	}
+	var hostDevice string
+	if block {
+		number, err := device.Number(p.MountPath)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrNotRecovered, err)
+		}
+		hostDevice = number
+	} else {
+		mounts, err := mount.ReadMountInfo(mount.DefaultMountInfoPath)
+		if err != nil {
+			return err
+		}
+		m, ok := mount.TopMount(mounts, p.MountPath)
+		if !ok {
+			return fmt.Errorf("%w: %s is not mounted", ErrNotRecovered, p.MountPath)
+		}
+		hostDevice = m.Device
+	}
+	pids, err := mount.PodProcesses(mount.DefaultProcPath, p.PodUID)
+	if err != nil {
+		return fmt.Errorf("failed to find the processes of pod %s/%s: %w", p.Namespace, p.PodName, err)
+	}
+	checked := 0
+	for _, pid := range pids {
+		dir := filepath.Join(mount.DefaultProcPath, strconv.Itoa(pid))
+		var mounts []mount.Mount
+		if !block {
+			if mounts, err = mount.ReadMountInfo(filepath.Join(dir, "mountinfo")); err != nil {
+				// the process exited
+				continue
+			}
+		}
+		for _, path := range p.ContainerPaths {
+			inPod := filepath.Join(dir, "root", path)
+			var seen string
+			if block {
+				number, err := device.Number(inPod)
+				if err != nil {
+					// not a process of a container using the device
+					continue
+				}
+				seen = number
+			} else {
+				m, ok := mount.TopMount(mounts, path)
+				if !ok {
+					continue
+				}
+				seen = m.Device
+				if seen == hostDevice {
+					if err := mount.Probe(inPod, verifyProbeTimeout); err != nil {
+						return fmt.Errorf("%w: %w", ErrNotRecovered, err)
+					}
+				}
+			}
+			checked++
+			if seen != hostDevice {
+				return fmt.Errorf("%w: a container of pod %s/%s still sees device %s on %s instead of %s", ErrNotRecovered,
+					p.Namespace, p.PodName, seen, path, hostDevice)
+			}
+		}
+	}
+	if checked == 0 {
+		return fmt.Errorf("%w: no container of pod %s/%s could be checked", ErrNotRecovered, p.Namespace, p.PodName)
+	}
+	return nil
+}
+
+// remount publishes the volume to the pod again, replacing a dead or
+// dangling target mount without touching the staging mount or the pod. It
+// only handles the filesystem volumes the running containers mount with
+// HostToContainer or Bidirectional propagation, the others would keep their
+// previous mount, and checks the containers see the new one.
+type remount struct {
+	logger  *slog.Logger
+	timeout time.Duration
+}
+
+func (*remount) Name() string {
+	return ActionRemount
+}
+
+func (*remount) CanHandle(t *Target) bool {
+	return canCallDriver(t) && reachesContainers(t.Info.Block, []Publication{t.publication()})
+}
+
+func (a *remount) Execute(ctx context.Context, t *Target) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	if err := t.Client.NodeUnpublishVolume(ctx, a.logger, t.PV.Spec.CSI.VolumeHandle, t.Info.MountPath); err != nil {
+		return fmt.Errorf("failed to unpublish volume: %w", err)
+	}
+	if err := t.Client.NodePublishVolume(ctx, a.logger, publishRequest(t, t.publication())); err != nil {
+		// publish the previous mount back, which may have failed for
+		// lack of time
+		rollbackCtx, rollbackCancel := rollbackContext(ctx, a.timeout)
+		defer rollbackCancel()
+		return republish(rollbackCtx, a.logger, t, []Publication{t.publication()}, fmt.Errorf("failed to publish volume: %w", err))
+	}
+	return nil
+}
+
+func (*remount) Verify(_ context.Context, t *Target) error {
+	if err := verifyMounted(t.Info.MountPath, t.Info.Block); err != nil {
+		return err
+	}
+	return verifyInPod(t.publication(), t.Info.Block)
+}
+
+// restage stages the volume again, replacing a dead staging mount, and
+// publishes it to the pod. A volume shared by other pods on the node, e.g. a
+// ReadWriteMany volume, is unpublished from all of them before it is
+// unstaged and published back to all of them, so no pod is left with a
+// broken mount; it is left out when some of the pods couldn't be found.
+// When the restage fails before the volume is unstaged, it is published back
+// to the pods. Like remount, it only handles volumes the running containers
+// of all the pods see published again.
+type restage struct {
+	logger  *slog.Logger
+	timeout time.Duration
+}
+
+func (*restage) Name() string {
+	return ActionRestage
+}
+
+func (*restage) CanHandle(t *Target) bool {
+	return canCallDriver(t) && t.StageUnstage && !t.Mirror && t.Info.StagingPath != "" && t.SharedWith == len(t.Shared) &&
+		reachesContainers(t.Info.Block, t.publications())
+}
+
+func (a *restage) Execute(ctx context.Context, t *Target) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	volumeID := t.PV.Spec.CSI.VolumeHandle
+	publications := t.publications()
+	// while the volume is still staged, the pods it was unpublished from
+	// get it back when the restage fails
+	rollbackCtx, rollbackCancel := rollbackContext(ctx, a.timeout)
+	defer rollbackCancel()
+	for i, p := range publications {
+		if err := t.Client.NodeUnpublishVolume(ctx, a.logger, volumeID, p.MountPath); err != nil {
+			return republish(rollbackCtx, a.logger, t, publications[:i],
+				fmt.Errorf("failed to unpublish volume from pod %s/%s: %w", p.Namespace, p.PodName, err))
+		}
+	}
+	if err := t.Client.NodeUnstageVolume(ctx, a.logger, volumeID, t.Info.StagingPath); err != nil {
+		return republish(rollbackCtx, a.logger, t, publications, fmt.Errorf("failed to unstage volume: %w", err))
+	}
+	// past this point the volume is unstaged, there is nothing left to
+	// roll back to
+	if err := t.Client.NodeStageVolume(ctx, a.logger, stageRequest(t)); err != nil {
+		return fmt.Errorf("%w: failed to stage volume: %w", ErrNeedsIntervention, err)
+	}
+	// publish to every pod even when one fails, the others get their
+	// volume back
+	var errs []error
+	for _, p := range publications {
+		if err := t.Client.NodePublishVolume(ctx, a.logger, publishRequest(t, p)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to publish volume to pod %s/%s: %w", p.Namespace, p.PodName, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w: %w", ErrNeedsIntervention, err)
+	}
+	return nil
+}
+
+func (*restage) Verify(_ context.Context, t *Target) error {
+	// block volumes are staged on a directory the driver may leave empty
+	if !t.Info.Block {
+		if err := verifyMounted(t.Info.StagingPath, false); err != nil {
+			return err
+		}
+	}
+	for _, p := range t.publications() {
+		if err := verifyMounted(p.MountPath, t.Info.Block); err != nil {
+			return err
+		}
+		if err := verifyInPod(p, t.Info.Block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scope is the volume, it is recovered for all the pods sharing it
+func (*restage) Scope(t *Target) string {
+	return VolumeScope(t.Info.PersistentVolumeName)
+}
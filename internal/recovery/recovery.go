@@ -0,0 +1,212 @@
+// Package recovery holds the actions recovering a CSI volume used by a pod,
+// e.g. restarting the pod or scaling its owner down so the volume is
+// unstaged. Each action is a strategy registered in a Registry, new ways of
+// recovering volumes are added by implementing Action.
+package recovery
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/csi"
+	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// Target is the abnormal volume to recover and the pod using it
+type Target struct {
+	Driver    string
+	Namespace string
+	PodName   string
+	PodUID    string
+	// ServiceAccount is the service account of the pod
+	ServiceAccount string
+	// PVCName is empty for inline ephemeral volumes
+	PVCName string
+	// Inline is set for inline ephemeral volumes, which live and die with
+	// their pod
+	Inline bool
+	// StageUnstage is set when the driver stages the volume on the node
+	StageUnstage bool
+	// Info is the volume located on the node, nil for pods stuck before
+	// their volumes were mounted
+	Info *volume.VolumeInfo
+	// Attachments are the VolumeAttachments of the volume stuck in the
+	// attach/detach controller
+	Attachments []storagev1.VolumeAttachment
+	// Hook runs while the owner of the pod is scaled down, may be nil
+	Hook kubernetes.ScaledDownHook
+	// Owner is the owner of the pod recovered as a whole when scaling it,
+	// empty when only the pod is
+	Owner string
+	// Mirror is set for the mirror pods of static pods, which the kubelet
+	// runs from manifests: deleting or scaling them doesn't restart them,
+	// only a remount may recover their volumes
+	Mirror bool
+
+	// The fields below are needed to stage and publish the volume again,
+	// Client and PV are nil when they couldn't be found
+	Client csi.Client
+	PV     *v1.PersistentVolume
+	// PublishContext is the attachment metadata of attachable volumes
+	PublishContext map[string]string
+	// PodInfoOnMount is set when the driver wants the pod information in
+	// the volume context
+	PodInfoOnMount bool
+	// StageSecrets and PublishSecrets are the data of the node stage and
+	// node publish secrets the PV references, or of the secret of the
+	// driver with --driver-secrets, nil when there is none
+	StageSecrets   map[string]string
+	PublishSecrets map[string]string
+	// ReadOnly is set when the pod mounts the volume read-only
+	ReadOnly bool
+	// ContainerPaths and Propagated describe how the containers of the pod
+	// mount the volume, see Publication
+	ContainerPaths []string
+	Propagated     bool
+	// SharedWith is the number of other pods on the node the volume is
+	// published to
+	SharedWith int
+	// Shared are the publications of the volume to the other pods, set
+	// only when all of them were found on the node
+	Shared []Publication
+}
+
+// Publication is a volume published to a pod
+type Publication struct {
+	Namespace      string
+	PodName        string
+	PodUID         string
+	ServiceAccount string
+	ReadOnly       bool
+	// MountPath is the target path of the volume in the pod
+	MountPath string
+	// ContainerPaths are where the running containers of the pod mount the
+	// volume, or the paths of its device in them for block volumes
+	ContainerPaths []string
+	// Propagated is set when the running containers see the volume
+	// published again behind them, i.e. mount it with HostToContainer or
+	// Bidirectional propagation. Containers mounting it with the default
+	// propagation keep their previous mount until restarted.
+	Propagated bool
+}
+
+// publication returns the publication of the volume to the pod of the target
+func (t *Target) publication() Publication {
+	return Publication{
+		Namespace:      t.Namespace,
+		PodName:        t.PodName,
+		PodUID:         t.PodUID,
+		ServiceAccount: t.ServiceAccount,
+		ReadOnly:       t.ReadOnly,
+		MountPath:      t.Info.MountPath,
+		ContainerPaths: t.ContainerPaths,
+		Propagated:     t.Propagated,
+	}
+}
+
+// publications returns the publications of the volume to the pod of the
+// target and to the pods sharing it
+func (t *Target) publications() []Publication {
+	return append([]Publication{t.publication()}, t.Shared...)
+}
+
+// Action is a way of recovering a volume
+type Action interface {
+	// Name identifies the action, it is recorded on the PVC
+	Name() string
+	// CanHandle reports whether the action applies to the target
+	CanHandle(t *Target) bool
+	// Execute recovers the target
+	Execute(ctx context.Context, t *Target) error
+	// Verify checks that the executed action took effect
+	Verify(ctx context.Context, t *Target) error
+}
+
+// Scoped is implemented by the actions recovering more than the volume, e.g.
+// all the volumes of the pod. The other volumes of the scope are recovered
+// along, the action is run once per scope and pass.
+type Scoped interface {
+	// Scope returns the key of what the action recovers for the target
+	Scope(t *Target) string
+}
+
+// Scope returns the scope of the action for the target, empty when the
+// action recovers only the volume
+func Scope(a Action, t *Target) string {
+	if scoped, ok := a.(Scoped); ok {
+		return scoped.Scope(t)
+	}
+	return ""
+}
+
+// PodScope is the scope of the actions recovering the pod
+func PodScope(namespace, podUID string) string {
+	return "pod/" + namespace + "/" + podUID
+}
+
+// VolumeScope is the scope of the actions recovering the volume for all the
+// pods on the node
+func VolumeScope(pvName string) string {
+	return "volume/" + pvName
+}
+
+// OwnerScope is the scope of the actions recovering all the pods of the owner
+func OwnerScope(namespace, owner string) string {
+	return "owner/" + namespace + "/" + owner
+}
+
+// Registry holds the recovery actions in the order they are tried, from the
+// least to the most disruptive
+type Registry struct {
+	actions []Action
+}
+
+// NewRegistry returns a registry of the actions
+func NewRegistry(actions ...Action) *Registry {
+	return &Registry{
+		actions: actions,
+	}
+}
+
+// Register adds the action after the registered ones
+func (r *Registry) Register(action Action) {
+	r.actions = append(r.actions, action)
+}
+
+// Get returns the action with the name, nil if none is registered
+func (r *Registry) Get(name string) Action {
+	for _, a := range r.actions {
+		if a.Name() == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// Subset returns a registry of the named actions, in the given order
+func (r *Registry) Subset(names []string) (*Registry, error) {
+	subset := NewRegistry()
+	for _, name := range names {
+		action := r.Get(name)
+		if action == nil {
+			return nil, fmt.Errorf("unknown recovery action %q", name)
+		}
+		subset.Register(action)
+	}
+	return subset, nil
+}
+
+// Plan returns the actions handling the target, in the order they are to be
+// tried
+func (r *Registry) Plan(t *Target) []Action {
+	var plan []Action
+	for _, a := range r.actions {
+		if a.CanHandle(t) {
+			plan = append(plan, a)
+		}
+	}
+	return plan
+}
@@ -0,0 +1,245 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/csi"
+	"github.com/Madhu-1/csi-volume-recovery/internal/volume"
+	"github.com/Madhu-1/csi-volume-recovery/pkg/csitest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	testDriver   = "csitest.example.com"
+	testHandle   = "vol-0123456789"
+	testStaging  = "/var/lib/kubelet/plugins/kubernetes.io/csi/csitest.example.com/abc/globalmount"
+	testTarget   = "/var/lib/kubelet/pods/uid-1/volumes/kubernetes.io~csi/pv-1/mount"
+	sharedTarget = "/var/lib/kubelet/pods/uid-2/volumes/kubernetes.io~csi/pv-1/mount"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// idleDriver is a driver client for the tests which don't call the driver,
+// any call panics
+type idleDriver struct {
+	csi.Client
+}
+
+// startDriver serves a mock driver for the test and returns a client of it
+func startDriver(t *testing.T) (*csitest.Driver, csi.Client) {
+	t.Helper()
+	driver := csitest.NewDriver(testDriver)
+	endpoint, err := driver.Start()
+	if err != nil {
+		t.Fatalf("failed to start driver: %v", err)
+	}
+	t.Cleanup(driver.Stop)
+	client, err := csi.NewClient(endpoint, nil, nil, testLogger)
+	if err != nil {
+		t.Fatalf("failed to connect to driver: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return driver, client
+}
+
+// newTarget returns a staged filesystem volume published to a pod whose
+// containers see it published again
+func newTarget(client csi.Client) *Target {
+	return &Target{
+		Driver:       testDriver,
+		Namespace:    "default",
+		PodName:      "app-0",
+		PodUID:       "uid-1",
+		PVCName:      "data-app-0",
+		StageUnstage: true,
+		Info: &volume.VolumeInfo{
+			PodUID:               "uid-1",
+			Name:                 "pv-1",
+			DriverName:           testDriver,
+			VolumeHandle:         testHandle,
+			PersistentVolumeName: "pv-1",
+			StagingPath:          testStaging,
+			MountPath:            testTarget,
+		},
+		Client: client,
+		PV: &v1.PersistentVolume{
+			Spec: v1.PersistentVolumeSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					CSI: &v1.CSIPersistentVolumeSource{
+						Driver:       testDriver,
+						VolumeHandle: testHandle,
+						FSType:       "ext4",
+					},
+				},
+			},
+		},
+		Propagated: true,
+	}
+}
+
+func names(actions []Action) []string {
+	var names []string
+	for _, a := range actions {
+		names = append(names, a.Name())
+	}
+	return names
+}
+
+func TestPlan(t *testing.T) {
+	registry := NewDefaultRegistry(testLogger, nil, time.Second)
+	tests := []struct {
+		name   string
+		modify func(*Target)
+		want   []string
+	}{
+		{
+			name: "staged volume",
+			want: []string{ActionRemount, ActionRestage, ActionScale},
+		},
+		{
+			name:   "volume without staging",
+			modify: func(t *Target) { t.StageUnstage = false; t.Info.StagingPath = "" },
+			want:   []string{ActionRemount, ActionRestart},
+		},
+		{
+			name:   "containers keep their previous mount",
+			modify: func(t *Target) { t.Propagated = false },
+			want:   []string{ActionScale},
+		},
+		{
+			name:   "pods sharing the volume not all found",
+			modify: func(t *Target) { t.SharedWith = 1 },
+			want:   []string{ActionRemount, ActionScale},
+		},
+		{
+			name:   "mirror pod",
+			modify: func(t *Target) { t.Mirror = true },
+			want:   []string{ActionRemount},
+		},
+		{
+			name:   "driver not reachable",
+			modify: func(t *Target) { t.Client = nil },
+			want:   []string{ActionScale},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := newTarget(idleDriver{})
+			if tt.modify != nil {
+				tt.modify(target)
+			}
+			if got := names(registry.Plan(target)); !slices.Equal(got, tt.want) {
+				t.Errorf("Plan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemount(t *testing.T) {
+	driver, client := startDriver(t)
+	target := newTarget(client)
+	action := NewDefaultRegistry(testLogger, nil, 10*time.Second).Get(ActionRemount)
+
+	if err := action.Execute(context.Background(), target); err != nil {
+		t.Fatalf("Execute() failed: %v", err)
+	}
+	want := []string{"NodeUnpublishVolume", "NodePublishVolume"}
+	if got := driver.Calls(); !slices.Equal(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	if path, ok := driver.TargetPath(testHandle); !ok || path != testTarget {
+		t.Errorf("volume published to %q (%t), want %q", path, ok, testTarget)
+	}
+	if _, ok := driver.StagingPath(testHandle); ok {
+		t.Error("remount staged the volume")
+	}
+}
+
+func TestRemountRollback(t *testing.T) {
+	driver, client := startDriver(t)
+	target := newTarget(client)
+	action := NewDefaultRegistry(testLogger, nil, 10*time.Second).Get(ActionRemount)
+	driver.SetError("NodePublishVolume", status.Error(codes.Internal, "publish failed"))
+
+	err := action.Execute(context.Background(), target)
+	if !errors.Is(err, ErrNeedsIntervention) {
+		t.Fatalf("Execute() = %v, want %v when the rollback fails too", err, ErrNeedsIntervention)
+	}
+	want := []string{"NodeUnpublishVolume", "NodePublishVolume", "NodePublishVolume"}
+	if got := driver.Calls(); !slices.Equal(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+func TestRestage(t *testing.T) {
+	driver, client := startDriver(t)
+	target := newTarget(client)
+	target.SharedWith = 1
+	target.Shared = []Publication{{Namespace: "default", PodName: "app-1", PodUID: "uid-2", MountPath: sharedTarget, Propagated: true}}
+	action := NewDefaultRegistry(testLogger, nil, 10*time.Second).Get(ActionRestage)
+
+	if err := action.Execute(context.Background(), target); err != nil {
+		t.Fatalf("Execute() failed: %v", err)
+	}
+	want := []string{"NodeUnpublishVolume", "NodeUnpublishVolume", "NodeUnstageVolume", "NodeStageVolume", "NodePublishVolume", "NodePublishVolume"}
+	if got := driver.Calls(); !slices.Equal(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	if path, ok := driver.StagingPath(testHandle); !ok || path != testStaging {
+		t.Errorf("volume staged on %q (%t), want %q", path, ok, testStaging)
+	}
+	if got := Scope(action, target); got != VolumeScope("pv-1") {
+		t.Errorf("Scope() = %q, want %q", got, VolumeScope("pv-1"))
+	}
+}
+
+func TestRestageFailure(t *testing.T) {
+	tests := []struct {
+		name             string
+		rpc              string
+		wantIntervention bool
+		wantCalls        []string
+	}{
+		{
+			// the volume is still staged, it is published back
+			name:      "unstage fails",
+			rpc:       "NodeUnstageVolume",
+			wantCalls: []string{"NodeUnpublishVolume", "NodeUnstageVolume", "NodePublishVolume"},
+		},
+		{
+			// nothing is left to roll back to
+			name:             "stage fails",
+			rpc:              "NodeStageVolume",
+			wantIntervention: true,
+			wantCalls:        []string{"NodeUnpublishVolume", "NodeUnstageVolume", "NodeStageVolume"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, client := startDriver(t)
+			target := newTarget(client)
+			action := NewDefaultRegistry(testLogger, nil, 10*time.Second).Get(ActionRestage)
+			driver.SetError(tt.rpc, status.Error(codes.Internal, "failed"))
+
+			err := action.Execute(context.Background(), target)
+			if err == nil {
+				t.Fatal("Execute() succeeded")
+			}
+			if got := errors.Is(err, ErrNeedsIntervention); got != tt.wantIntervention {
+				t.Errorf("Execute() = %v, needs intervention: %t, want %t", err, got, tt.wantIntervention)
+			}
+			if got := driver.Calls(); !slices.Equal(got, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", got, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrNeedsIntervention is returned when an action failed midway and what it
+// undid couldn't be rolled back, leaving the volume half recovered, e.g.
+// unstaged. The volume is left to an operator rather than escalated.
+var ErrNeedsIntervention = errors.New("volume left partially recovered, needs manual intervention")
+
+// rollbackContext returns the context of a rollback, which runs even when the
+// recovery was interrupted or ran out of time
+func rollbackContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
+
+// republish publishes the volume again to the pods it was unpublished from
+// before the action failed, restoring their previous mounts. The error wraps
+// ErrNeedsIntervention when some pods are left without their volume.
+func republish(ctx context.Context, logger *slog.Logger, t *Target, publications []Publication, cause error) error {
+	var errs []error
+	for _, p := range publications {
+		if err := t.Client.NodePublishVolume(ctx, logger, publishRequest(t, p)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to publish volume back to pod %s/%s: %w", p.Namespace, p.PodName, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w: %w, rollback failed: %w", ErrNeedsIntervention, cause, err)
+	}
+	if len(publications) > 0 {
+		logger.Info("rolled back the unpublished volume", "volumeID", t.PV.Spec.CSI.VolumeHandle, "pods", len(publications))
+	}
+	return cause
+}
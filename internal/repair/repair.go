@@ -0,0 +1,177 @@
+// Package repair checks and repairs the filesystem of a volume's device while
+// the volume is unstaged, for corruptions a new mount can't fix.
+package repair
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/device"
+	"github.com/Madhu-1/csi-volume-recovery/internal/mount"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ErrMounted is returned when the device is still mounted, repairing a
+// mounted filesystem corrupts it further
+var ErrMounted = errors.New("device is still mounted")
+
+// ErrDetached is returned when the device of the volume is no longer on the
+// node, e.g. unmapped by the unstage or detached
+var ErrDetached = errors.New("device of the volume is no longer on the node")
+
+// Repairer runs the repair tool of the filesystem on the unmounted device
+type Repairer struct {
+	logger *slog.Logger
+	// filesystems are the filesystem types allowed to be repaired, keyed
+	// by driver
+	filesystems   map[string][]string
+	mountInfoPath string
+	devices       *device.Checker
+	// unmountTimeout is how long to wait for the kubelet to unstage the
+	// volume
+	unmountTimeout time.Duration
+	timeout        time.Duration
+}
+
+// NewRepairer returns a repairer of the filesystems configured for each
+// driver. Volumes of other drivers or filesystems are never repaired.
+func NewRepairer(logger *slog.Logger, filesystems map[string][]string, unmountTimeout, timeout time.Duration) *Repairer {
+	return &Repairer{
+		logger:         logger,
+		filesystems:    filesystems,
+		mountInfoPath:  mount.DefaultMountInfoPath,
+		devices:        device.NewChecker(device.DefaultSysfsPath),
+		unmountTimeout: unmountTimeout,
+		timeout:        timeout,
+	}
+}
+
+// Enabled reports whether filesystems of the type are repaired for the driver
+func (r *Repairer) Enabled(driver, fsType string) bool {
+	for _, fs := range r.filesystems[driver] {
+		if fs == fsType {
+			return true
+		}
+	}
+	return false
+}
+
+// Identify returns the identity of the device of the volume with the handle,
+// taken while the volume is staged. The kernel name of the device may be
+// handed to another volume once this one is unstaged, so the device is only
+// ever found again by its identity. ErrUnidentified is returned for devices
+// nothing ties to the volume, whose filesystem is never repaired.
+func (r *Repairer) Identify(path, volumeHandle string) (device.Identity, error) {
+	return r.devices.Identify(path, volumeHandle)
+}
+
+// Repair waits for the device with the identity to be unmounted everywhere and
+// runs the repair tool of the filesystem on it
+func (r *Repairer) Repair(ctx context.Context, driver string, id device.Identity, fsType string) error {
+	if !r.Enabled(driver, fsType) {
+		return fmt.Errorf("repair of %s filesystems is not enabled for driver %s", fsType, driver)
+	}
+	// filesystems without repair tool fail before waiting
+	if _, err := repairCommand(fsType, ""); err != nil {
+		return err
+	}
+	if err := r.waitUnmounted(ctx, id); err != nil {
+		return err
+	}
+	// found again right before the repair, the device may have been
+	// remapped while waiting
+	path, err := r.find(id)
+	if err != nil {
+		return err
+	}
+	args, err := repairCommand(fsType, path)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	r.logger.Info("repairing filesystem", "driver", driver, "device", path, "identity", id.String(), "fsType", fsType, "command", args)
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil && !repaired(fsType, err) {
+		return fmt.Errorf("failed to repair %s filesystem on %s: %w: %s", fsType, path, err, out)
+	}
+	r.logger.Info("filesystem repaired", "driver", driver, "device", path, "fsType", fsType, "output", string(out))
+	return nil
+}
+
+// repairCommand returns the command repairing the filesystem without asking
+// any questions. ext filesystems are only preened, fixing what is safe to fix
+// unattended.
+func repairCommand(fsType, device string) ([]string, error) {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		return []string{"fsck." + fsType, "-p", device}, nil
+	case "xfs":
+		return []string{"xfs_repair", device}, nil
+	}
+	return nil, fmt.Errorf("repair of %s filesystems is not supported", fsType)
+}
+
+// repaired reports whether the exit status of the repair tool means the
+// errors were corrected, fsck exits with 1 when it fixed the filesystem
+func repaired(fsType string, err error) bool {
+	var exitErr *exec.ExitError
+	return fsType != "xfs" && errors.As(err, &exitErr) && exitErr.ExitCode() == 1
+}
+
+// find returns the path of the device with the identity, ErrDetached when it
+// is gone
+func (r *Repairer) find(id device.Identity) (string, error) {
+	path, err := r.devices.Find(id)
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return "", fmt.Errorf("%w: %s", ErrDetached, id)
+	}
+	return path, nil
+}
+
+// waitUnmounted waits until the device with the identity is no longer
+// mounted, the kubelet unstages the volume some time after the last pod using
+// it is gone
+func (r *Repairer) waitUnmounted(ctx context.Context, id device.Identity) error {
+	var path, mountPoint string
+	err := wait.PollUntilContextTimeout(ctx, time.Second, r.unmountTimeout, true, func(context.Context) (bool, error) {
+		var err error
+		if path, err = r.find(id); err != nil {
+			return false, err
+		}
+		mounts, err := mount.ReadMountInfo(r.mountInfoPath)
+		if err != nil {
+			return false, err
+		}
+		mountPoint = mountedAt(mounts, path)
+		return mountPoint == "", nil
+	})
+	if err != nil && mountPoint != "" {
+		return fmt.Errorf("%w: %s on %s", ErrMounted, path, mountPoint)
+	}
+	return err
+}
+
+// mountedAt returns a mount point of the device, or an empty string if it
+// isn't mounted. Symlinks such as /dev/disk/by-id entries are resolved.
+func mountedAt(mounts []mount.Mount, device string) string {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		resolved = device
+	}
+	for _, m := range mounts {
+		if m.Source == device || m.Source == resolved {
+			return m.MountPoint
+		}
+	}
+	return ""
+}
@@ -0,0 +1,177 @@
+// Package schedule parses maintenance windows, recurring time windows opened
+// by a cron expression, during which disruptive recovery actions are allowed.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxWindow bounds the duration of a window, the start of the window is
+// searched minute by minute
+const maxWindow = 7 * 24 * time.Hour
+
+// field is a cron field with the allowed values set
+type field struct {
+	values []bool
+	// any is set for *, which matters for the day of month and day of week
+	any bool
+}
+
+// cronFields are the bounds of the minute, hour, day of month, month and day
+// of week fields
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseField parses a comma separated list of *, values, ranges and steps
+func parseField(expr string, min, max int) (field, error) {
+	f := field{values: make([]bool, max+1), any: expr == "*"}
+	for _, part := range strings.Split(expr, ",") {
+		rng, step, hasStep := strings.Cut(part, "/")
+		n := 1
+		if hasStep {
+			var err error
+			n, err = strconv.Atoi(step)
+			if err != nil || n <= 0 {
+				return f, fmt.Errorf("invalid step %q", step)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(first); err != nil {
+				return f, fmt.Errorf("invalid value %q", first)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(last); err != nil {
+					return f, fmt.Errorf("invalid value %q", last)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return f, fmt.Errorf("%q is out of the %d-%d range", rng, min, max)
+		}
+		for v := lo; v <= hi; v += n {
+			f.values[v] = true
+		}
+	}
+	return f, nil
+}
+
+// Window is a recurring time window opened at each time matching a cron
+// expression and lasting for a duration
+type Window struct {
+	spec     string
+	fields   []field
+	duration time.Duration
+}
+
+// ParseWindow parses a window in the "minute hour day-of-month month
+// day-of-week duration" form, e.g. "0 22 * * 1-5 6h" for 22:00 to 04:00 after
+// each weekday. Times are in the local time zone of the process.
+func ParseWindow(spec string) (*Window, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFields)+1 {
+		return nil, fmt.Errorf("invalid maintenance window %q, expected a cron expression followed by a duration", spec)
+	}
+	w := &Window{spec: spec}
+	for i, cf := range cronFields {
+		f, err := parseField(parts[i], cf.min, cf.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in maintenance window %q: %w", cf.name, spec, err)
+		}
+		w.fields = append(w.fields, f)
+	}
+	// Sunday is both 0 and 7
+	w.fields[4].values[0] = w.fields[4].values[0] || w.fields[4].values[7]
+
+	duration, err := time.ParseDuration(parts[len(cronFields)])
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration in maintenance window %q: %w", spec, err)
+	}
+	if duration < time.Minute || duration > maxWindow {
+		return nil, fmt.Errorf("duration of maintenance window %q must be between 1m and %s", spec, maxWindow)
+	}
+	w.duration = duration
+	return w, nil
+}
+
+// String returns the window as it was parsed
+func (w *Window) String() string {
+	return w.spec
+}
+
+// opens reports whether the window opens at the minute
+func (w *Window) opens(t time.Time) bool {
+	f := w.fields
+	if !f[0].values[t.Minute()] || !f[1].values[t.Hour()] || !f[3].values[int(t.Month())] {
+		return false
+	}
+	dom, dow := f[2].values[t.Day()], f[4].values[int(t.Weekday())]
+	// like cron, a day matches either field when both are restricted
+	switch {
+	case f[2].any:
+		return dow
+	case f[4].any:
+		return dom
+	}
+	return dom || dow
+}
+
+// Contains reports whether the time falls in the window
+func (w *Window) Contains(t time.Time) bool {
+	start := t.Truncate(time.Minute)
+	for m := start; t.Sub(m) < w.duration; m = m.Add(-time.Minute) {
+		if w.opens(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Windows is a set of maintenance windows
+type Windows []*Window
+
+// ParseWindows parses the semicolon separated windows
+func ParseWindows(specs string) (Windows, error) {
+	var windows Windows
+	for _, spec := range strings.Split(specs, ";") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		w, err := ParseWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// Contains reports whether the time falls in any of the windows, or true when
+// there are no windows
+func (ws Windows) Contains(t time.Time) bool {
+	if len(ws) == 0 {
+		return true
+	}
+	for _, w := range ws {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	serviceName = "csi-volume-recovery"
+	tracerName  = "github.com/Madhu-1/csi-volume-recovery"
+)
+
+// Attribute keys shared by all the spans created by the tool
+const (
+	DriverKey    = attribute.Key("csi.driver")
+	EndpointKey  = attribute.Key("csi.endpoint")
+	VolumeIDKey  = attribute.Key("csi.volume_id")
+	ActionKey    = attribute.Key("recovery.action")
+	NamespaceKey = attribute.Key("k8s.namespace.name")
+	PodKey       = attribute.Key("k8s.pod.name")
+	PVCKey       = attribute.Key("k8s.pvc.name")
+	NodeKey      = attribute.Key("k8s.node.name")
+	VolumeKey    = attribute.Key("recovery.volume")
+	VolumesKey   = attribute.Key("recovery.volumes")
+	RetryKey     = attribute.Key("recovery.retry")
+	RecoveredKey = attribute.Key("recovery.recovered")
+)
+
+// Setup configures the global tracer provider to export spans to the OTLP
+// gRPC endpoint. If the endpoint is empty tracing stays disabled and the
+// returned shutdown function does nothing.
+func Setup(ctx context.Context, endpoint string, insecure bool) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter for %s: %w", endpoint, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer used to create the spans of the tool
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Start creates a new span with the given attributes
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartLinked creates a new span as a child of the parent span, or as the
+// root of a new trace linked to it when it ended long ago, e.g. for the
+// retries of a recovery started by a pass
+func StartLinked(ctx context.Context, name string, parent trace.SpanContext, child bool, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if child {
+		return Tracer().Start(trace.ContextWithSpanContext(ctx, parent), name, trace.WithAttributes(attrs...))
+	}
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...), trace.WithLinks(trace.Link{SpanContext: parent}))
+}
+
+// End records the error, if any, on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,72 @@
+package volume
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// cacheEntry is a cached lookup, failed lookups are cached with their error
+type cacheEntry struct {
+	driverName string
+	err        error
+	expires    time.Time
+}
+
+type cachedVolume struct {
+	Volume
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+var _ Volume = &cachedVolume{}
+
+// NewCachedVolume caches the lookups of the volume source keyed by the
+// namespace and name of the PVC. Successful lookups are kept for ttl, failed
+// ones for negativeTTL so missing objects aren't looked up on every pass.
+func NewCachedVolume(v Volume, ttl, negativeTTL time.Duration) Volume {
+	return &cachedVolume{
+		Volume:      v,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     map[string]cacheEntry{},
+	}
+}
+
+// GetDriverName returns the cached driver name of the volume, looking it up
+// when it isn't cached or the entry expired
+func (c *cachedVolume) GetDriverName(ctx context.Context, podUUID, podName, pvcName, namespace string) (string, error) {
+	key := namespace + "/" + pvcName
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.driverName, entry.err
+	}
+
+	driverName, err := c.Volume.GetDriverName(ctx, podUUID, podName, pvcName, namespace)
+	ttl := c.ttl
+	if err != nil {
+		ttl = c.negativeTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ttl > 0 {
+		c.entries[key] = cacheEntry{driverName: driverName, err: err, expires: now.Add(ttl)}
+	} else {
+		delete(c.entries, key)
+	}
+	// drop the expired entries of volumes which are gone
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	return driverName, err
+}
@@ -3,48 +3,108 @@ package volume
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/Madhu-1/csi-volume-recovery/internal/kubernetes"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
+// resolvedIdle is how long the resolution of a PVC is kept once the PVC isn't
+// looked up anymore, e.g. because it was deleted
+const resolvedIdle = time.Hour
+
 type kubeclient struct {
 	clientset kubernetes.Client
+	scanner   *Scanner
+
+	// resolved caches what the PVCs resolve to by their UID
+	mu       sync.Mutex
+	resolved map[types.UID]*resolvedPVC
+}
+
+// resolvedPVC is the driver and the PV a PVC resolves to, valid as long as
+// the PVC keeps its resource version
+type resolvedPVC struct {
+	resourceVersion string
+	// driverName is set from the provisioner annotations of the PVC or
+	// from the PV, pv once it was looked up
+	driverName string
+	pv         *v1.PersistentVolume
+	used       time.Time
 }
 
 var _ Volume = &kubeclient{}
 
-func NewKubeVolumeClient(clientset kubernetes.Client) Volume {
+// NewKubeVolumeClient returns a volume source looking the volumes up through
+// the API server, the paths of the volumes are found in the kubelet directory
+func NewKubeVolumeClient(clientset kubernetes.Client, kubeletPath string) Volume {
 	return &kubeclient{
 		clientset: clientset,
+		scanner:   NewScanner(kubeletPath),
+		resolved:  map[types.UID]*resolvedPVC{},
 	}
 }
 
 // GetDriverName returns the driver name of the volume
 func (k *kubeclient) GetDriverName(ctx context.Context, _, _ string, pvcName, namespace string) (string, error) {
+	driverName, _, err := k.resolve(ctx, pvcName, namespace, false)
+	return driverName, err
+}
+
+// resolve returns the driver name of the PVC and, when needPV is set or the
+// PVC has no provisioner annotation, its CSI PV. The resolution is cached by
+// the UID of the PVC and made again once the PVC changes, e.g. is bound or
+// annotated, so the PV is looked up once per PVC.
+func (k *kubeclient) resolve(ctx context.Context, pvcName, namespace string, needPV bool) (string, *v1.PersistentVolume, error) {
 	pvc, err := k.getPVC(ctx, pvcName, namespace)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	if pvc.Annotations != nil {
-		driverName := pvc.Annotations["volume.beta.kubernetes.io/storage-provisioner"]
-		if driverName != "" {
-			return driverName, nil
-		}
-		driverName = pvc.Annotations["volume.kubernetes.io/storage-provisioner"]
-		if driverName != "" {
-			return driverName, nil
+	now := time.Now()
+	k.mu.Lock()
+	entry, ok := k.resolved[pvc.UID]
+	if !ok || entry.resourceVersion != pvc.ResourceVersion {
+		entry = &resolvedPVC{resourceVersion: pvc.ResourceVersion, driverName: provisioner(pvc)}
+		k.resolved[pvc.UID] = entry
+	}
+	entry.used = now
+	for uid, e := range k.resolved {
+		if now.Sub(e.used) > resolvedIdle {
+			delete(k.resolved, uid)
 		}
 	}
+	driverName, pv := entry.driverName, entry.pv
+	k.mu.Unlock()
+	if pv != nil || (driverName != "" && !needPV) {
+		return driverName, pv, nil
+	}
+
 	pvName := pvc.Spec.VolumeName
-	pv, err := k.clientset.GetPV(ctx, pvName)
+	pv, err = k.clientset.GetPV(ctx, pvName)
 	if err != nil {
-		return "", fmt.Errorf("failed to get PV %s: %w", pvName, err)
+		return "", nil, fmt.Errorf("failed to get PV %s: %w", pvName, err)
 	}
 	if pv.Spec.CSI == nil {
-		return "", fmt.Errorf("PV %s is not a CSI volume", pvName)
+		return "", nil, fmt.Errorf("PV %s is not a CSI volume", pvName)
 	}
-	return pv.Spec.CSI.Driver, nil
+	if driverName == "" {
+		driverName = pv.Spec.CSI.Driver
+	}
+	k.mu.Lock()
+	entry.driverName, entry.pv = driverName, pv
+	k.mu.Unlock()
+	return driverName, pv, nil
+}
+
+// provisioner returns the driver provisioning the PVC from its annotations,
+// empty when it isn't annotated
+func provisioner(pvc *v1.PersistentVolumeClaim) string {
+	if driverName := pvc.Annotations["volume.beta.kubernetes.io/storage-provisioner"]; driverName != "" {
+		return driverName
+	}
+	return pvc.Annotations["volume.kubernetes.io/storage-provisioner"]
 }
 
 func (k *kubeclient) getPVC(ctx context.Context, pvcName, namespace string) (*v1.PersistentVolumeClaim, error) {
@@ -54,3 +114,63 @@ func (k *kubeclient) getPVC(ctx context.Context, pvcName, namespace string) (*v1
 	}
 	return pvc, nil
 }
+
+// GetVolumeHandle returns the CSI volume handle from the PV
+func (k *kubeclient) GetVolumeHandle(ctx context.Context, _, _ string, pvcName, namespace string) (string, error) {
+	pv, err := k.getCSIPV(ctx, pvcName, namespace)
+	if err != nil {
+		return "", err
+	}
+	return pv.Spec.CSI.VolumeHandle, nil
+}
+
+// GetVolumeAttributes returns the CSI volume attributes from the PV
+func (k *kubeclient) GetVolumeAttributes(ctx context.Context, _, _ string, pvcName, namespace string) (map[string]string, error) {
+	pv, err := k.getCSIPV(ctx, pvcName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return pv.Spec.CSI.VolumeAttributes, nil
+}
+
+// GetStagingTargetPath returns the staging path of the pod's volume from the
+// kubelet directory
+func (k *kubeclient) GetStagingTargetPath(ctx context.Context, podUUID, podName, pvcName, namespace string) (string, error) {
+	vol, err := k.podVolume(ctx, podUUID, podName, pvcName, namespace)
+	if err != nil {
+		return "", err
+	}
+	return vol.StagingPath, nil
+}
+
+// GetPublishTargetPath returns the target path of the pod's volume from the
+// kubelet directory
+func (k *kubeclient) GetPublishTargetPath(ctx context.Context, podUUID, podName, pvcName, namespace string) (string, error) {
+	vol, err := k.podVolume(ctx, podUUID, podName, pvcName, namespace)
+	if err != nil {
+		return "", err
+	}
+	return vol.MountPath, nil
+}
+
+// getCSIPV returns the CSI PV bound to the PVC
+func (k *kubeclient) getCSIPV(ctx context.Context, pvcName, namespace string) (*v1.PersistentVolume, error) {
+	_, pv, err := k.resolve(ctx, pvcName, namespace, true)
+	return pv, err
+}
+
+// podVolume finds the volume of the PVC's PV in the pod's kubelet directory
+func (k *kubeclient) podVolume(ctx context.Context, podUUID, podName, pvcName, namespace string) (*VolumeInfo, error) {
+	pvc, err := k.getPVC(ctx, pvcName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	vol, err := k.scanner.PodVolume(podUUID, pvc.Spec.VolumeName)
+	if err != nil {
+		return nil, err
+	}
+	if vol == nil {
+		return nil, fmt.Errorf("volume %s of pod %s in namespace %s not found in the kubelet directory", pvc.Spec.VolumeName, podName, namespace)
+	}
+	return vol, nil
+}
@@ -0,0 +1,72 @@
+package volume
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// KubeletPaths builds the paths the kubelet uses for CSI volumes, so the
+// stage and publish calls of recovery actions use the same paths the kubelet
+// would, even when the directories don't exist anymore.
+type KubeletPaths struct {
+	kubeletPath string
+}
+
+// NewKubeletPaths returns the path conventions of the kubelet directory
+func NewKubeletPaths(kubeletPath string) KubeletPaths {
+	return KubeletPaths{
+		kubeletPath: kubeletPath,
+	}
+}
+
+// csiPluginDir is the directory of the CSI plugin of the kubelet
+func (p KubeletPaths) csiPluginDir() string {
+	return filepath.Join(p.kubeletPath, "plugins", "kubernetes.io", "csi")
+}
+
+// PodVolumesDir is the directory of the filesystem CSI volumes of the pod
+func (p KubeletPaths) PodVolumesDir(podUID string) string {
+	return filepath.Join(p.kubeletPath, "pods", podUID, "volumes", "kubernetes.io~csi")
+}
+
+// PodVolumeDevicesDir is the directory of the block CSI volumes of the pod
+func (p KubeletPaths) PodVolumeDevicesDir(podUID string) string {
+	return filepath.Join(p.kubeletPath, "pods", podUID, "volumeDevices", "kubernetes.io~csi")
+}
+
+// BlockDataDir is where the kubelet stores the vol_data.json of the block
+// volume
+func (p KubeletPaths) BlockDataDir(pvName string) string {
+	return filepath.Join(p.csiPluginDir(), "volumeDevices", pvName, "data")
+}
+
+// StagingPath returns the staging target path of the volume. Filesystem
+// volumes are staged in a directory named after the hash of the volume
+// handle since Kubernetes 1.24 and after the PV before, the existing one is
+// preferred and the current convention is used when neither exists.
+func (p KubeletPaths) StagingPath(driver, volumeHandle, pvName string, block bool) string {
+	if block {
+		return filepath.Join(p.csiPluginDir(), "volumeDevices", "staging", pvName)
+	}
+	path := filepath.Join(p.csiPluginDir(), driver, fmt.Sprintf("%x", sha256.Sum256([]byte(volumeHandle))), "globalmount")
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	legacy := filepath.Join(p.csiPluginDir(), "pv", pvName, "globalmount")
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy
+	}
+	return path
+}
+
+// PublishPath returns the target path of the volume in the pod, the mount
+// point of filesystem volumes or the device file of block volumes. The name
+// is the PV name, or the pod volume name of inline ephemeral volumes.
+func (p KubeletPaths) PublishPath(podUID, name string, block bool) string {
+	if block {
+		return filepath.Join(p.csiPluginDir(), "volumeDevices", "publish", name, podUID)
+	}
+	return filepath.Join(p.PodVolumesDir(podUID), name, "mount")
+}
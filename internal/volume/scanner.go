@@ -0,0 +1,185 @@
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// VolumeInfo describes a CSI volume of a pod found in the kubelet directory
+type VolumeInfo struct {
+	PodUID string
+	// Name is the name of the volume directory, the PV name of persistent
+	// volumes or the pod volume name of inline ephemeral volumes
+	Name                 string
+	DriverName           string
+	VolumeHandle         string
+	PersistentVolumeName string
+	// StagingPath is the staging target path of NodeStageVolume, empty for
+	// volumes which aren't staged
+	StagingPath string
+	// MountPath is the target path of NodePublishVolume, the mount point of
+	// filesystem volumes or the device file of block volumes
+	MountPath string
+	Block     bool
+	Ephemeral bool
+	// AttachmentID is the name of the VolumeAttachment of attachable
+	// volumes, when the kubelet recorded it
+	AttachmentID string
+	// UnknownFields are the fields of vol_data.json this version doesn't
+	// know about, written by a newer kubelet
+	UnknownFields []string
+}
+
+// Scanner enumerates the CSI volumes of the pods from the kubelet directory
+type Scanner struct {
+	kubeletPath string
+	paths       KubeletPaths
+}
+
+// NewScanner returns a scanner of the kubelet directory
+func NewScanner(kubeletPath string) *Scanner {
+	return &Scanner{
+		kubeletPath: kubeletPath,
+		paths:       NewKubeletPaths(kubeletPath),
+	}
+}
+
+// Pods returns the UIDs of the pods which have a directory on the node
+func (s *Scanner) Pods() ([]string, error) {
+	dir := filepath.Join(s.kubeletPath, "pods")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pods directory %s: %w", dir, err)
+	}
+	var uids []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			uids = append(uids, entry.Name())
+		}
+	}
+	return uids, nil
+}
+
+// PodVolumes returns the filesystem and block CSI volumes of the pod
+func (s *Scanner) PodVolumes(podUID string) ([]VolumeInfo, error) {
+	filesystems, err := s.filesystemVolumes(podUID)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := s.blockVolumes(podUID)
+	if err != nil {
+		return nil, err
+	}
+	return append(filesystems, blocks...), nil
+}
+
+// PodVolume returns the CSI volume of the pod backed by the PV, or nil if the
+// pod has no such volume on the node
+func (s *Scanner) PodVolume(podUID, pvName string) (*VolumeInfo, error) {
+	volumes, err := s.PodVolumes(podUID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range volumes {
+		if volumes[i].PersistentVolumeName == pvName {
+			return &volumes[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// filesystemVolumes reads pods/<uid>/volumes/kubernetes.io~csi/<name>, where
+// vol_data.json is stored next to the mount directory
+func (s *Scanner) filesystemVolumes(podUID string) ([]VolumeInfo, error) {
+	dir := s.paths.PodVolumesDir(podUID)
+	names, err := readDirNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	var volumes []VolumeInfo
+	for _, name := range names {
+		data, err := readVolumeData(filepath.Join(dir, name, "vol_data.json"))
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		vol := s.volumeInfo(podUID, name, data)
+		vol.MountPath = s.paths.PublishPath(podUID, name, false)
+		if !vol.Ephemeral {
+			vol.StagingPath = s.paths.StagingPath(vol.DriverName, vol.VolumeHandle, vol.PersistentVolumeName, false)
+		}
+		volumes = append(volumes, vol)
+	}
+	return volumes, nil
+}
+
+// blockVolumes reads pods/<uid>/volumeDevices/kubernetes.io~csi/<name>, the
+// data of block volumes is stored by the kubelet in the plugin directory
+func (s *Scanner) blockVolumes(podUID string) ([]VolumeInfo, error) {
+	dir := s.paths.PodVolumeDevicesDir(podUID)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSI volume devices directory %s: %w", dir, err)
+	}
+	var volumes []VolumeInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		data, err := readVolumeData(filepath.Join(s.paths.BlockDataDir(name), "vol_data.json"))
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		vol := s.volumeInfo(podUID, name, data)
+		vol.Block = true
+		vol.StagingPath = s.paths.StagingPath(vol.DriverName, vol.VolumeHandle, name, true)
+		vol.MountPath = s.paths.PublishPath(podUID, name, true)
+		volumes = append(volumes, vol)
+	}
+	return volumes, nil
+}
+
+func (s *Scanner) volumeInfo(podUID, name string, data *volumeData) VolumeInfo {
+	vol := VolumeInfo{
+		PodUID:               podUID,
+		Name:                 name,
+		DriverName:           data.DriverName,
+		VolumeHandle:         data.VolumeHandle,
+		PersistentVolumeName: data.PersistentVolumeName,
+		Ephemeral:            data.ephemeral(),
+		AttachmentID:         data.AttachmentID,
+		UnknownFields:        data.Unknown,
+	}
+	if vol.PersistentVolumeName == "" && !vol.Ephemeral {
+		vol.PersistentVolumeName = name
+	}
+	return vol
+}
+
+// readDirNames returns the names of the sub directories, nothing if the
+// directory doesn't exist
+func readDirNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
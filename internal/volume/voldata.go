@@ -0,0 +1,95 @@
+package volume
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// volumeData is the vol_data.json the kubelet writes for each CSI volume.
+// Kubelets before 1.16 recorded driverMode (persistent or ephemeral) instead
+// of volumeLifecycleMode (Persistent or Ephemeral), older kubelets don't
+// record attachmentID and newer ones add fields such as seLinuxMountContext.
+type volumeData struct {
+	DriverName           string
+	PersistentVolumeName string
+	VolumeHandle         string
+	LifecycleMode        string
+	NodeName             string
+	AttachmentID         string
+	// Unknown are the fields which aren't known, sorted
+	Unknown []string
+}
+
+// volumeDataFields maps the known fields of all the layouts to the field of
+// volumeData they are read into
+var volumeDataFields = map[string]func(*volumeData) *string{
+	"driverName":          func(d *volumeData) *string { return &d.DriverName },
+	"specVolID":           func(d *volumeData) *string { return &d.PersistentVolumeName },
+	"volumeHandle":        func(d *volumeData) *string { return &d.VolumeHandle },
+	"volumeLifecycleMode": func(d *volumeData) *string { return &d.LifecycleMode },
+	"driverMode":          func(d *volumeData) *string { return &d.LifecycleMode },
+	"nodeName":            func(d *volumeData) *string { return &d.NodeName },
+	"attachmentID":        func(d *volumeData) *string { return &d.AttachmentID },
+}
+
+// knownVolumeDataFields are fields the kubelet writes which aren't needed
+var knownVolumeDataFields = map[string]bool{
+	"seLinuxMountContext": true,
+}
+
+// ephemeral reports whether the volume is an inline ephemeral volume, in the
+// old and new lifecycle spelling
+func (d *volumeData) ephemeral() bool {
+	return d.LifecycleMode == "Ephemeral" || d.LifecycleMode == "ephemeral"
+}
+
+// parseVolumeData parses any layout of vol_data.json. Fields which aren't
+// known are recorded instead of failing, fields of an unexpected type are
+// ignored the same way.
+func parseVolumeData(raw []byte) (*volumeData, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	data := &volumeData{}
+	for key, value := range fields {
+		field, ok := volumeDataFields[key]
+		if !ok {
+			if !knownVolumeDataFields[key] {
+				data.Unknown = append(data.Unknown, key)
+			}
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			data.Unknown = append(data.Unknown, key)
+			continue
+		}
+		// volumeLifecycleMode wins over driverMode when both are present
+		if target := field(data); *target == "" || key != "driverMode" {
+			*target = s
+		}
+	}
+	sort.Strings(data.Unknown)
+	return data, nil
+}
+
+// readVolumeData reads the vol_data.json file, nil if it doesn't exist
+func readVolumeData(path string) (*volumeData, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := parseVolumeData(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal volume data %s: %w", path, err)
+	}
+	return data, nil
+}
@@ -2,55 +2,101 @@ package volume
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 )
 
 type Volume interface {
 	GetDriverName(ctx context.Context, podUUID, podName, pvcName, namespace string) (string, error)
+	GetVolumeHandle(ctx context.Context, podUUID, podName, pvcName, namespace string) (string, error)
+	GetStagingTargetPath(ctx context.Context, podUUID, podName, pvcName, namespace string) (string, error)
+	GetPublishTargetPath(ctx context.Context, podUUID, podName, pvcName, namespace string) (string, error)
+	GetVolumeAttributes(ctx context.Context, podUUID, podName, pvcName, namespace string) (map[string]string, error)
 }
 
+// ErrNotAvailable is returned when the volume source doesn't record the
+// requested detail of the volume
+var ErrNotAvailable = errors.New("not available")
+
 type localHost struct {
-	kubeletPath string
+	scanner *Scanner
 }
 
 var _ Volume = &localHost{}
 
 func NewLocalHost(kubeletPath string) Volume {
 	return &localHost{
-		kubeletPath: kubeletPath,
+		scanner: NewScanner(kubeletPath),
 	}
 }
 
+// GetDriverName returns the driver of the pod's CSI volumes from the kubelet
+// directory. The directories of the volumes are named after their PV, which
+// can't be resolved from the PVC name without the API server, so the driver
+// is only returned when all the CSI volumes of the pod use the same driver.
 func (l *localHost) GetDriverName(_ context.Context, podUUID, podName, pvcName, namespace string) (string, error) {
-	pvName := "" // get the pv name
-	filePath := filepath.Join(
-		l.kubeletPath,
-		"pods",
-		podUUID,
-		"volumes/kubernetes.io~csi/",
-		pvName,
-		"vol_data.json",
-	)
-
-	type volumeData struct {
-		DriverName           string `json:"driverName"`
-		PersistentVolumeName string `json:"specVolID"`
-		VolumeHandle         string `json:"volumeHandle"`
-	}
-	vol := volumeData{}
-
-	data, err := os.ReadFile(filePath)
+	volumes, err := l.scanner.PodVolumes(podUUID)
 	if err != nil {
 		return "", err
 	}
+	if len(volumes) == 0 {
+		return "", fmt.Errorf("pod %s in namespace %s has no CSI volumes", podName, namespace)
+	}
+	driverName := volumes[0].DriverName
+	for _, vol := range volumes[1:] {
+		if vol.DriverName != driverName {
+			return "", fmt.Errorf("CSI volumes of pod %s in namespace %s use different drivers, can't tell the driver of PVC %s", podName, namespace, pvcName)
+		}
+	}
+	return driverName, nil
+}
 
-	err = json.Unmarshal(data, &vol)
+// GetVolumeHandle returns the handle of the pod's only CSI volume
+func (l *localHost) GetVolumeHandle(_ context.Context, podUUID, podName, pvcName, namespace string) (string, error) {
+	vol, err := l.podVolume(podUUID, podName, pvcName, namespace)
 	if err != nil {
-		return "", fmt.Errorf("failed to unmarshal volume data %v: %w", data, err)
+		return "", err
 	}
+	return vol.VolumeHandle, nil
+}
+
+// GetStagingTargetPath returns the staging path of the pod's only CSI volume
+func (l *localHost) GetStagingTargetPath(_ context.Context, podUUID, podName, pvcName, namespace string) (string, error) {
+	vol, err := l.podVolume(podUUID, podName, pvcName, namespace)
+	if err != nil {
+		return "", err
+	}
+	return vol.StagingPath, nil
+}
 
-	return vol.DriverName, nil
+// GetPublishTargetPath returns the target path of the pod's only CSI volume
+func (l *localHost) GetPublishTargetPath(_ context.Context, podUUID, podName, pvcName, namespace string) (string, error) {
+	vol, err := l.podVolume(podUUID, podName, pvcName, namespace)
+	if err != nil {
+		return "", err
+	}
+	return vol.MountPath, nil
+}
+
+// GetVolumeAttributes isn't supported, the kubelet directory doesn't record
+// the volume attributes of the PV
+func (l *localHost) GetVolumeAttributes(_ context.Context, _, _, pvcName, namespace string) (map[string]string, error) {
+	return nil, fmt.Errorf("volume attributes of PVC %s in namespace %s: %w", pvcName, namespace, ErrNotAvailable)
+}
+
+// podVolume returns the CSI volume of the pod, which must be the only one as
+// the PV of the PVC can't be resolved without the API server
+func (l *localHost) podVolume(podUUID, podName, pvcName, namespace string) (*VolumeInfo, error) {
+	volumes, err := l.scanner.PodVolumes(podUUID)
+	if err != nil {
+		return nil, err
+	}
+	switch len(volumes) {
+	case 0:
+		return nil, fmt.Errorf("pod %s in namespace %s has no CSI volumes", podName, namespace)
+	case 1:
+		return &volumes[0], nil
+	default:
+		return nil, fmt.Errorf("pod %s in namespace %s has %d CSI volumes, can't tell the volume of PVC %s", podName, namespace, len(volumes), pvcName)
+	}
 }
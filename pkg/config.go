@@ -1,8 +1,331 @@
 package pkg
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type Config struct {
-	Endpoint       string
-	KubeletPath    string
-	NodeName       string
-	KubeconfigPath string
+	Endpoint        string
+	KubeletPath     string
+	NodeName        string
+	KubeconfigPath  string
+	Interval        time.Duration
+	Mode            string
+	PlanFile        string
+	DaemonSetPolicy string
+	JobPolicy       string
+	OwnerPolicies   string
+	HPAPolicy       string
+	PDBPolicy       string
+	LockHolder      string
+	LockTTL         time.Duration
+	OTLPEndpoint    string
+	OTLPInsecure    bool
+	LogLevel        string
+	LogFormat       string
+	DriverSecrets   string
+	CSIQPS          float64
+	CSIBurst        int
+	CSIConcurrency  int
+
+	CleanupVolumeAttachments bool
+	VolumeAttachmentGrace    time.Duration
+	PendingGracePeriod       time.Duration
+	CordonNode               bool
+	RecoveryCooldown         time.Duration
+	RecoveryBackoffMax       time.Duration
+	RecoveryWorkers          int
+	VolumeWorkers            int
+	FullResyncInterval       time.Duration
+	RecoveryRetries          int
+	RecoveryRetryDelay       time.Duration
+	VerifyTimeout            time.Duration
+	MaxConcurrentRecoveries  int
+	NamespaceBudget          int
+	NamespaceBudgets         string
+	NamespaceBudgetWindow    time.Duration
+	MaintenanceWindows       string
+	HistoryNamespace         string
+	CircuitBreakerThreshold  int
+	RecoveryActions          string
+	DriverRecoveryActions    string
+	ProtectedNamespaces      string
+	IncludeSystemNamespaces  bool
+	Quarantine               bool
+	ProbeAddress             string
+	RBACServiceAccount       string
+	RBACOptional             bool
+	EnableActions            bool
+	ProbeTLSCertFile         string
+	ProbeTLSKeyFile          string
+	ProbeClientCAFile        string
+	ProbeTokenFile           string
+	AuditLog                 string
+	ReportFile               string
+	NodeSummaryEvent         bool
+	VolumeHealthCRD          bool
+	VolumeConditionEvents    bool
+	ReportFormat             string
+	RemountStaticPods        bool
+	DriverActionTimeout      time.Duration
+	PreRecoveryHook          string
+	PostRecoveryHook         string
+	HookTimeout              time.Duration
+	NotifyWebhooks           string
+	NotifySlackWebhook       string
+	NotifyWebhookFiles       string
+	NotifySlackWebhookFile   string
+	NotifyEvents             string
+	NotifyTemplate           string
+	HistorySize              int
+	NodeTaint                string
+	TaintAfterFailures       int
+	KubeletDirect            bool
+	KubeletPort              int
+	KubeletCAFile            string
+	StatsRetries             int
+	KubeAPIQPS               float64
+	KubeAPIBurst             int
+	KubeAPIPageSize          int64
+	ScaleTimeout             time.Duration
+	PodDeletionTimeout       time.Duration
+	PDBWaitTimeout           time.Duration
+	ReplacementTimeout       time.Duration
+	APITimeout               time.Duration
+	SkipPermissionCheck      bool
+	VolumeSource             string
+	VolumeCacheTTL           time.Duration
+	VolumeCacheNegativeTTL   time.Duration
+	CheckMounts              bool
+	CheckReadOnly            bool
+	CheckBindMounts          bool
+	ProbeMounts              bool
+	CheckDevices             bool
+	CheckCeph                bool
+	CheckShares              bool
+	HealthCheckers           string
+	SeverityActions          string
+	NodeCondition            string
+	VolumeEvents             bool
+	VeleroNamespace          string
+	VolumeEventWindow        time.Duration
+	ProbeIO                  bool
+	IOProbeTimeout           time.Duration
+	CapacityThreshold        int
+	InodeThreshold           int
+	AutoExpand               bool
+	ExpandPercent            int
+	ExpandLimit              string
+	KernelLogPath            string
+	RepairFilesystems        string
+	RepairUnmountTimeout     time.Duration
+	RepairTimeout            time.Duration
+	DetectOrphans            bool
+	CleanupOrphans           bool
+	ImpersonateUser          string
+	ImpersonateGroups        string
+}
+
+// SecretRef references a Kubernetes secret
+type SecretRef struct {
+	Namespace string
+	Name      string
+}
+
+// DriverSecretRefs parses the DriverSecrets option, a comma separated list of
+// driver=namespace/name entries, into a map keyed by the driver name.
+func (c *Config) DriverSecretRefs() (map[string]SecretRef, error) {
+	refs := map[string]SecretRef{}
+	if c.DriverSecrets == "" {
+		return refs, nil
+	}
+	for _, entry := range strings.Split(c.DriverSecrets, ",") {
+		driver, ref, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || driver == "" {
+			return nil, fmt.Errorf("invalid driver secret %q, expected driver=namespace/name", entry)
+		}
+		namespace, name, ok := strings.Cut(ref, "/")
+		if !ok || namespace == "" || name == "" {
+			return nil, fmt.Errorf("invalid secret reference %q for driver %s, expected namespace/name", ref, driver)
+		}
+		refs[driver] = SecretRef{Namespace: namespace, Name: name}
+	}
+	return refs, nil
+}
+
+// OwnerPolicyMap parses the OwnerPolicies option, a comma separated list of
+// Kind.group=policy entries, into a map keyed by Kind.group.
+func (c *Config) OwnerPolicyMap() (map[string]string, error) {
+	policies := map[string]string{}
+	if c.OwnerPolicies == "" {
+		return policies, nil
+	}
+	for _, entry := range strings.Split(c.OwnerPolicies, ",") {
+		kind, policy, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || kind == "" || policy == "" {
+			return nil, fmt.Errorf("invalid owner policy %q, expected Kind.group=policy", entry)
+		}
+		policies[kind] = policy
+	}
+	return policies, nil
+}
+
+// RepairFilesystemMap parses the RepairFilesystems option, a comma separated
+// list of driver=fstype|fstype entries, into a map keyed by the driver name.
+func (c *Config) RepairFilesystemMap() (map[string][]string, error) {
+	filesystems := map[string][]string{}
+	if c.RepairFilesystems == "" {
+		return filesystems, nil
+	}
+	for _, entry := range strings.Split(c.RepairFilesystems, ",") {
+		driver, list, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || driver == "" || list == "" {
+			return nil, fmt.Errorf("invalid repair filesystems %q, expected driver=fstype|fstype", entry)
+		}
+		filesystems[driver] = append(filesystems[driver], strings.Split(list, "|")...)
+	}
+	return filesystems, nil
+}
+
+// NamespaceBudgetMap parses the NamespaceBudgets option, a comma separated
+// list of namespace=count entries, into a map keyed by the namespace.
+func (c *Config) NamespaceBudgetMap() (map[string]int, error) {
+	budgets := map[string]int{}
+	if c.NamespaceBudgets == "" {
+		return budgets, nil
+	}
+	for _, entry := range strings.Split(c.NamespaceBudgets, ",") {
+		namespace, count, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || namespace == "" {
+			return nil, fmt.Errorf("invalid namespace budget %q, expected namespace=count", entry)
+		}
+		budget, err := strconv.Atoi(count)
+		if err != nil || budget < 0 {
+			return nil, fmt.Errorf("invalid budget %q for namespace %s, expected a non-negative count", count, namespace)
+		}
+		budgets[namespace] = budget
+	}
+	return budgets, nil
+}
+
+// SeverityActionMap parses the SeverityActions option, a comma separated list
+// of severity=action entries, into a map keyed by the severity.
+func (c *Config) SeverityActionMap() (map[string]string, error) {
+	actions := map[string]string{}
+	for _, entry := range strings.Split(c.SeverityActions, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		severity, action, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || severity == "" || action == "" {
+			return nil, fmt.Errorf("invalid severity action %q, expected severity=action", entry)
+		}
+		actions[severity] = action
+	}
+	return actions, nil
+}
+
+// HealthCheckerList splits the HealthCheckers option, a comma separated list
+// of health checker names
+func (c *Config) HealthCheckerList() []string {
+	var names []string
+	for _, name := range strings.Split(c.HealthCheckers, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// NotifyWebhookList splits the NotifyWebhooks option, a comma separated list
+// of URLs
+func (c *Config) NotifyWebhookList() []string {
+	var urls []string
+	for _, url := range strings.Split(c.NotifyWebhooks, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// NotifyWebhookFileList splits the NotifyWebhookFiles option, a comma
+// separated list of paths to files holding a URL each
+func (c *Config) NotifyWebhookFileList() []string {
+	var paths []string
+	for _, path := range strings.Split(c.NotifyWebhookFiles, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// NotifyEventList splits the NotifyEvents option, a comma separated list of
+// the events to notify
+func (c *Config) NotifyEventList() []string {
+	var events []string
+	for _, event := range strings.Split(c.NotifyEvents, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// RecoveryActionList splits the RecoveryActions option, a comma separated
+// list of recovery action names
+func (c *Config) RecoveryActionList() []string {
+	var names []string
+	for _, name := range strings.Split(c.RecoveryActions, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// DriverRecoveryActionMap parses the DriverRecoveryActions option, a comma
+// separated list of driver=action|action entries, into a map keyed by the
+// driver name.
+func (c *Config) DriverRecoveryActionMap() (map[string][]string, error) {
+	ladders := map[string][]string{}
+	if c.DriverRecoveryActions == "" {
+		return ladders, nil
+	}
+	for _, entry := range strings.Split(c.DriverRecoveryActions, ",") {
+		driver, list, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || driver == "" || list == "" {
+			return nil, fmt.Errorf("invalid driver recovery actions %q, expected driver=action|action", entry)
+		}
+		ladders[driver] = append(ladders[driver], strings.Split(list, "|")...)
+	}
+	return ladders, nil
+}
+
+// ProtectedNamespaceList splits the ProtectedNamespaces option, a comma
+// separated list of namespaces
+func (c *Config) ProtectedNamespaceList() []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(c.ProtectedNamespaces, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// ImpersonateGroupList splits the ImpersonateGroups option, a comma separated
+// list of groups
+func (c *Config) ImpersonateGroupList() []string {
+	var groups []string
+	for _, group := range strings.Split(c.ImpersonateGroups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
 }
@@ -0,0 +1,284 @@
+// Package csitest provides an in-process CSI driver implementing the Identity
+// and Node services, so the recovery pipeline can be exercised end-to-end
+// without a real driver.
+package csitest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Driver is a scriptable CSI driver served over a unix socket
+type Driver struct {
+	csipbv1.UnimplementedIdentityServer
+	csipbv1.UnimplementedNodeServer
+
+	mu           sync.Mutex
+	name         string
+	nodeID       string
+	ready        bool
+	capabilities []csipbv1.NodeServiceCapability_RPC_Type
+	conditions   map[string]*csipbv1.VolumeCondition
+	errors       map[string]error
+	staged       map[string]string
+	published    map[string]string
+	calls        []string
+
+	dir    string
+	server *grpc.Server
+}
+
+var (
+	_ csipbv1.IdentityServer = &Driver{}
+	_ csipbv1.NodeServer     = &Driver{}
+)
+
+// NewDriver returns a ready driver with the given name which doesn't advertise
+// any node capabilities.
+func NewDriver(name string) *Driver {
+	return &Driver{
+		name:       name,
+		nodeID:     "csitest-node",
+		ready:      true,
+		conditions: map[string]*csipbv1.VolumeCondition{},
+		errors:     map[string]error{},
+		staged:     map[string]string{},
+		published:  map[string]string{},
+	}
+}
+
+// Start serves the driver on a unix socket in a temporary directory and
+// returns the endpoint to connect to.
+func (d *Driver) Start() (string, error) {
+	dir, err := os.MkdirTemp("", "csitest")
+	if err != nil {
+		return "", fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	socket := filepath.Join(dir, "csi.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+
+	d.dir = dir
+	d.server = grpc.NewServer()
+	csipbv1.RegisterIdentityServer(d.server, d)
+	csipbv1.RegisterNodeServer(d.server, d)
+	go d.server.Serve(listener)
+
+	return "unix://" + socket, nil
+}
+
+// Stop stops serving and removes the socket
+func (d *Driver) Stop() {
+	if d.server != nil {
+		d.server.Stop()
+	}
+	if d.dir != "" {
+		os.RemoveAll(d.dir)
+	}
+}
+
+// SetReady sets the readiness reported by Probe
+func (d *Driver) SetReady(ready bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ready = ready
+}
+
+// SetCapabilities sets the capabilities reported by NodeGetCapabilities
+func (d *Driver) SetCapabilities(capabilities ...csipbv1.NodeServiceCapability_RPC_Type) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.capabilities = capabilities
+}
+
+// SetVolumeCondition sets the condition NodeGetVolumeStats reports for the
+// volume. Volumes without a condition are reported as healthy.
+func (d *Driver) SetVolumeCondition(volumeID string, abnormal bool, message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.conditions[volumeID] = &csipbv1.VolumeCondition{
+		Abnormal: abnormal,
+		Message:  message,
+	}
+}
+
+// SetError makes every call of the rpc, e.g. "NodeStageVolume", fail with
+// err. A nil err clears the scripted failure.
+func (d *Driver) SetError(rpc string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		delete(d.errors, rpc)
+		return
+	}
+	d.errors[rpc] = err
+}
+
+// Calls returns the names of the rpcs served so far, in order
+func (d *Driver) Calls() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.calls...)
+}
+
+// StagingPath returns the staging path of the volume, if it is staged
+func (d *Driver) StagingPath(volumeID string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path, ok := d.staged[volumeID]
+	return path, ok
+}
+
+// TargetPath returns the target path of the volume, if it is published
+func (d *Driver) TargetPath(volumeID string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path, ok := d.published[volumeID]
+	return path, ok
+}
+
+// record registers the call and returns the scripted error of the rpc. The
+// caller must hold the lock.
+func (d *Driver) record(rpc string) error {
+	d.calls = append(d.calls, rpc)
+	return d.errors[rpc]
+}
+
+func (d *Driver) GetPluginInfo(context.Context, *csipbv1.GetPluginInfoRequest) (*csipbv1.GetPluginInfoResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record("GetPluginInfo"); err != nil {
+		return nil, err
+	}
+	return &csipbv1.GetPluginInfoResponse{
+		Name:          d.name,
+		VendorVersion: "csitest",
+	}, nil
+}
+
+func (d *Driver) GetPluginCapabilities(context.Context, *csipbv1.GetPluginCapabilitiesRequest) (*csipbv1.GetPluginCapabilitiesResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record("GetPluginCapabilities"); err != nil {
+		return nil, err
+	}
+	return &csipbv1.GetPluginCapabilitiesResponse{}, nil
+}
+
+func (d *Driver) Probe(context.Context, *csipbv1.ProbeRequest) (*csipbv1.ProbeResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record("Probe"); err != nil {
+		return nil, err
+	}
+	return &csipbv1.ProbeResponse{
+		Ready: wrapperspb.Bool(d.ready),
+	}, nil
+}
+
+func (d *Driver) NodeGetInfo(context.Context, *csipbv1.NodeGetInfoRequest) (*csipbv1.NodeGetInfoResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record("NodeGetInfo"); err != nil {
+		return nil, err
+	}
+	return &csipbv1.NodeGetInfoResponse{
+		NodeId: d.nodeID,
+	}, nil
+}
+
+func (d *Driver) NodeGetCapabilities(context.Context, *csipbv1.NodeGetCapabilitiesRequest) (*csipbv1.NodeGetCapabilitiesResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record("NodeGetCapabilities"); err != nil {
+		return nil, err
+	}
+	resp := &csipbv1.NodeGetCapabilitiesResponse{}
+	for _, capability := range d.capabilities {
+		resp.Capabilities = append(resp.Capabilities, &csipbv1.NodeServiceCapability{
+			Type: &csipbv1.NodeServiceCapability_Rpc{
+				Rpc: &csipbv1.NodeServiceCapability_RPC{
+					Type: capability,
+				},
+			},
+		})
+	}
+	return resp, nil
+}
+
+func (d *Driver) NodeGetVolumeStats(_ context.Context, req *csipbv1.NodeGetVolumeStatsRequest) (*csipbv1.NodeGetVolumeStatsResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record("NodeGetVolumeStats"); err != nil {
+		return nil, err
+	}
+	if req.GetVolumeId() == "" || req.GetVolumePath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and volume path are required")
+	}
+	condition, ok := d.conditions[req.GetVolumeId()]
+	if !ok {
+		condition = &csipbv1.VolumeCondition{Message: "volume is healthy"}
+	}
+	return &csipbv1.NodeGetVolumeStatsResponse{
+		VolumeCondition: condition,
+	}, nil
+}
+
+func (d *Driver) NodeStageVolume(_ context.Context, req *csipbv1.NodeStageVolumeRequest) (*csipbv1.NodeStageVolumeResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record("NodeStageVolume"); err != nil {
+		return nil, err
+	}
+	if req.GetVolumeId() == "" || req.GetStagingTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and staging target path are required")
+	}
+	d.staged[req.GetVolumeId()] = req.GetStagingTargetPath()
+	return &csipbv1.NodeStageVolumeResponse{}, nil
+}
+
+func (d *Driver) NodeUnstageVolume(_ context.Context, req *csipbv1.NodeUnstageVolumeRequest) (*csipbv1.NodeUnstageVolumeResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record("NodeUnstageVolume"); err != nil {
+		return nil, err
+	}
+	delete(d.staged, req.GetVolumeId())
+	return &csipbv1.NodeUnstageVolumeResponse{}, nil
+}
+
+func (d *Driver) NodePublishVolume(_ context.Context, req *csipbv1.NodePublishVolumeRequest) (*csipbv1.NodePublishVolumeResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record("NodePublishVolume"); err != nil {
+		return nil, err
+	}
+	if req.GetVolumeId() == "" || req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and target path are required")
+	}
+	d.published[req.GetVolumeId()] = req.GetTargetPath()
+	return &csipbv1.NodePublishVolumeResponse{}, nil
+}
+
+func (d *Driver) NodeUnpublishVolume(_ context.Context, req *csipbv1.NodeUnpublishVolumeRequest) (*csipbv1.NodeUnpublishVolumeResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record("NodeUnpublishVolume"); err != nil {
+		return nil, err
+	}
+	delete(d.published, req.GetVolumeId())
+	return &csipbv1.NodeUnpublishVolumeResponse{}, nil
+}
@@ -0,0 +1,187 @@
+package csitest_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/Madhu-1/csi-volume-recovery/internal/csi"
+	"github.com/Madhu-1/csi-volume-recovery/pkg/csitest"
+	csipbv1 "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testDriver  = "csitest.example.com"
+	testVolume  = "vol-1"
+	testStaging = "/staging/vol-1"
+	testTarget  = "/target/vol-1"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// start serves the driver for the test and returns the endpoint to reach it
+func start(t *testing.T, driver *csitest.Driver) string {
+	t.Helper()
+	endpoint, err := driver.Start()
+	if err != nil {
+		t.Fatalf("failed to start driver: %v", err)
+	}
+	t.Cleanup(driver.Stop)
+	return endpoint
+}
+
+// connect returns a client of the endpoint as the tool creates them
+func connect(t *testing.T, endpoint string) csi.Client {
+	t.Helper()
+	client, err := csi.NewClient(endpoint, nil, nil, testLogger)
+	if err != nil {
+		t.Fatalf("failed to connect to %s: %v", endpoint, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestIdentity(t *testing.T) {
+	driver := csitest.NewDriver(testDriver)
+	client := connect(t, start(t, driver))
+	ctx := context.Background()
+
+	name, err := client.GetDriverName(ctx, testLogger)
+	if err != nil || name != testDriver {
+		t.Errorf("GetDriverName() = %q, %v, want %q", name, err, testDriver)
+	}
+	if healthy, err := client.IsHealthy(ctx, testLogger); err != nil || !healthy {
+		t.Errorf("IsHealthy() = %t, %v, want true", healthy, err)
+	}
+	driver.SetReady(false)
+	if healthy, err := client.IsHealthy(ctx, testLogger); err != nil || healthy {
+		t.Errorf("IsHealthy() = %t, %v of a driver not ready, want false", healthy, err)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	tests := []struct {
+		name             string
+		capabilities     []csipbv1.NodeServiceCapability_RPC_Type
+		wantCondition    bool
+		wantStageUnstage bool
+	}{
+		{
+			name: "no capabilities",
+		},
+		{
+			name:          "volume condition",
+			capabilities:  []csipbv1.NodeServiceCapability_RPC_Type{csipbv1.NodeServiceCapability_RPC_VOLUME_CONDITION},
+			wantCondition: true,
+		},
+		{
+			name: "volume condition and staging",
+			capabilities: []csipbv1.NodeServiceCapability_RPC_Type{
+				csipbv1.NodeServiceCapability_RPC_VOLUME_CONDITION,
+				csipbv1.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+			},
+			wantCondition:    true,
+			wantStageUnstage: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := csitest.NewDriver(testDriver)
+			driver.SetCapabilities(tt.capabilities...)
+			client := connect(t, start(t, driver))
+			ctx := context.Background()
+
+			if got, err := client.NodeSupportsVolumeCondition(ctx, testLogger); err != nil || got != tt.wantCondition {
+				t.Errorf("NodeSupportsVolumeCondition() = %t, %v, want %t", got, err, tt.wantCondition)
+			}
+			if got, err := client.NodeSupportsStageUnstage(ctx, testLogger); err != nil || got != tt.wantStageUnstage {
+				t.Errorf("NodeSupportsStageUnstage() = %t, %v, want %t", got, err, tt.wantStageUnstage)
+			}
+		})
+	}
+}
+
+func TestVolumeCondition(t *testing.T) {
+	driver := csitest.NewDriver(testDriver)
+	client := connect(t, start(t, driver))
+	ctx := context.Background()
+
+	resp, err := client.NodeGetVolumeStats(ctx, testLogger, testVolume, testTarget, testStaging)
+	if err != nil {
+		t.Fatalf("NodeGetVolumeStats() failed: %v", err)
+	}
+	if resp.GetVolumeCondition().GetAbnormal() {
+		t.Error("volume without a condition reported abnormal")
+	}
+
+	driver.SetVolumeCondition(testVolume, true, "transport endpoint is not connected")
+	resp, err = client.NodeGetVolumeStats(ctx, testLogger, testVolume, testTarget, testStaging)
+	if err != nil {
+		t.Fatalf("NodeGetVolumeStats() failed: %v", err)
+	}
+	if condition := resp.GetVolumeCondition(); !condition.GetAbnormal() || condition.GetMessage() != "transport endpoint is not connected" {
+		t.Errorf("condition = %v, want the abnormal condition set", condition)
+	}
+}
+
+// TestRestage stages and publishes a volume again the way the recoveries do,
+// and checks a scripted failure reaches the caller
+func TestRestage(t *testing.T) {
+	driver := csitest.NewDriver(testDriver)
+	client := connect(t, start(t, driver))
+	ctx := context.Background()
+
+	if err := client.NodeUnpublishVolume(ctx, testLogger, testVolume, testTarget); err != nil {
+		t.Fatalf("NodeUnpublishVolume() failed: %v", err)
+	}
+	if err := client.NodeUnstageVolume(ctx, testLogger, testVolume, testStaging); err != nil {
+		t.Fatalf("NodeUnstageVolume() failed: %v", err)
+	}
+	if err := client.NodeStageVolume(ctx, testLogger, csi.StageRequest{VolumeID: testVolume, StagingTargetPath: testStaging}); err != nil {
+		t.Fatalf("NodeStageVolume() failed: %v", err)
+	}
+	if err := client.NodePublishVolume(ctx, testLogger, csi.PublishRequest{VolumeID: testVolume, StagingTargetPath: testStaging, TargetPath: testTarget}); err != nil {
+		t.Fatalf("NodePublishVolume() failed: %v", err)
+	}
+	if path, ok := driver.StagingPath(testVolume); !ok || path != testStaging {
+		t.Errorf("volume staged on %q (%t), want %q", path, ok, testStaging)
+	}
+	if path, ok := driver.TargetPath(testVolume); !ok || path != testTarget {
+		t.Errorf("volume published to %q (%t), want %q", path, ok, testTarget)
+	}
+	want := []string{"NodeUnpublishVolume", "NodeUnstageVolume", "NodeStageVolume", "NodePublishVolume"}
+	if got := driver.Calls(); !slices.Equal(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+
+	driver.SetError("NodeStageVolume", status.Error(codes.Internal, "mount failed"))
+	err := client.NodeStageVolume(ctx, testLogger, csi.StageRequest{VolumeID: testVolume, StagingTargetPath: testStaging})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("NodeStageVolume() = %v, want the scripted failure", err)
+	}
+}
+
+// TestFailover checks the calls reach the driver through its second socket
+// while the first one is gone, as across a driver upgrade
+func TestFailover(t *testing.T) {
+	gone := csitest.NewDriver(testDriver)
+	goneEndpoint := start(t, gone)
+	gone.Stop()
+	driver := csitest.NewDriver(testDriver)
+	client, err := csi.NewFailoverClient(connect(t, goneEndpoint), connect(t, start(t, driver)))
+	if err != nil {
+		t.Fatalf("NewFailoverClient() failed: %v", err)
+	}
+
+	name, err := client.GetDriverName(context.Background(), testLogger)
+	if err != nil || name != testDriver {
+		t.Fatalf("GetDriverName() = %q, %v, want %q", name, err, testDriver)
+	}
+	if got := driver.Calls(); !slices.Equal(got, []string{"GetPluginInfo"}) {
+		t.Errorf("calls = %v, want the call to fail over", got)
+	}
+}